@@ -0,0 +1,1078 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	puthttp "github.com/putdotio/putio-sync/http"
+	"github.com/putdotio/putio-sync/sync"
+)
+
+// defaultAPIAddr is the address of the running putio-sync server.
+const defaultAPIAddr = "http://127.0.0.1:3000"
+
+// apiKeyEnv is the environment variable holding the API key used by the
+// commands talking to the running server.
+const apiKeyEnv = "PUTIO_SYNC_API_KEY"
+
+// command is a putio-sync subcommand, e.g. "putio-sync sync-now 123".
+type command struct {
+	// One line description of the command
+	help string
+
+	// run executes the command with the arguments following the command name
+	run func(args []string) error
+}
+
+var commands = map[string]command{
+	"add-url": {
+		help: "Fetch links with Put.io and download the results on the running server",
+		run:  runAddURL,
+	},
+	"adopt": {
+		help: "Record the files already in the download folders as downloaded",
+		run:  runAdopt,
+	},
+	"api-key": {
+		help: "Manage the API keys of the HTTP API",
+		run:  runAPIKey,
+	},
+	"db": {
+		help: "Back up the database, list the backups and restore one",
+		run:  runDB,
+	},
+	"decrypt": {
+		help: "Decrypt files encrypted by putio-sync",
+		run:  runDecrypt,
+	},
+	"doctor": {
+		help: "Check the setup for common problems",
+		run:  runDoctor,
+	},
+	"explain": {
+		help: "Explain why a remote file is or isn't downloaded",
+		run:  runExplain,
+	},
+	"export": {
+		help: "Export the downloads and the history as CSV or JSON",
+		run:  runExport,
+	},
+	"fetch": {
+		help: "Search Put.io and download the results on the running server",
+		run:  runFetch,
+	},
+	"halt": {
+		help: "Abort all network activity of the running server immediately",
+		run:  runHalt,
+	},
+	"health": {
+		help: "Check the health of the running server for NAS package scripts",
+		run:  runHealth,
+	},
+	"provision": {
+		help: "Seed users, configurations and API keys from a YAML or JSON file",
+		run:  runProvision,
+	},
+	"purge": {
+		help: "Remove all the data of a user, including the token",
+		run:  runPurge,
+	},
+	"setup": {
+		help: "Set up the account, the folders and the limits interactively",
+		run:  runSetup,
+	},
+	"sync-now": {
+		help: "Sync a remote folder immediately on the running server",
+		run:  runSyncNow,
+	},
+	"tray": {
+		help: "Show the sync status and controls in the system tray (Windows)",
+		run:  runTray,
+	},
+	"update": {
+		help: "Update the running server to the newest release",
+		run:  runUpdate,
+	},
+}
+
+// runCommand runs the command with the given name.
+func runCommand(name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return cmd.run(args)
+}
+
+// usage prints the flags and the available commands.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: putio-sync [flags] [command [arguments]]\n\nFlags:\n")
+	flag.PrintDefaults()
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "\nCommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12v %v\n", name, commands[name].help)
+	}
+}
+
+// apiClient returns the HTTP client and the base URL to reach the server at
+// addr, which is a URL or unix:<path> for a socket. The default address is
+// reached over the control socket of the daemon if it is running, which works
+// without the HTTP server and API keys.
+func apiClient(addr string) (*http.Client, string) {
+	path := strings.TrimPrefix(addr, "unix:")
+	if addr == defaultAPIAddr && daemonRunning() {
+		path, _ = sync.ControlSocketPath()
+	} else if path == addr {
+		return http.DefaultClient, addr
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return puthttp.DialSocket(ctx, path)
+		},
+	}
+	return &http.Client{Transport: transport}, "http://putio-sync"
+}
+
+// daemonRunning reports whether a daemon serves the control socket. The
+// database is locked by the daemon while it runs, the commands must go through
+// the socket.
+func daemonRunning() bool {
+	path, err := sync.ControlSocketPath()
+	if err != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, err := puthttp.DialSocket(ctx, path)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// controlRequest sends a request to the running daemon and decodes the JSON
+// response into v.
+func controlRequest(method, path string, params url.Values, v interface{}) error {
+	body, err := apiRequest(defaultAPIAddr, method, path, params)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// apiRequest sends a request to the HTTP API of the running server and
+// returns the response body.
+func apiRequest(addr, method, path string, params url.Values) ([]byte, error) {
+	client, base := apiClient(addr)
+
+	var req *http.Request
+	var err error
+	if method == "GET" || method == "DELETE" {
+		req, err = http.NewRequest(method, base+path+"?"+params.Encode(), nil)
+	} else {
+		req, err = http.NewRequest(method, base+path, strings.NewReader(params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if key := os.Getenv(apiKeyEnv); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("is the server running? %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server responded with %v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func runSyncNow(args []string) error {
+	fs := flag.NewFlagSet("sync-now", flag.ExitOnError)
+	var (
+		addr      = fs.String("addr", defaultAPIAddr, "Address of the running server")
+		recursive = fs.Bool("recursive", false, "Sync the subfolders too")
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: putio-sync sync-now [flags] <folder-id>\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	id, err := strconv.ParseInt(fs.Arg(0), 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid folder id: %v", fs.Arg(0))
+	}
+
+	params := url.Values{}
+	params.Set("id", strconv.FormatInt(id, 10))
+	params.Set("recursive", strconv.FormatBool(*recursive))
+
+	_, err = apiRequest(*addr, "POST", "/api/sync-now", params)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Folder %v is being synced\n", id)
+	return nil
+}
+
+func runAdopt(args []string) error {
+	fs := flag.NewFlagSet("adopt", flag.ExitOnError)
+	var (
+		addr   = fs.String("addr", defaultAPIAddr, "Address of the running server")
+		verify = fs.Bool("verify", false, "Compare the CRC32 checksums too, not only the sizes")
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: putio-sync adopt [flags]\n\n")
+		fmt.Fprintf(os.Stderr, "The local files matching the remote files are not downloaded again.\n")
+		fmt.Fprintf(os.Stderr, "The sync must be stopped on the running server.\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	params := url.Values{}
+	params.Set("verify", strconv.FormatBool(*verify))
+
+	body, err := apiRequest(*addr, "POST", "/api/adopt", params)
+	if err != nil {
+		return err
+	}
+
+	var report sync.AdoptReport
+	err = json.Unmarshal(body, &report)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range report.Mismatched {
+		fmt.Printf("Mismatch: %v\n", path)
+	}
+	for _, e := range report.Errors {
+		fmt.Printf("Error: %v\n", e)
+	}
+	fmt.Printf("%v files adopted, %v already known, %v missing, %v mismatched\n",
+		report.Adopted, report.Known, report.Missing, len(report.Mismatched))
+	return nil
+}
+
+func runHalt(args []string) error {
+	fs := flag.NewFlagSet("halt", flag.ExitOnError)
+	var (
+		addr   = fs.String("addr", defaultAPIAddr, "Address of the running server")
+		resume = fs.Bool("resume", false, "Allow network activity again, the sync stays stopped until it is started")
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: putio-sync halt [flags]\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	method := "POST"
+	if *resume {
+		method = "DELETE"
+	}
+	_, err := apiRequest(*addr, method, "/api/halt", url.Values{})
+	if err != nil {
+		return err
+	}
+
+	if *resume {
+		fmt.Println("Network activity is allowed again")
+	} else {
+		fmt.Println("All network activity is halted")
+	}
+	return nil
+}
+
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	jsonFlag := fs.Bool("json", false, "Print the report as JSON")
+	_ = fs.Parse(args)
+
+	var checks []sync.Check
+	if daemonRunning() {
+		err := controlRequest("GET", "/api/doctor", url.Values{}, &checks)
+		if err != nil {
+			return err
+		}
+	} else {
+		client, err := sync.NewClient(false)
+		if err != nil {
+			return fmt.Errorf("error opening the database: %v", err)
+		}
+		defer client.Store.Close()
+
+		checks = client.Diagnose(context.Background())
+	}
+
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(checks)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, check := range checks {
+			status := "OK"
+			if !check.OK {
+				status = "FAIL"
+			}
+			fmt.Printf("[%4v] %-10v %v\n", status, check.Name, check.Message)
+		}
+	}
+
+	for _, check := range checks {
+		if !check.OK {
+			os.Exit(1)
+		}
+	}
+	return nil
+}
+
+func runAPIKey(args []string) error {
+	fs := flag.NewFlagSet("api-key", flag.ExitOnError)
+	role := fs.String("role", string(sync.RoleReadOnly), "Role of the new key: read-only or admin")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: putio-sync api-key [flags] add <name>\n")
+		fmt.Fprintf(os.Stderr, "       putio-sync api-key list\n")
+		fmt.Fprintf(os.Stderr, "       putio-sync api-key remove <name>\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// keys are managed by the daemon while it runs
+	remote := daemonRunning()
+	var response struct {
+		Keys []*sync.APIKey `json:"keys"`
+		Key  string         `json:"key"`
+	}
+
+	var client *sync.Client
+	if !remote {
+		var err error
+		client, err = sync.NewClient(false)
+		if err != nil {
+			return fmt.Errorf("error opening the database: %v", err)
+		}
+		defer client.Store.Close()
+	}
+
+	switch {
+	case fs.Arg(0) == "list" && fs.NArg() == 1:
+		var err error
+		if remote {
+			err = controlRequest("GET", "/api/api-keys", url.Values{}, &response)
+		} else {
+			response.Keys, err = client.Store.APIKeys()
+		}
+		if err != nil {
+			return err
+		}
+		for _, key := range response.Keys {
+			fmt.Printf("%-20v %-10v %v\n", key.Name, key.Role, key.CreatedAt.Format("2006-01-02 15:04"))
+		}
+	case fs.Arg(0) == "add" && fs.NArg() == 2:
+		if !sync.ValidRole(sync.Role(*role)) {
+			return fmt.Errorf("invalid role: %v", *role)
+		}
+		if remote {
+			params := url.Values{}
+			params.Set("name", fs.Arg(1))
+			params.Set("role", *role)
+			err := controlRequest("POST", "/api/api-keys", params, &response)
+			if err != nil {
+				return err
+			}
+		} else {
+			secret, key, err := sync.NewAPIKey(fs.Arg(1), sync.Role(*role))
+			if err != nil {
+				return err
+			}
+			err = client.Store.SaveAPIKey(key)
+			if err != nil {
+				return err
+			}
+			response.Key = secret
+		}
+		fmt.Printf("%v\n", response.Key)
+		fmt.Fprintf(os.Stderr, "Store the key safely, it can't be shown again.\n")
+	case fs.Arg(0) == "remove" && fs.NArg() == 2:
+		if remote {
+			params := url.Values{}
+			params.Set("name", fs.Arg(1))
+			return controlRequest("DELETE", "/api/api-keys", params, &response)
+		}
+		return client.Store.DeleteAPIKey(fs.Arg(1))
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+func runDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyFlag := fs.String("key", "", "Encryption key file (default ~/.putio-sync/"+sync.EncryptionKeyFile+")")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: putio-sync decrypt [flags] <file>...\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	keyPath := *keyFlag
+	if keyPath == "" {
+		u, err := user.Current()
+		if err != nil {
+			return err
+		}
+		keyPath = filepath.Join(u.HomeDir, ".putio-sync", sync.EncryptionKeyFile)
+	}
+
+	key, err := sync.LoadEncryptionKey(keyPath)
+	if err != nil {
+		return fmt.Errorf("error reading the key: %v", err)
+	}
+
+	for _, src := range fs.Args() {
+		dst := strings.TrimSuffix(src, sync.EncryptedExtension)
+		if dst == src {
+			dst += ".decrypted"
+		}
+		if _, err := os.Stat(dst); err == nil {
+			return fmt.Errorf("%v already exists", dst)
+		}
+
+		err = sync.DecryptFile(key, src, dst)
+		if err != nil {
+			return fmt.Errorf("error decrypting %v: %v", src, err)
+		}
+		fmt.Printf("%v\n", dst)
+	}
+	return nil
+}
+
+func runExplain(args []string) error {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	var (
+		addr     = fs.String("addr", defaultAPIAddr, "Address of the running server")
+		jsonFlag = fs.Bool("json", false, "Print the explanation as JSON")
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: putio-sync explain [flags] <remote path or id>\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	params := url.Values{}
+	params.Set("file", fs.Arg(0))
+	body, err := apiRequest(*addr, "GET", "/api/explain", params)
+	if err != nil {
+		return err
+	}
+
+	if *jsonFlag {
+		_, err = os.Stdout.Write(body)
+		return err
+	}
+
+	var e sync.Explanation
+	err = json.Unmarshal(body, &e)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range e.Steps {
+		status := "OK"
+		if !step.Passed {
+			status = "SKIP"
+		}
+		fmt.Printf("[%4v] %-14v %v\n", status, step.Rule, step.Detail)
+	}
+	if e.Download {
+		fmt.Printf("%v is downloaded\n", fs.Arg(0))
+	} else {
+		fmt.Printf("%v is not downloaded\n", fs.Arg(0))
+	}
+	return nil
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var (
+		addr    = fs.String("addr", defaultAPIAddr, "Address of the running server")
+		format  = fs.String("format", sync.ExportCSV, "Output format: csv or json")
+		columns = fs.String("columns", "", "Comma separated columns, all of them if empty: "+strings.Join(sync.ExportColumns, ","))
+		output  = fs.String("o", "", "Write to the file instead of the standard output")
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: putio-sync export [flags]\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	params := url.Values{}
+	params.Set("format", *format)
+	if *columns != "" {
+		params.Set("columns", *columns)
+	}
+
+	body, err := apiRequest(*addr, "GET", "/api/export", params)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(body)
+		return err
+	}
+	return ioutil.WriteFile(*output, body, 0644)
+}
+
+func runFetch(args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	var (
+		addr = fs.String("addr", defaultAPIAddr, "Address of the running server")
+		all  = fs.Bool("all", false, "Download all the results")
+		pick = fs.String("pick", "", "Comma separated numbers of the results to download, e.g. 1,3")
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: putio-sync fetch [flags] <query>\n")
+		fmt.Fprintf(os.Stderr, "The only result is downloaded. Use -all or -pick if there are more.\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	params := url.Values{}
+	params.Set("q", strings.Join(fs.Args(), " "))
+	body, err := apiRequest(*addr, "GET", "/api/search", params)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Files []struct {
+			ID          int64  `json:"id"`
+			Name        string `json:"name"`
+			Size        int64  `json:"size"`
+			ContentType string `json:"content_type"`
+		} `json:"files"`
+	}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return err
+	}
+
+	if len(result.Files) == 0 {
+		fmt.Printf("No results\n")
+		return nil
+	}
+
+	picked := make(map[int]bool)
+	switch {
+	case *all:
+		for i := range result.Files {
+			picked[i] = true
+		}
+	case *pick != "":
+		for _, p := range strings.Split(*pick, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(p))
+			if err != nil || n < 1 || n > len(result.Files) {
+				return fmt.Errorf("invalid result number: %v", p)
+			}
+			picked[n-1] = true
+		}
+	case len(result.Files) == 1:
+		picked[0] = true
+	}
+
+	if len(picked) == 0 {
+		for i, f := range result.Files {
+			fmt.Printf("%3v. %v (%v)\n", i+1, f.Name, sync.FormatBytes(f.Size))
+		}
+		fmt.Printf("Use -all or -pick to download the results\n")
+		return nil
+	}
+
+	params = url.Values{}
+	for i, f := range result.Files {
+		if picked[i] {
+			params.Add("id", strconv.FormatInt(f.ID, 10))
+		}
+	}
+	_, err = apiRequest(*addr, "POST", "/api/fetch", params)
+	if err != nil {
+		return err
+	}
+
+	for i, f := range result.Files {
+		if picked[i] {
+			fmt.Printf("%v is queued\n", f.Name)
+		}
+	}
+	return nil
+}
+
+func runAddURL(args []string) error {
+	fs := flag.NewFlagSet("add-url", flag.ExitOnError)
+	var (
+		addr   = fs.String("addr", defaultAPIAddr, "Address of the running server")
+		parent = fs.Int64("parent", -1, "ID of the Put.io folder to fetch into (default: the download folder)")
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: putio-sync add-url [flags] <url>...\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	for _, link := range fs.Args() {
+		params := url.Values{}
+		params.Set("url", link)
+		if *parent >= 0 {
+			params.Set("parent", strconv.FormatInt(*parent, 10))
+		}
+
+		_, err := apiRequest(*addr, "POST", "/api/add-url", params)
+		if err != nil {
+			return fmt.Errorf("error adding %v: %v", link, err)
+		}
+		fmt.Printf("%v is added, it is downloaded when the transfer completes\n", link)
+	}
+	return nil
+}
+
+func runProvision(args []string) error {
+	fs := flag.NewFlagSet("provision", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: putio-sync provision <file>\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	p, err := sync.ParseProvision(data)
+	if err != nil {
+		return fmt.Errorf("error parsing %v: %v", fs.Arg(0), err)
+	}
+
+	if daemonRunning() {
+		return fmt.Errorf("putio-sync is running, stop it before provisioning")
+	}
+
+	client, err := sync.NewClient(false)
+	if err != nil {
+		return fmt.Errorf("error opening the database, is the server running? %v", err)
+	}
+	defer client.Store.Close()
+
+	generated, err := p.Apply(context.Background(), client.Store)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Provisioned %v users and %v API keys\n", len(p.Users), len(p.APIKeys))
+	var names []string
+	for name := range generated {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("API key %v: %v\n", name, generated[name])
+	}
+	return nil
+}
+
+// Exit codes of the health command, following the LSB init script status
+// codes understood by the Synology and QNAP package frameworks.
+const (
+	healthOK         = 0
+	healthUnhealthy  = 1
+	healthNotRunning = 3
+)
+
+func runHealth(args []string) error {
+	fs := flag.NewFlagSet("health", flag.ExitOnError)
+	var (
+		addr   = fs.String("addr", defaultAPIAddr, "Address of the running server")
+		socket = fs.String("socket", "", "Read the health from the status socket instead of the API")
+		quiet  = fs.Bool("quiet", false, "Print nothing, only set the exit code")
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: putio-sync health [flags]\n\nExits with 0 if healthy, 1 if unhealthy and 3 if the server is not running.\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	var body []byte
+	var err error
+	if *socket != "" {
+		body, err = readStatusSocket(*socket)
+	} else {
+		body, err = healthRequest(*addr)
+	}
+	if err != nil {
+		if !*quiet {
+			fmt.Fprintf(os.Stderr, "not running: %v\n", err)
+		}
+		os.Exit(healthNotRunning)
+	}
+
+	var h sync.Health
+	err = json.Unmarshal(body, &h)
+	if err != nil {
+		return err
+	}
+
+	if !*quiet {
+		fmt.Printf("%v\n", h.Label)
+		for _, p := range h.Problems {
+			fmt.Printf("  %v\n", p)
+		}
+	}
+	if !h.Healthy {
+		os.Exit(healthUnhealthy)
+	}
+	os.Exit(healthOK)
+	return nil
+}
+
+// readStatusSocket reads the health line written by the status socket.
+func readStatusSocket(path string) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return ioutil.ReadAll(conn)
+}
+
+// healthRequest fetches the health from the API. Unlike apiRequest, it
+// accepts the 503 response of an unhealthy server.
+func healthRequest(addr string) ([]byte, error) {
+	req, err := http.NewRequest("GET", addr+"/api/health", nil)
+	if err != nil {
+		return nil, err
+	}
+	if key := os.Getenv(apiKeyEnv); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil, fmt.Errorf("server responded with %v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func runUpdate(args []string) error {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	var (
+		addr    = fs.String("addr", defaultAPIAddr, "Address of the running server")
+		channel = fs.String("channel", "", "Update channel, stable or beta (default the configured channel)")
+		check   = fs.Bool("check", false, "Only check for a newer release")
+	)
+	_ = fs.Parse(args)
+
+	method := "POST"
+	if *check {
+		method = "GET"
+	}
+
+	params := url.Values{}
+	params.Set("channel", *channel)
+	body, err := apiRequest(*addr, method, "/api/update", params)
+	if err != nil {
+		return err
+	}
+
+	var response struct {
+		Current string        `json:"current"`
+		Latest  *sync.Release `json:"latest"`
+	}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case response.Latest == nil:
+		fmt.Printf("%v is up to date\n", response.Current)
+	case *check:
+		fmt.Printf("%v is available, running %v\n%v\n", response.Latest.Version, response.Current, response.Latest.URL)
+	default:
+		fmt.Printf("Updated from %v to %v, the server is restarting\n", response.Current, response.Latest.Version)
+	}
+	return nil
+}
+
+func runDB(args []string) error {
+	fs := flag.NewFlagSet("db", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: putio-sync db backup\n")
+		fmt.Fprintf(os.Stderr, "       putio-sync db list\n")
+		fmt.Fprintf(os.Stderr, "       putio-sync db restore <backup>\n\n")
+		fmt.Fprintf(os.Stderr, "The server must be stopped before restoring a backup.\n")
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// the backups are taken by the daemon while it runs
+	remote := daemonRunning()
+	var response struct {
+		Backups []string `json:"backups"`
+		Backup  string   `json:"backup"`
+	}
+	if remote && fs.Arg(0) == "restore" {
+		return fmt.Errorf("putio-sync is running, stop it before restoring a backup")
+	}
+
+	var client *sync.Client
+	if !remote {
+		var err error
+		client, err = sync.NewClient(false)
+		if err != nil {
+			return fmt.Errorf("error opening the database: %v", err)
+		}
+	}
+
+	switch {
+	case fs.Arg(0) == "backup" && fs.NArg() == 1:
+		var err error
+		if remote {
+			err = controlRequest("POST", "/api/backups", url.Values{}, &response)
+		} else {
+			defer client.Store.Close()
+			response.Backup, err = client.Store.Backup("manual")
+		}
+		if err != nil {
+			return err
+		}
+		if response.Backup == "" {
+			return fmt.Errorf("backups are disabled")
+		}
+		fmt.Println(response.Backup)
+	case fs.Arg(0) == "list" && fs.NArg() == 1:
+		var err error
+		if remote {
+			err = controlRequest("GET", "/api/backups", url.Values{}, &response)
+		} else {
+			defer client.Store.Close()
+			response.Backups, err = client.Store.Backups()
+		}
+		if err != nil {
+			return err
+		}
+		for _, backup := range response.Backups {
+			fi, err := os.Stat(backup)
+			if err != nil {
+				continue
+			}
+			fmt.Printf("%-70v %v\n", backup, sync.FormatBytes(fi.Size()))
+		}
+	case fs.Arg(0) == "restore" && fs.NArg() == 2:
+		path := client.Store.Path()
+		backup := fs.Arg(1)
+		// a bare name refers to the backups folder
+		if !strings.ContainsRune(backup, filepath.Separator) {
+			backups, err := client.Store.Backups()
+			if err != nil {
+				return err
+			}
+			for _, b := range backups {
+				if filepath.Base(b) == backup {
+					backup = b
+				}
+			}
+		}
+
+		err := client.Store.Close()
+		if err != nil {
+			return err
+		}
+		err = sync.RestoreBackup(path, backup)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Restored %v\n", backup)
+	default:
+		if client != nil {
+			client.Store.Close()
+		}
+		fs.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+func runPurge(args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	var (
+		addr = fs.String("addr", defaultAPIAddr, "Address of the running server")
+		list = fs.Bool("list", false, "List the users that have data stored")
+		yes  = fs.Bool("yes", false, "Don't ask for confirmation")
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: putio-sync purge [flags] [username]\n\n")
+		fmt.Fprintf(os.Stderr, "The current user is purged if no username is given.\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	body, err := apiRequest(*addr, "GET", "/api/users", url.Values{})
+	if err != nil {
+		return err
+	}
+	var users struct {
+		Users   []string `json:"users"`
+		Current string   `json:"current"`
+	}
+	err = json.Unmarshal(body, &users)
+	if err != nil {
+		return err
+	}
+
+	if *list {
+		for _, u := range users.Users {
+			if u == users.Current {
+				fmt.Printf("%v (current)\n", u)
+			} else {
+				fmt.Println(u)
+			}
+		}
+		return nil
+	}
+
+	username := fs.Arg(0)
+	if username == "" {
+		username = users.Current
+	}
+	if username == "" {
+		return fmt.Errorf("no user is logged in, give a username")
+	}
+
+	if !*yes {
+		fmt.Printf("All the data of %v, including the token, will be removed. Continue? [y/N] ", username)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+			return fmt.Errorf("aborted")
+		}
+	}
+
+	params := url.Values{}
+	params.Set("username", username)
+	_, err = apiRequest(*addr, "POST", "/api/purge", params)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Purged %v\n", username)
+	return nil
+}
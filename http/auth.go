@@ -0,0 +1,143 @@
+package http
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/putdotio/putio-sync/sync"
+)
+
+// readOnlyPaths can be queried with a read-only API key. Every other path
+// requires an admin key, including the ones that browse the local disk or the
+// remote files.
+var readOnlyPaths = map[string]bool{
+	"/status":               true,
+	"/api/list-downloads":   true,
+	"/api/queue":            true,
+	"/api/uploads":          true,
+	"/api/watched-torrents": true,
+	"/api/remote-deletions": true,
+	"/api/audit":            true,
+	"/api/url-transfers":    true,
+	"/api/reports":          true,
+	"/api/errors":           true,
+	"/api/history":          true,
+	"/api/export":           true,
+	"/api/ping":             true,
+	"/api/health":           true,
+	"/api/halt":             true,
+	"/api/device":           true,
+	"/api/users":            true,
+	"/api/conflicts":        true,
+}
+
+// requiredRole returns the role needed for the request.
+func requiredRole(r *http.Request) sync.Role {
+	if r.Method == "GET" && readOnlyPaths[r.URL.Path] {
+		return sync.RoleReadOnly
+	}
+	return sync.RoleAdmin
+}
+
+// apiKey extracts the API key from the "Authorization: Bearer <key>" or
+// "X-API-Key" request headers.
+func apiKey(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// lookupAPIKey returns the stored API key matching key. It returns nil if
+// there is no such key.
+func (h *Handler) lookupAPIKey(key string) (*sync.APIKey, error) {
+	keys, err := h.sync.Store.APIKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if k.Matches(key) {
+			return k, nil
+		}
+	}
+	return nil, nil
+}
+
+// authorize reports whether the request may proceed. Authentication is
+// disabled until the first API key is created.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	// preflight requests don't carry credentials, and the callbacks of
+	// Put.io are authorized by their secret
+	if r.Method == "OPTIONS" || h.local || r.URL.Path == sync.CallbackPath {
+		return true
+	}
+
+	keys, err := h.sync.Store.APIKeys()
+	if err != nil {
+		h.sync.Errorf("Error reading API keys: %v\n", err)
+		h.error(w, "internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if len(keys) == 0 {
+		return true
+	}
+
+	key := apiKey(r)
+	if key == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		h.error(w, "api key required", http.StatusUnauthorized)
+		return false
+	}
+
+	k, err := h.lookupAPIKey(key)
+	if err != nil {
+		h.sync.Errorf("Error reading API keys: %v\n", err)
+		h.error(w, "internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if k == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		h.error(w, "invalid api key", http.StatusUnauthorized)
+		return false
+	}
+	if !k.Role.Allows(requiredRole(r)) {
+		h.error(w, "permission denied", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// SetBasicAuth protects the web UI and the API with HTTP basic
+// authentication. Requests with a valid API key are let through as well.
+func (h *Handler) SetBasicAuth(username, password string) {
+	h.basicUsername = username
+	h.basicPassword = password
+}
+
+// checkBasicAuth reports whether the request passes the basic authentication,
+// if enabled.
+func (h *Handler) checkBasicAuth(w http.ResponseWriter, r *http.Request) bool {
+	if h.basicUsername == "" || r.Method == "OPTIONS" || h.local || r.URL.Path == sync.CallbackPath {
+		return true
+	}
+
+	username, password, ok := r.BasicAuth()
+	if ok &&
+		subtle.ConstantTimeCompare([]byte(username), []byte(h.basicUsername)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(h.basicPassword)) == 1 {
+		return true
+	}
+
+	if key := apiKey(r); key != "" {
+		k, err := h.lookupAPIKey(key)
+		if err == nil && k != nil {
+			return true
+		}
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="putio-sync"`)
+	h.error(w, "authentication required", http.StatusUnauthorized)
+	return false
+}
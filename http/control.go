@@ -0,0 +1,58 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/putdotio/putio-sync/sync"
+)
+
+// ControlServer serves the API on the control socket of the daemon. Only the
+// daemon opens the database, the commands and local scripts talk to it over
+// the socket instead of waiting for the database lock.
+type ControlServer struct {
+	ln      net.Listener
+	Handler *Handler
+	Path    string
+
+	// Permissions of the socket, and the group it belongs to. Only the user
+	// running the daemon can connect by default.
+	Mode  os.FileMode
+	Group string
+}
+
+// NewControlServer returns a new control server listening on the unix socket,
+// or the named pipe on Windows, at path.
+func NewControlServer(sync *sync.Client, path string) *ControlServer {
+	h := NewHandler(sync)
+	h.local = true
+	return &ControlServer{
+		Handler: h,
+		Path:    path,
+		Mode:    0600,
+	}
+}
+
+// Open creates the socket.
+func (s *ControlServer) Open() error {
+	ln, err := ListenSocket(s.Path, s.Mode, s.Group)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
+	return nil
+}
+
+// Close closes the socket.
+func (s *ControlServer) Close() error {
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Close()
+}
+
+// Serve serves the API requests until the socket is closed.
+func (s *ControlServer) Serve() error {
+	return http.Serve(s.ln, s.Handler)
+}
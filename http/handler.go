@@ -1,6 +1,7 @@
 package http
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"io/ioutil"
@@ -8,12 +9,14 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/igungor/go-putio/putio"
 	"github.com/putdotio/putio-sync/sync"
 )
 
@@ -26,6 +29,20 @@ type Handler struct {
 
 	// Bundled copy of the web UI, which are served as static files
 	staticFS http.FileSystem
+
+	// Credentials of the basic authentication. It is disabled if empty.
+	basicUsername string
+	basicPassword string
+
+	// URL prefix which the handler is mounted under, e.g. "/putio-sync"
+	basePath string
+
+	// Respect the X-Forwarded-* headers of the reverse proxy
+	trustProxy bool
+
+	// Requests come over the control socket, the permissions of the socket
+	// file authorize them
+	local bool
 }
 
 func NewHandler(s *sync.Client) *Handler {
@@ -36,15 +53,46 @@ func NewHandler(s *sync.Client) *Handler {
 	}
 	h.mux.HandleFunc("/api/start", h.handleStart)
 	h.mux.HandleFunc("/api/stop", h.handleStop)
+	h.mux.HandleFunc("/api/halt", h.handleHalt)
 	h.mux.HandleFunc("/api/list-downloads", h.handleListDownloads)
 	h.mux.HandleFunc("/api/config", h.handleConfig)
 	h.mux.HandleFunc("/api/logout", h.handleLogout)
+	h.mux.HandleFunc("/api/users", h.handleUsers)
+	h.mux.HandleFunc("/api/purge", h.handlePurge)
+	h.mux.HandleFunc("/api/doctor", h.handleDoctor)
+	h.mux.HandleFunc("/api/api-keys", h.handleAPIKeys)
+	h.mux.HandleFunc("/api/backups", h.handleBackups)
 	h.mux.HandleFunc("/api/clear", h.handleClear)
 	h.mux.HandleFunc("/api/tree", h.handleTree)
+	h.mux.HandleFunc("/api/remote-tree", h.handleRemoteTree)
+	h.mux.HandleFunc("/api/remote-path", h.handleRemotePath)
+	h.mux.HandleFunc("/api/conflicts", h.handleConflicts)
+	h.mux.HandleFunc("/api/sync-now", h.handleSyncNow)
+	h.mux.HandleFunc("/api/adopt", h.handleAdopt)
+	h.mux.HandleFunc("/api/search", h.handleSearch)
+	h.mux.HandleFunc("/api/fetch", h.handleFetch)
+	h.mux.HandleFunc("/api/queue", h.handleQueue)
+	h.mux.HandleFunc("/api/uploads", h.handleUploads)
+	h.mux.HandleFunc("/api/watched-torrents", h.handleWatchedTorrents)
+	h.mux.HandleFunc("/api/remote-deletions", h.handleRemoteDeletions)
+	h.mux.HandleFunc("/api/audit", h.handleAudit)
+	h.mux.HandleFunc("/api/reports", h.handleReports)
+	h.mux.HandleFunc("/api/errors", h.handleErrors)
+	h.mux.HandleFunc("/api/history", h.handleHistory)
+	h.mux.HandleFunc("/api/export", h.handleExport)
+	h.mux.HandleFunc("/api/thumbnail", h.handleThumbnail)
+	h.mux.HandleFunc("/api/explain", h.handleExplain)
+	h.mux.HandleFunc("/api/rules", h.handleRules)
 	h.mux.HandleFunc("/api/ping", h.handlePing)
+	h.mux.HandleFunc("/api/health", h.handleHealth)
+	h.mux.HandleFunc("/api/update", h.handleUpdate)
+	h.mux.HandleFunc("/api/device", h.handleDevice)
 	h.mux.HandleFunc("/api/go-to-file", h.handleGoToFile)
 	h.mux.HandleFunc("/api/add-magnet", h.handleAddMagnet)
 	h.mux.HandleFunc("/api/add-torrent", h.handleAddTorrent)
+	h.mux.HandleFunc("/api/add-url", h.handleAddURL)
+	h.mux.HandleFunc("/api/url-transfers", h.handleURLTransfers)
+	h.mux.HandleFunc(sync.CallbackPath, h.handleTransferCallback)
 
 	return h
 }
@@ -53,11 +101,37 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	apiHandler := CORSMiddleware(JSONMiddleware(h.mux))
 	fsHandler := CORSMiddleware(http.FileServer(h.staticFS))
 
+	h.forwarded(r)
+	if !h.stripBasePath(w, r) {
+		return
+	}
+
+	if !h.checkBasicAuth(w, r) {
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/status" {
+		if !h.authorize(w, r) {
+			return
+		}
+	}
+
+	// profiles are not JSON
+	if strings.HasPrefix(r.URL.Path, pprofPrefix) {
+		CORSMiddleware(http.HandlerFunc(h.handlePprof)).ServeHTTP(w, r)
+		return
+	}
+
 	if strings.HasPrefix(r.URL.Path, "/api/") {
 		apiHandler.ServeHTTP(w, r)
 		return
 	}
 
+	if r.URL.Path == "/status" {
+		CORSMiddleware(http.HandlerFunc(h.handleStatusPage)).ServeHTTP(w, r)
+		return
+	}
+
 	if strings.HasPrefix(r.URL.Path, "/welcome") {
 		r.URL.Path = "/"
 		fsHandler.ServeHTTP(w, r)
@@ -73,13 +147,19 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.NotFound(w, r)
 }
 
+// error replies to the request with the message translated to the configured
+// locale.
+func (h *Handler) error(w http.ResponseWriter, msg string, code int) {
+	http.Error(w, h.sync.T(msg), code)
+}
+
 func (h *Handler) handleStart(w http.ResponseWriter, r *http.Request) {
 	h.sync.Debugf("start called\n")
 
 	err := h.sync.Run()
 	if err != nil {
 		h.sync.Println(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -90,8 +170,8 @@ func (h *Handler) handleStart(w http.ResponseWriter, r *http.Request) {
 	}
 	err = json.NewEncoder(w).Encode(&response)
 	if err != nil {
-		h.sync.Printf("Error encoding response: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 	}
 	return
 }
@@ -101,7 +181,7 @@ func (h *Handler) handleStop(w http.ResponseWriter, r *http.Request) {
 
 	err := h.sync.Stop()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -112,8 +192,40 @@ func (h *Handler) handleStop(w http.ResponseWriter, r *http.Request) {
 	}
 	err = json.NewEncoder(w).Encode(&response)
 	if err != nil {
-		h.sync.Printf("Error encoding response: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
+
+// handleHalt aborts all network activity on POST and allows it again on
+// DELETE.
+func (h *Handler) handleHalt(w http.ResponseWriter, r *http.Request) {
+	var err error
+	switch r.Method {
+	case "GET":
+	case "POST":
+		err = h.sync.Halt()
+	case "DELETE":
+		err = h.sync.Unhalt()
+	default:
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		h.error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	var response = struct {
+		Halted bool `json:"halted"`
+	}{
+		Halted: h.sync.Halted(),
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 	}
 	return
 }
@@ -133,7 +245,7 @@ func (d ByDate) Less(i, j int) bool {
 func (h *Handler) handleListDownloads(w http.ResponseWriter, r *http.Request) {
 	states, err := h.sync.Store.States(h.sync.User.Username)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -148,326 +260,1478 @@ func (h *Handler) handleListDownloads(w http.ResponseWriter, r *http.Request) {
 
 		bytes := float64(state.BytesTransferredSinceLastUpdate / 1024)
 		duration := now.Sub(state.DownloadStartedAt).Seconds()
+		// the wall clock might be set back since the download is started
+		if duration <= 0 {
+			continue
+		}
 		state.DownloadSpeed = bytes / duration
 
 		totalSpeed += state.DownloadSpeed
 	}
 
+	queue, err := h.sync.QueueEstimate()
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	labels := make(map[string]string)
+	for ds := sync.DownloadIdle; ds <= sync.DownloadCompleted; ds++ {
+		labels[ds.String()] = h.sync.T(ds.Label())
+	}
+
 	listResponse := struct {
-		Status     string        `json:"status"`
-		TotalSpeed float64       `json:"total_speed"`
-		Files      []*sync.State `json:"files"`
+		Status      string             `json:"status"`
+		StatusLabel string             `json:"status_label"`
+		Labels      map[string]string  `json:"labels"`
+		TotalSpeed  float64            `json:"total_speed"`
+		Queue       sync.QueueEstimate `json:"queue"`
+		Files       []*sync.State      `json:"files"`
 	}{
-		Status:     h.sync.Status(),
-		TotalSpeed: totalSpeed,
-		Files:      states,
+		Status:      h.sync.Status(),
+		StatusLabel: h.sync.StatusLabel(),
+		Labels:      labels,
+		TotalSpeed:  totalSpeed,
+		Queue:       queue,
+		Files:       states,
 	}
 	err = json.NewEncoder(w).Encode(&listResponse)
 	if err != nil {
-		h.sync.Printf("Error encoding response: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	return
 }
 
-func (h *Handler) handleConfig(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "GET" {
-		err := json.NewEncoder(w).Encode(h.sync.Config)
+func (h *Handler) handleDevice(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		err := h.sync.SetDeviceName(strings.TrimSpace(r.FormValue("name")))
 		if err != nil {
-			h.sync.Printf("Error encoding config: %v\n", err)
-			http.Error(w, "", http.StatusInternalServerError)
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+	} else if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if r.Method != "POST" {
-		http.Error(w, "", http.StatusMethodNotAllowed)
+	err := json.NewEncoder(w).Encode(h.sync.DeviceInfo())
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
+
+func (h *Handler) handleUploads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// New configuration POST'ed
+	uploads, err := h.sync.Store.Uploads(h.sync.User.Username)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	var c sync.Config
-	err := json.NewDecoder(r.Body).Decode(&c)
+	response := struct {
+		Uploads []*sync.Upload `json:"uploads"`
+	}{
+		Uploads: uploads,
+	}
+	err = json.NewEncoder(w).Encode(&response)
 	if err != nil {
-		h.sync.Printf("Error decoding config: %v\n", err)
-		http.Error(w, "", http.StatusInternalServerError)
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
+
+func (h *Handler) handleWatchedTorrents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if c.OAuth2Token != "" {
-		h.sync.Config.OAuth2Token = c.OAuth2Token
-		// RenewToken is called here since a new OAuth2 token is inplace and a
-		// new client associated with this token must be created.
-		err = h.sync.RenewToken()
-		if err != nil {
-			h.sync.Printf("Error renewing token: %v\n", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	torrents, err := h.sync.Store.WatchedTorrents(h.sync.User.Username)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	if c.PollInterval >= sync.Duration(time.Minute) {
-		h.sync.Config.PollInterval = c.PollInterval
+	response := struct {
+		Torrents []*sync.WatchedTorrent `json:"torrents"`
+	}{
+		Torrents: torrents,
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 	}
+	return
+}
 
-	if c.DownloadTo != "" {
-		h.sync.Config.DownloadTo = c.DownloadTo
+func (h *Handler) handleRemoteDeletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	if c.DownloadFrom >= 0 {
-		h.sync.Config.DownloadFrom = c.DownloadFrom
+	deletions, err := h.sync.Store.RemoteDeletions(h.sync.User.Username)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	if c.SegmentsPerFile > 0 {
-		h.sync.Config.SegmentsPerFile = c.SegmentsPerFile
+	response := struct {
+		Deletions []*sync.RemoteDeletion `json:"deletions"`
+	}{
+		Deletions: deletions,
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 	}
+	return
+}
 
-	if c.MaxParallelFiles > 0 {
-		oldmax, newmax := int(h.sync.Config.MaxParallelFiles), int(c.MaxParallelFiles)
-		h.sync.Config.MaxParallelFiles = c.MaxParallelFiles
-		err = h.sync.AdjustConcurreny(newmax - oldmax)
+// handleAudit returns the state transitions in the audit log, newest first,
+// optionally of a single file.
+func (h *Handler) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var fileID int64
+	if v := r.FormValue("file_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
 		if err != nil {
-			h.sync.Printf("Error setting max parallel files: %v\n", err)
-			http.Error(w, "Error setting max parallel files", http.StatusBadRequest)
+			h.error(w, "invalid file id", http.StatusBadRequest)
 			return
 		}
+		fileID = id
 	}
 
-	h.sync.Config.IsPaused = c.IsPaused
-
-	h.sync.Config.DeleteRemoteFile = c.DeleteRemoteFile
+	var limit int
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			h.error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
 
-	err = h.sync.Store.SaveConfig(h.sync.Config, h.sync.User.Username)
+	entries, err := h.sync.Store.AuditLog(h.sync.User.Username, fileID, limit)
 	if err != nil {
-		h.sync.Printf("Error saving config: %v\n", err)
-		http.Error(w, "", http.StatusInternalServerError)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	response := struct {
-		Status string `json:"status"`
+		Entries []*sync.AuditEntry `json:"entries"`
 	}{
-		Status: "ok",
+		Entries: entries,
 	}
 	err = json.NewEncoder(w).Encode(&response)
 	if err != nil {
-		h.sync.Printf("Error encoding response: %v\n", err)
-		http.Error(w, "", http.StatusInternalServerError)
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "", http.StatusMethodNotAllowed)
+// handleExport writes the states and the download history as CSV or JSON,
+// with the selected columns.
+func (h *Handler) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
+	format := r.FormValue("format")
+	if format == "" {
+		format = sync.ExportCSV
+	}
+	if !sync.ValidExportFormat(format) {
+		h.error(w, "invalid export format", http.StatusBadRequest)
+		return
 	}
 
-	_ = h.sync.Stop()
-	_ = h.sync.DeleteToken()
+	var columns []string
+	if v := r.FormValue("columns"); v != "" {
+		columns = strings.Split(v, ",")
+		for _, column := range columns {
+			if !sync.ValidExportColumn(column) {
+				h.error(w, "unknown export column", http.StatusBadRequest)
+				return
+			}
+		}
+	}
 
-	response := struct {
-		Status string `json:"status"`
-	}{
-		Status: "ok",
+	if format == sync.ExportCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
 	}
-	err := json.NewEncoder(w).Encode(&response)
+	w.Header().Set("Content-Disposition", "attachment; filename=putio-sync."+format)
+
+	err := h.sync.Export(w, format, columns)
 	if err != nil {
-		h.sync.Printf("Error encoding response: %v\n", err)
-		http.Error(w, "", http.StatusInternalServerError)
+		h.sync.Errorf("Error exporting states: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 	}
-	return
 }
 
-func (h *Handler) handleClear(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Unsupported method", http.StatusBadRequest)
+func (h *Handler) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	states, err := h.sync.Store.States(h.sync.User.Username)
+	entries, err := h.sync.Store.HistoryEntries(h.sync.User.Username)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	for _, state := range states {
-		if state.DownloadStatus != sync.DownloadCompleted {
-			continue
-		}
-		state.IsHidden = true
-		_ = h.sync.Store.SaveState(state, h.sync.User.Username)
-	}
-
 	response := struct {
-		Status string `json:"status"`
+		History []*sync.HistoryEntry `json:"history"`
 	}{
-		Status: "ok",
+		History: entries,
 	}
 	err = json.NewEncoder(w).Encode(&response)
 	if err != nil {
-		h.sync.Printf("Error encoding response: %v\n", err)
-		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 	}
 	return
 }
 
-func (h *Handler) handleAddMagnet(w http.ResponseWriter, r *http.Request) {
-	h.sync.Debugf("add-magnet called\n")
+// handleThumbnail serves the cached Put.io screenshot of a file.
+func (h *Handler) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	if r.Method != "POST" {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	fileID, err := strconv.ParseInt(r.URL.Query().Get("file"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid file id", http.StatusBadRequest)
 		return
 	}
 
-	uri := r.FormValue("url")
-	if uri == "" {
-		http.Error(w, "empty magnet uri", http.StatusBadRequest)
+	// buffered, so that a failed request is still reported as an error
+	var thumbnail bytes.Buffer
+	err = h.sync.Thumbnail(r.Context(), fileID, &thumbnail)
+	switch err {
+	case nil:
+	case sync.ErrStateNotFound, sync.ErrNoThumbnail:
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	default:
+		h.error(w, err.Error(), http.StatusBadGateway)
 		return
 	}
 
-	magnetURI, err := base64.URLEncoding.DecodeString(uri)
-	if err != nil {
-		h.sync.Printf("Error decoding url: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// the API responses are JSON by default
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	thumbnail.WriteTo(w)
+}
+
+func (h *Handler) handleReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	transfer, err := h.sync.C.Transfers.Add(nil, string(magnetURI), h.sync.Config.DownloadFrom, "")
+	reports, err := h.sync.Store.Reports(h.sync.User.Username)
 	if err != nil {
-		h.sync.Printf("Error adding a new transfer: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = json.NewEncoder(w).Encode(&transfer)
+	response := struct {
+		Reports []*sync.Report `json:"reports"`
+	}{
+		Reports: reports,
+	}
+	err = json.NewEncoder(w).Encode(&response)
 	if err != nil {
-		h.sync.Printf("Error encoding response: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 	}
-
 	return
 }
 
-func (h *Handler) handleAddTorrent(w http.ResponseWriter, r *http.Request) {
-	h.sync.Debugf("add-magnet called\n")
-
-	if r.Method != "POST" {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+// handleErrors returns the error log on GET and clears it on POST.
+func (h *Handler) handleErrors(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		err := h.sync.Store.ClearErrors(h.sync.User.Username)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	torrentPath := r.FormValue("path")
-	if torrentPath == "" {
-		http.Error(w, "empty torrent path", http.StatusBadRequest)
+	entries, err := h.sync.Store.Errors(h.sync.User.Username)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	b, err := base64.URLEncoding.DecodeString(torrentPath)
+	response := struct {
+		Errors []*sync.ErrorEntry `json:"errors"`
+	}{
+		Errors: entries,
+	}
+	err = json.NewEncoder(w).Encode(&response)
 	if err != nil {
-		h.sync.Printf("Error decoding path: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 	}
+	return
+}
 
-	torrentPath = string(b)
-
-	if !exists(torrentPath) {
-		http.Error(w, "file not found", http.StatusBadRequest)
+func (h *Handler) handleExplain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	f, err := os.Open(torrentPath)
-	if err != nil {
-		h.sync.Printf("Error opening file: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	ref := r.FormValue("file")
+	if ref == "" {
+		h.error(w, "empty file", http.StatusBadRequest)
 		return
 	}
-	defer f.Close()
 
-	_, filename := filepath.Split(torrentPath)
-	upload, err := h.sync.C.Files.Upload(nil, f, filename, h.sync.Config.DownloadFrom)
+	explanation, err := h.sync.Explain(r.Context(), ref)
 	if err != nil {
-		h.sync.Printf("Error uploading file: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = json.NewEncoder(w).Encode(upload.Transfer)
+	err = json.NewEncoder(w).Encode(explanation)
 	if err != nil {
-		h.sync.Printf("Error encoding response: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 	}
-
 	return
 }
 
-func (h *Handler) handlePing(w http.ResponseWriter, r *http.Request) {
-	h.sync.Debugf("ping called\n")
+func (h *Handler) handleRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		var rules []sync.Rule
+		err := json.NewDecoder(r.Body).Decode(&rules)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-	if r.Method != "GET" {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		err = h.sync.SetRules(rules)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = h.sync.PushConfig(r.Context())
+		if err != nil {
+			h.sync.Errorf("Error pushing shared configuration: %v\n", err)
+		}
+	} else if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	_, err := h.sync.C.Account.Info(nil)
+	response := struct {
+		Rules []sync.Rule `json:"rules"`
+	}{
+		Rules: h.sync.Rules(),
+	}
+	err := json.NewEncoder(w).Encode(&response)
 	if err != nil {
-		h.sync.Printf("Error fetching account info: %v\n", err)
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 	}
-
 	return
 }
 
-func (h *Handler) handleGoToFile(w http.ResponseWriter, r *http.Request) {
-	h.sync.Debugf("go-to-file called\n")
-
+func (h *Handler) handleQueue(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
-		http.Error(w, "method now allowed", http.StatusMethodNotAllowed)
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	fileID, err := strconv.ParseInt(r.FormValue("id"), 0, 64)
+	queue, err := h.sync.QueueEstimate()
 	if err != nil {
-		h.sync.Debugf("invalid file id: %v\n", err)
-		http.Error(w, "invalid file id", http.StatusBadRequest)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	state, err := h.sync.Store.State(fileID, h.sync.User.Username)
-	if err == sync.ErrStateNotFound {
-		h.sync.Debugf("fetching state failed for %v: %v\n", fileID, err)
-		http.Error(w, "file not found", http.StatusBadRequest)
-		return
+	err = json.NewEncoder(w).Encode(&queue)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 	}
+	return
+}
 
-	if err != nil {
-		h.sync.Debugf("fetching state failed for %v: %v\n", fileID, err)
-		http.Error(w, "internal server error", http.StatusInternalServerError)
-		return
+// sameProfiles reports whether the custom transcode profiles are the same. No
+// profiles and an empty set of profiles are the same.
+func sameProfiles(a, b map[string]sync.TranscodeProfile) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
 	}
+	return reflect.DeepEqual(a, b)
+}
 
-	var cmd string
-	switch runtime.GOOS {
-	case "darwin":
-		cmd = "open"
-	case "linux":
-		cmd = "xdg-open"
+func (h *Handler) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		err := json.NewEncoder(w).Encode(h.sync.Config)
+		if err != nil {
+			h.sync.Errorf("Error encoding config: %v\n", err)
+			h.error(w, "", http.StatusInternalServerError)
+		}
+		return
 	}
 
-	if cmd == "" {
-		h.sync.Debugf("can't open file for this OS\n")
-		http.Error(w, "cant open file for this OS", http.StatusInternalServerError)
+	if r.Method != "POST" {
+		h.error(w, "", http.StatusMethodNotAllowed)
 		return
 	}
 
-	_ = exec.Command(cmd, state.LocalPath).Run()
-}
+	// New configuration POST'ed
 
-func (h *Handler) handleTree(w http.ResponseWriter, r *http.Request) {
+	var c sync.Config
+	err := json.NewDecoder(r.Body).Decode(&c)
+	if err != nil {
+		h.sync.Errorf("Error decoding config: %v\n", err)
+		h.error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	// ffmpeg runs the configured programs with the given arguments, they are
+	// changed only over the control socket or by provisioning
+	if !h.local && (c.FFmpegPath != h.sync.Config.FFmpegPath ||
+		c.FFprobePath != h.sync.Config.FFprobePath ||
+		!sameProfiles(c.CustomTranscodeProfiles, h.sync.Config.CustomTranscodeProfiles)) {
+		h.error(w, "ffmpeg settings can only be changed over the control socket", http.StatusForbidden)
+		return
+	}
+
+	if c.OAuth2Token != "" {
+		h.sync.Config.OAuth2Token = c.OAuth2Token
+		// RenewToken is called here since a new OAuth2 token is inplace and a
+		// new client associated with this token must be created.
+		err = h.sync.RenewToken()
+		if err != nil {
+			h.sync.Errorf("Error renewing token: %v\n", err)
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if c.PollInterval >= sync.Duration(time.Minute) {
+		h.sync.Config.PollInterval = c.PollInterval
+	}
+
+	h.sync.Config.AdaptivePolling = c.AdaptivePolling
+
+	if c.MaxPollInterval >= sync.Duration(time.Minute) {
+		h.sync.Config.MaxPollInterval = c.MaxPollInterval
+	}
+
+	if c.MoveCompletedTo != "" {
+		err = sync.ValidateLocalDir(c.MoveCompletedTo)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	h.sync.Config.MoveCompletedTo = c.MoveCompletedTo
+
+	if c.PollJitter >= 0 {
+		h.sync.Config.PollJitter = c.PollJitter
+	}
+
+	h.sync.Config.SkipPollActiveDownloads = c.SkipPollActiveDownloads
+
+	if c.DownloadTo != "" {
+		err = sync.ValidateLocalDir(c.DownloadTo)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.sync.Config.DownloadTo = c.DownloadTo
+	}
+
+	if c.DownloadFromPath != "" {
+		if h.sync.C == nil {
+			h.error(w, "no OAuth2 token, please log in", http.StatusBadRequest)
+			return
+		}
+		id, err := h.sync.ResolvePath(r.Context(), c.DownloadFromPath)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.DownloadFrom = id
+	}
+
+	if c.DownloadFrom >= 0 {
+		h.sync.Config.DownloadFrom = c.DownloadFrom
+	}
+
+	if c.FolderMappings != nil {
+		for _, m := range c.FolderMappings {
+			if m.DownloadFrom < 0 {
+				h.error(w, "invalid folder mapping", http.StatusBadRequest)
+				return
+			}
+			if err = sync.ValidateHeaders(m.Headers); err == nil {
+				err = sync.ValidateCookies(m.Cookies)
+			}
+			if err != nil {
+				h.error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		err = h.sync.ValidateMappings(r.Context(), c.FolderMappings)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.sync.Config.FolderMappings = c.FolderMappings
+	}
+
+	if err = sync.ValidateHeaders(c.Headers); err == nil {
+		err = sync.ValidateCookies(c.Cookies)
+	}
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, e := range c.VPNEgress {
+		err = sync.ValidateVPNEgress(e)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	err = sync.ValidateVPNCheckURL(c.VPNCheckURL)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.sync.Config.VPNInterface = c.VPNInterface
+	h.sync.Config.VPNEgress = c.VPNEgress
+	h.sync.Config.VPNCheckURL = c.VPNCheckURL
+
+	for _, server := range c.DNSServers {
+		err = sync.ValidateDNSServer(server)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	h.sync.Config.DNSServers = c.DNSServers
+	h.sync.Config.DNSCacheTTL = c.DNSCacheTTL
+	h.sync.Config.RaceEndpoints = c.RaceEndpoints
+	h.sync.Config.Headers = c.Headers
+	h.sync.Config.Cookies = c.Cookies
+
+	if c.SegmentsPerFile > 0 {
+		h.sync.SetSegmentsPerFile(c.SegmentsPerFile)
+	}
+
+	if c.SingleSegmentBelow >= 0 {
+		h.sync.Config.SingleSegmentBelow = c.SingleSegmentBelow
+	}
+
+	if c.MaxSegmentsAbove >= 0 {
+		h.sync.Config.MaxSegmentsAbove = c.MaxSegmentsAbove
+	}
+
+	if c.MaxParallelFiles > 0 {
+		err = h.sync.SetMaxParallelFiles(c.MaxParallelFiles)
+		if err != nil {
+			h.sync.Errorf("Error setting max parallel files: %v\n", err)
+			h.error(w, "Error setting max parallel files", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if c.ConflictPolicy != "" {
+		if !sync.ValidConflictPolicy(c.ConflictPolicy) {
+			h.error(w, "invalid conflict policy", http.StatusBadRequest)
+			return
+		}
+		h.sync.Config.ConflictPolicy = c.ConflictPolicy
+	}
+
+	if c.DuplicatePolicy != "" {
+		if !sync.ValidDuplicatePolicy(c.DuplicatePolicy) {
+			h.error(w, "invalid duplicate policy", http.StatusBadRequest)
+			return
+		}
+		h.sync.Config.DuplicatePolicy = c.DuplicatePolicy
+	}
+
+	if c.QueuePolicy != "" {
+		if !sync.ValidQueuePolicy(c.QueuePolicy) {
+			h.error(w, "invalid queue policy", http.StatusBadRequest)
+			return
+		}
+		h.sync.Config.QueuePolicy = c.QueuePolicy
+	}
+
+	h.sync.Config.MaxFilesPerTransfer = c.MaxFilesPerTransfer
+	h.sync.Config.FolderLimits = c.FolderLimits
+
+	if c.PartialNaming != "" {
+		if !sync.ValidPartialNaming(c.PartialNaming) {
+			h.error(w, "invalid partial naming", http.StatusBadRequest)
+			return
+		}
+		h.sync.Config.PartialNaming = c.PartialNaming
+	}
+	h.sync.Config.HidePartialFiles = c.HidePartialFiles
+	h.sync.Config.SkipHiddenFiles = c.SkipHiddenFiles
+	h.sync.Config.MirrorEmptyFolders = c.MirrorEmptyFolders
+	h.sync.Config.FlattenTransfers = c.FlattenTransfers
+	h.sync.Config.CollapseSingleFolders = c.CollapseSingleFolders
+	h.sync.Config.MaxDepth = c.MaxDepth
+
+	err = sync.ValidateRenameRules(c.RenameRules)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.sync.Config.RenameRules = c.RenameRules
+
+	err = h.sync.SetScript(c.Script)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.sync.Config.BackfillParallelFiles = c.BackfillParallelFiles
+	if c.BackfillSpeedLimit >= 0 {
+		h.sync.Config.BackfillSpeedLimit = c.BackfillSpeedLimit
+	}
+	err = h.sync.SetBackfill(c.Backfill)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if c.DownloadSpeedLimit >= 0 {
+		h.sync.SetSpeedLimit(c.DownloadSpeedLimit)
+	}
+
+	if !c.CacheListings && h.sync.Config.CacheListings {
+		err = h.sync.Store.ClearListings(h.sync.User.Username)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	h.sync.Config.CacheListings = c.CacheListings
+	if c.ListingCacheTTL >= 0 {
+		h.sync.Config.ListingCacheTTL = c.ListingCacheTTL
+	}
+
+	h.sync.Config.AutoTuneConcurrency = c.AutoTuneConcurrency
+	h.sync.Config.MinFreeMemory = c.MinFreeMemory
+	h.sync.Config.MaxMemory = c.MaxMemory
+
+	h.sync.Config.IsPaused = c.IsPaused
+
+	h.sync.Config.DeleteRemoteFile = c.DeleteRemoteFile
+	h.sync.Config.DeleteRate = c.DeleteRate
+	h.sync.Config.DeleteEmptyFolders = c.DeleteEmptyFolders
+
+	h.sync.Config.IgnoreFilesOlderThan = c.IgnoreFilesOlderThan
+
+	h.sync.Config.StartFromNow = c.StartFromNow
+
+	if c.UploadFolder != "" {
+		err = sync.ValidateLocalDir(c.UploadFolder)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	h.sync.Config.UploadFolder = c.UploadFolder
+
+	if c.UploadTo < 0 {
+		h.error(w, "invalid upload folder", http.StatusBadRequest)
+		return
+	}
+	h.sync.Config.UploadTo = c.UploadTo
+
+	h.sync.Config.DeleteUploaded = c.DeleteUploaded
+
+	h.sync.Config.ExtractArchives = c.ExtractArchives
+
+	h.sync.Config.CompressExtensions = c.CompressExtensions
+
+	err = sync.ValidatePipeline(c.Pipeline)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.sync.Config.Pipeline = c.Pipeline
+
+	err = sync.ValidatePipelines(c.Pipelines)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.sync.Config.Pipelines = c.Pipelines
+
+	h.sync.Config.StepRetries = c.StepRetries
+
+	err = sync.ValidateTranscodeProfile(c.TranscodeProfile, c.CustomTranscodeProfiles)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.sync.Config.TranscodeProfile = c.TranscodeProfile
+	h.sync.Config.CustomTranscodeProfiles = c.CustomTranscodeProfiles
+	h.sync.Config.FFmpegPath = c.FFmpegPath
+
+	h.sync.Config.SaveThumbnails = c.SaveThumbnails
+
+	h.sync.Config.TMDbAPIKey = c.TMDbAPIKey
+
+	h.sync.Config.ProbeMedia = c.ProbeMedia
+	h.sync.Config.FFprobePath = c.FFprobePath
+
+	if c.NotifyTemplate != "" {
+		_, err = sync.ParseNotifyTemplate(c.NotifyTemplate)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	h.sync.Config.NotifyURL = c.NotifyURL
+	h.sync.Config.NotifyTemplate = c.NotifyTemplate
+	h.sync.Config.SilenceAlarm = c.SilenceAlarm
+
+	if c.StatusFile != "" && !filepath.IsAbs(c.StatusFile) {
+		h.error(w, "status file must be an absolute path", http.StatusBadRequest)
+		return
+	}
+	h.sync.Config.StatusFile = c.StatusFile
+
+	if c.ManifestFile != "" && !filepath.IsAbs(c.ManifestFile) {
+		h.error(w, "manifest file must be an absolute path", http.StatusBadRequest)
+		return
+	}
+	h.sync.Config.ManifestFile = c.ManifestFile
+
+	if c.StatusSocket != "" && !filepath.IsAbs(c.StatusSocket) {
+		h.error(w, "status socket must be an absolute path", http.StatusBadRequest)
+		return
+	}
+	h.sync.Config.StatusSocket = c.StatusSocket
+
+	h.sync.Config.OTLPEndpoint = c.OTLPEndpoint
+
+	err = sync.ValidateUpdateChannel(c.UpdateChannel)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = c.Database.Validate()
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the memory map size takes effect after a restart, since reopening
+	// the database would fail the running transactions
+	h.sync.Config.Database = c.Database
+	h.sync.Store.ApplyOptions(c.Database)
+
+	h.sync.Config.Watchdog = c.Watchdog
+	h.sync.Config.WatchdogRestart = c.WatchdogRestart
+	h.sync.Config.SuspendThreshold = c.SuspendThreshold
+	h.sync.Config.WakeCatchUp = c.WakeCatchUp
+	h.sync.Config.PollOnDrain = c.PollOnDrain
+
+	if !sync.ValidPowerPolicy(c.OnBattery) || !sync.ValidPowerPolicy(c.OnMetered) {
+		h.error(w, "invalid power policy", http.StatusBadRequest)
+		return
+	}
+	h.sync.Config.OnBattery = c.OnBattery
+	h.sync.Config.OnMetered = c.OnMetered
+	h.sync.Config.ThrottleSpeedLimit = c.ThrottleSpeedLimit
+
+	h.sync.Config.EnableProfiling = c.EnableProfiling
+
+	h.sync.Config.CrashReports = c.CrashReports
+	h.sync.Config.CrashReportURL = c.CrashReportURL
+
+	h.sync.Config.AutoUpdate = c.AutoUpdate
+	h.sync.Config.UpdateChannel = c.UpdateChannel
+
+	h.sync.Config.MQTTBroker = c.MQTTBroker
+	h.sync.Config.MQTTUsername = c.MQTTUsername
+	h.sync.Config.MQTTPassword = c.MQTTPassword
+	h.sync.Config.MQTTTopicPrefix = c.MQTTTopicPrefix
+	h.sync.Config.MQTTDiscoveryPrefix = c.MQTTDiscoveryPrefix
+
+	h.sync.Config.SyncConfigRemotely = c.SyncConfigRemotely
+
+	err = sync.ValidateCallbackURL(c.CallbackURL)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.sync.Config.CallbackURL = c.CallbackURL
+
+	h.sync.Config.DownloadLeases = c.DownloadLeases
+
+	if c.Locale != "" {
+		if !sync.ValidLocale(c.Locale) {
+			h.error(w, "invalid locale", http.StatusBadRequest)
+			return
+		}
+		h.sync.Config.Locale = c.Locale
+	}
+
+	err = sync.ValidateTimeZone(c.TimeZone)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.sync.Config.TimeZone = c.TimeZone
+
+	err = h.sync.Store.SaveConfig(h.sync.Config, h.sync.User.Username)
+	if err != nil {
+		h.sync.Errorf("Error saving config: %v\n", err)
+		h.error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.sync.PushConfig(r.Context())
+	if err != nil {
+		h.sync.Errorf("Error pushing shared configuration: %v\n", err)
+	}
+
+	response := struct {
+		Status string `json:"status"`
+	}{
+		Status: "ok",
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, "", http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		h.error(w, "", http.StatusMethodNotAllowed)
+
+	}
+
+	_ = h.sync.Stop()
+	_ = h.sync.DeleteToken()
+
+	response := struct {
+		Status string `json:"status"`
+	}{
+		Status: "ok",
+	}
+	err := json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, "", http.StatusInternalServerError)
+	}
+	return
+}
+
+func (h *Handler) handleUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	users, err := h.sync.Store.Users()
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Users   []string `json:"users"`
+		Current string   `json:"current"`
+	}{
+		Users: users,
+	}
+	if h.sync.User != nil {
+		response.Current = h.sync.User.Username
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
+
+func (h *Handler) handlePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.FormValue("username")
+	if username == "" && h.sync.User != nil {
+		username = h.sync.User.Username
+	}
+	if username == "" {
+		h.error(w, "empty username", http.StatusBadRequest)
+		return
+	}
+
+	err := h.sync.PurgeUser(username)
+	if err == sync.ErrUserNotFound {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+	}{
+		Status: "ok",
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
+
+func (h *Handler) handleDoctor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	checks := h.sync.Diagnose(r.Context())
+	err := json.NewEncoder(w).Encode(checks)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
+
+func (h *Handler) handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	var secret string
+	switch r.Method {
+	case "GET":
+	case "POST":
+		name := r.FormValue("name")
+		if name == "" {
+			h.error(w, "empty name", http.StatusBadRequest)
+			return
+		}
+		role := sync.Role(r.FormValue("role"))
+		if !sync.ValidRole(role) {
+			h.error(w, "invalid role", http.StatusBadRequest)
+			return
+		}
+
+		var key *sync.APIKey
+		var err error
+		secret, key, err = sync.NewAPIKey(name, role)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = h.sync.Store.SaveAPIKey(key)
+		if err == sync.ErrAPIKeyExists {
+			h.error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case "DELETE":
+		err := h.sync.Store.DeleteAPIKey(r.FormValue("name"))
+		if err == sync.ErrAPIKeyNotFound {
+			h.error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keys, err := h.sync.Store.APIKeys()
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Keys []*sync.APIKey `json:"keys"`
+		// The new key, it is only shown once
+		Key string `json:"key,omitempty"`
+	}{
+		Keys: keys,
+		Key:  secret,
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
+
+func (h *Handler) handleBackups(w http.ResponseWriter, r *http.Request) {
+	var backup string
+	if r.Method == "POST" {
+		var err error
+		backup, err = h.sync.Store.Backup("manual")
+		if err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if backup == "" {
+			h.error(w, "backups are disabled", http.StatusBadRequest)
+			return
+		}
+	} else if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	backups, err := h.sync.Store.Backups()
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Backups []string `json:"backups"`
+		// The backup just taken
+		Backup string `json:"backup,omitempty"`
+	}{
+		Backups: backups,
+		Backup:  backup,
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
+
+func (h *Handler) handleClear(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		h.error(w, "Unsupported method", http.StatusBadRequest)
+		return
+	}
+
+	states, err := h.sync.Store.States(h.sync.User.Username)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, state := range states {
+		if state.DownloadStatus != sync.DownloadCompleted {
+			continue
+		}
+		state.IsHidden = true
+		_ = h.sync.Store.SaveState(state, h.sync.User.Username)
+	}
+
+	response := struct {
+		Status string `json:"status"`
+	}{
+		Status: "ok",
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, "Error encoding response", http.StatusInternalServerError)
+	}
+	return
+}
+
+// handleTransferCallback is called by Put.io when a transfer submitted with the
+// callback URL completes. It is authorized by the secret in the URL instead
+// of an API key.
+func (h *Handler) handleTransferCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.sync.ValidCallback(r.URL.Query().Get("secret")) {
+		h.error(w, "invalid secret", http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		h.error(w, "invalid transfer id", http.StatusBadRequest)
+		return
+	}
+
+	h.sync.Debugf("Callback of transfer %v\n", id)
+	err = h.sync.TransferCallback(id)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+	}{
+		Status: "ok",
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
+
+func (h *Handler) handleSyncNow(w http.ResponseWriter, r *http.Request) {
+	h.sync.Debugf("sync-now called\n")
+
+	if r.Method != "POST" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	folderID, err := strconv.ParseInt(r.FormValue("id"), 0, 64)
+	if err != nil {
+		h.error(w, "invalid folder id", http.StatusBadRequest)
+		return
+	}
+
+	recursive := r.FormValue("recursive") == "true"
+
+	err = h.sync.SyncNow(folderID, recursive)
+	if err != nil {
+		h.sync.Errorf("Error syncing folder %v: %v\n", folderID, err)
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+	}{
+		Status: "ok",
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
+
+// handleAdopt records the files already in the download roots as downloaded.
+// The sync must be stopped.
+func (h *Handler) handleAdopt(w http.ResponseWriter, r *http.Request) {
+	h.sync.Debugf("adopt called\n")
+
+	if r.Method != "POST" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	verify := r.FormValue("verify") == "true"
+
+	report, err := h.sync.Adopt(r.Context(), verify)
+	if err != nil {
+		h.sync.Errorf("Error adopting local files: %v\n", err)
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) handleConflicts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conflicts, err := h.sync.Store.Conflicts(h.sync.User.Username)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Conflicts []*sync.Conflict `json:"conflicts"`
+	}{
+		Conflicts: conflicts,
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
+
+func (h *Handler) handleAddMagnet(w http.ResponseWriter, r *http.Request) {
+	h.sync.Debugf("add-magnet called\n")
+
+	if r.Method != "POST" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	uri := r.FormValue("url")
+	if uri == "" {
+		h.error(w, "empty magnet uri", http.StatusBadRequest)
+		return
+	}
+
+	magnetURI, err := base64.URLEncoding.DecodeString(uri)
+	if err != nil {
+		h.sync.Errorf("Error decoding url: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	transfer, err := h.sync.AddTransfer(r.Context(), string(magnetURI), h.sync.Config.DownloadFrom)
+	if err != nil {
+		h.sync.Errorf("Error adding a new transfer: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(&transfer)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+
+	return
+}
+
+func (h *Handler) handleAddTorrent(w http.ResponseWriter, r *http.Request) {
+	h.sync.Debugf("add-magnet called\n")
+
+	if r.Method != "POST" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	torrentPath := r.FormValue("path")
+	if torrentPath == "" {
+		h.error(w, "empty torrent path", http.StatusBadRequest)
+		return
+	}
+
+	b, err := base64.URLEncoding.DecodeString(torrentPath)
+	if err != nil {
+		h.sync.Errorf("Error decoding path: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	torrentPath = string(b)
+
+	if !exists(torrentPath) {
+		h.error(w, "file not found", http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(torrentPath)
+	if err != nil {
+		h.sync.Errorf("Error opening file: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	_, filename := filepath.Split(torrentPath)
+	upload, err := h.sync.UploadTorrent(r.Context(), f, filename, h.sync.Config.DownloadFrom)
+	if err != nil {
+		h.sync.Errorf("Error uploading file: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(upload.Transfer)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	return
+}
+
+func (h *Handler) handlePing(w http.ResponseWriter, r *http.Request) {
+	h.sync.Debugf("ping called\n")
+
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, err := h.sync.C.Account.Info(nil)
+	if err != nil {
+		h.sync.Errorf("Error fetching account info: %v\n", err)
+		h.error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	return
+}
+
+// handleHealth responds with 503 Service Unavailable if the daemon is
+// unhealthy, so that it can be used as a plain HTTP health check.
+func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	health := h.sync.Health()
+	if !health.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	err := json.NewEncoder(w).Encode(health)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+	}
+	return
+}
+
+// handleUpdate reports the newest release of the channel on GET, and installs
+// it and restarts the daemon on POST.
+func (h *Handler) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	channel := r.FormValue("channel")
+	if channel == "" {
+		channel = h.sync.Config.UpdateChannel
+	}
+	err := sync.ValidateUpdateChannel(channel)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	release, err := h.sync.CheckUpdate(r.Context(), channel)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == "POST" && release != nil {
+		err = h.sync.UpdateAndRestart(r.Context(), release)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var response = struct {
+		Current string        `json:"current"`
+		Latest  *sync.Release `json:"latest"`
+	}{
+		Current: sync.Version,
+		Latest:  release,
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+	}
+	return
+}
+
+func (h *Handler) handleGoToFile(w http.ResponseWriter, r *http.Request) {
+	h.sync.Debugf("go-to-file called\n")
+
+	if r.Method != "GET" {
+		h.error(w, "method now allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fileID, err := strconv.ParseInt(r.FormValue("id"), 0, 64)
+	if err != nil {
+		h.sync.Debugf("invalid file id: %v\n", err)
+		h.error(w, "invalid file id", http.StatusBadRequest)
+		return
+	}
+
+	state, err := h.sync.Store.State(fileID, h.sync.User.Username)
+	if err == sync.ErrStateNotFound {
+		h.sync.Debugf("fetching state failed for %v: %v\n", fileID, err)
+		h.error(w, "file not found", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		h.sync.Debugf("fetching state failed for %v: %v\n", fileID, err)
+		h.error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var cmd string
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = "open"
+	case "linux":
+		cmd = "xdg-open"
+	}
+
+	if cmd == "" {
+		h.sync.Debugf("can't open file for this OS\n")
+		h.error(w, "cant open file for this OS", http.StatusInternalServerError)
+		return
+	}
+
+	_ = exec.Command(cmd, state.LocalPath).Run()
+}
+
+func (h *Handler) handleTree(w http.ResponseWriter, r *http.Request) {
 	parent := r.FormValue("parent")
 	if parent == "" {
 		parent = "/"
@@ -476,7 +1740,7 @@ func (h *Handler) handleTree(w http.ResponseWriter, r *http.Request) {
 	files, err := ioutil.ReadDir(parent)
 	if err != nil {
 		h.sync.Println(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -509,8 +1773,95 @@ func (h *Handler) handleTree(w http.ResponseWriter, r *http.Request) {
 
 	err = json.NewEncoder(w).Encode(&response)
 	if err != nil {
-		h.sync.Printf("Error encoding response: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	return
+}
+
+// handleRemotePath resolves a remote path to its file ID, or a file ID to its
+// path.
+func (h *Handler) handleRemotePath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var response struct {
+		ID   int64  `json:"id"`
+		Path string `json:"path"`
+	}
+
+	var err error
+	if v := r.FormValue("id"); v != "" {
+		response.ID, err = strconv.ParseInt(v, 0, 64)
+		if err != nil {
+			h.error(w, "invalid file id", http.StatusBadRequest)
+			return
+		}
+		response.Path, err = h.sync.RemotePath(r.Context(), response.ID)
+	} else {
+		response.Path = r.FormValue("path")
+		response.ID, err = h.sync.ResolvePath(r.Context(), response.Path)
+	}
+	switch err {
+	case nil:
+	case sync.ErrRemotePathNotFound:
+		h.error(w, err.Error(), http.StatusNotFound)
+		return
+	default:
+		h.error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) handleRemoteTree(w http.ResponseWriter, r *http.Request) {
+	h.sync.Debugf("remote-tree called\n")
+
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// root folder of the user
+	var parent int64
+	if v := r.FormValue("parent"); v != "" {
+		id, err := strconv.ParseInt(v, 0, 64)
+		if err != nil {
+			h.error(w, "invalid folder id", http.StatusBadRequest)
+			return
+		}
+		parent = id
+	}
+
+	depth := 1
+	if v := r.FormValue("depth"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 1 || d > sync.MaxRemoteTreeDepth {
+			h.error(w, "invalid depth", http.StatusBadRequest)
+			return
+		}
+		depth = d
+	}
+
+	folder, err := h.sync.RemoteTree(r.Context(), parent, depth)
+	if err != nil {
+		h.sync.Errorf("Error listing remote folder %v: %v\n", parent, err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(folder)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	return
@@ -520,3 +1871,147 @@ func exists(filename string) bool {
 	_, err := os.Stat(filename)
 	return !os.IsNotExist(err)
 }
+
+func (h *Handler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.FormValue("q")
+	if query == "" {
+		h.error(w, "empty search query", http.StatusBadRequest)
+		return
+	}
+
+	files, err := h.sync.Search(r.Context(), query)
+	if err != nil {
+		h.sync.Errorf("Error searching %q: %v\n", query, err)
+		h.error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	response := struct {
+		Files []putio.File `json:"files"`
+	}{
+		Files: files,
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
+
+// handleFetch queues the files with the given ids, regardless of the folder
+// mappings.
+func (h *Handler) handleFetch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ids []int64
+	for _, v := range r.Form["id"] {
+		id, err := strconv.ParseInt(v, 0, 64)
+		if err != nil {
+			h.error(w, "invalid file id", http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		h.error(w, "invalid file id", http.StatusBadRequest)
+		return
+	}
+
+	err = h.sync.Fetch(ids)
+	if err != nil {
+		h.sync.Errorf("Error fetching %v: %v\n", ids, err)
+		h.error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := struct {
+		Status string `json:"status"`
+	}{
+		Status: "ok",
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
+
+// handleAddURL submits a link to Put.io and downloads the result when the
+// transfer completes.
+func (h *Handler) handleAddURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	link := r.FormValue("url")
+	if link == "" {
+		h.error(w, "empty url", http.StatusBadRequest)
+		return
+	}
+
+	parent := h.sync.Config.DownloadFrom
+	if v := r.FormValue("parent"); v != "" {
+		id, err := strconv.ParseInt(v, 0, 64)
+		if err != nil {
+			h.error(w, "invalid folder id", http.StatusBadRequest)
+			return
+		}
+		parent = id
+	}
+
+	transfer, err := h.sync.AddURL(r.Context(), link, parent)
+	if err != nil {
+		h.sync.Errorf("Error adding a new transfer: %v\n", err)
+		h.error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(transfer)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
+
+func (h *Handler) handleURLTransfers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	transfers, err := h.sync.Store.URLTransfers(h.sync.User.Username)
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Transfers []*sync.URLTransfer `json:"transfers"`
+	}{
+		Transfers: transfers,
+	}
+	err = json.NewEncoder(w).Encode(&response)
+	if err != nil {
+		h.sync.Errorf("Error encoding response: %v\n", err)
+		h.error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return
+}
@@ -22,7 +22,7 @@ func CORSMiddleware(next http.Handler) http.Handler {
 		// Intercept OPTIONS requests
 		if r.Method == "OPTIONS" {
 			w.Header().Add("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Add("Access-Control-Allow-Headers", "Authorization, Cache-Control, Content-Type")
+			w.Header().Add("Access-Control-Allow-Headers", "Authorization, Cache-Control, Content-Type, X-API-Key")
 			w.WriteHeader(http.StatusOK)
 			return
 		}
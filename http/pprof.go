@@ -0,0 +1,59 @@
+package http
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"strings"
+)
+
+// pprofPrefix is the path of the profiling endpoints. They are under /api/ to
+// require an admin API key.
+const pprofPrefix = "/api/debug/pprof/"
+
+// maxProfileSeconds limits the duration of CPU profiles and execution traces.
+const maxProfileSeconds = 60
+
+// handlePprof serves the runtime profiles if profiling is enabled in the
+// configuration, e.g. "go tool pprof http://127.0.0.1:3000/api/debug/pprof/heap".
+// Unlike the rest of the API, profiles are never served without an API key.
+func (h *Handler) handlePprof(w http.ResponseWriter, r *http.Request) {
+	if !h.sync.Config.EnableProfiling {
+		h.error(w, "profiling is disabled", http.StatusNotFound)
+		return
+	}
+
+	keys, err := h.sync.Store.APIKeys()
+	if err != nil {
+		h.sync.Errorf("Error reading API keys: %v\n", err)
+		h.error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(keys) == 0 {
+		h.error(w, "profiling requires an admin api key", http.StatusForbidden)
+		return
+	}
+
+	if s := r.FormValue("seconds"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxProfileSeconds {
+			h.error(w, "invalid seconds", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// the handlers of net/http/pprof expect their default path
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/api")
+	switch r.URL.Path {
+	case "/debug/pprof/cmdline":
+		pprof.Cmdline(w, r)
+	case "/debug/pprof/profile":
+		pprof.Profile(w, r)
+	case "/debug/pprof/symbol":
+		pprof.Symbol(w, r)
+	case "/debug/pprof/trace":
+		pprof.Trace(w, r)
+	default:
+		pprof.Index(w, r)
+	}
+}
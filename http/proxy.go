@@ -0,0 +1,80 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SetBasePath mounts the web UI and the API under the URL prefix, e.g.
+// "/putio-sync", so that a reverse proxy can serve them alongside other
+// services.
+func (h *Handler) SetBasePath(prefix string) {
+	h.basePath = strings.TrimSuffix("/"+strings.Trim(prefix, "/"), "/")
+}
+
+// BasePath returns the URL prefix of the handler.
+func (h *Handler) BasePath() string {
+	return h.basePath
+}
+
+// SetTrustProxy makes the handler respect the X-Forwarded-* headers set by a
+// reverse proxy. It must be enabled only if the server is not reachable
+// bypassing the proxy, since the headers can be forged.
+func (h *Handler) SetTrustProxy(trust bool) {
+	h.trustProxy = trust
+}
+
+// forwarded applies the X-Forwarded-* headers to the request.
+func (h *Handler) forwarded(r *http.Request) {
+	if !h.trustProxy {
+		return
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		// the first address is the client, the rest are the proxies
+		client := strings.TrimSpace(strings.Split(xff, ",")[0])
+		if net.ParseIP(client) != nil {
+			r.RemoteAddr = net.JoinHostPort(client, "0")
+		}
+	}
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		r.Host = host
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		r.URL.Scheme = proto
+	}
+}
+
+// prefix returns the URL prefix the client sees in front of the handler
+// paths, including the prefix stripped by the reverse proxy.
+func (h *Handler) prefix(r *http.Request) string {
+	var prefix string
+	if h.trustProxy {
+		prefix = strings.TrimSuffix(r.Header.Get("X-Forwarded-Prefix"), "/")
+	}
+	return prefix + h.basePath
+}
+
+// stripBasePath removes the base path from the request URL. It reports false
+// if the request is handled already, i.e. it is outside of the base path or
+// redirected.
+func (h *Handler) stripBasePath(w http.ResponseWriter, r *http.Request) bool {
+	if h.basePath == "" {
+		return true
+	}
+
+	if r.URL.Path == h.basePath {
+		http.Redirect(w, r, h.prefix(r)+"/", http.StatusMovedPermanently)
+		return false
+	}
+
+	if !strings.HasPrefix(r.URL.Path, h.basePath+"/") {
+		http.NotFound(w, r)
+		return false
+	}
+
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, h.basePath)
+	r.URL.RawPath = ""
+	return true
+}
@@ -1,41 +1,95 @@
 package http
 
 import (
+	"crypto/tls"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/putdotio/putio-sync/sync"
 )
 
 const (
 	defaultAddr = ":3000"
+
+	// socketScheme is the prefix of the addresses of sockets.
+	socketScheme = "unix:"
 )
 
 // Server represent the HTTP interface to the sync client.
 type Server struct {
 	ln      net.Listener
 	Handler *Handler
-	Addr    string
+
+	// Address to listen on, host:port or unix:<path> to serve on a unix
+	// socket, or a named pipe on Windows, without opening a TCP port
+	Addr string
+
+	// Permissions of the socket if the server listens on a socket, and the
+	// group it belongs to
+	SocketMode  os.FileMode
+	SocketGroup string
+
+	// Serve over TLS with the given certificate and key files
+	CertFile string
+	KeyFile  string
+
+	// Serve over TLS with a generated self-signed certificate if no
+	// certificate is given
+	SelfSigned bool
 }
 
 // NewServer returns a new instance of Server.
 func NewServer(sync *sync.Client) *Server {
 	return &Server{
-		Handler: NewHandler(sync),
-		Addr:    defaultAddr,
+		Handler:    NewHandler(sync),
+		Addr:       defaultAddr,
+		SocketMode: 0600,
 	}
 }
 
 // Open opens up the underlying socket for the HTTP server.
 func (s *Server) Open() error {
-	ln, err := net.Listen("tcp", s.Addr)
+	var ln net.Listener
+	var err error
+	if path := s.SocketPath(); path != "" {
+		ln, err = ListenSocket(path, s.SocketMode, s.SocketGroup)
+	} else {
+		ln, err = net.Listen("tcp", s.Addr)
+	}
 	if err != nil {
 		return err
 	}
+
+	if s.TLS() {
+		var cert tls.Certificate
+		if s.CertFile != "" {
+			cert, err = tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		} else {
+			cert, err = selfSignedCert(filepath.Dir(s.Handler.sync.Store.Path()))
+		}
+		if err != nil {
+			ln.Close()
+			return err
+		}
+
+		ln = tls.NewListener(ln, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		})
+	}
+
 	s.ln = ln
 	return nil
 }
 
+// TLS reports whether the server serves over TLS.
+func (s *Server) TLS() bool {
+	return s.CertFile != "" || s.SelfSigned
+}
+
 // Close closes the underlying socket.
 func (s *Server) Close() error {
 	if s.ln == nil {
@@ -45,7 +99,17 @@ func (s *Server) Close() error {
 	return s.ln.Close()
 }
 
-// Port returns the port that the server is open on. Only valid after open.
+// SocketPath returns the path of the socket the server listens on. It is
+// empty if the server listens on a TCP port.
+func (s *Server) SocketPath() string {
+	if strings.HasPrefix(s.Addr, socketScheme) {
+		return strings.TrimPrefix(s.Addr, socketScheme)
+	}
+	return ""
+}
+
+// Port returns the port that the server is open on. Only valid after open,
+// and if the server listens on a TCP port.
 func (s *Server) Port() int {
 	return s.ln.Addr().(*net.TCPAddr).Port
 }
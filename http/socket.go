@@ -0,0 +1,76 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// pipePrefix is the namespace of the named pipes on Windows.
+const pipePrefix = `\\.\pipe\`
+
+// ListenSocket listens on the unix socket at path, or on the named pipe if
+// path is in the \\.\pipe\ namespace on Windows. Only the user running the
+// daemon can connect, unless mode permits the group or the others. If group
+// is given, the socket belongs to that group.
+func ListenSocket(path string, mode os.FileMode, group string) (net.Listener, error) {
+	if strings.HasPrefix(path, pipePrefix) {
+		return listenPipe(path, mode, group)
+	}
+
+	// a socket left behind by a crashed daemon refuses new listeners
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("socket %v is in use by another process", path)
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%v exists and is not a socket", path)
+		}
+		os.Remove(path)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(path, mode)
+	if err == nil && group != "" {
+		var gid int
+		gid, err = lookupGroup(group)
+		if err == nil {
+			err = os.Chown(path, -1, gid)
+		}
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// DialSocket connects to the unix socket or the named pipe at path.
+func DialSocket(ctx context.Context, path string) (net.Conn, error) {
+	if strings.HasPrefix(path, pipePrefix) {
+		return dialPipe(ctx, path)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", path)
+}
+
+// lookupGroup returns the ID of the group given by its name or ID.
+func lookupGroup(group string) (int, error) {
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		g, err = user.LookupGroupId(group)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return strconv.Atoi(g.Gid)
+}
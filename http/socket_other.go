@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+)
+
+var errNoPipes = errors.New("named pipes are only supported on Windows")
+
+func listenPipe(path string, mode os.FileMode, group string) (net.Listener, error) {
+	return nil, errNoPipes
+}
+
+func dialPipe(ctx context.Context, path string) (net.Conn, error) {
+	return nil, errNoPipes
+}
@@ -0,0 +1,274 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32 = syscall.NewLazyDLL("kernel32.dll")
+	advapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	procCreateNamedPipe           = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe          = kernel32.NewProc("ConnectNamedPipe")
+	procWaitNamedPipe             = kernel32.NewProc("WaitNamedPipeW")
+	procGetOverlappedResult       = kernel32.NewProc("GetOverlappedResult")
+	procConvertSecurityDescriptor = advapi32.NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+)
+
+// Win32 constants
+const (
+	pipeAccessDuplex          = 0x3
+	fileFlagFirstPipeInstance = 0x80000
+	pipeRejectRemoteClients   = 0x8
+	pipeUnlimitedInstances    = 255
+	pipeBufferSize            = 64 * 1024
+	pipeBusyWait              = 50 // milliseconds
+
+	securitySQOSPresent    = 0x100000
+	securityIdentification = 0x10000
+
+	sddlRevision1 = 1
+
+	errorPipeBusy      = syscall.Errno(231)
+	errorPipeConnected = syscall.Errno(535)
+)
+
+var errPipeClosed = errors.New("named pipe is closed")
+
+// pipeListener accepts connections on a named pipe. Every connection is
+// served by a separate instance of the pipe, the next instance is created
+// when the previous one is connected.
+type pipeListener struct {
+	path string
+	sa   *syscall.SecurityAttributes
+
+	mu     sync.Mutex
+	closed bool
+
+	// Instance waiting for a client, and whether Accept waits on it
+	handle    syscall.Handle
+	accepting bool
+}
+
+func listenPipe(path string, mode os.FileMode, group string) (net.Listener, error) {
+	sa, err := pipeSecurity(mode, group)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &pipeListener{path: path, sa: sa}
+
+	// the first instance fails if another process serves the pipe
+	l.handle, err = l.create(fileFlagFirstPipeInstance)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// pipeSecurity returns the security attributes giving access to the user, and
+// to the group or everyone if mode permits.
+func pipeSecurity(mode os.FileMode, group string) (*syscall.SecurityAttributes, error) {
+	u, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+
+	// user IDs and group IDs are SIDs on Windows
+	sddl := "D:P(A;;GA;;;SY)(A;;GA;;;" + u.Uid + ")"
+	if group != "" && mode&0060 != 0 {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return nil, err
+		}
+		sddl += "(A;;GRGW;;;" + g.Gid + ")"
+	}
+	if mode&0006 != 0 {
+		sddl += "(A;;GRGW;;;WD)"
+	}
+
+	s, err := syscall.UTF16PtrFromString(sddl)
+	if err != nil {
+		return nil, err
+	}
+	var sd uintptr
+	r, _, err := procConvertSecurityDescriptor.Call(uintptr(unsafe.Pointer(s)), sddlRevision1, uintptr(unsafe.Pointer(&sd)), 0)
+	if r == 0 {
+		return nil, err
+	}
+
+	return &syscall.SecurityAttributes{
+		Length:             uint32(unsafe.Sizeof(syscall.SecurityAttributes{})),
+		SecurityDescriptor: sd,
+	}, nil
+}
+
+// create creates a new instance of the pipe.
+func (l *pipeListener) create(flags uintptr) (syscall.Handle, error) {
+	name, err := syscall.UTF16PtrFromString(l.path)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	r, _, err := procCreateNamedPipe.Call(
+		uintptr(unsafe.Pointer(name)),
+		pipeAccessDuplex|syscall.FILE_FLAG_OVERLAPPED|flags,
+		pipeRejectRemoteClients,
+		pipeUnlimitedInstances,
+		pipeBufferSize,
+		pipeBufferSize,
+		0,
+		uintptr(unsafe.Pointer(l.sa)),
+	)
+	if syscall.Handle(r) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, err
+	}
+	return syscall.Handle(r), nil
+}
+
+// Accept waits for a client to connect to the pipe.
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, errPipeClosed
+	}
+	if l.handle == syscall.InvalidHandle {
+		h, err := l.create(0)
+		if err != nil {
+			l.mu.Unlock()
+			return nil, err
+		}
+		l.handle = h
+	}
+	h := l.handle
+	l.accepting = true
+	l.mu.Unlock()
+
+	err := connectPipe(h)
+
+	l.mu.Lock()
+	closed := l.closed
+	l.handle = syscall.InvalidHandle
+	l.accepting = false
+	l.mu.Unlock()
+
+	if closed {
+		err = errPipeClosed
+	}
+	if err != nil {
+		syscall.CloseHandle(h)
+		return nil, err
+	}
+	return newPipeConn(h, l.path), nil
+}
+
+// connectPipe waits for a client to connect to the instance of the pipe.
+func connectPipe(h syscall.Handle) error {
+	// the kernel writes to it until the operation completes, it must not be
+	// on the stack
+	o := new(syscall.Overlapped)
+
+	r, _, err := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(o)))
+	if r != 0 {
+		return nil
+	}
+	switch err {
+	case errorPipeConnected:
+		return nil
+	case syscall.ERROR_IO_PENDING:
+		var n uint32
+		r, _, err = procGetOverlappedResult.Call(uintptr(h), uintptr(unsafe.Pointer(o)), uintptr(unsafe.Pointer(&n)), 1)
+		if r == 0 {
+			return err
+		}
+		return nil
+	}
+	return err
+}
+
+// Close stops accepting connections. The connections accepted before are not
+// closed.
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	if l.handle == syscall.InvalidHandle {
+		return nil
+	}
+	if l.accepting {
+		// Accept closes the instance
+		return syscall.CancelIoEx(l.handle, nil)
+	}
+	err := syscall.CloseHandle(l.handle)
+	l.handle = syscall.InvalidHandle
+	return err
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr(l.path) }
+
+func dialPipe(ctx context.Context, path string) (net.Conn, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		// the server can't impersonate the client
+		h, err := syscall.CreateFile(
+			name,
+			syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+			0,
+			nil,
+			syscall.OPEN_EXISTING,
+			syscall.FILE_FLAG_OVERLAPPED|securitySQOSPresent|securityIdentification,
+			0,
+		)
+		if err == nil {
+			return newPipeConn(h, path), nil
+		}
+		if err != errorPipeBusy {
+			return nil, err
+		}
+
+		// all the instances are connected, the server creates a new one
+		procWaitNamedPipe.Call(uintptr(unsafe.Pointer(name)), pipeBusyWait)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// pipeConn is a connection over an instance of a named pipe. The handle is
+// opened for overlapped I/O, so the file is served by the runtime poller and
+// supports deadlines.
+type pipeConn struct {
+	*os.File
+	addr pipeAddr
+}
+
+func newPipeConn(h syscall.Handle, path string) *pipeConn {
+	return &pipeConn{
+		File: os.NewFile(uintptr(h), path),
+		addr: pipeAddr(path),
+	}
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.addr }
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
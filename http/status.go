@@ -0,0 +1,164 @@
+package http
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/putdotio/putio-sync/sync"
+)
+
+// statusRefresh is the number of seconds between automatic reloads of the
+// status page.
+const statusRefresh = 10
+
+// statusErrors is the number of recent errors on the status page.
+const statusErrors = 10
+
+// statusTemplate is a plain HTML page which works without JavaScript, for
+// constrained browsers and screen readers. Its texts are translated by t,
+// which is bound to the configured locale when the page is rendered.
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"bytes": sync.FormatBytes,
+	"t":     func(msg string) string { return msg },
+}).Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>putio-sync: {{.Status}}</title>
+</head>
+<body>
+<main>
+<h1>putio-sync</h1>
+<p role="status">{{.Status}}</p>
+{{with .Queue}}<p>{{printf (t "%v remaining at %v/s") (bytes .RemainingBytes) (bytes .Speed)}}{{if gt .ETA 0}}{{printf (t ", about %v seconds left") .ETA}}{{end}}</p>{{end}}
+{{if .Files}}
+<table>
+<caption>{{t "Downloads"}}</caption>
+<thead>
+<tr><th scope="col">{{t "File"}}</th><th scope="col">{{t "Size"}}</th><th scope="col">{{t "Status"}}</th><th scope="col">{{t "Progress"}}</th></tr>
+</thead>
+<tbody>
+{{range .Files}}<tr>
+<th scope="row">{{if .Thumbnail}}<img src="api/thumbnail?file={{.ID}}" alt="" width="80"> {{end}}{{.Name}}</th>
+<td>{{bytes .Size}}</td>
+<td>{{.Status}}{{with .Error}}: {{.}}{{end}}</td>
+<td><progress max="100" value="{{printf "%.0f" .Progress}}">{{printf "%.0f" .Progress}}%</progress></td>
+</tr>
+{{end}}</tbody>
+</table>
+{{else}}
+<p>{{t "No downloads"}}</p>
+{{end}}
+{{if .Errors}}
+<table>
+<caption>{{t "Recent errors"}}</caption>
+<thead>
+<tr><th scope="col">{{t "Error"}}</th><th scope="col">{{t "Count"}}</th><th scope="col">{{t "Last seen"}}</th></tr>
+</thead>
+<tbody>
+{{range .Errors}}<tr>
+<th scope="row">{{.Message}}</th>
+<td>{{.Count}}</td>
+<td><time datetime="{{.LastSeen.Format "2006-01-02T15:04:05Z07:00"}}">{{(.LastSeen.In $.Location).Format "2006-01-02 15:04"}}</time></td>
+</tr>
+{{end}}</tbody>
+</table>
+{{end}}
+</main>
+</body>
+</html>
+`))
+
+type statusFile struct {
+	ID        int64
+	Thumbnail bool
+	Name      string
+	Size      int64
+	Status    string
+	Error     string
+	Progress  float64
+}
+
+// handleStatusPage renders the status page on the server side. The page
+// reloads itself every statusRefresh seconds.
+func (h *Handler) handleStatusPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page := struct {
+		Lang     string
+		Location *time.Location
+		Refresh  int
+		Status   string
+		Queue    *sync.QueueEstimate
+		Files    []statusFile
+		Errors   []*sync.ErrorEntry
+	}{
+		Lang:     h.sync.Config.Locale,
+		Location: h.sync.Config.Location(),
+		Refresh:  statusRefresh,
+		Status:   h.sync.StatusLabel(),
+	}
+	if page.Lang == "" {
+		page.Lang = sync.DefaultLocale
+	}
+
+	if h.sync.User != nil {
+		states, err := h.sync.Store.States(h.sync.User.Username)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sort.Sort(ByDate(states))
+
+		for _, state := range states {
+			page.Files = append(page.Files, statusFile{
+				ID:        state.FileID,
+				Thumbnail: state.FileIcon != "",
+				Name:      state.FileName,
+				Size:      state.FileLength,
+				Status:    h.sync.T(state.DownloadStatus.Label()),
+				Error:     state.Error,
+				Progress:  state.Progress(),
+			})
+		}
+
+		errors, err := h.sync.Store.Errors(h.sync.User.Username)
+		if err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(errors) > statusErrors {
+			errors = errors[:statusErrors]
+		}
+		page.Errors = errors
+
+		queue, err := h.sync.QueueEstimate()
+		if err != nil {
+			h.error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if queue.RemainingBytes > 0 {
+			page.Queue = &queue
+		}
+	}
+
+	tmpl, err := statusTemplate.Clone()
+	if err != nil {
+		h.error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmpl.Funcs(template.FuncMap{"t": h.sync.T})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = tmpl.Execute(w, &page)
+	if err != nil {
+		h.sync.Errorf("Error rendering status page: %v\n", err)
+	}
+}
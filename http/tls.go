@@ -0,0 +1,105 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// self-signed certificate files, stored next to the database so that the
+// browsers need to trust the certificate only once.
+const (
+	selfSignedCertFile = "putio-sync.crt"
+	selfSignedKeyFile  = "putio-sync.key"
+)
+
+// selfSignedCert loads the self-signed certificate in dir, generating one if
+// it doesn't exist.
+func selfSignedCert(dir string) (tls.Certificate, error) {
+	certFile := filepath.Join(dir, selfSignedCertFile)
+	keyFile := filepath.Join(dir, selfSignedKeyFile)
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil {
+		return cert, nil
+	}
+	if !os.IsNotExist(err) {
+		return cert, err
+	}
+
+	err = generateCert(certFile, keyFile)
+	if err != nil {
+		return cert, err
+	}
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+// generateCert creates a self-signed certificate for localhost and the host
+// name, valid for ten years.
+func generateCert(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	hosts := []string{"localhost"}
+	if host, err := os.Hostname(); err == nil {
+		hosts = append(hosts, host)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"putio-sync"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              hosts,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = writePEM(keyFile, "EC PRIVATE KEY", keyDER, 0600)
+	if err != nil {
+		return err
+	}
+	return writePEM(certFile, "CERTIFICATE", der, 0644)
+}
+
+func writePEM(path, typ string, der []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	err = pem.Encode(f, &pem.Block{Type: typ, Bytes: der})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
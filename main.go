@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 
 	"github.com/putdotio/putio-sync/http"
 	"github.com/putdotio/putio-sync/sync"
@@ -17,24 +21,119 @@ func main() {
 	var (
 		serverFlag = flag.Bool("server", false, "Run in server mode")
 		debugFlag  = flag.Bool("debug", false, "Run in debug mode")
+		traceFlag  = flag.Bool("trace", false, "Log API requests and segment timings to a trace file")
+
+		tlsCertFlag       = flag.String("tls-cert", "", "Serve over TLS with this certificate file")
+		tlsKeyFlag        = flag.String("tls-key", "", "Private key file of the TLS certificate")
+		tlsSelfSignedFlag = flag.Bool("tls-self-signed", false, "Serve over TLS with a generated self-signed certificate")
+		basicAuthFlag     = flag.String("basic-auth", "", "Protect the web UI with basic auth credentials in user:password form (default $PUTIO_SYNC_BASIC_AUTH)")
+		basePathFlag      = flag.String("base-path", "", "Serve the web UI and the API under this URL prefix, e.g. /putio-sync")
+		trustProxyFlag    = flag.Bool("trust-proxy", false, "Respect the X-Forwarded-* headers of a reverse proxy")
+		addrFlag          = flag.String("addr", ":3000", "Address to serve the web UI on, host:port, or unix:<path> to serve on a socket without opening a TCP port")
+		socketFlag        = flag.String("socket", "", "Path of the control socket, or the named pipe on Windows (default ~/.putio-sync/"+sync.ControlSocketName+")")
+		socketModeFlag    = flag.String("socket-mode", "0600", "Permissions of the sockets in octal, e.g. 0660 to let the group in")
+		socketGroupFlag   = flag.String("socket-group", "", "Group the sockets belong to")
 	)
+	flag.Usage = usage
 	flag.Parse()
 
+	if flag.NArg() > 0 {
+		err := runCommand(flag.Arg(0), flag.Args()[1:])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	controlPath := *socketFlag
+	if controlPath == "" {
+		var err error
+		controlPath, err = sync.ControlSocketPath()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	socketMode, err := strconv.ParseUint(*socketModeFlag, 8, 32)
+	if err != nil || socketMode > 0777 {
+		log.Fatalf("invalid socket mode: %v\n", *socketModeFlag)
+	}
+
 	sync, err := sync.NewClient(*debugFlag)
 	if err != nil {
 		log.Fatalf("error creating new sync client: %v\n", err)
 	}
 
+	defer sync.RecoverCrash()
+
+	if *traceFlag {
+		err = sync.EnableTrace()
+		if err != nil {
+			log.Fatalf("error enabling trace: %v\n", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go sync.ServeMQTT(ctx)
+	go sync.ServeStatusSocket(ctx)
+	go sync.ServeUpdates(ctx)
+	go sync.WatchPower(ctx)
+	go sync.WatchVPN(ctx)
+
+	// the commands talk to the daemon over the control socket
+	control := http.NewControlServer(sync, controlPath)
+	control.Mode = os.FileMode(socketMode)
+	control.Group = *socketGroupFlag
+	err = control.Open()
+	if err != nil {
+		log.Printf("Error opening the control socket: %v\n", err)
+	} else {
+		go func() { _ = control.Serve() }()
+	}
+
 	var server *http.Server
 	if *serverFlag {
 		server = http.NewServer(sync)
+		server.Addr = *addrFlag
+		server.SocketMode = os.FileMode(socketMode)
+		server.SocketGroup = *socketGroupFlag
+		server.CertFile = *tlsCertFlag
+		server.KeyFile = *tlsKeyFlag
+		server.SelfSigned = *tlsSelfSignedFlag
+
+		server.Handler.SetBasePath(*basePathFlag)
+		server.Handler.SetTrustProxy(*trustProxyFlag)
+
+		// the credentials are read from the environment here, a default value
+		// of the flag would be printed in the usage
+		basicAuth := *basicAuthFlag
+		if basicAuth == "" {
+			basicAuth = os.Getenv("PUTIO_SYNC_BASIC_AUTH")
+		}
+		if basicAuth != "" {
+			i := strings.Index(basicAuth, ":")
+			if i <= 0 {
+				log.Fatalln("basic auth credentials must be in user:password form")
+			}
+			server.Handler.SetBasicAuth(basicAuth[:i], basicAuth[i+1:])
+		}
+
 		err = server.Open()
 		if err != nil {
 			log.Fatalln(err)
 		}
 
+		scheme := "http"
+		if server.TLS() {
+			scheme = "https"
+		}
+
 		go func() {
-			log.Printf("Visit 'http://127.0.0.1%v'\n", server.Addr)
+			if path := server.SocketPath(); path != "" {
+				log.Printf("Serving on %v\n", path)
+			} else {
+				log.Printf("Visit '%v://%v%v/'\n", scheme, visitAddr(server.Addr), server.Handler.BasePath())
+			}
 			log.Fatalln(server.Serve())
 		}()
 	} else {
@@ -47,8 +146,17 @@ func main() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt)
 
-	sig := <-sigCh
-	log.Printf("%q signal received, closing running tasks...\n", sig)
+	restart := false
+	select {
+	case sig := <-sigCh:
+		log.Printf("%q signal received, closing running tasks...\n", sig)
+	case <-sync.RestartRequested():
+		log.Printf("Restarting into the updated version, closing running tasks...\n")
+		restart = true
+	}
+
+	cancel()
+	_ = control.Close()
 
 	err = sync.Close()
 	if err != nil {
@@ -61,4 +169,24 @@ func main() {
 			log.Fatalln(err)
 		}
 	}
+
+	if restart {
+		err = restartProcess()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
+// visitAddr returns the address of the web UI to visit in the browser. The
+// loopback address is used if the server listens on every interface.
+func visitAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
 }
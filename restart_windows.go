@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// restartProcess starts a fresh process of the binary. There is no exec on
+// Windows, the caller exits after.
+func restartProcess() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Start()
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/putdotio/putio-sync/sync"
+)
+
+// tokenURL is where users create an OAuth2 token for the wizard.
+const tokenURL = "https://app.put.io/settings/account/oauth/apps"
+
+// prompter reads the answers of the setup wizard.
+type prompter struct {
+	r *bufio.Reader
+}
+
+// ask prints the question and returns the answer, or def if the answer is
+// empty.
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Printf("%v [%v]: ", question, def)
+	} else {
+		fmt.Printf("%v: ", question)
+	}
+
+	line, err := p.r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+// confirm asks a yes/no question.
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	d := "y/N"
+	if def {
+		d = "Y/n"
+	}
+	answer, err := p.ask(question, d)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	case "n", "no":
+		return false, nil
+	}
+	return def, nil
+}
+
+func runSetup(args []string) error {
+	if daemonRunning() {
+		return fmt.Errorf("putio-sync is running, stop it or use the web UI to set it up")
+	}
+
+	client, err := sync.NewClient(false)
+	if err != nil {
+		return fmt.Errorf("error opening the database, is the server running? %v", err)
+	}
+	defer client.Store.Close()
+
+	p := &prompter{r: bufio.NewReader(os.Stdin)}
+	fmt.Printf("This wizard sets up putio-sync. Press enter to keep the value in brackets.\n\n")
+
+	err = setupLogin(p, client)
+	if err != nil {
+		return err
+	}
+
+	err = setupDownloadFrom(p, client)
+	if err != nil {
+		return err
+	}
+
+	for {
+		dir, err := p.ask("Local directory to download to", client.Config.DownloadTo)
+		if err != nil {
+			return err
+		}
+		err = sync.ValidateLocalDir(dir)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			continue
+		}
+		client.Config.DownloadTo = dir
+		break
+	}
+
+	for {
+		answer, err := p.ask("Number of files to download at the same time", strconv.FormatUint(uint64(client.Config.MaxParallelFiles), 10))
+		if err != nil {
+			return err
+		}
+		n, err := strconv.ParseUint(answer, 10, 32)
+		if err != nil || n == 0 {
+			fmt.Printf("Please enter a positive number\n")
+			continue
+		}
+		client.Config.MaxParallelFiles = uint(n)
+		break
+	}
+
+	for {
+		answer, err := p.ask("Download speed limit in KB/s, 0 for unlimited", strconv.FormatInt(client.Config.DownloadSpeedLimit/1024, 10))
+		if err != nil {
+			return err
+		}
+		n, err := strconv.ParseInt(answer, 10, 64)
+		if err != nil || n < 0 {
+			fmt.Printf("Please enter a number\n")
+			continue
+		}
+		client.Config.DownloadSpeedLimit = n * 1024
+		break
+	}
+
+	err = client.Store.SaveConfig(client.Config, client.User.Username)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nConfiguration is saved. Run putio-sync to start syncing.\n")
+	return nil
+}
+
+// setupLogin asks for an OAuth2 token unless the current one is kept.
+func setupLogin(p *prompter, client *sync.Client) error {
+	if client.Config.OAuth2Token != "" && client.User != nil && client.User.Username != "" {
+		keep, err := p.confirm(fmt.Sprintf("Logged in as %v. Keep this account?", client.User.Username), true)
+		if err != nil || keep {
+			return err
+		}
+	}
+
+	fmt.Printf("Create an OAuth2 token at %v\n", tokenURL)
+	for {
+		token, err := p.ask("OAuth2 token", "")
+		if err != nil {
+			return err
+		}
+		if token == "" {
+			continue
+		}
+
+		client.Config.OAuth2Token = token
+		err = client.RenewToken()
+		if err != nil {
+			fmt.Printf("Token is not valid: %v\n", err)
+			continue
+		}
+		fmt.Printf("Logged in as %v\n", client.User.Username)
+		return nil
+	}
+}
+
+// setupDownloadFrom lets the user choose the Put.io folder to sync by
+// browsing the folders.
+func setupDownloadFrom(p *prompter, client *sync.Client) error {
+	fmt.Printf("\nChoose the Put.io folder to sync. Enter a number to open a folder, .. to go up\nand an empty line to choose the current folder.\n")
+
+	ctx := context.Background()
+	id := client.Config.DownloadFrom
+	if id < 0 {
+		id = 0
+	}
+
+	for {
+		files, parent, err := client.C.Files.List(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		var folders []int64
+		fmt.Printf("\n%v\n", folderName(parent.Name, id))
+		for _, f := range files {
+			if f.IsDir() {
+				folders = append(folders, f.ID)
+				fmt.Printf("%4v. %v\n", len(folders), f.Name)
+			}
+		}
+
+		answer, err := p.ask("Folder", "")
+		if err != nil {
+			return err
+		}
+
+		switch answer {
+		case "":
+			client.Config.DownloadFrom = id
+			fmt.Printf("Syncing %v\n\n", folderName(parent.Name, id))
+			return nil
+		case "..":
+			if id != 0 {
+				id = parent.ParentID
+			}
+		default:
+			n, err := strconv.Atoi(answer)
+			if err != nil || n < 1 || n > len(folders) {
+				fmt.Printf("Please enter a folder number\n")
+				continue
+			}
+			id = folders[n-1]
+		}
+	}
+}
+
+func folderName(name string, id int64) string {
+	if id == 0 {
+		return "Your Files"
+	}
+	return name
+}
@@ -0,0 +1,157 @@
+package sync
+
+import (
+	"context"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TriggerAdopt is the trigger of the states created for the files adopted
+// from the local library.
+const TriggerAdopt = "adopt"
+
+// AdoptReport is the outcome of adopting the local library.
+type AdoptReport struct {
+	// Local files recorded as downloaded
+	Adopted int `json:"adopted"`
+
+	// Remote files with a state already
+	Known int `json:"known"`
+
+	// Remote files without a local file
+	Missing int `json:"missing"`
+
+	// Local files of which the size or the checksum doesn't match the
+	// remote file. They are downloaded again by the sync.
+	Mismatched []string `json:"mismatched"`
+
+	Errors []string `json:"errors"`
+}
+
+// Adopt scans the download roots for the files of the folder mappings and
+// records those which are already there as downloaded, so that an existing
+// mirror isn't downloaded again. A local file matches the remote file at the
+// same path if their sizes are equal, and their CRC32 checksums too if verify
+// is true. The sync must be stopped while the files are adopted.
+func (c *Client) Adopt(ctx context.Context, verify bool) (*AdoptReport, error) {
+	c.mu.Lock()
+	running := c.CancelFunc != nil
+	c.mu.Unlock()
+
+	if running {
+		return nil, Error("sync must be stopped to adopt the local files")
+	}
+
+	report := &AdoptReport{
+		Mismatched: make([]string, 0),
+		Errors:     make([]string, 0),
+	}
+	for _, m := range c.Config.Mappings() {
+		err := c.adoptFolder(ctx, m, m.DownloadFrom, "/", "/", verify, report)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	c.Printf("Adopted %v local files, %v are missing and %v don't match\n",
+		report.Adopted, report.Missing, len(report.Mismatched))
+	return report, nil
+}
+
+// adoptFolder adopts the local files of the given Put.io folder and its
+// subfolders. The local paths are found the same way as walk does.
+func (c *Client) adoptFolder(ctx context.Context, m FolderMapping, id int64, cwd, localcwd string, verify bool, report *AdoptReport) error {
+	files, _, err := c.C.Files.List(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 1 && cwd != "/" && c.Config.CollapseSingleFolders {
+		localcwd = filepath.Dir(localcwd)
+	}
+
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		relpath := filepath.Join(cwd, file.Name)
+		if file.IsDir() {
+			err = c.adoptFolder(ctx, m, file.ID, relpath, filepath.Join(localcwd, file.Name), verify, report)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, err = c.Store.State(file.ID, c.User.Username)
+		if err == nil {
+			report.Known++
+			continue
+		}
+		if err != ErrStateNotFound {
+			return err
+		}
+
+		state := c.Config.newState(file, filepath.Join(m.DownloadTo, c.Config.flattenDir(localcwd)))
+		fi, err := os.Stat(state.LocalPath)
+		if os.IsNotExist(err) || (err == nil && fi.IsDir()) {
+			report.Missing++
+			continue
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+
+		if fi.Size() != file.Size {
+			report.Mismatched = append(report.Mismatched, state.LocalPath)
+			continue
+		}
+
+		if verify && file.CRC32 != "" {
+			sum, err := fileCRC32(state.LocalPath)
+			if err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			if sum != file.CRC32 {
+				report.Mismatched = append(report.Mismatched, state.LocalPath)
+				continue
+			}
+		}
+
+		for i := uint32(0); i < state.Bitfield.Len(); i++ {
+			state.Bitfield.Set(i)
+		}
+		state.DownloadStatus = DownloadCompleted
+		state.DownloadFinishedAt = time.Now().UTC()
+		state.DownloadedBy = c.DeviceInfo()
+		state.trigger = TriggerAdopt
+
+		c.recordHistory(state, state.LocalPath)
+		c.Debugf("Adopted local file %v\n", state.LocalPath)
+		report.Adopted++
+	}
+	return nil
+}
+
+// fileCRC32 returns the hex encoded CRC32 checksum of the file at path.
+func fileCRC32(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
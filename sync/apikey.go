@@ -0,0 +1,72 @@
+package sync
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"time"
+)
+
+// Role is the set of permissions of an API key.
+type Role string
+
+const (
+	// RoleReadOnly can only query the status and the metrics.
+	RoleReadOnly Role = "read-only"
+
+	// RoleAdmin can also change the configuration and act on the queue.
+	RoleAdmin Role = "admin"
+)
+
+// ValidRole reports whether role is a known role.
+func ValidRole(role Role) bool {
+	return role == RoleReadOnly || role == RoleAdmin
+}
+
+// Allows reports whether the role has the permissions of the required role.
+func (r Role) Allows(required Role) bool {
+	return r == RoleAdmin || r == required
+}
+
+const (
+	ErrAPIKeyExists   = Error("api key already exists")
+	ErrAPIKeyNotFound = Error("api key not found")
+)
+
+// APIKey is a credential for the HTTP API. Only the hash of the key is
+// stored.
+type APIKey struct {
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	Hash      string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewAPIKey generates a random API key and returns it along with the record
+// to store. The key itself can't be recovered from the record.
+func NewAPIKey(name string, role Role) (string, *APIKey, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := hex.EncodeToString(b)
+	return key, &APIKey{
+		Name:      name,
+		Role:      role,
+		Hash:      hashAPIKey(key),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Matches reports whether key is the API key of the record.
+func (k *APIKey) Matches(key string) bool {
+	return subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hashAPIKey(key))) == 1
+}
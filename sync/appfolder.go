@@ -0,0 +1,41 @@
+package sync
+
+import (
+	"context"
+)
+
+// appFolderName is the hidden Put.io folder in the root folder where
+// putio-sync keeps the files shared between devices. It is never downloaded.
+const appFolderName = ".putio-sync"
+
+// rootFolderID is the ID of the Put.io root folder.
+const rootFolderID = 0
+
+// appFolder returns the ID of the application folder, creating it if it
+// doesn't exist.
+func (c *Client) appFolder(ctx context.Context) (int64, error) {
+	c.appFolderMu.Lock()
+	defer c.appFolderMu.Unlock()
+
+	if c.appFolderID != 0 {
+		return c.appFolderID, nil
+	}
+
+	files, _, err := c.C.Files.List(ctx, rootFolderID)
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range files {
+		if f.Name == appFolderName && f.IsDir() {
+			c.appFolderID = f.ID
+			return f.ID, nil
+		}
+	}
+
+	f, err := c.C.Files.CreateFolder(ctx, appFolderName, rootFolderID)
+	if err != nil {
+		return 0, err
+	}
+	c.appFolderID = f.ID
+	return f.ID, nil
+}
@@ -0,0 +1,152 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// maxAuditEntries is the number of state transitions kept in the audit log.
+const maxAuditEntries = 10000
+
+// Triggers of the state transitions
+const (
+	TriggerPoller    = "poller"
+	TriggerAPI       = "api"
+	TriggerRetry     = "retry"
+	TriggerDuplicate = "duplicate"
+	TriggerLease     = "lease"
+)
+
+// AuditEntry is a download status transition of a file in the audit log. It
+// is encoded as Gob and stored to a persistent storage.
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	FileID     int64     `json:"file_id"`
+	DownloadID string    `json:"download_id"`
+	FileName   string    `json:"file_name"`
+
+	// From is empty when the state is saved for the first time
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// What queued the download: the poller, the API or the retry of the
+	// failed downloads, or what finished it without a download
+	Trigger string `json:"trigger"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type triggerKey struct{}
+
+// withTrigger returns a copy of ctx in which the states found are recorded
+// as changed by trigger.
+func withTrigger(ctx context.Context, trigger string) context.Context {
+	return context.WithValue(ctx, triggerKey{}, trigger)
+}
+
+// triggerOf returns the trigger carried by ctx, the poller if there is none.
+func triggerOf(ctx context.Context) string {
+	if trigger, ok := ctx.Value(triggerKey{}).(string); ok {
+		return trigger
+	}
+	return TriggerPoller
+}
+
+// markStored records the download status of a state as it is in the store.
+func (s *State) markStored() {
+	s.stored = true
+	s.storedStatus = s.DownloadStatus
+}
+
+// recordTransition appends the change of the download status of the state
+// to the audit log, if there is one since the state is last loaded or saved.
+func (t StoreTx) recordTransition(state *State) error {
+	if state.stored && state.storedStatus == state.DownloadStatus {
+		return nil
+	}
+
+	auditBkt := t.bucket(auditBucket)
+	if auditBkt == nil {
+		return nil
+	}
+
+	entry := AuditEntry{
+		Time:       time.Now().UTC(),
+		FileID:     state.FileID,
+		DownloadID: state.DownloadID,
+		FileName:   state.FileName,
+		To:         state.DownloadStatus.String(),
+		Trigger:    state.trigger,
+		Error:      state.Error,
+	}
+	if state.stored {
+		entry.From = state.storedStatus.String()
+	}
+	if entry.Trigger == "" {
+		entry.Trigger = TriggerPoller
+	}
+
+	seq, err := auditBkt.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	var value bytes.Buffer
+	err = gob.NewEncoder(&value).Encode(&entry)
+	if err != nil {
+		return err
+	}
+
+	err = auditBkt.Put(itob(int64(seq)), value.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// keys are sequential, so the entry falling out of the log is known
+	return auditBkt.Delete(itob(int64(seq) - maxAuditEntries))
+}
+
+// AuditLog returns the state transitions in the audit log, newest first. If
+// fileID is not zero, only the transitions of that file are returned. At most
+// limit entries are returned unless limit is zero.
+func (s *Store) AuditLog(forUser string, fileID int64, limit int) ([]*AuditEntry, error) {
+	entries := make([]*AuditEntry, 0)
+
+	if forUser == "" {
+		return entries, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		if userBkt == nil {
+			return ErrUserNotFound
+		}
+		auditBkt := userBkt.Bucket(auditBucket)
+		if auditBkt == nil {
+			return nil
+		}
+
+		cursor := auditBkt.Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			var entry AuditEntry
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entry)
+			if err != nil {
+				return err
+			}
+			if fileID != 0 && entry.FileID != fileID {
+				continue
+			}
+			entries = append(entries, &entry)
+			if limit > 0 && len(entries) == limit {
+				break
+			}
+		}
+		return nil
+	})
+
+	return entries, err
+}
@@ -0,0 +1,135 @@
+package sync
+
+import (
+	"context"
+
+	"github.com/boltdb/bolt"
+)
+
+// defaultBackfillFiles is the number of parallel downloads while backfilling
+// unless the configuration has another one.
+const defaultBackfillFiles = 1
+
+// backfillFiles returns the number of parallel downloads while backfilling.
+func (c *Config) backfillFiles() uint {
+	if c.BackfillParallelFiles > 0 {
+		return c.BackfillParallelFiles
+	}
+	return defaultBackfillFiles
+}
+
+// speedLimit returns the download speed limit in effect, the lower one of
+// DownloadSpeedLimit and BackfillSpeedLimit while backfilling. Zero means
+// unlimited.
+func (c *Config) speedLimit() int64 {
+	limit := c.DownloadSpeedLimit
+	if backfill := c.BackfillSpeedLimit; c.Backfill && backfill > 0 && (limit <= 0 || backfill < limit) {
+		limit = backfill
+	}
+	return limit
+}
+
+// Backfilled reports whether the Put.io folder is checkpointed as fully
+// downloaded by the backfill.
+func (s *Store) Backfilled(id int64, forUser string) (bool, error) {
+	var done bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		if userBkt == nil {
+			return ErrUserNotFound
+		}
+		done = userBkt.Bucket(backfillBucket).Get(itob(id)) != nil
+		return nil
+	})
+	return done, err
+}
+
+// SaveBackfilled checkpoints the Put.io folder as fully downloaded by the
+// backfill.
+func (s *Store) SaveBackfilled(id int64, forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		if userBkt == nil {
+			return ErrUserNotFound
+		}
+		return userBkt.Bucket(backfillBucket).Put(itob(id), []byte{})
+	})
+}
+
+// ClearBackfill removes the checkpoints of the backfill.
+func (s *Store) ClearBackfill(forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		err := userBkt.DeleteBucket(backfillBucket)
+		if err != nil {
+			return err
+		}
+		_, err = userBkt.CreateBucket(backfillBucket)
+		return err
+	})
+}
+
+// backfilled reports whether walk can skip the folder, since it is fully
+// downloaded by the backfill.
+func (c *Client) backfilled(id int64) bool {
+	done, err := c.Store.Backfilled(id, c.User.Username)
+	if err != nil {
+		c.Errorf("Error reading backfill checkpoint of folder %v: %v\n", id, err)
+		return false
+	}
+	return done
+}
+
+// checkpoint records the folder as fully downloaded by the backfill if
+// walking it and its subfolders queued no files and hit no errors. queued and
+// errors are the counts of the report before the folder is walked.
+func (c *Client) checkpoint(ctx context.Context, id int64, recursive bool, report *Report, queued, errors int) {
+	if !recursive || ctx.Err() != nil || report.Queued != queued || len(report.Errors) != errors {
+		return
+	}
+
+	err := c.Store.SaveBackfilled(id, c.User.Username)
+	if err != nil {
+		c.Errorf("Error saving backfill checkpoint of folder %v: %v\n", id, err)
+	}
+}
+
+// SetBackfill starts or stops the backfill. A new backfill starts without
+// checkpoints.
+func (c *Client) SetBackfill(backfill bool) error {
+	if backfill && !c.Config.Backfill {
+		err := c.Store.ClearBackfill(c.User.Username)
+		if err != nil {
+			return err
+		}
+	}
+	c.Config.Backfill = backfill
+	c.limiter.SetRate(c.Config.speedLimit())
+	c.queue.notify()
+	return nil
+}
+
+// finishBackfill ends the backfill once the download roots of every folder
+// mapping are fully downloaded. The sync goes on with the normal concurrency
+// and speed limit.
+func (c *Client) finishBackfill() {
+	for _, m := range c.Config.Mappings() {
+		if !c.backfilled(m.DownloadFrom) {
+			return
+		}
+	}
+
+	c.Printf("Backfill is finished, restoring the download limits\n")
+	c.Config.Backfill = false
+	err := c.Store.SaveConfig(c.Config, c.User.Username)
+	if err != nil {
+		c.Errorf("Error saving config: %v\n", err)
+	}
+	err = c.Store.ClearBackfill(c.User.Username)
+	if err != nil {
+		c.Errorf("Error clearing backfill checkpoints: %v\n", err)
+	}
+
+	c.limiter.SetRate(c.Config.speedLimit())
+	c.queue.notify()
+}
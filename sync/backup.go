@@ -0,0 +1,264 @@
+package sync
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// backupsDir is the directory of the database backups, next to the
+	// database.
+	backupsDir = "backups"
+
+	// defaultBackups is the number of backups kept if not configured.
+	defaultBackups = 5
+)
+
+var backupReasonRe = regexp.MustCompile(`[^a-zA-Z0-9.]+`)
+
+// Backup copies the database to a timestamped file in the backups folder
+// before a risky operation, e.g. an upgrade, and removes the oldest backups
+// beyond DatabaseOptions.Backups. It returns the path of the backup, or an
+// empty string if backups are disabled.
+func (s *Store) Backup(reason string) (string, error) {
+	keep := s.options.Backups
+	if keep == 0 {
+		keep = defaultBackups
+	}
+	if keep < 0 {
+		return "", nil
+	}
+
+	dir := filepath.Join(filepath.Dir(s.path), backupsDir)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	name := "putio-sync-" + time.Now().UTC().Format("20060102-150405")
+	if reason = backupReasonRe.ReplaceAllString(reason, "-"); reason != "" {
+		name += "-" + reason
+	}
+	path := filepath.Join(dir, name+".db")
+
+	f, err := ioutil.TempFile(dir, ".backup")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(f)
+		return err
+	})
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return "", err
+	}
+
+	backups, err := s.Backups()
+	if err != nil {
+		return path, err
+	}
+	for len(backups) > keep {
+		os.Remove(backups[len(backups)-1])
+		backups = backups[:len(backups)-1]
+	}
+	return path, nil
+}
+
+// Backups returns the paths of the database backups, newest first.
+func (s *Store) Backups() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(s.path), backupsDir, "putio-sync-*.db"))
+	if err != nil {
+		return nil, err
+	}
+
+	// the names sort by time
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	return paths, nil
+}
+
+// backupOnUpgrade backs up the database if it was last used by another
+// version of putio-sync, or by a version with an older record schema.
+func (s *Store) backupOnUpgrade() error {
+	const key = "version"
+	current := fmt.Sprintf("%v/%v.%v", Version, stateSchema, configSchema)
+
+	var last string
+	var used bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		last = string(tx.Bucket(defaultsBucket).Get([]byte(key)))
+		used = tx.Bucket(defaultsBucket).Get([]byte("current-user")) != nil
+		return nil
+	})
+	if err != nil || last == current {
+		return err
+	}
+
+	// a new database has nothing to lose
+	if used {
+		reason := "upgrade"
+		if last != "" {
+			reason = "upgrade-from-" + last
+		}
+		_, err = s.Backup(reason)
+		if err != nil {
+			return fmt.Errorf("backing up the database: %v", err)
+		}
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(defaultsBucket).Put([]byte(key), []byte(current))
+	})
+}
+
+// RestoreBackup replaces the database at path with the backup. The current
+// database is backed up first. It fails if the database is in use.
+func RestoreBackup(path, backup string) error {
+	// the backup is copied before the current database is backed up, which
+	// may remove it as the oldest backup
+	src, err := os.Open(backup)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".restore")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, src)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// the backup must be a sound database
+	check := NewStore(f.Name())
+	err = check.Open()
+	if err != nil {
+		return fmt.Errorf("backup can't be opened: %v", err)
+	}
+	err = check.Check()
+	if cerr := check.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("backup is corrupted: %v", err)
+	}
+
+	// opening the database makes sure that no daemon is using it
+	s := NewStore(path)
+	err = s.Open()
+	if err != nil {
+		return err
+	}
+	_, err = s.Backup("before-restore")
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// scrubBackups removes the records of the user from the backups. The backups
+// are copied without the user, since the pages freed by deleting a bucket
+// keep its data in the file.
+func (s *Store) scrubBackups(username string) error {
+	backups, err := s.Backups()
+	if err != nil {
+		return err
+	}
+	for _, backup := range backups {
+		err = scrubBackup(backup, username)
+		if err != nil {
+			return fmt.Errorf("scrubbing %v: %v", filepath.Base(backup), err)
+		}
+	}
+	return nil
+}
+
+func scrubBackup(path, username string) error {
+	src, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var found bool
+	err = src.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket([]byte(username)) != nil
+		return nil
+	})
+	if err != nil || !found {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".scrub")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	dst, err := bolt.Open(f.Name(), 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return err
+	}
+	err = src.View(func(stx *bolt.Tx) error {
+		return dst.Update(func(dtx *bolt.Tx) error {
+			err := stx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				if string(name) == username {
+					return nil
+				}
+				nb, err := dtx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(nb, b)
+			})
+			if err != nil {
+				return err
+			}
+			return forgetUser(dtx, username)
+		})
+	})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	src.Close()
+	return os.Rename(f.Name(), path)
+}
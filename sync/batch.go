@@ -0,0 +1,124 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/boltdb/bolt"
+)
+
+// StoreTx is a transaction of a user's records in Store.Batch. Its
+// operations are applied together when the batch function returns nil, and
+// none of them otherwise.
+type StoreTx struct {
+	tx   *bolt.Tx
+	user string
+	seen *seenIndex
+
+	// Stored status of the states saved in the transaction before it
+	saved *[]savedState
+}
+
+// savedState is the stored status of a state before it is saved, restored
+// if the transaction is rolled back.
+type savedState struct {
+	state        *State
+	stored       bool
+	storedStatus DownloadStatus
+}
+
+// Batch runs fn in a single write transaction of the user's records, instead
+// of a transaction per write. The transaction is shared with the concurrent
+// writes of the store, e.g. the pieces of the segments being downloaded, so
+// fn may be called more than once. fn must not call the methods of the Store
+// and must not have side effects outside of the transaction.
+func (s *Store) Batch(forUser string, fn func(tx StoreTx) error) error {
+	var saved []savedState
+	restore := func() {
+		for i := len(saved) - 1; i >= 0; i-- {
+			saved[i].state.stored, saved[i].state.storedStatus = saved[i].stored, saved[i].storedStatus
+		}
+		saved = saved[:0]
+	}
+	return s.groupUpdate(func(tx *bolt.Tx) error {
+		// executed again if another write of the group fails
+		restore()
+		err := fn(StoreTx{tx: tx, user: forUser, seen: &s.seen, saved: &saved})
+		if err != nil {
+			restore()
+		}
+		return err
+	})
+}
+
+// bucket returns the bucket of the user with the given name.
+func (t StoreTx) bucket(name []byte) *bolt.Bucket {
+	return t.tx.Bucket([]byte(t.user)).Bucket(name)
+}
+
+// SaveState inserts or updates the given state.
+func (t StoreTx) SaveState(state *State) error {
+	value, err := encodeState(state)
+	if err != nil {
+		return err
+	}
+	err = t.bucket(downloadItemsBucket).Put(itob(state.FileID), value)
+	if err != nil {
+		return err
+	}
+	err = t.recordTransition(state)
+	if err != nil {
+		return err
+	}
+	if t.saved != nil {
+		*t.saved = append(*t.saved, savedState{state, state.stored, state.storedStatus})
+	}
+	state.markStored()
+
+	id, completed := state.FileID, state.DownloadStatus == DownloadCompleted
+	t.tx.OnCommit(func() { t.seen.set(t.user, id, completed) })
+	return nil
+}
+
+// State returns a state by the given file ID.
+func (t StoreTx) State(id int64) (*State, error) {
+	value := t.bucket(downloadItemsBucket).Get(itob(id))
+	if value == nil {
+		return nil, ErrStateNotFound
+	}
+
+	var state State
+	err := decodeState(value, &state)
+	if err != nil {
+		return nil, err
+	}
+	state.ensureDownloadID()
+	state.markStored()
+	return &state, nil
+}
+
+// SaveHistory records the downloaded file with the given checksum and size
+// in the history index.
+func (t StoreTx) SaveHistory(crc32 string, size int64, entry *HistoryEntry) error {
+	var value bytes.Buffer
+	err := gob.NewEncoder(&value).Encode(entry)
+	if err != nil {
+		return err
+	}
+	return t.bucket(historyBucket).Put(historyKey(crc32, size), value.Bytes())
+}
+
+// History looks up a downloaded file by its checksum and size.
+func (t StoreTx) History(crc32 string, size int64) (*HistoryEntry, error) {
+	value := t.bucket(historyBucket).Get(historyKey(crc32, size))
+	if value == nil {
+		return nil, ErrHistoryNotFound
+	}
+
+	var entry HistoryEntry
+	err := gob.NewDecoder(bytes.NewReader(value)).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
@@ -0,0 +1,99 @@
+package sync
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/url"
+	"strings"
+
+	"github.com/igungor/go-putio/putio"
+)
+
+// CallbackPath is the path of the transfer callbacks under the callback URL.
+const CallbackPath = "/api/transfer-callback"
+
+// ValidateCallbackURL checks that the callback URL is an absolute HTTP URL.
+func ValidateCallbackURL(s string) error {
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Error("invalid callback url")
+	}
+	return nil
+}
+
+// callbackURL returns the URL Put.io calls back when a transfer completes. It
+// is empty if no callback URL is configured or there is no user to store its
+// secret.
+func (c *Client) callbackURL() string {
+	if c.Config.CallbackURL == "" || c.User == nil {
+		return ""
+	}
+
+	if c.Config.CallbackSecret == "" {
+		b := make([]byte, 16)
+		_, err := rand.Read(b)
+		if err != nil {
+			c.Errorf("Error generating the callback secret: %v\n", err)
+			return ""
+		}
+		c.Config.CallbackSecret = hex.EncodeToString(b)
+		err = c.Store.SaveConfig(c.Config, c.User.Username)
+		if err != nil {
+			c.Errorf("Error saving the callback secret: %v\n", err)
+			return ""
+		}
+	}
+
+	return strings.TrimSuffix(c.Config.CallbackURL, "/") + CallbackPath + "?secret=" + c.Config.CallbackSecret
+}
+
+// AddTransfer starts a transfer of the torrent or magnet URL in the parent
+// folder, like UploadTorrent. Unlike AddURL, the transfer isn't tracked, its
+// files are found by the polls.
+func (c *Client) AddTransfer(ctx context.Context, link string, parent int64) (putio.Transfer, error) {
+	return c.C.Transfers.Add(ctx, link, parent, c.callbackURL())
+}
+
+// ValidCallback reports whether the secret of the callback request is the
+// configured one.
+func (c *Client) ValidCallback(secret string) bool {
+	return c.Config.CallbackSecret != "" &&
+		subtle.ConstantTimeCompare([]byte(secret), []byte(c.Config.CallbackSecret)) == 1
+}
+
+// TransferCallback handles the callback of Put.io for a transfer submitted
+// with the callback URL. A tracked transfer is checked, and its files are
+// queued at once if it is completed. Put.io only tells the transfer ID, the
+// status is read from the API. The other transfers, e.g. of the torrents,
+// complete into the polled folders, which are polled at once.
+func (c *Client) TransferCallback(id int64) error {
+	c.mu.Lock()
+	ctx := c.Ctx
+	running := c.CancelFunc != nil
+	c.mu.Unlock()
+
+	if !running {
+		// it is picked up by the next poll
+		return nil
+	}
+
+	transfers, err := c.Store.URLTransfers(c.User.Username)
+	if err != nil {
+		return err
+	}
+	for _, u := range transfers {
+		if u.TransferID == id {
+			if !u.done() {
+				go c.checkURLTransfer(ctx, u)
+			}
+			return nil
+		}
+	}
+	c.pollSoon()
+	return nil
+}
@@ -0,0 +1,95 @@
+package sync
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// clockCheckInterval is the interval of comparing the wall clock to the
+	// monotonic clock.
+	clockCheckInterval = 5 * time.Second
+
+	// defaultSuspendThreshold is the jump of the wall clock considered a
+	// suspend unless the configuration has another one.
+	defaultSuspendThreshold = 30 * time.Second
+)
+
+// suspendThreshold returns the jump of the wall clock ahead of the monotonic
+// clock which is considered a suspend. It is zero if the detection is
+// disabled.
+func (c *Config) suspendThreshold() time.Duration {
+	switch d := time.Duration(c.SuspendThreshold); {
+	case d < 0:
+		return 0
+	case d == 0:
+		return defaultSuspendThreshold
+	default:
+		return d
+	}
+}
+
+// watchClock detects the system suspends and the wall clock changes until ctx
+// is cancelled. The monotonic clock doesn't advance while the system sleeps,
+// so the difference of the wall clock and the monotonic clock between two
+// checks is the time slept, or the change of the wall clock.
+func (c *Client) watchClock(ctx context.Context) {
+	defer c.RecoverCrash()
+
+	ticker := time.NewTicker(clockCheckInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		now := time.Now()
+		// Round(0) strips the monotonic reading
+		jump := now.Round(0).Sub(last.Round(0)) - now.Sub(last)
+		last = now
+
+		threshold := c.Config.suspendThreshold()
+		if threshold == 0 {
+			continue
+		}
+		switch {
+		case jump >= threshold:
+			c.resumed(jump)
+		case jump <= -threshold:
+			c.Printf("Wall clock is set back by %v\n", -jump)
+			c.shiftStarts(jump)
+		}
+	}
+}
+
+// resumed resets the throughput measurements after the system is suspended
+// for d, or the wall clock is set forward by d. The timers don't advance while
+// the system sleeps, so a poll is made immediately if the system slept through
+// a poll and Config.WakeCatchUp is set.
+func (c *Client) resumed(d time.Duration) {
+	c.Printf("System is resumed after %v, or the wall clock is set forward\n", d.Round(time.Second))
+	c.meter.Reset()
+	c.shiftStarts(d)
+
+	if c.Config.WakeCatchUp && d >= time.Duration(c.Config.PollInterval) {
+		c.Printf("Polling to catch up with the changes while the system slept\n")
+		c.pollSoon()
+	}
+}
+
+// shiftStarts moves the start of the active downloads by d, so that their
+// speeds, computed from the wall clock, don't count the time slept or the
+// change of the clock.
+func (c *Client) shiftStarts(d time.Duration) {
+	for _, t := range c.Tasks.List() {
+		t.state.mu.Lock()
+		if !t.state.DownloadStartedAt.IsZero() {
+			t.state.DownloadStartedAt = t.state.DownloadStartedAt.Add(d)
+		}
+		t.state.mu.Unlock()
+	}
+}
@@ -0,0 +1,86 @@
+package sync
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CompressionGzip is the only supported compression. Compressed files get
+// the .gz extension and can be read with the standard tools.
+const CompressionGzip = "gzip"
+
+const gzipExtension = ".gz"
+
+// maxCompressSize is the size of the largest file compressed. Compression is
+// meant for small text files, the larger files are kept as they are.
+const maxCompressSize = 64 * 1024 * 1024
+
+const errCompressedExists = Error("compressed file already exists")
+
+// shouldCompress reports whether the file at path has one of the configured
+// extensions.
+func (c *Config) shouldCompress(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == "" {
+		return false
+	}
+	for _, e := range c.CompressExtensions {
+		if strings.ToLower("."+strings.TrimPrefix(e, ".")) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// compressFile compresses the file at path to path+".gz" and removes the
+// original.
+func compressFile(path string) (string, error) {
+	dst := path + gzipExtension
+	if exists(dst) {
+		return "", errCompressedExists
+	}
+
+	err := transformFile(path, dst, func(w io.Writer, r io.Reader) error {
+		zw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+		if err != nil {
+			return err
+		}
+		zw.Name = filepath.Base(path)
+		_, err = io.Copy(zw, r)
+		if err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+	if err != nil {
+		return "", err
+	}
+	return dst, os.Remove(path)
+}
+
+// compressCompleted compresses the completed download of the task if its
+// extension is configured for compression and it is not larger than
+// maxCompressSize. The compression is recorded in
+// the state.
+func (c *Client) compressCompleted(t *Task) error {
+	info, err := os.Stat(t.state.LocalPath)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || info.Size() > maxCompressSize || !c.Config.shouldCompress(t.state.LocalPath) {
+		return nil
+	}
+
+	dst, err := compressFile(t.state.LocalPath)
+	if err != nil {
+		return err
+	}
+
+	c.Debugf("Compressed %v to %v\n", t.state.LocalPath, dst)
+	t.state.LocalPath = dst
+	t.state.Compression = CompressionGzip
+	return c.Store.SaveState(t.state, c.User.Username)
+}
@@ -2,6 +2,9 @@ package sync
 
 import (
 	"encoding"
+	"encoding/json"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -12,6 +15,10 @@ const (
 	defaultMaxParallelFiles = 2
 	defaultDownloadFrom     = -1
 	defaultPollInterval     = 2 * time.Minute
+	defaultMaxPollInterval  = 30 * time.Minute
+
+	defaultSingleSegmentBelow = 50 * 1024 * 1024
+	defaultMaxSegmentsAbove   = 2 * 1024 * 1024 * 1024
 
 	limitSegmentsPerFile = 8
 	limitParallelFiles   = 8
@@ -22,18 +29,96 @@ type Config struct {
 	// Walk DownloadFrom directory for every n interval
 	PollInterval Duration `json:"poll-interval"`
 
+	// Double the poll interval after every poll which finds no new files, up
+	// to MaxPollInterval. The interval is reset once new files are found.
+	AdaptivePolling bool `json:"adaptive-polling"`
+
+	// Upper limit of the poll interval for adaptive polling
+	MaxPollInterval Duration `json:"max-poll-interval"`
+
+	// Move completed downloads to this directory. Files are downloaded to
+	// DownloadTo and stay there if empty.
+	MoveCompletedTo string `json:"move-completed-to"`
+
+	// Add a random delay up to this duration to every poll interval, so that
+	// many clients don't hit the API at the same time
+	PollJitter Duration `json:"poll-jitter"`
+
+	// Skip polls while at least this many downloads are active. Zero disables
+	// skipping.
+	SkipPollActiveDownloads uint `json:"skip-poll-active-downloads"`
+
 	// Download Put.io files to this directory
 	DownloadTo string `json:"download-to"`
 
 	// Download files only in this directory (Put.io file ID)
 	DownloadFrom int64 `json:"download-from"`
 
+	// Slash separated path of the Put.io folder, e.g. "/Movies". It is
+	// resolved into DownloadFrom when the configuration is saved.
+	DownloadFromPath string `json:"download-from-path,omitempty"`
+
 	// Max number of connections to server for each download
 	SegmentsPerFile uint `json:"segments-per-file"`
 
+	// Files smaller than this size, in bytes, are downloaded with a single
+	// connection. Zero disables the threshold.
+	SingleSegmentBelow int64 `json:"single-segment-below"`
+
+	// Files larger than this size, in bytes, are downloaded with the maximum
+	// number of connections allowed. Zero disables the threshold.
+	MaxSegmentsAbove int64 `json:"max-segments-above"`
+
 	// Max number of parallel file downloads
 	MaxParallelFiles uint `json:"max-parallel-files"`
 
+	// Limit total download speed to this many bytes per second. Zero means
+	// unlimited.
+	DownloadSpeedLimit int64 `json:"download-speed-limit"`
+
+	// Adjust MaxParallelFiles and SegmentsPerFile at runtime to maximize
+	// throughput within DownloadSpeedLimit
+	AutoTuneConcurrency bool `json:"auto-tune-concurrency"`
+
+	// Order of the downloads, "fifo", "smallest-first", "largest-first" or
+	// "round-robin", "fifo" if empty
+	QueuePolicy string `json:"queue-policy"`
+
+	// Download at most this many files of a transfer at once, so that a
+	// large transfer doesn't starve the others. Unlimited if zero.
+	MaxFilesPerTransfer uint `json:"max-files-per-transfer"`
+
+	// Download at most the given number of files at once from each remote
+	// folder, e.g. {"/4K": 1}. The folders are relative to the remote folder
+	// of the mapping, and the innermost one applies.
+	FolderLimits map[string]uint `json:"folder-limits"`
+
+	// Mirror a large existing library slowly: download at most
+	// BackfillParallelFiles files at once (1 if zero), no faster than
+	// BackfillSpeedLimit bytes per second (DownloadSpeedLimit if zero), and
+	// checkpoint the remote folders which are fully downloaded, so that they
+	// are not walked again after a restart. New files in those folders are
+	// found after the backfill. It is turned off once every download root is
+	// fully downloaded.
+	Backfill              bool  `json:"backfill"`
+	BackfillParallelFiles uint  `json:"backfill-parallel-files"`
+	BackfillSpeedLimit    int64 `json:"backfill-speed-limit"`
+
+	// Cache the remote folder listings, and list a folder again only if its
+	// size is changed or its listing is older than ListingCacheTTL (24 hours
+	// if zero). A cached listing with an ETag is revalidated by a conditional
+	// request, which finds the changes that keep the size of a folder, e.g.
+	// renames. Without an ETag, those are found late. It saves many API calls
+	// and transfers on large libraries.
+	CacheListings   bool     `json:"cache-listings"`
+	ListingCacheTTL Duration `json:"listing-cache-ttl"`
+
+	// Download a single file with a single segment while the system has
+	// less free memory than this many bytes, or the process uses more than
+	// MaxMemory bytes. Disabled if zero.
+	MinFreeMemory int64 `json:"min-free-memory"`
+	MaxMemory     int64 `json:"max-memory"`
+
 	// User's OAuth2 token for this application
 	OAuth2Token string `json:"oauth2-token"`
 
@@ -43,11 +128,366 @@ type Config struct {
 	// User's prefered folder to watch for new .torrent files
 	TorrentsFolder string `json:"torrents-folder"`
 
+	// Upload every file dropped into this local directory to Put.io.
+	// Uploading is disabled if empty.
+	UploadFolder string `json:"upload-folder"`
+
+	// Put.io folder ID to upload files to. Zero is the root folder.
+	UploadTo int64 `json:"upload-to"`
+
+	// Delete the local file after a successful upload
+	DeleteUploaded bool `json:"delete-uploaded"`
+
 	// Last pause/resume state
 	IsPaused bool `json:"is-paused"`
 
 	// Delete the remote file after a successful download
 	DeleteRemoteFile bool `json:"delete-remotefile"`
+
+	// Remote files deleted per minute at most, 30 if zero. The deletions are
+	// queued and retried if they fail.
+	DeleteRate uint `json:"delete-rate"`
+
+	// Levels of remote folders deleted once they are emptied by the remote
+	// deletions, 0 keeps the folders. 1 deletes the folder of the file, 2
+	// its parent too, and so on. Download roots are never deleted.
+	DeleteEmptyFolders uint `json:"delete-empty-folders"`
+
+	// Don't download the remote files added before this time. Files which
+	// are already being downloaded are not affected.
+	IgnoreFilesOlderThan time.Time `json:"ignore-files-older-than"`
+
+	// Set IgnoreFilesOlderThan to the time of the first poll, so that a new
+	// installation doesn't download the existing library
+	StartFromNow bool `json:"start-from-now"`
+
+	// What to do when a download would replace an existing local file. One
+	// of "overwrite", "keep-local" or "rename".
+	ConflictPolicy string `json:"conflict-policy"`
+
+	// What to do with a remote file which has the same CRC32 and size as a
+	// file downloaded before. One of "download", "skip" or "link".
+	DuplicatePolicy string `json:"duplicate-policy"`
+
+	// How the files are named while they are being downloaded. One of
+	// "putdl", "part", "sync" or "hidden", "putdl" if empty.
+	PartialNaming string `json:"partial-naming"`
+
+	// Mark the partial files hidden on Windows while they are being
+	// downloaded. The other platforms hide only the files of the "hidden"
+	// partial naming.
+	HidePartialFiles bool `json:"hide-partial-files"`
+
+	// Skip the remote files and folders whose names start with a dot
+	SkipHiddenFiles bool `json:"skip-hidden-files"`
+
+	// Create local folders for the empty remote folders too. Only the
+	// folders of the downloaded files are created otherwise.
+	MirrorEmptyFolders bool `json:"mirror-empty-folders"`
+
+	// Drop the top level folder of the remote paths, which is usually the
+	// folder of the transfer, e.g. "/Movie (2023)/Movie.mkv" is downloaded
+	// as "/Movie.mkv"
+	FlattenTransfers bool `json:"flatten-transfers"`
+
+	// Merge the folders holding a single file or folder into their parent
+	// folders
+	CollapseSingleFolders bool `json:"collapse-single-folders"`
+
+	// Keep at most this many levels of folders, the files deeper are
+	// downloaded into the folder at this depth. Unlimited if zero.
+	MaxDepth uint `json:"max-depth"`
+
+	// Rules rewriting the local names of the new files, applied in order
+	RenameRules []RenameRule `json:"rename-rules"`
+
+	// Script deciding what happens to the remote files before the rules.
+	// See Script for the language.
+	Script string `json:"script"`
+
+	// Extract downloaded tar and zip archives and delete them afterwards. Tar
+	// archives are extracted while downloading, so they don't need extra
+	// disk space for the archive itself.
+	ExtractArchives bool `json:"extract-archives"`
+
+	// Compress the downloaded files with these extensions, e.g. ".txt" or
+	// ".log", with gzip. Compressed files get the .gz extension. Files larger
+	// than 64 MB are not compressed.
+	CompressExtensions []string `json:"compress-extensions"`
+
+	// Order of the post-processing steps, see DefaultPipeline
+	Pipeline []string `json:"pipeline"`
+
+	// Named pipelines which the rules can choose for the files they match,
+	// e.g. one extracting and notifying for TV shows and another only
+	// moving music
+	Pipelines map[string][]string `json:"pipelines"`
+
+	// Retries of a failed post-processing step. Zero means the default, 2.
+	// Negative values disable the retries.
+	StepRetries int `json:"step-retries"`
+
+	// Name of the profile used to transcode the completed videos with
+	// ffmpeg, e.g. "remux-mp4". Videos are not transcoded if empty.
+	TranscodeProfile string `json:"transcode-profile"`
+
+	// Transcode profiles in addition to the built-in TranscodeProfiles
+	CustomTranscodeProfiles map[string]TranscodeProfile `json:"custom-transcode-profiles"`
+
+	// Path of the ffmpeg executable. It is looked up in PATH if empty.
+	FFmpegPath string `json:"ffmpeg-path"`
+
+	// Read the duration, resolution and codecs of the downloaded videos and
+	// audio files with ffprobe
+	ProbeMedia bool `json:"probe-media"`
+
+	// Path of the ffprobe executable. It is looked up in PATH if empty.
+	FFprobePath string `json:"ffprobe-path"`
+
+	// Store the Put.io screenshots of the videos next to them
+	SaveThumbnails bool `json:"save-thumbnails"`
+
+	// Match the downloaded movies to TMDb entries with this API key
+	TMDbAPIKey string `json:"tmdb-api-key"`
+
+	// POST a notification to this URL when a download completes or fails
+	NotifyURL string `json:"notify-url"`
+
+	// Go template of the notification body, see ParseNotifyTemplate. The
+	// notification is sent as JSON if empty.
+	NotifyTemplate string `json:"notify-template"`
+
+	// Send a notification when the polls succeed but find no new files for
+	// this long, which is often a sign of a broken filter or a wrong
+	// DownloadFrom folder. Disabled if zero.
+	SilenceAlarm Duration `json:"silence-alarm"`
+
+	// Write a JSON status snapshot to this file every 10 seconds while
+	// syncing. Changes take effect after a restart of the sync.
+	StatusFile string `json:"status-file"`
+
+	// Write the list of the files being downloaded to this file as JSON, so
+	// that the automation watching the download folders can skip them.
+	ManifestFile string `json:"manifest-file"`
+
+	// Serve the health of the daemon on this unix socket for the package
+	// frameworks of NAS systems, see the health command. Changes take effect
+	// after a restart.
+	StatusSocket string `json:"status-socket"`
+
+	// Consider the sync loop stalled after this many poll intervals without a
+	// poll, and the downloads after as long without progress. The goroutines
+	// are dumped next to the database then. Disabled if zero.
+	Watchdog uint `json:"watchdog"`
+
+	// Restart a stalled sync. The daemon exits if it can't be stopped, for
+	// the service manager to restart it.
+	WatchdogRestart bool `json:"watchdog-restart"`
+
+	// Consider the system suspended when the wall clock jumps ahead of the
+	// monotonic clock by this much, and measure the throughput afresh after
+	// it. 30s if zero, disabled if negative.
+	SuspendThreshold Duration `json:"suspend-threshold"`
+
+	// Poll immediately after the system wakes up from a suspend longer than
+	// PollInterval, instead of waiting for the rest of the poll delay
+	WakeCatchUp bool `json:"wake-catch-up"`
+
+	// Poll shortly after the last download finishes, instead of waiting for
+	// the rest of the poll delay
+	PollOnDrain bool `json:"poll-on-drain"`
+
+	// What to do on battery power and on a metered connection. One of
+	// "pause", "throttle", or empty to keep syncing.
+	OnBattery string `json:"on-battery"`
+	OnMetered string `json:"on-metered"`
+
+	// Download speed limit in bytes per second while throttled by OnBattery
+	// or OnMetered, 512 KiB/s if zero
+	ThrottleSpeedLimit int64 `json:"throttle-speed-limit"`
+
+	// Serve the runtime profiles under /api/debug/pprof/ to admin API keys
+	EnableProfiling bool `json:"enable-profiling"`
+
+	// Write a crash report with the stack traces and the recent log to the
+	// crashes folder next to the database when the daemon panics
+	CrashReports bool `json:"crash-reports"`
+
+	// Also POST the crash reports to this URL
+	CrashReportURL string `json:"crash-report-url"`
+
+	// Install signed releases automatically and restart into them
+	AutoUpdate bool `json:"auto-update"`
+
+	// "stable" or "beta", stable if empty
+	UpdateChannel string `json:"update-channel"`
+
+	// Publish the state to this MQTT broker for Home Assistant, e.g.
+	// tcp://homeassistant.local:1883 or ssl://broker:8883. Changes take
+	// effect after a restart.
+	MQTTBroker   string `json:"mqtt-broker"`
+	MQTTUsername string `json:"mqtt-username"`
+	MQTTPassword string `json:"mqtt-password"`
+
+	// Prefix of the state and command topics, "putio-sync" if empty
+	MQTTTopicPrefix string `json:"mqtt-topic-prefix"`
+
+	// Home Assistant discovery prefix, "homeassistant" if empty
+	MQTTDiscoveryPrefix string `json:"mqtt-discovery-prefix"`
+
+	// Export traces and metrics to this OTLP/HTTP collector, e.g.
+	// http://localhost:4318. Telemetry is disabled if empty. Changes take
+	// effect after a restart.
+	OTLPEndpoint string `json:"otlp-endpoint"`
+
+	// Share the filters, the rules and the polling schedule with the other
+	// devices syncing the same account through a hidden Put.io folder. The
+	// route rules stay local.
+	SyncConfigRemotely bool `json:"sync-config-remotely"`
+
+	// Coordinate with the other devices syncing the same account through
+	// lease markers in a hidden Put.io folder, so that each file is
+	// downloaded by only one device.
+	DownloadLeases bool `json:"download-leases"`
+
+	// Public URL of the web UI, e.g. through a tunnel or a reverse proxy.
+	// Put.io calls it back when a transfer submitted by putio-sync
+	// completes, so that its files are downloaded at once instead of at the
+	// next poll.
+	CallbackURL string `json:"callback-url"`
+
+	// Secret in the callback URL which authorizes the callbacks. It is
+	// generated when the callback URL is first used.
+	CallbackSecret string `json:"callback-secret"`
+
+	// Language of the user-facing messages, e.g. "tr". Defaults to English.
+	Locale string `json:"locale"`
+
+	// IANA time zone of the account, e.g. "America/New_York", for the times
+	// shown to the user. The local time zone of the server if empty.
+	TimeZone string `json:"time-zone"`
+
+	// Remote folders to download from, each with its own destination and
+	// filters. DownloadFrom and DownloadTo are used if none is given.
+	FolderMappings []FolderMapping `json:"folder-mappings"`
+
+	// Kill-switch: sync only while this network interface is up and the
+	// egress IP, as seen by VPNCheckURL, is one of VPNEgress. The addresses
+	// may be CIDR blocks. Disabled if both are empty.
+	VPNInterface string   `json:"vpn-interface"`
+	VPNEgress    []string `json:"vpn-egress"`
+
+	// Service echoing the IP address of the client as plain text,
+	// https://api.ipify.org if empty
+	VPNCheckURL string `json:"vpn-check-url"`
+
+	// DNS servers resolving the Put.io hosts, as IP addresses with optional
+	// ports, instead of the system resolver
+	DNSServers []string `json:"dns-servers"`
+
+	// How long the resolved addresses are used, 5m if zero, not cached if
+	// negative. They are used longer if the resolution fails.
+	DNSCacheTTL Duration `json:"dns-cache-ttl"`
+
+	// Race the first segment of every file across the download endpoints of
+	// Put.io, the direct one and the tunnel, and download the rest of the
+	// file from the fastest
+	RaceEndpoints bool `json:"race-endpoints"`
+
+	// Custom headers and cookies of the download requests, e.g. for
+	// debugging a CDN. The folder mappings can override them.
+	Headers map[string]string `json:"headers"`
+	Cookies map[string]string `json:"cookies"`
+
+	// Tuning of the database
+	Database DatabaseOptions `json:"database"`
+
+	// Stored fields unknown to this version, kept when the configuration is
+	// saved
+	extra map[string]json.RawMessage
+}
+
+// FolderMapping maps a remote Put.io folder to a local directory.
+type FolderMapping struct {
+	// Download files only in this directory (Put.io file ID)
+	DownloadFrom int64 `json:"download-from"`
+
+	// Slash separated path of the Put.io folder, e.g. "/Movies". It is
+	// resolved into DownloadFrom when the mapping is saved.
+	DownloadFromPath string `json:"download-from-path,omitempty"`
+
+	// Download files to this directory. Config.DownloadTo is used if empty.
+	DownloadTo string `json:"download-to"`
+
+	// Files to download from this folder
+	Filter Filter `json:"filter"`
+
+	// Encrypt the completed downloads with the key in the application
+	// directory. They can be decrypted with the decrypt command.
+	Encrypt bool `json:"encrypt"`
+
+	// Custom headers and cookies of the download requests from this folder,
+	// in addition to Config.Headers and Config.Cookies
+	Headers map[string]string `json:"headers"`
+	Cookies map[string]string `json:"cookies"`
+}
+
+// Mappings returns the folder mappings to poll. If there are no explicit
+// mappings, a single mapping for DownloadFrom and DownloadTo is returned.
+func (c *Config) Mappings() []FolderMapping {
+	if len(c.FolderMappings) == 0 {
+		return []FolderMapping{{
+			DownloadFrom: c.DownloadFrom,
+			DownloadTo:   c.DownloadTo,
+		}}
+	}
+
+	mappings := make([]FolderMapping, len(c.FolderMappings))
+	for i, m := range c.FolderMappings {
+		if m.DownloadTo == "" {
+			m.DownloadTo = c.DownloadTo
+		}
+		mappings[i] = m
+	}
+	return mappings
+}
+
+// segmentsFor returns the number of connections to download a file of the
+// given size, which is segments unless the size has its own setting.
+func (c *Config) segmentsFor(size int64, segments uint) uint {
+	switch {
+	case c.SingleSegmentBelow > 0 && size < c.SingleSegmentBelow:
+		return 1
+	case c.MaxSegmentsAbove > 0 && size > c.MaxSegmentsAbove:
+		return limitSegmentsPerFile
+	}
+	return segments
+}
+
+// mapping returns the folder mapping which downloads to root.
+func (c *Config) mapping(root string) (FolderMapping, bool) {
+	for _, m := range c.Mappings() {
+		if filepath.Clean(m.DownloadTo) == filepath.Clean(root) {
+			return m, true
+		}
+	}
+	return FolderMapping{}, false
+}
+
+// localRoot returns the destination directory of the mapping the given local
+// path belongs to.
+func (c *Config) localRoot(path string) string {
+	var root string
+	for _, m := range c.Mappings() {
+		dir := filepath.Clean(m.DownloadTo)
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) && len(dir) > len(root) {
+			root = dir
+		}
+	}
+	if root == "" {
+		return c.DownloadTo
+	}
+	return root
 }
 
 // Duration is a JSON wrapper type for time.Duration.
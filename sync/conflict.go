@@ -0,0 +1,120 @@
+package sync
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Conflict policies decide what to do when the local path of a download is
+// already occupied by another file.
+const (
+	// Replace the local file with the remote one
+	ConflictOverwrite = "overwrite"
+
+	// Keep the local file and don't download the remote one
+	ConflictKeepLocal = "keep-local"
+
+	// Download the remote file next to the local one with a new name
+	ConflictRename = "rename"
+)
+
+// ValidConflictPolicy reports whether p is a known conflict policy.
+func ValidConflictPolicy(p string) bool {
+	switch p {
+	case ConflictOverwrite, ConflictKeepLocal, ConflictRename:
+		return true
+	}
+	return false
+}
+
+// ConflictResolution represents how a conflict is resolved.
+type ConflictResolution int
+
+const (
+	ConflictKeptRemote ConflictResolution = iota
+	ConflictKeptLocal
+	ConflictRenamed
+)
+
+// String implements fmt.Stringer interface for ConflictResolution.
+func (cr ConflictResolution) String() string {
+	var s string
+	switch cr {
+	case ConflictKeptRemote:
+		s = "kept-remote"
+	case ConflictKeptLocal:
+		s = "kept-local"
+	case ConflictRenamed:
+		s = "renamed"
+	}
+	return s
+}
+
+// MarshalJSON implements json.Marshaler interface for ConflictResolution.
+func (cr ConflictResolution) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("\"%v\"", cr)), nil
+}
+
+// Conflict is a journal entry of a conflict and its resolution.
+type Conflict struct {
+	FileID     int64              `json:"file_id"`
+	FileName   string             `json:"file_name"`
+	LocalPath  string             `json:"local_path"`
+	RenamedTo  string             `json:"renamed_to,omitempty"`
+	Resolution ConflictResolution `json:"resolution"`
+	ResolvedAt time.Time          `json:"resolved_at"`
+}
+
+// resolveConflict checks whether the final path of the task is occupied and
+// resolves the conflict according to the configured policy. It reports whether
+// the download should go on.
+func (c *Client) resolveConflict(t *Task, path string) bool {
+	if !exists(path) {
+		return true
+	}
+
+	conflict := &Conflict{
+		FileID:     t.state.FileID,
+		FileName:   t.state.FileName,
+		LocalPath:  path,
+		ResolvedAt: time.Now().UTC(),
+	}
+
+	proceed := true
+	switch c.Config.ConflictPolicy {
+	case ConflictKeepLocal:
+		conflict.Resolution = ConflictKeptLocal
+		proceed = false
+	case ConflictRename:
+		newpath := uniquePath(path)
+		conflict.Resolution = ConflictRenamed
+		conflict.RenamedTo = newpath
+		t.state.FileName = filepath.Base(newpath)
+		t.state.LocalPath = newpath
+	default:
+		conflict.Resolution = ConflictKeptRemote
+	}
+
+	c.Printf("Conflict on %v resolved as %v\n", path, conflict.Resolution)
+	err := c.Store.SaveConflict(conflict, c.User.Username)
+	if err != nil {
+		c.Errorf("Error saving conflict for %v: %v\n", t, err)
+	}
+
+	return proceed
+}
+
+// uniquePath returns a non-existing path by appending a counter to the file
+// name. E.g. /foo/bar.mkv becomes /foo/bar (1).mkv.
+func uniquePath(path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		p := fmt.Sprintf("%v (%v)%v", base, i, ext)
+		if !exists(p) && !partialExists(p) {
+			return p
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package sync
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+)
+
+// ControlSocketName is the name of the unix socket in the application folder
+// which the daemon serves the API on for the commands and local scripts.
+const ControlSocketName = "putio-sync.sock"
+
+// ControlSocketEnv is the environment variable overriding the path of the
+// control socket, for both the daemon and the commands.
+const ControlSocketEnv = "PUTIO_SYNC_SOCKET"
+
+// ControlSocketPath returns the path of the control socket of the daemon. It
+// is a named pipe of the user on Windows.
+func ControlSocketPath() (string, error) {
+	if path := os.Getenv(ControlSocketEnv); path != "" {
+		return path, nil
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "windows" {
+		// user IDs are SIDs, they are valid pipe names
+		return `\\.\pipe\putio-sync-` + u.Uid, nil
+	}
+	return filepath.Join(u.HomeDir, ".putio-sync", ControlSocketName), nil
+}
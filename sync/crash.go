@@ -0,0 +1,228 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// crashesDir is the directory of the crash reports, next to the
+	// database.
+	crashesDir = "crashes"
+
+	// crashReportsKept is the number of crash reports kept, the oldest ones
+	// are removed.
+	crashReportsKept = 10
+
+	// crashLogLines is the number of recent log lines in a crash report.
+	crashLogLines = 200
+)
+
+// CrashReport is written when the daemon panics and crash reports are
+// enabled.
+type CrashReport struct {
+	Version   string    `json:"version"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	GoVersion string    `json:"go_version"`
+	DeviceID  string    `json:"device_id"`
+	Time      time.Time `json:"time"`
+
+	// Value of the panic
+	Panic string `json:"panic"`
+
+	// Stack traces of all goroutines
+	Stack string `json:"stack"`
+
+	// Recent log lines with the credentials redacted
+	Logs []string `json:"logs"`
+}
+
+// logRing keeps the last lines written to the log.
+type logRing struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+}
+
+func newLogRing(size int) *logRing {
+	return &logRing{lines: make([]string, 0, size)}
+}
+
+// Write stores a log entry. log.Logger writes every entry with a single call.
+func (r *logRing) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+
+	r.mu.Lock()
+	if len(r.lines) < cap(r.lines) {
+		r.lines = append(r.lines, line)
+	} else {
+		r.lines[r.next] = line
+		r.next = (r.next + 1) % len(r.lines)
+	}
+	r.mu.Unlock()
+	return len(p), nil
+}
+
+// Lines returns the stored lines, oldest first.
+func (r *logRing) Lines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(append([]string{}, r.lines[r.next:]...), r.lines[:r.next]...)
+}
+
+var (
+	secretParamRe = regexp.MustCompile(`(?i)((?:token|key|secret|password)[\w-]*=)[^&\s"]+`)
+	bearerRe      = regexp.MustCompile(`(?i)(bearer\s+)\S+`)
+)
+
+// secrets returns the credentials in the configuration. The values of the
+// custom headers and cookies are included, they often carry a session.
+func (c *Config) secrets() []string {
+	secrets := []string{c.OAuth2Token, c.TMDbAPIKey, c.MQTTPassword, c.CallbackSecret}
+	add := func(m map[string]string) {
+		for _, v := range m {
+			secrets = append(secrets, v)
+		}
+	}
+	add(c.Headers)
+	add(c.Cookies)
+	for _, m := range c.FolderMappings {
+		add(m.Headers)
+		add(m.Cookies)
+	}
+	return secrets
+}
+
+// redactLine hides the given secrets and anything which looks like a
+// credential in the log line.
+func redactLine(line string, secrets []string) string {
+	for _, s := range secrets {
+		if s != "" {
+			line = strings.Replace(line, s, redacted, -1)
+		}
+	}
+	line = secretParamRe.ReplaceAllString(line, "${1}"+redacted)
+	return bearerRe.ReplaceAllString(line, "${1}"+redacted)
+}
+
+// RecoverCrash writes a crash report of a panic if crash reports are enabled,
+// then panics again. It is deferred at the top of the long running goroutines.
+func (c *Client) RecoverCrash() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	if c.Config.CrashReports {
+		path, err := c.writeCrashReport(r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing crash report: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Crash report is written to %v\n", path)
+		}
+	}
+	panic(r)
+}
+
+func (c *Client) writeCrashReport(v interface{}) (string, error) {
+	stack := make([]byte, 1<<20)
+	stack = stack[:runtime.Stack(stack, true)]
+
+	report := CrashReport{
+		Version:   Version,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		GoVersion: runtime.Version(),
+		DeviceID:  c.Device.ID,
+		Time:      time.Now().UTC(),
+		Panic:     fmt.Sprint(v),
+		Stack:     string(stack),
+		Logs:      make([]string, 0),
+	}
+
+	secrets := c.Config.secrets()
+	for _, line := range c.Logger.recent.Lines() {
+		report.Logs = append(report.Logs, redactLine(line, secrets))
+	}
+
+	if c.Config.CrashReportURL != "" {
+		err := postCrashReport(c.Config.CrashReportURL, &report, c.transport)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error sending crash report: %v\n", err)
+		}
+	}
+
+	dir := filepath.Join(filepath.Dir(c.Store.Path()), crashesDir)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	pruneCrashReports(dir)
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "crash-"+report.Time.Format("20060102-150405")+".json")
+	return path, ioutil.WriteFile(path, b, 0600)
+}
+
+// postCrashReport sends the report to the crash report endpoint. The process
+// is about to exit, so it doesn't wait long.
+func postCrashReport(url string, report *CrashReport, transport http.RoundTripper) error {
+	b, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", defaultUserAgent)
+
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("crash report endpoint responded with %v", resp.Status)
+	}
+	return nil
+}
+
+// removeCrashReports removes all the crash reports. Their logs may mention
+// any user.
+func removeCrashReports(dir string) error {
+	return os.RemoveAll(filepath.Join(dir, crashesDir))
+}
+
+// pruneCrashReports removes the oldest reports, leaving room for a new one.
+func pruneCrashReports(dir string) {
+	names, err := filepath.Glob(filepath.Join(dir, "crash-*.json"))
+	if err != nil || len(names) < crashReportsKept {
+		return
+	}
+
+	// the names sort by time
+	sort.Strings(names)
+	for _, name := range names[:len(names)-crashReportsKept+1] {
+		os.Remove(name)
+	}
+}
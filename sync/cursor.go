@@ -0,0 +1,134 @@
+package sync
+
+import (
+	"context"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// cursorSaveInterval is the minimum time between two saves of the walk
+// cursor, so that large accounts don't write the store for every folder.
+const cursorSaveInterval = 10 * time.Second
+
+// walkCursor checkpoints the remote folders fully walked by a poll, so that
+// a poll interrupted by a restart resumes where it stopped instead of from the
+// root. The files queued before the restart but not started yet are found by
+// the next full poll.
+type walkCursor struct {
+	// Folders walked by the interrupted poll
+	walked map[int64]bool
+
+	// Folders walked by this poll which are not saved yet
+	pending []int64
+	saved   time.Time
+}
+
+// WalkedFolders returns the remote folders walked by the last poll if it is
+// interrupted.
+func (s *Store) WalkedFolders(forUser string) (map[int64]bool, error) {
+	walked := make(map[int64]bool)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		if userBkt == nil {
+			return ErrUserNotFound
+		}
+		return userBkt.Bucket(walkCursorBucket).ForEach(func(k, v []byte) error {
+			walked[btoi(k)] = true
+			return nil
+		})
+	})
+	return walked, err
+}
+
+// SaveWalkedFolders adds the remote folders to the walk cursor.
+func (s *Store) SaveWalkedFolders(ids []int64, forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		if userBkt == nil {
+			return ErrUserNotFound
+		}
+		cursorBkt := userBkt.Bucket(walkCursorBucket)
+		for _, id := range ids {
+			err := cursorBkt.Put(itob(id), []byte{})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ClearWalkedFolders resets the walk cursor once a poll is finished.
+func (s *Store) ClearWalkedFolders(forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		err := userBkt.DeleteBucket(walkCursorBucket)
+		if err != nil {
+			return err
+		}
+		_, err = userBkt.CreateBucket(walkCursorBucket)
+		return err
+	})
+}
+
+// loadWalkCursor returns the cursor of a new poll, which skips the folders
+// walked by the interrupted poll, if any.
+func (c *Client) loadWalkCursor() *walkCursor {
+	walked, err := c.Store.WalkedFolders(c.User.Username)
+	if err != nil {
+		c.Errorf("Error reading walk cursor: %v\n", err)
+	}
+	if len(walked) > 0 {
+		c.Printf("Resuming the interrupted poll, skipping %v walked folders\n", len(walked))
+	}
+	return &walkCursor{walked: walked, saved: time.Now()}
+}
+
+// skip reports whether the folder is walked by the interrupted poll.
+func (w *walkCursor) skip(id int64) bool {
+	return w != nil && w.walked[id]
+}
+
+// walkedFolder records that the folder and its subfolders are walked, and
+// saves the cursor if it isn't saved for a while. errors is the number of the
+// errors in the report when the walk of the folder started. The folder isn't
+// recorded if the walk added errors, so that it is walked again.
+func (c *Client) walkedFolder(ctx context.Context, report *Report, id int64, errors int) {
+	w := report.cursor
+	if w == nil || ctx.Err() != nil || len(report.Errors) != errors {
+		return
+	}
+
+	w.pending = append(w.pending, id)
+	if time.Since(w.saved) >= cursorSaveInterval {
+		c.saveWalkCursor(w)
+	}
+}
+
+// saveWalkCursor saves the pending folders of the cursor.
+func (c *Client) saveWalkCursor(w *walkCursor) {
+	if len(w.pending) == 0 {
+		return
+	}
+	err := c.Store.SaveWalkedFolders(w.pending, c.User.Username)
+	if err != nil {
+		c.Errorf("Error saving walk cursor: %v\n", err)
+		return
+	}
+	w.pending = w.pending[:0]
+	w.saved = time.Now()
+}
+
+// finishWalkCursor clears the cursor after a finished poll. The pending
+// folders of an interrupted poll are saved instead.
+func (c *Client) finishWalkCursor(ctx context.Context, w *walkCursor) {
+	if ctx.Err() != nil {
+		c.saveWalkCursor(w)
+		return
+	}
+	err := c.Store.ClearWalkedFolders(c.User.Username)
+	if err != nil {
+		c.Errorf("Error clearing walk cursor: %v\n", err)
+	}
+}
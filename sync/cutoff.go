@@ -0,0 +1,33 @@
+package sync
+
+import (
+	"time"
+
+	"github.com/igungor/go-putio/putio"
+)
+
+// tooOld reports whether the remote file was added before the cutoff and
+// must not be downloaded.
+func (c *Config) tooOld(f putio.File) bool {
+	if c.IgnoreFilesOlderThan.IsZero() || f.CreatedAt == nil {
+		return false
+	}
+	return f.CreatedAt.Before(c.IgnoreFilesOlderThan)
+}
+
+// startFromNow sets the cutoff to the current time on the first poll if
+// StartFromNow is set, so that only the files added from now on are
+// downloaded.
+func (c *Client) startFromNow() {
+	if !c.Config.StartFromNow || !c.Config.IgnoreFilesOlderThan.IsZero() {
+		return
+	}
+
+	c.Config.IgnoreFilesOlderThan = time.Now().UTC()
+	c.Printf("Ignoring the files added before %v\n", c.Config.IgnoreFilesOlderThan)
+
+	err := c.Store.SaveConfig(c.Config, c.User.Username)
+	if err != nil {
+		c.Errorf("Error saving config: %v\n", err)
+	}
+}
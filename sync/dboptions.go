@@ -0,0 +1,107 @@
+package sync
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Fsync policies of the database
+const (
+	// Sync every commit to disk
+	FsyncAlways = "always"
+
+	// Don't sync the file size when the database grows. It is only safe on
+	// filesystems which order metadata updates, e.g. ext3 and ext4.
+	FsyncNoGrow = "no-grow"
+
+	// Never sync. Writes are fast, but a power loss or a kernel crash can
+	// corrupt the database.
+	FsyncNever = "never"
+)
+
+// DatabaseOptions tune the bolt database for large databases on slow
+// storage. The fsync policy and the allocation size take effect at once, the
+// memory map size when the database is opened. The freelist options of the
+// newer bolt forks are not available in boltdb.
+type DatabaseOptions struct {
+	// One of "always", "no-grow" or "never", always if empty
+	FsyncPolicy string `json:"fsync-policy"`
+
+	// Initial size of the memory map in bytes. A map as large as the
+	// database saves the remaps as it grows, which block the writers.
+	InitialMmapSize int `json:"initial-mmap-size"`
+
+	// Space allocated at a time when the database grows, in bytes. 16 MB if
+	// zero.
+	AllocSize int `json:"alloc-size"`
+
+	// Number of backups kept, taken before upgrades and other risky
+	// operations. 5 if zero, none if negative.
+	Backups int `json:"backups"`
+}
+
+// Validate checks the options.
+func (o DatabaseOptions) Validate() error {
+	switch o.FsyncPolicy {
+	case "", FsyncAlways, FsyncNoGrow, FsyncNever:
+	default:
+		return fmt.Errorf("invalid fsync policy %q", o.FsyncPolicy)
+	}
+	if o.InitialMmapSize < 0 || o.AllocSize < 0 {
+		return Error("database sizes can't be negative")
+	}
+	return nil
+}
+
+// boltOptions returns the options for opening the database.
+func (o DatabaseOptions) boltOptions() *bolt.Options {
+	return &bolt.Options{
+		Timeout:         10 * time.Second,
+		NoGrowSync:      o.FsyncPolicy == FsyncNoGrow,
+		InitialMmapSize: o.InitialMmapSize,
+	}
+}
+
+// apply sets the options which don't need reopening the database.
+func (o DatabaseOptions) apply(db *bolt.DB) {
+	db.NoSync = o.FsyncPolicy == FsyncNever
+	db.NoGrowSync = o.FsyncPolicy == FsyncNoGrow
+	if o.AllocSize > 0 {
+		db.AllocSize = o.AllocSize
+	} else {
+		db.AllocSize = bolt.DefaultAllocSize
+	}
+}
+
+// ApplyOptions applies the database options which don't need reopening the
+// database. The memory map size is kept until SetOptions is called when the
+// database is opened next.
+func (s *Store) ApplyOptions(o DatabaseOptions) {
+	o.InitialMmapSize = s.options.InitialMmapSize
+	s.options = o
+	o.apply(s.db)
+}
+
+// SetOptions applies the database options. The database is reopened if the
+// memory map changes, so there must be no open transactions.
+func (s *Store) SetOptions(o DatabaseOptions) error {
+	err := o.Validate()
+	if err != nil {
+		return err
+	}
+
+	reopen := o.InitialMmapSize != s.options.InitialMmapSize
+	s.options = o
+	if !reopen {
+		o.apply(s.db)
+		return nil
+	}
+
+	err = s.db.Close()
+	if err != nil {
+		return err
+	}
+	return s.Open()
+}
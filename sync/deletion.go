@@ -0,0 +1,286 @@
+package sync
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/igungor/go-putio/putio"
+)
+
+const (
+	// defaultDeleteRate is the number of remote deletions per minute unless
+	// the configuration has another one.
+	defaultDeleteRate = 30
+
+	// maxDeleteAttempts is the number of attempts before a remote deletion
+	// is given up.
+	maxDeleteAttempts = 10
+
+	// maxRemoteDeletions is the number of finished deletions kept as the
+	// audit log.
+	maxRemoteDeletions = 500
+
+	// Bounds of the backoff between the attempts of a failed deletion
+	deleteRetryMin = 30 * time.Second
+	deleteRetryMax = time.Hour
+)
+
+// Remote deletion statuses
+const (
+	DeletionQueued  = "queued"
+	DeletionDone    = "done"
+	DeletionFailed  = "failed"
+	DeletionMissing = "missing"
+)
+
+// RemoteDeletion is a remote file queued for deletion after its download.
+// The finished deletions are kept as an audit log. It is encoded as Gob and
+// stored to a persistent storage.
+type RemoteDeletion struct {
+	ID         int64  `json:"id"`
+	FileID     int64  `json:"file_id"`
+	FileName   string `json:"file_name"`
+	DownloadID string `json:"download_id"`
+
+	// The entry is an emptied folder deleted after the files in it
+	IsDir bool `json:"is_dir"`
+
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	Error       string    `json:"error"`
+	QueuedAt    time.Time `json:"queued_at"`
+	NextAttempt time.Time `json:"next_attempt"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// finished reports whether the deletion is not in the queue anymore.
+func (d *RemoteDeletion) finished() bool {
+	return d.Status != DeletionQueued
+}
+
+// backoff returns the delay before the next attempt.
+func (d *RemoteDeletion) backoff() time.Duration {
+	delay := deleteRetryMin
+	for i := 1; i < d.Attempts && delay < deleteRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > deleteRetryMax {
+		delay = deleteRetryMax
+	}
+	return delay
+}
+
+// deleteInterval returns the minimum time between two remote deletions.
+func (c *Config) deleteInterval() time.Duration {
+	rate := c.DeleteRate
+	if rate == 0 {
+		rate = defaultDeleteRate
+	}
+	return time.Minute / time.Duration(rate)
+}
+
+// queueRemoteDeletion adds the remote file of the downloaded state to the
+// deletion queue.
+func (c *Client) queueRemoteDeletion(state *State) error {
+	now := time.Now().UTC()
+	err := c.Store.SaveRemoteDeletion(&RemoteDeletion{
+		FileID:      state.FileID,
+		FileName:    state.FileName,
+		DownloadID:  state.DownloadID,
+		Status:      DeletionQueued,
+		QueuedAt:    now,
+		NextAttempt: now,
+	}, c.User.Username)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case c.deleteCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// runDeletions deletes the queued remote files one by one, no faster than
+// Config.DeleteRate, until ctx is cancelled. Failed deletions are retried with
+// backoff.
+func (c *Client) runDeletions(ctx context.Context) {
+	defer c.RecoverCrash()
+
+	var last time.Time
+	var wait time.Duration
+	for {
+		select {
+		case <-time.After(wait):
+		case <-c.deleteCh:
+		case <-ctx.Done():
+			return
+		}
+
+		if d := c.Config.deleteInterval() - time.Since(last); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		var deleted bool
+		wait, deleted = c.deleteNext(ctx)
+		if deleted {
+			last = time.Now()
+		}
+	}
+}
+
+// deleteNext deletes the oldest due remote file in the queue. It returns the
+// delay until the next deletion is due and whether the API is called.
+func (c *Client) deleteNext(ctx context.Context) (time.Duration, bool) {
+	deletions, err := c.Store.RemoteDeletions(c.User.Username)
+	if err != nil {
+		c.Errorf("Error retrieving remote deletions: %v\n", err)
+		return deleteRetryMin, false
+	}
+
+	now := time.Now().UTC()
+	var d *RemoteDeletion
+	next := deleteRetryMax
+	for _, deletion := range deletions {
+		if deletion.finished() {
+			continue
+		}
+		wait := deletion.NextAttempt.Sub(now)
+		if wait <= 0 && d == nil {
+			d = deletion
+			continue
+		}
+		if wait < 0 {
+			wait = 0
+		}
+		if wait < next {
+			next = wait
+		}
+	}
+	if d == nil {
+		return next, false
+	}
+
+	// the parent is looked up before the file is gone
+	var parentID int64 = -1
+	if c.Config.DeleteEmptyFolders > 0 {
+		f, err := c.C.Files.Get(ctx, d.FileID)
+		if err == nil {
+			parentID = f.ParentID
+		}
+	}
+
+	d.Attempts++
+	err = c.C.Files.Delete(ctx, d.FileID)
+	switch {
+	case ctx.Err() != nil:
+		// retried on the next start
+		d.Attempts--
+		return next, true
+	case err == nil:
+		d.Status = DeletionDone
+		d.Error = ""
+		d.FinishedAt = time.Now().UTC()
+		c.Printf("Deleted remote file %v (%v) of download %v\n", d.FileName, d.FileID, d.DownloadID)
+	case apiStatusCode(err) == http.StatusNotFound:
+		d.Status = DeletionMissing
+		d.FinishedAt = time.Now().UTC()
+		c.Printf("Remote file %v (%v) of download %v is already deleted\n", d.FileName, d.FileID, d.DownloadID)
+	case d.Attempts >= maxDeleteAttempts:
+		d.Status = DeletionFailed
+		d.Error = err.Error()
+		d.FinishedAt = time.Now().UTC()
+		c.Errorf("Error deleting remote file %v, giving up after %v attempts: %v\n", d.FileName, d.Attempts, err)
+	default:
+		d.Error = err.Error()
+		d.NextAttempt = now.Add(d.backoff())
+		if d.backoff() < next {
+			next = d.backoff()
+		}
+		c.Errorf("Error deleting remote file %v, retrying in %v: %v\n", d.FileName, d.backoff(), err)
+	}
+
+	err = c.Store.SaveRemoteDeletion(d, c.User.Username)
+	if err != nil {
+		c.Errorf("Error saving remote deletion of %v: %v\n", d.FileName, err)
+	}
+	if d.Status == DeletionDone && parentID >= 0 {
+		c.deleteEmptyFolders(ctx, parentID, d.DownloadID)
+	}
+	return next, true
+}
+
+// deleteEmptyFolders deletes the folder with the given ID and its parents, up
+// to Config.DeleteEmptyFolders levels, as long as they are empty. Only the
+// folders below the download root of a folder mapping are deleted, download
+// roots and the Put.io root folder are kept.
+func (c *Client) deleteEmptyFolders(ctx context.Context, id int64, downloadID string) {
+	roots := make(map[int64]bool)
+	for _, m := range c.Config.Mappings() {
+		roots[m.DownloadFrom] = true
+	}
+
+	// the file may have been moved out of the mappings on Put.io
+	if c.Config.DeleteEmptyFolders > 0 && id != 0 && !roots[id] {
+		_, _, err := c.locate(ctx, id)
+		if err != nil {
+			c.Debugf("Keeping remote folder %v: %v\n", id, err)
+			return
+		}
+	}
+
+	for depth := c.Config.DeleteEmptyFolders; depth > 0; depth-- {
+		if id == 0 || roots[id] || ctx.Err() != nil {
+			return
+		}
+
+		files, folder, err := c.C.Files.List(ctx, id)
+		if err != nil {
+			c.Errorf("Error listing remote folder %v: %v\n", id, err)
+			return
+		}
+		if len(files) > 0 {
+			return
+		}
+
+		err = c.C.Files.Delete(ctx, id)
+		if err != nil {
+			c.Errorf("Error deleting empty remote folder %v: %v\n", folder.Name, err)
+			return
+		}
+		c.Printf("Deleted empty remote folder %v (%v)\n", folder.Name, id)
+
+		now := time.Now().UTC()
+		err = c.Store.SaveRemoteDeletion(&RemoteDeletion{
+			FileID:     id,
+			FileName:   folder.Name,
+			DownloadID: downloadID,
+			IsDir:      true,
+			Status:     DeletionDone,
+			Attempts:   1,
+			QueuedAt:   now,
+			FinishedAt: now,
+		}, c.User.Username)
+		if err != nil {
+			c.Errorf("Error saving remote deletion of %v: %v\n", folder.Name, err)
+		}
+
+		id = folder.ParentID
+	}
+}
+
+// apiStatusCode returns the HTTP status code of the Put.io API error. It is
+// zero for the other errors.
+func apiStatusCode(err error) int {
+	e, ok := err.(*putio.ErrorResponse)
+	if !ok || e.Response == nil {
+		return 0
+	}
+	return e.Response.StatusCode
+}
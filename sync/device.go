@@ -0,0 +1,101 @@
+package sync
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+
+	"github.com/boltdb/bolt"
+)
+
+// keys of the device identity in the defaults bucket
+var (
+	deviceIDKey   = []byte("device-id")
+	deviceNameKey = []byte("device-name")
+)
+
+// Device identifies the machine running putio-sync, so that multiple
+// machines syncing the same account can be told apart.
+type Device struct {
+	// Random UUID generated on the first run
+	ID string `json:"id"`
+
+	// Human readable name, the host name by default
+	Name string `json:"name"`
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// Device returns the identity of this machine, creating it on the first
+// call.
+func (s *Store) Device() (*Device, error) {
+	var d Device
+	err := s.update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(defaultsBucket)
+		d.ID = string(bkt.Get(deviceIDKey))
+		d.Name = string(bkt.Get(deviceNameKey))
+
+		if d.ID == "" {
+			id, err := newUUID()
+			if err != nil {
+				return err
+			}
+			d.ID = id
+			err = bkt.Put(deviceIDKey, []byte(d.ID))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Name == "" {
+		d.Name, _ = os.Hostname()
+	}
+	return &d, nil
+}
+
+// SaveDeviceName changes the name of this machine.
+func (s *Store) SaveDeviceName(name string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(defaultsBucket)
+		return bkt.Put(deviceNameKey, []byte(name))
+	})
+}
+
+// SetDeviceName renames this machine.
+func (c *Client) SetDeviceName(name string) error {
+	if name == "" {
+		return Error("empty device name")
+	}
+
+	err := c.Store.SaveDeviceName(name)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.Device.Name = name
+	c.mu.Unlock()
+	return nil
+}
+
+// DeviceInfo returns a copy of the identity of this machine. The name can be
+// changed at any time, so the fields of Device are not read directly.
+func (c *Client) DeviceInfo() Device {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *c.Device
+}
@@ -0,0 +1,231 @@
+package sync
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultDNSCacheTTL is how long the resolved addresses are used unless
+	// the configuration has another duration.
+	defaultDNSCacheTTL = 5 * time.Minute
+
+	// fallbackDelay is how long the dialer waits for the preferred address
+	// family before racing the other one, as in RFC 8305.
+	fallbackDelay = 300 * time.Millisecond
+
+	dialTimeout = 30 * time.Second
+)
+
+// ValidateDNSServer checks that the DNS server is an IP address, optionally
+// with a port.
+func ValidateDNSServer(s string) error {
+	host := s
+	if h, _, err := net.SplitHostPort(s); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) == nil {
+		return Error("DNS server must be an IP address")
+	}
+	return nil
+}
+
+// dnsEntry is a cached result of a lookup.
+type dnsEntry struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+// dialer connects to the Put.io API and the CDN. It resolves through
+// Config.DNSServers if they are given, caches the results for
+// Config.DNSCacheTTL, and races IPv6 and IPv4 with happy eyeballs. An expired
+// result is still used if the lookup fails, since the resolvers of some NAS
+// devices fail intermittently.
+type dialer struct {
+	config func() *Config
+
+	mu    sync.Mutex
+	cache map[string]dnsEntry
+
+	// round robin index of the DNS servers
+	next uint32
+}
+
+func newDialer(config func() *Config) *dialer {
+	return &dialer{
+		config: config,
+		cache:  make(map[string]dnsEntry),
+	}
+}
+
+// transport returns an HTTP transport dialing with d.
+func (d *dialer) transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = d.DialContext
+	return t
+}
+
+// DialContext connects to the address on the named network.
+func (d *dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var nd net.Dialer
+	if net.ParseIP(host) != nil {
+		return nd.DialContext(ctx, network, address)
+	}
+
+	addrs, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	// the family of the first address is preferred, the other one is raced
+	// after fallbackDelay
+	var primaries, fallbacks []net.IPAddr
+	for _, a := range addrs {
+		if (a.IP.To4() == nil) == (addrs[0].IP.To4() == nil) {
+			primaries = append(primaries, a)
+		} else {
+			fallbacks = append(fallbacks, a)
+		}
+	}
+	return dialParallel(ctx, network, port, primaries, fallbacks)
+}
+
+// dialParallel dials the primary addresses one by one, and the fallback
+// addresses in parallel once the primaries are given fallbackDelay or fail.
+// The first connection wins.
+func dialParallel(ctx context.Context, network, port string, primaries, fallbacks []net.IPAddr) (net.Conn, error) {
+	if len(fallbacks) == 0 {
+		return dialSerial(ctx, network, port, primaries)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+	results := make(chan result)
+	race := func(primary bool, addrs []net.IPAddr) {
+		conn, err := dialSerial(ctx, network, port, addrs)
+		select {
+		case results <- result{conn: conn, err: err, primary: primary}:
+		case <-ctx.Done():
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+
+	go race(true, primaries)
+	timer := time.NewTimer(fallbackDelay)
+	defer timer.Stop()
+
+	var fallbackStarted bool
+	var err error
+	for pending := 1; pending > 0; {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				go race(false, fallbacks)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.conn, nil
+			}
+			err = r.err
+			if r.primary && !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				go race(false, fallbacks)
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, err
+}
+
+// dialSerial dials the addresses one by one until one connects.
+func dialSerial(ctx context.Context, network, port string, addrs []net.IPAddr) (net.Conn, error) {
+	nd := net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+
+	var err error
+	for _, a := range addrs {
+		var conn net.Conn
+		conn, err = nd.DialContext(ctx, network, net.JoinHostPort(a.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	if err == nil {
+		err = Error("no addresses to dial")
+	}
+	return nil, err
+}
+
+// lookup resolves the host, from the cache if the result isn't expired.
+func (d *dialer) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	cfg := d.config()
+	ttl := time.Duration(cfg.DNSCacheTTL)
+	if ttl == 0 {
+		ttl = defaultDNSCacheTTL
+	}
+
+	d.mu.Lock()
+	entry, cached := d.cache[host]
+	d.mu.Unlock()
+	if cached && ttl > 0 && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := d.resolver(cfg.DNSServers).LookupIPAddr(ctx, host)
+	if err != nil {
+		if cached && ctx.Err() == nil {
+			return entry.addrs, nil
+		}
+		return nil, err
+	}
+
+	if ttl > 0 {
+		d.mu.Lock()
+		d.cache[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(ttl)}
+		d.mu.Unlock()
+	}
+	return addrs, nil
+}
+
+// resolver returns the resolver querying the given DNS servers in turn, or
+// the system resolver if there are none.
+func (d *dialer) resolver(servers []string) *net.Resolver {
+	if len(servers) == 0 {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			server := servers[int(atomic.AddUint32(&d.next, 1))%len(servers)]
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				server = net.JoinHostPort(server, "53")
+			}
+			var nd net.Dialer
+			return nd.DialContext(ctx, network, server)
+		},
+	}
+}
@@ -0,0 +1,21 @@
+package sync
+
+import (
+	"os"
+	"syscall"
+)
+
+// errDiskFull is returned when there is no space left on the download disk.
+// The whole queue is paused when it is encountered.
+const errDiskFull = Error("disk is full")
+
+// isDiskFull reports whether err is caused by insufficient disk space.
+func isDiskFull(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	return err == syscall.ENOSPC || err == errDiskFull
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package sync
+
+import "fmt"
+
+// freeSpace returns the available bytes on the filesystem of the given path.
+func freeSpace(path string) (int64, error) {
+	return 0, fmt.Errorf("Operation not supported on this platform")
+}
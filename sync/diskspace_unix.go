@@ -0,0 +1,16 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package sync
+
+import "syscall"
+
+// freeSpace returns the available bytes on the filesystem of the given path.
+func freeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,120 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// apiHost is the host of the Put.io API.
+const apiHost = "api.put.io"
+
+// maxClockSkew is the tolerated difference between the local clock and the
+// clock of the API servers.
+const maxClockSkew = 2 * time.Minute
+
+// Check is the result of a single diagnostic check.
+type Check struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// Diagnose runs self-checks for the most common setup problems: token
+// validity, DNS resolution, API reachability, clock skew, database integrity,
+// download directory permissions and disk space.
+func (c *Client) Diagnose(ctx context.Context) []Check {
+	var checks []Check
+	add := func(name string, msg string, err error) {
+		check := Check{Name: name, OK: err == nil, Message: msg}
+		if err != nil {
+			check.Message = err.Error()
+		}
+		checks = append(checks, check)
+	}
+
+	addrs, err := net.LookupHost(apiHost)
+	add("dns", fmt.Sprintf("%v resolves to %v", apiHost, addrs), err)
+
+	skew, err := c.clockSkew(ctx)
+	if err == nil && (skew > maxClockSkew || skew < -maxClockSkew) {
+		err = fmt.Errorf("local clock is off by %v", skew)
+	}
+	add("api", fmt.Sprintf("API is reachable, clock skew is %v", skew), err)
+
+	msg, err := c.checkToken(ctx)
+	add("token", msg, err)
+
+	add("database", fmt.Sprintf("%v is consistent", c.Store.Path()), c.Store.Check())
+
+	dirs := []string{c.Config.MoveCompletedTo}
+	for _, m := range c.Config.Mappings() {
+		dirs = append(dirs, m.DownloadTo)
+	}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		msg, err = c.checkDir(dir)
+		add("directory", msg, err)
+	}
+
+	return checks
+}
+
+// clockSkew returns the difference between the local clock and the Date
+// header of the API response.
+func (c *Client) clockSkew(ctx context.Context) (time.Duration, error) {
+	req, err := http.NewRequest("HEAD", "https://"+apiHost, nil)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("API is unreachable: %v", err)
+	}
+	resp.Body.Close()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("API response has no valid date: %v", err)
+	}
+	return time.Since(date), nil
+}
+
+func (c *Client) checkToken(ctx context.Context) (string, error) {
+	if c.Config.OAuth2Token == "" {
+		return "", Error("no OAuth2 token, please log in")
+	}
+
+	info, err := c.C.Account.Info(ctx)
+	if err != nil {
+		return "", fmt.Errorf("token is not valid: %v", err)
+	}
+	if info.Username == "" {
+		return "", Error("token is not valid")
+	}
+	return fmt.Sprintf("logged in as %v", info.Username), nil
+}
+
+// checkDir checks that dir is valid, writable and has free space.
+func (c *Client) checkDir(dir string) (string, error) {
+	err := checkWritable(dir)
+	if err != nil {
+		return "", err
+	}
+
+	free, err := freeSpace(dir)
+	if err != nil {
+		return fmt.Sprintf("%v is writable", dir), nil
+	}
+	if free == 0 {
+		return "", fmt.Errorf("%v has no free space", dir)
+	}
+	return fmt.Sprintf("%v is writable, %v MB free", dir, free/1024/1024), nil
+}
@@ -0,0 +1,154 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Duplicate policies decide what to do when a remote file has the same
+// content, by CRC32 and size, as a file downloaded before.
+const (
+	// Download the file again
+	DuplicateDownload = "download"
+
+	// Don't download the file, mark it as completed
+	DuplicateSkip = "skip"
+
+	// Link the previously downloaded file to the new path instead of
+	// downloading it
+	DuplicateLink = "link"
+)
+
+// ValidDuplicatePolicy reports whether p is a known duplicate policy.
+func ValidDuplicatePolicy(p string) bool {
+	switch p {
+	case DuplicateDownload, DuplicateSkip, DuplicateLink:
+		return true
+	}
+	return false
+}
+
+// HistoryEntry records a downloaded file in the history index.
+type HistoryEntry struct {
+	DownloadID   string     `json:"download_id"`
+	FileID       int64      `json:"file_id"`
+	FileName     string     `json:"file_name"`
+	FileLength   int64      `json:"file_length"`
+	LocalPath    string     `json:"local_path"`
+	DownloadedAt time.Time  `json:"downloaded_at"`
+	Media        *MediaInfo `json:"media"`
+	Movie        *MovieInfo `json:"movie"`
+
+	// Path of the downloaded file before the post-processing, which might
+	// have moved, compressed or encrypted it into LocalPath
+	DownloadPath string `json:"download_path"`
+}
+
+// historyKey is the key of a file in the history index.
+func historyKey(crc32 string, size int64) []byte {
+	return append([]byte(crc32+":"), itob(size)...)
+}
+
+// recordHistory saves the completed download together with its entry in the
+// history index. downloadPath is the path of the file before post-processing.
+func (c *Client) recordHistory(state *State, downloadPath string) {
+	err := c.Store.Batch(c.User.Username, func(tx StoreTx) error {
+		err := tx.SaveState(state)
+		if err != nil || state.CRC32 == "" {
+			return err
+		}
+		return tx.SaveHistory(state.CRC32, state.FileLength, &HistoryEntry{
+			DownloadID:   state.DownloadID,
+			FileID:       state.FileID,
+			FileName:     state.FileName,
+			FileLength:   state.FileLength,
+			LocalPath:    state.LocalPath,
+			DownloadedAt: state.DownloadFinishedAt,
+			DownloadPath: downloadPath,
+			Media:        state.Media,
+			Movie:        state.Movie,
+		})
+	})
+	if err != nil {
+		c.Errorf("Error saving history of %v: %v\n", state.FileName, err)
+	}
+}
+
+// handleDuplicate applies the duplicate policy to a newly seen file. It
+// reports whether the file is handled and must not be downloaded.
+func (c *Client) handleDuplicate(state *State) bool {
+	policy := c.Config.DuplicatePolicy
+	if policy == "" || policy == DuplicateDownload || state.CRC32 == "" {
+		return false
+	}
+
+	entry, err := c.Store.History(state.CRC32, state.FileLength, c.User.Username)
+	if err == ErrHistoryNotFound || (err == nil && entry.FileID == state.FileID) {
+		return false
+	}
+	if err != nil {
+		c.Errorf("Error looking up duplicate %v: %v\n", state.FileName, err)
+		return false
+	}
+
+	// the file is linked outside of a transaction, so that the file
+	// system doesn't hold the database
+	original := entry.LocalPath
+	if policy == DuplicateLink {
+		original = entry.original()
+		if original == "" {
+			return false
+		}
+		err = linkFile(original, state.LocalPath)
+		if err != nil {
+			c.Errorf("Error linking duplicate %v: %v\n", state.FileName, err)
+			return false
+		}
+	}
+
+	state.DownloadStatus = DownloadCompleted
+	state.DownloadFinishedAt = time.Now().UTC()
+	state.DuplicateOf = entry.FileID
+	state.trigger = TriggerDuplicate
+	err = c.Store.SaveState(state, c.User.Username)
+	if err != nil {
+		c.Errorf("Error saving state of duplicate %v: %v\n", state.FileName, err)
+		return false
+	}
+
+	c.Printf("File %v is a duplicate of %v, policy: %v\n", state.FileName, original, policy)
+	return true
+}
+
+// original returns the path of the downloaded file with the content of the
+// entry, or an empty path if there is none. The file is kept as downloaded
+// or only moved by the post-processing, unless it is transformed, e.g.
+// compressed or encrypted, which changes its size.
+func (e *HistoryEntry) original() string {
+	for _, path := range []string{e.DownloadPath, e.LocalPath} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err == nil && info.Mode().IsRegular() && info.Size() == e.FileLength {
+			return path
+		}
+	}
+	return ""
+}
+
+// linkFile creates a hard link to src at dst, or a symbolic link if hard
+// links are not possible, e.g. across filesystems.
+func linkFile(src, dst string) error {
+	err := os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = os.Link(src, dst)
+	if err == nil {
+		return nil
+	}
+	return os.Symlink(src, dst)
+}
@@ -0,0 +1,295 @@
+package sync
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EncryptedExtension is appended to the names of the encrypted files.
+const EncryptedExtension = ".enc"
+
+// EncryptionKeyFile is the name of the key file in the application directory.
+const EncryptionKeyFile = "encryption.key"
+
+// Encrypted files start with the magic and the version, followed by a random
+// nonce prefix. The content is split into chunks, each sealed with AES-256-GCM
+// using the nonce prefix, the chunk counter and a flag marking the last chunk,
+// so that chunks can't be reordered or truncated.
+const (
+	encryptionMagic     = "putioenc"
+	encryptionVersion   = 1
+	encryptionChunkSize = 64 * 1024
+	noncePrefixSize     = 7
+)
+
+const (
+	ErrNotEncrypted    = Error("file is not encrypted by putio-sync")
+	ErrDecryptFailed   = Error("file is corrupted or the key is wrong")
+	ErrInvalidKeyFile  = Error("invalid encryption key file")
+	errEncryptedExists = Error("encrypted file already exists")
+)
+
+// LoadEncryptionKey reads the hex encoded key in the file.
+func LoadEncryptionKey(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidKeyFile
+	}
+	return key, nil
+}
+
+// encryptionKey returns the key in the application directory, generating
+// one on the first use. The key file must be backed up, encrypted files
+// can't be recovered without it.
+func (c *Client) encryptionKey() ([]byte, error) {
+	path := filepath.Join(filepath.Dir(c.Store.Path()), EncryptionKeyFile)
+
+	key, err := LoadEncryptionKey(path)
+	if !os.IsNotExist(err) {
+		return key, err
+	}
+
+	key = make([]byte, 32)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// the key is written completely before it is linked into place, so a
+	// concurrent caller never reads a partial key. The one linking it first
+	// wins and the others use its key.
+	f, err := ioutil.TempFile(filepath.Dir(path), EncryptionKeyFile+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(hex.EncodeToString(key) + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Link(f.Name(), path)
+	if os.IsExist(err) {
+		return LoadEncryptionKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Printf("Generated encryption key %v, back it up to be able to decrypt the files\n", path)
+	return key, nil
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt writes the encrypted content of r to w.
+func encrypt(key []byte, w io.Writer, r io.Reader) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	prefix := make([]byte, noncePrefixSize)
+	_, err = rand.Read(prefix)
+	if err != nil {
+		return err
+	}
+
+	header := append([]byte(encryptionMagic), encryptionVersion)
+	_, err = w.Write(append(header, prefix...))
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, encryptionChunkSize)
+	sealed := make([]byte, 0, encryptionChunkSize+gcm.Overhead())
+	for counter := uint32(0); ; counter++ {
+		// a full chunk is never the last one, so a content of a multiple of
+		// the chunk size ends with an empty chunk
+		n, err := io.ReadFull(r, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+
+		sealed = gcm.Seal(sealed[:0], chunkNonce(prefix, counter, last), buf[:n], nil)
+		_, err = w.Write(sealed)
+		if err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// decrypt writes the decrypted content of r to w.
+func decrypt(key []byte, w io.Writer, r io.Reader) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, len(encryptionMagic)+1+noncePrefixSize)
+	_, err = io.ReadFull(r, header)
+	if err != nil || !bytes.HasPrefix(header, []byte(encryptionMagic)) || header[len(encryptionMagic)] != encryptionVersion {
+		return ErrNotEncrypted
+	}
+	prefix := header[len(encryptionMagic)+1:]
+
+	buf := make([]byte, encryptionChunkSize+gcm.Overhead())
+	plain := make([]byte, 0, encryptionChunkSize)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(r, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+
+		plain, err = gcm.Open(plain[:0], chunkNonce(prefix, counter, last), buf[:n], nil)
+		if err != nil {
+			return ErrDecryptFailed
+		}
+		_, err = w.Write(plain)
+		if err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// transformFile writes fn's output for the content of src to dst, through a
+// temporary file, so that dst never has partial content.
+func transformFile(src, dst string, fn func(w io.Writer, r io.Reader) error) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFile(dst, func(w io.Writer) error {
+		return fn(w, bufio.NewReader(in))
+	})
+}
+
+// writeFile writes fn's output to dst through a temporary file.
+func writeFile(dst string, fn func(w io.Writer) error) error {
+	tmp := dst + inProgressExtension
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(out)
+	err = fn(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// EncryptFile encrypts the file at path to path+EncryptedExtension and removes
+// the original.
+func EncryptFile(key []byte, path string) (string, error) {
+	dst := path + EncryptedExtension
+	if exists(dst) {
+		return "", errEncryptedExists
+	}
+
+	err := transformFile(path, dst, func(w io.Writer, r io.Reader) error {
+		return encrypt(key, w, r)
+	})
+	if err != nil {
+		return "", err
+	}
+	return dst, os.Remove(path)
+}
+
+// DecryptFile decrypts the file at src to dst.
+func DecryptFile(key []byte, src, dst string) error {
+	return transformFile(src, dst, func(w io.Writer, r io.Reader) error {
+		return decrypt(key, w, r)
+	})
+}
+
+// encryptCompleted encrypts the completed download of the task, or every
+// file in it if it is an extracted archive.
+func (c *Client) encryptCompleted(t *Task) error {
+	key, err := c.encryptionKey()
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(t.state.LocalPath)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		dst, err := EncryptFile(key, t.state.LocalPath)
+		if err != nil {
+			return err
+		}
+		t.state.LocalPath = dst
+		return c.Store.SaveState(t.state, c.User.Username)
+	}
+
+	return filepath.Walk(t.state.LocalPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasSuffix(path, EncryptedExtension) {
+			return nil
+		}
+		_, err = EncryptFile(key, path)
+		return err
+	})
+}
@@ -0,0 +1,81 @@
+package sync
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// maxErrors is the number of distinct errors kept in the error log. The
+// least recently seen errors are dropped.
+const maxErrors = 200
+
+// ErrorEntry is a distinct error in the error log. Repeated errors are
+// counted instead of stored again.
+type ErrorEntry struct {
+	// Source file and line which logged the error
+	Source string `json:"source"`
+
+	// Last message of the error
+	Message string `json:"message"`
+
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// numberRe matches the numbers in error messages, which are mostly IDs and
+// sizes that differ between otherwise identical errors.
+var numberRe = regexp.MustCompile(`[0-9]+`)
+
+// key identifies the entry for deduplication.
+func (e *ErrorEntry) key() string {
+	return e.Source + " " + numberRe.ReplaceAllString(e.Message, "#")
+}
+
+// Errorf logs the error like Printf and records it in the error log.
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	_ = l.Output(2, msg)
+
+	if l.errors == nil {
+		return
+	}
+
+	source := "unknown"
+	if _, file, line, ok := runtime.Caller(1); ok {
+		source = fmt.Sprintf("%v:%v", filepath.Base(file), line)
+	}
+
+	entry := &ErrorEntry{
+		Source:  source,
+		Message: strings.TrimSpace(msg),
+		Count:   1,
+	}
+	entry.FirstSeen = time.Now().UTC()
+	entry.LastSeen = entry.FirstSeen
+
+	// the store is written to by the error log goroutine, an error may be
+	// logged in the middle of a transaction
+	select {
+	case l.errors <- entry:
+	default:
+	}
+}
+
+// recordErrors saves the errors logged with Errorf until the channel is
+// closed.
+func (c *Client) recordErrors(errors <-chan *ErrorEntry) {
+	for e := range errors {
+		if c.User == nil || c.User.Username == "" {
+			continue
+		}
+		err := c.Store.RecordError(e, c.User.Username)
+		if err != nil {
+			c.Printf("Error recording error: %v\n", err)
+		}
+	}
+}
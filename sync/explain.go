@@ -0,0 +1,229 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/igungor/go-putio/putio"
+)
+
+// Explanation tells whether a remote file is going to be downloaded, and why,
+// by tracing every rule the poller applies to it.
+type Explanation struct {
+	FileID   int64         `json:"file_id"`
+	Path     string        `json:"path"`
+	Download bool          `json:"download"`
+	Steps    []ExplainStep `json:"steps"`
+}
+
+// ExplainStep is the outcome of a single rule.
+type ExplainStep struct {
+	Rule   string `json:"rule"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+func (e *Explanation) step(rule string, passed bool, format string, v ...interface{}) bool {
+	e.Steps = append(e.Steps, ExplainStep{Rule: rule, Passed: passed, Detail: fmt.Sprintf(format, v...)})
+	return passed
+}
+
+// Explain reports why the remote file, given by its ID or its absolute path
+// in the Put.io file tree, is or isn't downloaded.
+func (c *Client) Explain(ctx context.Context, ref string) (*Explanation, error) {
+	if c.User == nil {
+		return nil, Error("No authenticated user found")
+	}
+
+	file, err := c.resolveRemote(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Explanation{FileID: file.ID}
+	if !e.step("file", !file.IsDir(), "%v, %v bytes", file.Name, file.Size) {
+		e.Steps[0].Detail = "folders are not downloaded, the files in them are"
+		return e, nil
+	}
+
+	// folders between the download root and the file, from the root down
+	m, ancestors, err := c.ancestors(ctx, file.ParentID)
+	if err != nil {
+		e.step("download-root", false, "%v", err)
+		return e, nil
+	}
+	e.step("download-root", true, "folder %v is downloaded to %v", m.DownloadFrom, m.DownloadTo)
+
+	cwd := "/"
+	if len(ancestors) > 0 {
+		cwd = ancestors[len(ancestors)-1].cwd
+	}
+	relpath := filepath.Join(cwd, file.Name)
+	e.Path = relpath
+
+	if m.DownloadFrom == rootFolderID && strings.HasPrefix(relpath, "/"+appFolderName+"/") {
+		e.step("app-folder", false, "%v is the folder of putio-sync", appFolderName)
+		return e, nil
+	}
+	if file.Name == ignoreFileName {
+		e.step("ignore-file", false, "%v files are never downloaded", ignoreFileName)
+		return e, nil
+	}
+
+	if c.Config.SkipHiddenFiles && isHiddenPath(relpath) {
+		e.step("hidden", false, "%v is hidden and hidden files are skipped", relpath)
+		return e, nil
+	}
+
+	var ignores ignoreList
+	for _, a := range ancestors {
+		if a.cwd != "/" && ignores.Match(a.cwd) {
+			e.step("ignore", false, "folder %v is ignored by a %v file", a.cwd, ignoreFileName)
+			return e, nil
+		}
+		files, _, err := c.C.Files.List(ctx, a.id)
+		if err != nil {
+			return nil, err
+		}
+		ignores = append(ignores, c.readIgnoreFiles(ctx, files, filepath.Join(m.DownloadTo, a.cwd), a.cwd)...)
+	}
+	if !e.step("ignore", !ignores.Match(relpath), "%v files", ignoreFileName) {
+		e.Steps[len(e.Steps)-1].Detail = fmt.Sprintf("%v is ignored by a %v file", relpath, ignoreFileName)
+		return e, nil
+	}
+
+	if rule := c.decide(relpath, file); rule != nil {
+		if !e.step("rule", rule.Action != RuleExclude, "matches rule %q with action %v", rule.Name, rule.Action) {
+			return e, nil
+		}
+	} else {
+		e.step("rule", true, "matches no rule, the folder filter applies")
+		if !c.explainFilter(e, m.Filter, relpath, file.Size) {
+			return e, nil
+		}
+	}
+
+	state, err := c.Store.State(file.ID, c.User.Username)
+	switch {
+	case err == ErrStateNotFound:
+		if c.Config.tooOld(file) {
+			e.step("cutoff", false, "added at %v, before %v", file.CreatedAt, c.Config.IgnoreFilesOlderThan)
+			return e, nil
+		}
+		if c.Config.DuplicatePolicy != "" && c.Config.DuplicatePolicy != DuplicateDownload && file.CRC32 != "" {
+			entry, err := c.Store.History(file.CRC32, file.Size, c.User.Username)
+			if err == nil && entry.FileID != file.ID {
+				e.step("duplicate", false, "same content as %v, duplicate policy is %v", entry.LocalPath, c.Config.DuplicatePolicy)
+				return e, nil
+			}
+		}
+		e.step("state", true, "not seen yet, it is queued on the next poll")
+	case err != nil:
+		return nil, err
+	case state.DownloadStatus == DownloadCompleted:
+		detail := fmt.Sprintf("already downloaded to %v at %v", state.LocalPath, state.DownloadFinishedAt)
+		if state.DuplicateOf != 0 {
+			detail = fmt.Sprintf("duplicate of file %v", state.DuplicateOf)
+		} else if state.DownloadedBy.ID != "" && state.DownloadedBy.ID != c.Device.ID {
+			detail = fmt.Sprintf("downloaded by device %v", state.DownloadedBy.Name)
+		}
+		if state.IsHidden {
+			detail += ", hidden from the list"
+		}
+		e.step("state", false, "%v", detail)
+		return e, nil
+	default:
+		detail := fmt.Sprintf("download %v is %v", state.DownloadID, state.DownloadStatus)
+		if state.Error != "" {
+			detail += ": " + state.Error
+		}
+		e.step("state", true, "%v", detail)
+	}
+
+	c.mu.Lock()
+	running := c.CancelFunc != nil
+	c.mu.Unlock()
+	e.Download = e.step("running", running, "sync is %v", c.Status())
+	return e, nil
+}
+
+// explainFilter traces the filter of the folder mapping. It reports whether
+// the file passes the filter.
+func (c *Client) explainFilter(e *Explanation, f Filter, relpath string, size int64) bool {
+	if !e.step("min-size", size >= f.MinSize, "size %v, minimum %v", size, f.MinSize) {
+		return false
+	}
+	for _, p := range f.Exclude {
+		if matchAny([]string{p}, relpath) {
+			e.step("exclude", false, "matches the exclude pattern %q", p)
+			return false
+		}
+	}
+	e.step("exclude", true, "matches no exclude pattern")
+	if len(f.Include) > 0 {
+		return e.step("include", matchAny(f.Include, relpath), "include patterns %q", f.Include)
+	}
+	return true
+}
+
+// resolveRemote finds the remote file by its ID or its absolute path.
+func (c *Client) resolveRemote(ctx context.Context, ref string) (putio.File, error) {
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		return c.C.Files.Get(ctx, id)
+	}
+
+	var file putio.File
+	var id int64 = rootFolderID
+	parts := strings.Split(strings.Trim(ref, "/"), "/")
+	for _, part := range parts {
+		files, _, err := c.C.Files.List(ctx, id)
+		if err != nil {
+			return file, err
+		}
+
+		found := false
+		for _, f := range files {
+			if f.Name == part {
+				file, id, found = f, f.ID, true
+				break
+			}
+		}
+		if !found {
+			return file, fmt.Errorf("%v not found", ref)
+		}
+	}
+	return file, nil
+}
+
+// ancestor is a folder between a download root and a file.
+type ancestor struct {
+	id  int64
+	cwd string
+}
+
+// ancestors returns the folder mapping containing the folder with the given
+// ID, and the folders from the root of the mapping down to it.
+func (c *Client) ancestors(ctx context.Context, id int64) (FolderMapping, []ancestor, error) {
+	m, cwd, err := c.locate(ctx, id)
+	if err != nil {
+		return m, nil, err
+	}
+
+	var list []ancestor
+	for {
+		list = append([]ancestor{{id: id, cwd: cwd}}, list...)
+		if id == m.DownloadFrom {
+			return m, list, nil
+		}
+
+		f, err := c.C.Files.Get(ctx, id)
+		if err != nil {
+			return m, nil, err
+		}
+		id = f.ParentID
+		cwd = filepath.Dir(cwd)
+	}
+}
@@ -0,0 +1,196 @@
+package sync
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Export formats
+const (
+	ExportCSV  = "csv"
+	ExportJSON = "json"
+)
+
+// ExportColumns are the columns of an export, in their default order.
+var ExportColumns = []string{
+	"download_id",
+	"file_id",
+	"file_name",
+	"size",
+	"status",
+	"local_path",
+	"discovered_at",
+	"started_at",
+	"finished_at",
+	"duration",
+	"speed",
+	"downloaded_by",
+	"duplicate_of",
+	"error",
+}
+
+// ValidExportColumn reports whether column is a known export column.
+func ValidExportColumn(column string) bool {
+	for _, c := range ExportColumns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidExportFormat reports whether format is a known export format.
+func ValidExportFormat(format string) bool {
+	return format == ExportCSV || format == ExportJSON
+}
+
+// exportValue returns the value of the column for the state. Times are in
+// RFC 3339 and durations in seconds. Unknown times are nil.
+func exportValue(state *State, column string) interface{} {
+	timeValue := func(t time.Time) interface{} {
+		if t.IsZero() {
+			return nil
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+
+	switch column {
+	case "download_id":
+		return state.DownloadID
+	case "file_id":
+		return state.FileID
+	case "file_name":
+		return state.FileName
+	case "size":
+		return state.FileLength
+	case "status":
+		return state.DownloadStatus.String()
+	case "local_path":
+		return state.LocalPath
+	case "discovered_at":
+		return timeValue(state.DiscoveredAt)
+	case "started_at":
+		return timeValue(state.DownloadStartedAt)
+	case "finished_at":
+		return timeValue(state.DownloadFinishedAt)
+	case "duration":
+		if state.DownloadStartedAt.IsZero() || state.DownloadFinishedAt.Before(state.DownloadStartedAt) {
+			return nil
+		}
+		return state.DownloadFinishedAt.Sub(state.DownloadStartedAt).Seconds()
+	case "speed":
+		return state.DownloadSpeed
+	case "downloaded_by":
+		return state.DownloadedBy.Name
+	case "duplicate_of":
+		if state.DuplicateOf == 0 {
+			return nil
+		}
+		return state.DuplicateOf
+	case "error":
+		return state.Error
+	}
+	return nil
+}
+
+// exportStates returns the states and the downloads in the history without
+// a visible state, e.g. the cleared downloads, ordered by the file ID.
+func (c *Client) exportStates() ([]*State, error) {
+	states, err := c.Store.States(c.User.Username)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := c.Store.HistoryEntries(c.User.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int64]bool, len(states))
+	for _, state := range states {
+		seen[state.FileID] = true
+	}
+	for _, e := range entries {
+		if seen[e.FileID] {
+			continue
+		}
+		seen[e.FileID] = true
+		states = append(states, &State{
+			DownloadID:         e.DownloadID,
+			FileID:             e.FileID,
+			FileName:           e.FileName,
+			FileLength:         e.FileLength,
+			LocalPath:          e.LocalPath,
+			DownloadStatus:     DownloadCompleted,
+			DownloadFinishedAt: e.DownloadedAt,
+		})
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].FileID < states[j].FileID
+	})
+	return states, nil
+}
+
+// Export writes the states and the download history to w in the given
+// format, with the given columns. All the columns are written if columns is
+// empty.
+func (c *Client) Export(w io.Writer, format string, columns []string) error {
+	if !ValidExportFormat(format) {
+		return Error("invalid export format")
+	}
+	if len(columns) == 0 {
+		columns = ExportColumns
+	}
+	for _, column := range columns {
+		if !ValidExportColumn(column) {
+			return fmt.Errorf("unknown export column %q", column)
+		}
+	}
+
+	states, err := c.exportStates()
+	if err != nil {
+		return err
+	}
+
+	if format == ExportJSON {
+		rows := make([]map[string]interface{}, 0, len(states))
+		for _, state := range states {
+			row := make(map[string]interface{}, len(columns))
+			for _, column := range columns {
+				row[column] = exportValue(state, column)
+			}
+			rows = append(rows, row)
+		}
+		return json.NewEncoder(w).Encode(rows)
+	}
+
+	cw := csv.NewWriter(w)
+	err = cw.Write(columns)
+	if err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	for _, state := range states {
+		for i, column := range columns {
+			switch v := exportValue(state, column).(type) {
+			case nil:
+				record[i] = ""
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
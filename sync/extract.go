@@ -0,0 +1,257 @@
+package sync
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archive extensions and the extensions to trim for the extraction directory
+var (
+	tarExtensions = []string{".tar.gz", ".tgz", ".tar"}
+	zipExtensions = []string{".zip"}
+)
+
+// archiveExt returns the archive extension of name among exts, if any.
+func archiveExt(name string, exts []string) (string, bool) {
+	lower := strings.ToLower(name)
+	for _, ext := range exts {
+		if strings.HasSuffix(lower, ext) {
+			return ext, true
+		}
+	}
+	return "", false
+}
+
+// streamExtract downloads a tar archive with a single connection and extracts
+// it on the fly, so the archive itself never touches the disk. Files are
+// extracted into a directory named after the archive. Streamed downloads are
+// not resumable; a cancelled download starts over.
+func (c *Client) streamExtract(ctx context.Context, t *Task, taskdir string) error {
+	ext, _ := archiveExt(t.state.FileName, tarExtensions)
+	dest := filepath.Join(taskdir, t.state.FileName[:len(t.state.FileName)-len(ext)])
+
+	c.Debugf("Extracting %v to %v while downloading\n", t, dest)
+
+	t.state.DownloadStartedAt = time.Now().UTC()
+	t.state.DownloadStatus = DownloadInProgress
+	t.state.BytesTransferredSinceLastUpdate = 0
+	err := c.Store.SaveState(t.state, c.User.Username)
+	if err != nil {
+		return err
+	}
+
+	body, err := c.C.Files.Download(ctx, t.state.FileID, false, nil)
+	if err != nil {
+		return c.failStreamExtract(t, err)
+	}
+	defer body.Close()
+
+	h := crc32.NewIEEE()
+	r := &progressReader{ctx: ctx, r: io.TeeReader(body, h), c: c, state: t.state}
+
+	var tr *tar.Reader
+	if ext == ".tar" {
+		tr = tar.NewReader(r)
+	} else {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return c.failStreamExtract(t, err)
+		}
+		defer gr.Close()
+		tr = tar.NewReader(gr)
+	}
+
+	err = extractTar(tr, dest)
+	if err != nil {
+		return c.failStreamExtract(t, err)
+	}
+
+	// consume the trailing bytes for the checksum
+	_, err = io.Copy(ioutil.Discard, r)
+	if err != nil {
+		return c.failStreamExtract(t, err)
+	}
+
+	err = verifyCRC32(h, t.state.CRC32)
+	if err != nil {
+		return c.failStreamExtract(t, err)
+	}
+
+	t.state.LocalPath = dest
+	t.state.DownloadStatus = DownloadCompleted
+	t.state.DownloadFinishedAt = time.Now().UTC()
+	t.state.DownloadedBy = c.DeviceInfo()
+	t.state.Error = ""
+	return c.Store.SaveState(t.state, c.User.Username)
+}
+
+func (c *Client) failStreamExtract(t *Task, err error) error {
+	if isDiskFull(err) {
+		return c.failDiskFull(t)
+	}
+
+	if strings.Contains(err.Error(), "request canceled") || err == context.Canceled {
+		err = context.Canceled
+		t.state.DownloadStatus = DownloadPaused
+	} else {
+		t.state.DownloadStatus = DownloadFailed
+		t.state.Error = err.Error()
+	}
+	_ = c.Store.SaveState(t.state, c.User.Username)
+	return err
+}
+
+// progressReader accounts the bytes read from a download for throughput,
+// speed limit and the download speed of the state.
+type progressReader struct {
+	ctx   context.Context
+	r     io.Reader
+	c     *Client
+	state *State
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.c.meter.Add(int64(n))
+
+		p.state.mu.Lock()
+		p.state.BytesTransferredSinceLastUpdate += int64(n)
+		p.state.mu.Unlock()
+
+		if werr := p.c.limiter.Wait(p.ctx, int64(n)); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// extractTar extracts regular files and directories of the archive into dest.
+func extractTar(tr *tar.Reader, dest string) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := extractPath(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = extractFile(tr, target, hdr.FileInfo().Mode())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractZip extracts an already downloaded zip archive next to it and
+// deletes the archive afterwards. The archive and its contents have to fit on
+// the disk at the same time. It returns the extraction directory. The archive
+// is kept if the extraction fails.
+func extractZip(path string) (dest string, err error) {
+	ext, _ := archiveExt(path, zipExtensions)
+	dest = path[:len(path)-len(ext)]
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return "", err
+	}
+
+	// remove what is extracted so far, unless the directory was there
+	if _, serr := os.Stat(dest); os.IsNotExist(serr) {
+		defer func() {
+			if err != nil {
+				os.RemoveAll(dest)
+			}
+		}()
+	}
+
+	for _, f := range zr.File {
+		target, err := extractPath(dest, f.Name)
+		if err != nil {
+			zr.Close()
+			return "", err
+		}
+
+		if f.FileInfo().IsDir() {
+			err = os.MkdirAll(target, 0755)
+		} else {
+			var rc io.ReadCloser
+			rc, err = f.Open()
+			if err == nil {
+				err = extractFile(rc, target, f.Mode())
+				rc.Close()
+			}
+		}
+		if err != nil {
+			zr.Close()
+			return "", err
+		}
+	}
+
+	err = zr.Close()
+	if err != nil {
+		return "", err
+	}
+	return dest, os.Remove(path)
+}
+
+// extractPath returns the path of an archive entry under dest. Entries
+// escaping dest are rejected.
+func extractPath(dest, name string) (string, error) {
+	target := filepath.Join(dest, name)
+	if target != dest && !strings.HasPrefix(target, dest+string(filepath.Separator)) {
+		return "", fmt.Errorf("illegal path in archive: %v", name)
+	}
+	return target, nil
+}
+
+func extractFile(r io.Reader, target string, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0200)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// verifyCRC32 compares the sum of h against the expected hex encoded CRC32.
+func verifyCRC32(h hash.Hash, want string) error {
+	sumHex := hex.EncodeToString(h.Sum(nil))
+	if sumHex != want {
+		return fmt.Errorf("CRC32 check failed. got: %v want: %v", sumHex, want)
+	}
+	return nil
+}
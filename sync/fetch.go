@@ -0,0 +1,97 @@
+package sync
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+
+	"github.com/igungor/go-putio/putio"
+)
+
+// Search returns the first page of the Put.io search results for the query.
+func (c *Client) Search(ctx context.Context, query string) ([]putio.File, error) {
+	// the client puts the query into the URL path as is
+	result, err := c.C.Files.Search(ctx, url.PathEscape(query), 1)
+	if err != nil {
+		return nil, err
+	}
+	return result.Files, nil
+}
+
+// Fetch queues the given remote files and folders for download into
+// DownloadTo, whether they are in a download root or not. Folders are walked
+// recursively and their files are queued in the background.
+func (c *Client) Fetch(ids []int64) error {
+	c.mu.Lock()
+	ctx := c.Ctx
+	running := c.CancelFunc != nil
+	c.mu.Unlock()
+
+	if !running {
+		return Error("sync is not running")
+	}
+	ctx = withTrigger(ctx, TriggerAPI)
+
+	for _, id := range ids {
+		f, err := c.C.Files.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if f.IsDir() {
+			ignores, err := c.fetchIgnores(ctx, f)
+			if err != nil {
+				return err
+			}
+			m := FolderMapping{DownloadFrom: id, DownloadTo: c.Config.DownloadTo}
+			dir := "/" + f.Name
+			go func(id int64) {
+				defer c.RecoverCrash()
+				c.walk(ctx, m, id, dir, dir, ignores, true, newReport())
+			}(id)
+			continue
+		}
+
+		err = c.fetchFile(ctx, f, c.Config.DownloadTo, "/")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchFile queues a single remote file for download into the cwd directory
+// of the download root.
+func (c *Client) fetchFile(ctx context.Context, f putio.File, root, cwd string) error {
+	remoteDir := cwd
+	cwd = c.Config.flattenDir(cwd)
+	state, err := c.Store.State(f.ID, c.User.Username)
+	if err == ErrStateNotFound {
+		state = c.newState(f, filepath.Join(root, cwd), nil)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	state.trigger = triggerOf(ctx)
+
+	if state.DownloadStatus == DownloadCompleted {
+		c.Debugf("Skipping already downloaded file %v\n", f)
+		return nil
+	}
+
+	if state.LocalRoot != "" {
+		root = state.LocalRoot
+	}
+	t := NewTask(state, root, taskDir(state, root, cwd), c.segmentsFor(state.FileLength))
+	t.remoteDir = remoteDir
+
+	go func() {
+		select {
+		case c.taskCh <- t:
+			c.Debugf("Adding %v to queue\n", t)
+		case <-ctx.Done():
+		}
+	}()
+	return nil
+}
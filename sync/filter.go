@@ -0,0 +1,54 @@
+package sync
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Filter decides which remote files are going to be downloaded.
+type Filter struct {
+	// Download only the files matching one of these patterns. Every file is
+	// downloaded if no pattern is given.
+	Include []string `json:"include"`
+
+	// Skip the files matching any of these patterns
+	Exclude []string `json:"exclude"`
+
+	// Skip the files smaller than this size, in bytes
+	MinSize int64 `json:"min-size"`
+}
+
+// Match reports whether a file with the given path and size passes the filter.
+// Patterns are shell file name patterns and are matched against both the file
+// name and the path relative to the download root.
+func (f Filter) Match(relpath string, size int64) bool {
+	if size < f.MinSize {
+		return false
+	}
+
+	if matchAny(f.Exclude, relpath) {
+		return false
+	}
+
+	if len(f.Include) == 0 {
+		return true
+	}
+
+	return matchAny(f.Include, relpath)
+}
+
+// matchAny reports whether relpath or its base name matches any of the given
+// patterns. Malformed patterns never match.
+func matchAny(patterns []string, relpath string) bool {
+	relpath = strings.TrimPrefix(filepath.ToSlash(relpath), "/")
+	name := filepath.Base(relpath)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, relpath); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package sync
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// flattenDir returns the local folder of the files in the folder at cwd,
+// relative to the download root, with Config.FlattenTransfers and
+// Config.MaxDepth applied.
+func (c *Config) flattenDir(cwd string) string {
+	cwd = filepath.ToSlash(filepath.Clean(cwd))
+	parts := strings.Split(strings.Trim(cwd, "/"), "/")
+	if parts[0] == "" {
+		return "/"
+	}
+
+	if c.FlattenTransfers {
+		parts = parts[1:]
+	}
+	if c.MaxDepth > 0 && uint(len(parts)) > c.MaxDepth {
+		parts = parts[:c.MaxDepth]
+	}
+	return filepath.Join(append([]string{"/"}, parts...)...)
+}
+
+// taskDir returns the folder of the task relative to root. A file stays in the
+// folder it is started in, so that changing the flattening options doesn't
+// move a partial download away. cwd is the folder of the new files.
+func taskDir(state *State, root, cwd string) string {
+	if state.LocalPath == "" {
+		return cwd
+	}
+	rel, err := filepath.Rel(root, filepath.Dir(state.LocalPath))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return cwd
+	}
+	return filepath.Join("/", rel)
+}
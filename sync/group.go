@@ -0,0 +1,99 @@
+package sync
+
+import (
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// errLead hands the commit of the waiting writes over to a waiting writer.
+const errLead = Error("lead the next group")
+
+// writeGroup commits the writes which arrive while a write transaction is
+// being committed together in the next transaction. Bolt allows a single
+// writer at a time, so the writers of the download loops of the users and of
+// the API queue up behind each other otherwise, paying for a commit each. A
+// lone write is committed at once, unlike with bolt.DB.Batch which waits for
+// more writes.
+type writeGroup struct {
+	mu      sync.Mutex
+	pending []*groupCall
+
+	// A writer is committing the pending writes
+	leading bool
+}
+
+type groupCall struct {
+	fn   func(tx *bolt.Tx) error
+	done chan error
+}
+
+// groupUpdate executes fn in a write transaction, which may be shared with
+// other concurrent writes. fn may be called more than once, so it must not
+// have side effects outside of the transaction.
+func (s *Store) groupUpdate(fn func(tx *bolt.Tx) error) error {
+	g := &s.group
+	call := &groupCall{fn: fn, done: make(chan error, 1)}
+
+	g.mu.Lock()
+	g.pending = append(g.pending, call)
+	lead := !g.leading
+	g.leading = true
+	g.mu.Unlock()
+
+	if !lead {
+		err := <-call.done
+		if err != errLead {
+			return err
+		}
+	}
+
+	g.mu.Lock()
+	calls := g.pending
+	g.pending = nil
+	g.mu.Unlock()
+
+	s.commitGroup(calls)
+
+	// the writes arrived meanwhile are committed by one of their writers,
+	// so that this one returns
+	g.mu.Lock()
+	if len(g.pending) > 0 {
+		g.pending[0].done <- errLead
+	} else {
+		g.leading = false
+	}
+	g.mu.Unlock()
+
+	return <-call.done
+}
+
+// commitGroup executes the writes in a single transaction. If one of them
+// fails, the transaction is rolled back, the error is returned to its writer
+// and the others are executed again.
+func (s *Store) commitGroup(calls []*groupCall) {
+	s.telemetry.Add("putio_sync.store.grouped_writes", int64(len(calls)))
+
+	for len(calls) > 0 {
+		failed := -1
+		err := s.update(func(tx *bolt.Tx) error {
+			for i, c := range calls {
+				err := c.fn(tx)
+				if err != nil {
+					failed = i
+					return err
+				}
+			}
+			return nil
+		})
+		if failed < 0 {
+			for _, c := range calls {
+				c.done <- err
+			}
+			return
+		}
+
+		calls[failed].done <- err
+		calls = append(calls[:failed], calls[failed+1:]...)
+	}
+}
@@ -0,0 +1,149 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// errHalted is returned for the requests made while the sync is halted.
+const errHalted = Error("sync is halted")
+
+// haltTransport is the transport of all the outbound HTTP requests: Put.io,
+// the CDN, the updates, the thumbnails, TMDb, the crash reports and the
+// telemetry. Halting it aborts the requests in flight and refuses the new
+// ones until it is resumed. The MQTT connection is closed separately.
+type haltTransport struct {
+	transport http.RoundTripper
+
+	mu       sync.Mutex
+	halted   bool
+	next     uint64
+	inflight map[uint64]context.CancelFunc
+}
+
+var _ http.RoundTripper = &haltTransport{}
+
+func (h *haltTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+
+	h.mu.Lock()
+	if h.halted {
+		h.mu.Unlock()
+		cancel()
+		return nil, errHalted
+	}
+	if h.inflight == nil {
+		h.inflight = make(map[uint64]context.CancelFunc)
+	}
+	id := h.next
+	h.next++
+	h.inflight[id] = cancel
+	h.mu.Unlock()
+
+	done := func() {
+		h.mu.Lock()
+		delete(h.inflight, id)
+		h.mu.Unlock()
+		cancel()
+	}
+
+	resp, err := h.transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		done()
+		return nil, err
+	}
+	// the request is in flight until its body is closed
+	resp.Body = &haltBody{ReadCloser: resp.Body, done: done}
+	return resp, nil
+}
+
+// halt aborts the requests in flight and refuses the new ones.
+func (h *haltTransport) halt() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.halted = true
+	for id, cancel := range h.inflight {
+		cancel()
+		delete(h.inflight, id)
+	}
+}
+
+// resume lets the requests through again.
+func (h *haltTransport) resume() {
+	h.mu.Lock()
+	h.halted = false
+	h.mu.Unlock()
+}
+
+// isHalted reports whether the requests are refused.
+func (h *haltTransport) isHalted() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.halted
+}
+
+// haltBody releases the request of the response once the body is closed.
+type haltBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *haltBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// Halt aborts all the requests in flight immediately and keeps the daemon
+// idle until Unhalt. Unlike Stop, the running segments are not finished. The
+// downloads are paused and their states are saved, so that they are resumed
+// after the sync is started again.
+func (c *Client) Halt() error {
+	c.mu.Lock()
+	if c.halt.isHalted() {
+		c.mu.Unlock()
+		return Error("already halted")
+	}
+	// the watchers don't resume the sync
+	c.powerPaused = false
+	c.vpnDown = false
+	cancel := c.CancelFunc
+	c.mu.Unlock()
+
+	// the tasks see the cancellation before their requests fail, so that
+	// they are paused rather than failed
+	if cancel != nil {
+		cancel()
+	}
+	c.halt.halt()
+	c.Printf("Halted all network activity\n")
+
+	if cancel == nil {
+		return nil
+	}
+	err := c.Stop()
+	if err == Error("already stopped") {
+		return nil
+	}
+	return err
+}
+
+// Unhalt lets the requests through again. The sync stays stopped until it is
+// started.
+func (c *Client) Unhalt() error {
+	if !c.halt.isHalted() {
+		return Error("not halted")
+	}
+	c.halt.resume()
+	c.Printf("Network activity is allowed again\n")
+	return nil
+}
+
+// Halted reports whether the sync is halted.
+func (c *Client) Halted() bool {
+	return c.halt.isHalted()
+}
@@ -0,0 +1,118 @@
+package sync
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// reservedHeaders are the headers of the download requests which can't be
+// overridden, since the downloads depend on them.
+var reservedHeaders = map[string]bool{
+	"Authorization": true,
+	"Host":          true,
+	"Range":         true,
+}
+
+// ValidateHeaders checks that the custom headers can be sent with the download
+// requests.
+func ValidateHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if name == "" || strings.IndexFunc(name, invalidHeaderRune) >= 0 {
+			return Error("invalid header name")
+		}
+		if reservedHeaders[http.CanonicalHeaderKey(name)] {
+			return Error("header is reserved")
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return Error("invalid header value")
+		}
+	}
+	return nil
+}
+
+// ValidateCookies checks that the cookies can be sent with the download
+// requests.
+func ValidateCookies(cookies map[string]string) error {
+	for name, value := range cookies {
+		if name == "" || strings.IndexFunc(name, invalidHeaderRune) >= 0 || strings.ContainsAny(name, "=;,") {
+			return Error("invalid cookie name")
+		}
+		if strings.ContainsAny(value, "\r\n;") {
+			return Error("invalid cookie value")
+		}
+	}
+	return nil
+}
+
+// invalidHeaderRune reports whether r can't be in a header name.
+func invalidHeaderRune(r rune) bool {
+	return r <= ' ' || r >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, r)
+}
+
+// customHeader reports whether the header is one of the custom headers of
+// the downloads, of any folder mapping.
+func (c *Config) customHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	has := func(headers map[string]string) bool {
+		for k := range headers {
+			if http.CanonicalHeaderKey(k) == name {
+				return true
+			}
+		}
+		return false
+	}
+	if has(c.Headers) {
+		return true
+	}
+	for _, m := range c.FolderMappings {
+		if has(m.Headers) {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadHeaders returns the custom headers of the downloads into root,
+// including the cookies. The headers and the cookies of the folder mapping
+// override the global ones.
+func (c *Config) downloadHeaders(root string) http.Header {
+	headers := make(map[string]string)
+	cookies := make(map[string]string)
+	for k, v := range c.Headers {
+		headers[http.CanonicalHeaderKey(k)] = v
+	}
+	for k, v := range c.Cookies {
+		cookies[k] = v
+	}
+	if m, ok := c.mapping(root); ok {
+		for k, v := range m.Headers {
+			headers[http.CanonicalHeaderKey(k)] = v
+		}
+		for k, v := range m.Cookies {
+			cookies[k] = v
+		}
+	}
+
+	h := make(http.Header)
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	if len(cookies) > 0 {
+		names := make([]string, 0, len(cookies))
+		for name := range cookies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		pairs := make([]string, 0, len(names))
+		for _, name := range names {
+			pairs = append(pairs, name+"="+cookies[name])
+		}
+		if cookie := h.Get("Cookie"); cookie != "" {
+			pairs = append([]string{cookie}, pairs...)
+		}
+		h.Set("Cookie", strings.Join(pairs, "; "))
+	}
+	return h
+}
@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Health is the health of the sync daemon, for the package frameworks of
+// NAS systems and other supervisors which only need a yes or no answer.
+type Health struct {
+	Healthy  bool     `json:"healthy"`
+	Problems []string `json:"problems"`
+
+	StatusSnapshot
+}
+
+// Health checks the daemon. A stopped sync is healthy; a sync which is
+// logged out, out of disk space or not polling anymore is not.
+func (c *Client) Health() Health {
+	h := Health{
+		Problems:       make([]string, 0),
+		StatusSnapshot: c.Snapshot(),
+	}
+
+	if c.User == nil || c.User.Username == "" {
+		h.Problems = append(h.Problems, "no authenticated user found")
+	}
+
+	switch h.Status {
+	case "disk-full":
+		h.Problems = append(h.Problems, "the disk is full")
+	case "vpn-down":
+		h.Problems = append(h.Problems, "the VPN is down")
+	case "stopped", "power-paused", "halted":
+	default:
+		// the poll interval grows up to the maximum while the account is
+		// idle, allow two of them before giving up on the poller
+		max := time.Duration(c.Config.MaxPollInterval)
+		if max <= 0 {
+			max = defaultMaxPollInterval
+		}
+		if !h.LastPoll.IsZero() && time.Since(h.LastPoll) > 2*max {
+			h.Problems = append(h.Problems, fmt.Sprintf("no poll since %v", h.LastPoll.In(c.Config.Location()).Format(time.RFC3339)))
+		}
+	}
+
+	if stalled := c.Stalled(); stalled != "" {
+		h.Problems = append(h.Problems, "stalled "+stalled)
+	}
+
+	h.Healthy = len(h.Problems) == 0
+	return h
+}
+
+// ServeStatusSocket writes the health of the daemon as a single JSON line to
+// every connection to the unix socket at Config.StatusSocket, until ctx is
+// done. It returns immediately if no socket is configured.
+func (c *Client) ServeStatusSocket(ctx context.Context) {
+	path := c.Config.StatusSocket
+	if path == "" {
+		return
+	}
+
+	// a socket left behind by a crashed daemon refuses new listeners
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		c.Printf("Status socket %v is in use by another process\n", path)
+		return
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			c.Errorf("Status socket %v exists and is not a socket\n", path)
+			return
+		}
+		os.Remove(path)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		c.Errorf("Error listening on status socket: %v\n", err)
+		return
+	}
+	defer l.Close()
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				c.Errorf("Error accepting status socket connection: %v\n", err)
+			}
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			err := json.NewEncoder(conn).Encode(c.Health())
+			if err != nil {
+				c.Debugf("Error writing to status socket: %v\n", err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,24 @@
+package sync
+
+import "syscall"
+
+// setHidden sets or clears the hidden attribute of the file.
+func setHidden(path string, hidden bool) error {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	attrs, err := syscall.GetFileAttributes(p)
+	if err != nil {
+		return err
+	}
+
+	want := attrs &^ syscall.FILE_ATTRIBUTE_HIDDEN
+	if hidden {
+		want |= syscall.FILE_ATTRIBUTE_HIDDEN
+	}
+	if want == attrs {
+		return nil
+	}
+	return syscall.SetFileAttributes(p, want)
+}
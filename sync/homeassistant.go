@@ -0,0 +1,273 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Home Assistant MQTT integration defaults
+const (
+	defaultMQTTTopicPrefix     = "putio-sync"
+	defaultMQTTDiscoveryPrefix = "homeassistant"
+
+	mqttKeepAlive      = 60 * time.Second
+	mqttUpdateInterval = 10 * time.Second
+	mqttRetryDelay     = 30 * time.Second
+)
+
+// haEntity is an entity announced with Home Assistant MQTT discovery.
+type haEntity struct {
+	component string
+	id        string
+	config    map[string]interface{}
+}
+
+// ServeMQTT publishes the sync state to the MQTT broker of the configuration
+// following the Home Assistant discovery conventions, and reacts to the sync
+// switch. It reconnects until ctx is done. It returns immediately if no
+// broker is configured.
+func (c *Client) ServeMQTT(ctx context.Context) {
+	if c.Config.MQTTBroker == "" {
+		return
+	}
+
+	for {
+		// the broker isn't connected while the network activity is halted
+		var err error = errHalted
+		if !c.Halted() {
+			err = c.serveMQTT(ctx)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != errHalted {
+			c.Printf("MQTT connection to %v is lost, reconnecting in %v: %v\n", c.Config.MQTTBroker, mqttRetryDelay, err)
+		}
+
+		select {
+		case <-time.After(mqttRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// mqttTopic returns the topic of this device with the given suffix.
+func (c *Client) mqttTopic(suffix string) string {
+	prefix := c.Config.MQTTTopicPrefix
+	if prefix == "" {
+		prefix = defaultMQTTTopicPrefix
+	}
+	return prefix + "/" + c.Device.ID + "/" + suffix
+}
+
+func (c *Client) serveMQTT(ctx context.Context) error {
+	availability := c.mqttTopic("availability")
+	conn, err := dialMQTT(
+		c.Config.MQTTBroker,
+		"putio-sync-"+c.Device.ID,
+		c.Config.MQTTUsername,
+		c.Config.MQTTPassword,
+		mqttKeepAlive,
+		&mqttWill{topic: availability, payload: "offline", retain: true},
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c.Printf("Connected to MQTT broker %v\n", c.Config.MQTTBroker)
+
+	for _, e := range c.haEntities() {
+		b, err := json.Marshal(e.config)
+		if err != nil {
+			return err
+		}
+		discovery := c.Config.MQTTDiscoveryPrefix
+		if discovery == "" {
+			discovery = defaultMQTTDiscoveryPrefix
+		}
+		topic := fmt.Sprintf("%v/%v/putio-sync-%v/%v/config", discovery, e.component, c.Device.ID, e.id)
+		err = conn.Publish(topic, string(b), true)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = conn.Publish(availability, "online", true)
+	if err != nil {
+		return err
+	}
+
+	command := c.mqttTopic("sync/set")
+	err = conn.Subscribe(command)
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		for {
+			topic, payload, err := conn.ReadMessage()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if topic == command {
+				c.handleMQTTCommand(payload)
+				c.publishMQTTState(conn)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(mqttUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		err = c.publishMQTTState(conn)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ticker.C:
+			if c.Halted() {
+				return errHalted
+			}
+		case err = <-errCh:
+			return err
+		case <-ctx.Done():
+			_ = conn.Publish(availability, "offline", true)
+			return nil
+		}
+	}
+}
+
+// handleMQTTCommand starts or stops the sync from the switch.
+func (c *Client) handleMQTTCommand(payload string) {
+	var err error
+	switch strings.ToUpper(strings.TrimSpace(payload)) {
+	case "ON":
+		err = c.Run()
+	case "OFF":
+		err = c.Stop()
+	default:
+		c.Printf("Unknown MQTT command %q\n", payload)
+		return
+	}
+	if err != nil {
+		c.Errorf("Error handling MQTT command %q: %v\n", payload, err)
+	}
+}
+
+// mqttDownload is a download in the attributes of the progress sensor.
+type mqttDownload struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Size     int64   `json:"size"`
+	Progress float64 `json:"progress"`
+}
+
+func (c *Client) publishMQTTState(conn *mqttConn) error {
+	snapshot := c.Snapshot()
+
+	downloads := make([]mqttDownload, 0)
+	var total, done int64
+	if c.User != nil && c.User.Username != "" {
+		states, err := c.Store.States(c.User.Username)
+		if err == nil {
+			for _, s := range states {
+				if s.DownloadStatus != DownloadInProgress {
+					continue
+				}
+				progress := s.Progress()
+				downloads = append(downloads, mqttDownload{ID: s.DownloadID, Name: s.FileName, Size: s.FileLength, Progress: progress})
+				total += s.FileLength
+				done += int64(float64(s.FileLength) * progress / 100)
+			}
+		}
+	}
+
+	var progress float64
+	if total > 0 {
+		progress = 100 * float64(done) / float64(total)
+	}
+
+	state := map[string]interface{}{
+		"status":           snapshot.Status,
+		"label":            snapshot.Label,
+		"running":          snapshot.Status != "stopped",
+		"speed":            int64(snapshot.Speed),
+		"remaining_bytes":  snapshot.RemainingBytes,
+		"active_downloads": snapshot.ActiveDownloads,
+		"progress":         fmt.Sprintf("%.1f", progress),
+		"last_poll":        snapshot.LastPoll,
+		"downloads":        downloads,
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return conn.Publish(c.mqttTopic("state"), string(b), true)
+}
+
+// haEntities are the entities of the putio-sync device in Home Assistant.
+// They all read the state topic.
+func (c *Client) haEntities() []haEntity {
+	device := map[string]interface{}{
+		"identifiers":  []string{"putio-sync-" + c.Device.ID},
+		"name":         "putio-sync " + c.DeviceInfo().Name,
+		"manufacturer": "put.io",
+		"model":        "putio-sync",
+	}
+
+	entity := func(component, id, name string, config map[string]interface{}) haEntity {
+		config["name"] = name
+		config["unique_id"] = "putio-sync-" + c.Device.ID + "-" + id
+		config["object_id"] = "putio_sync_" + strings.Replace(id, "-", "_", -1)
+		config["device"] = device
+		config["availability_topic"] = c.mqttTopic("availability")
+		config["state_topic"] = c.mqttTopic("state")
+		return haEntity{component: component, id: id, config: config}
+	}
+
+	return []haEntity{
+		entity("switch", "sync", "Sync", map[string]interface{}{
+			"command_topic":  c.mqttTopic("sync/set"),
+			"value_template": "{{ 'ON' if value_json.running else 'OFF' }}",
+			"icon":           "mdi:cloud-download",
+		}),
+		entity("sensor", "status", "Status", map[string]interface{}{
+			"value_template": "{{ value_json.label }}",
+		}),
+		entity("sensor", "speed", "Download speed", map[string]interface{}{
+			"value_template":                "{{ value_json.speed }}",
+			"unit_of_measurement":           "B/s",
+			"device_class":                  "data_rate",
+			"state_class":                   "measurement",
+			"suggested_unit_of_measurement": "MB/s",
+		}),
+		entity("sensor", "remaining", "Remaining", map[string]interface{}{
+			"value_template":                "{{ value_json.remaining_bytes }}",
+			"unit_of_measurement":           "B",
+			"device_class":                  "data_size",
+			"suggested_unit_of_measurement": "GB",
+		}),
+		entity("sensor", "active-downloads", "Active downloads", map[string]interface{}{
+			"value_template": "{{ value_json.active_downloads }}",
+			"state_class":    "measurement",
+		}),
+		entity("sensor", "progress", "Download progress", map[string]interface{}{
+			"value_template":           "{{ value_json.progress }}",
+			"unit_of_measurement":      "%",
+			"json_attributes_topic":    c.mqttTopic("state"),
+			"json_attributes_template": "{{ {'downloads': value_json.downloads} | tojson }}",
+		}),
+		entity("sensor", "last-poll", "Last poll", map[string]interface{}{
+			"value_template": "{{ value_json.last_poll }}",
+			"device_class":   "timestamp",
+		}),
+	}
+}
@@ -0,0 +1,160 @@
+package sync
+
+import "sort"
+
+// DefaultLocale is the locale of the messages in the source code.
+const DefaultLocale = "en"
+
+// catalogs holds the translations of user-facing messages. Messages are keyed
+// by their English text, like the gettext catalogs of the web UI.
+var catalogs = map[string]map[string]string{
+	"tr": {
+		// status labels
+		"Stopped":      "Durduruldu",
+		"Up to date":   "Güncel",
+		"Syncing":      "Eşitleniyor",
+		"Disk is full": "Disk dolu",
+		"Paused on battery or metered connection":          "Pilde veya kotalı bağlantıda duraklatıldı",
+		"DNS server must be an IP address":                 "DNS sunucusu bir IP adresi olmalı",
+		"Waiting for the VPN":                              "VPN bekleniyor",
+		"VPN egress must be an IP address or a CIDR block": "VPN çıkışı bir IP adresi veya CIDR bloğu olmalı",
+		"invalid VPN check url":                            "geçersiz VPN kontrol adresi",
+		"invalid rename pattern":                           "geçersiz yeniden adlandırma kalıbı",
+		"empty pipeline name":                              "boş hat adı",
+		"Halted":                                           "Durduruldu, ağ erişimi kesildi",
+		"sync is halted":                                   "eşitleme kesildi",
+		"already halted":                                   "zaten kesilmiş",
+		"not halted":                                       "kesilmemiş",
+		"Syncing slowly, memory is low":                    "Yavaş eşitleniyor, bellek az",
+		"invalid limit":                                    "geçersiz sınır",
+		"sync must be stopped to adopt the local files":    "yerel dosyaları benimsemek için eşitleme durdurulmalı",
+		"invalid export format":                            "geçersiz dışa aktarma biçimi",
+		"unknown export column":                            "bilinmeyen dışa aktarma sütunu",
+		"remote path not found":                            "uzak yol bulunamadı",
+		"no OAuth2 token, please log in":                   "OAuth2 anahtarı yok, lütfen giriş yapın",
+		// status page
+		"Downloads":               "İndirmeler",
+		"File":                    "Dosya",
+		"Size":                    "Boyut",
+		"Status":                  "Durum",
+		"Progress":                "İlerleme",
+		"No downloads":            "İndirme yok",
+		"Recent errors":           "Son hatalar",
+		"Error":                   "Hata",
+		"Count":                   "Sayı",
+		"Last seen":               "Son görülme",
+		"%v remaining at %v/s":    "%v kaldı, %v/sn hızla",
+		", about %v seconds left": ", yaklaşık %v saniye kaldı",
+		"invalid upload folder":   "geçersiz yükleme klasörü",
+		"invalid header name":     "geçersiz başlık adı",
+		"header is reserved":      "başlık ayrılmış",
+		"invalid header value":    "geçersiz başlık değeri",
+		"invalid cookie name":     "geçersiz çerez adı",
+		"invalid cookie value":    "geçersiz çerez değeri",
+		"invalid power policy":    "geçersiz güç politikası",
+		"Idle":                    "Boşta",
+		"Failed":                  "Başarısız",
+		"In queue":                "Sırada",
+		"Paused":                  "Duraklatıldı",
+		"In progress":             "İndiriliyor",
+		"Completed":               "Tamamlandı",
+
+		// errors
+		"already running":                          "zaten çalışıyor",
+		"already stopped":                          "zaten durdurulmuş",
+		"Invalid Put.io folder ID":                 "Geçersiz Put.io klasör numarası",
+		"OAuth2 token not found":                   "OAuth2 anahtarı bulunamadı",
+		"No authenticated user found":              "Oturum açmış kullanıcı bulunamadı",
+		"sync is not running":                      "eşitleme çalışmıyor",
+		"folder is outside of download roots":      "klasör indirme kökleri dışında",
+		"folder is ignored by a .putioignore file": "klasör bir .putioignore dosyası tarafından yok sayılıyor",
+		"not a folder":                             "klasör değil",
+		"state not found":                          "durum bulunamadı",
+		"disk is full":                             "disk dolu",
+		"local file is kept":                       "yerel dosya korundu",
+		"method not allowed":                       "yönteme izin verilmiyor",
+		"invalid folder id":                        "geçersiz klasör numarası",
+		"invalid file id":                          "geçersiz dosya numarası",
+		"file not found":                           "dosya bulunamadı",
+		"invalid depth":                            "geçersiz derinlik",
+		"invalid folder mapping":                   "geçersiz klasör eşlemesi",
+		"invalid conflict policy":                  "geçersiz çakışma politikası",
+		"invalid duplicate policy":                 "geçersiz kopya politikası",
+		"invalid queue policy":                     "geçersiz sıra politikası",
+		"invalid partial naming":                   "geçersiz yarım dosya adlandırması",
+		"invalid locale":                           "geçersiz dil",
+		"empty file":                               "boş dosya",
+		"empty username":                           "boş kullanıcı adı",
+		"empty name":                               "boş ad",
+		"invalid role":                             "geçersiz rol",
+		"backups are disabled":                     "yedekler devre dışı",
+		"invalid callback url":                     "geçersiz geri çağırma adresi",
+		"invalid secret":                           "geçersiz gizli anahtar",
+		"invalid transfer id":                      "geçersiz transfer kimliği",
+		"user not found":                           "kullanıcı bulunamadı",
+		"empty magnet uri":                         "boş magnet adresi",
+		"empty torrent path":                       "boş torrent yolu",
+		"empty device name":                        "boş cihaz adı",
+		"empty search query":                       "boş arama sorgusu",
+		"empty url":                                "boş adres",
+		"status file must be an absolute path":     "durum dosyası mutlak bir yol olmalı",
+		"manifest file must be an absolute path":   "bildirim dosyası mutlak bir yol olmalı",
+		"status socket must be an absolute path":   "durum soketi mutlak bir yol olmalı",
+		"profiling is disabled":                    "profil çıkarma kapalı",
+		"profiling requires an admin api key":      "profil çıkarma için yönetici api anahtarı gerekli",
+		"invalid seconds":                          "geçersiz saniye",
+		"database sizes can't be negative":         "veritabanı boyutları negatif olamaz",
+		"api key required":                         "api anahtarı gerekli",
+		"invalid api key":                          "geçersiz api anahtarı",
+		"authentication required":                  "kimlik doğrulaması gerekli",
+		"permission denied":                        "izin verilmedi",
+		"internal server error":                    "sunucu hatası",
+	},
+}
+
+// Locales returns the supported locales.
+func Locales() []string {
+	locales := []string{DefaultLocale}
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// ValidLocale reports whether the locale is supported.
+func ValidLocale(locale string) bool {
+	_, ok := catalogs[locale]
+	return ok || locale == DefaultLocale
+}
+
+// Translate returns the translation of msg for the given locale. msg is
+// returned as is if there is no translation.
+func Translate(locale, msg string) string {
+	if t, ok := catalogs[locale][msg]; ok {
+		return t
+	}
+	return msg
+}
+
+// T translates msg to the configured locale.
+func (c *Client) T(msg string) string {
+	return Translate(c.Config.Locale, msg)
+}
+
+// statusLabels are the human readable labels of the client statuses.
+var statusLabels = map[string]string{
+	"stopped":      "Stopped",
+	"up-to-date":   "Up to date",
+	"syncing":      "Syncing",
+	"disk-full":    "Disk is full",
+	"power-paused": "Paused on battery or metered connection",
+	"vpn-down":     "Waiting for the VPN",
+	"halted":       "Halted",
+	"degraded":     "Syncing slowly, memory is low",
+}
+
+// StatusLabel returns the translated, human readable current status.
+func (c *Client) StatusLabel() string {
+	return c.T(statusLabels[c.Status()])
+}
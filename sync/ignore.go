@@ -0,0 +1,159 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/igungor/go-putio/putio"
+)
+
+// ignoreFileName is the name of the file which lists the patterns to skip. It
+// can be placed in remote folders or in their local counterparts.
+const ignoreFileName = ".putioignore"
+
+// maxIgnoreFileSize limits the bytes read from an ignore file.
+const maxIgnoreFileSize = 64 * 1024
+
+// ignorePattern is a pattern read from an ignore file. Patterns are matched
+// against paths relative to the folder of the ignore file.
+type ignorePattern struct {
+	dir     string
+	pattern string
+
+	// base is prepended to the matched paths. It is set on the patterns of
+	// a download root when a folder of it is walked as a root on its own.
+	base string
+}
+
+// ignoreList holds the patterns collected while walking down the filetree. A
+// pattern applies to its folder and all the folders below.
+type ignoreList []ignorePattern
+
+// Match reports whether the given path, which is relative to the download
+// root, is ignored.
+func (l ignoreList) Match(relpath string) bool {
+	relpath = filepath.ToSlash(relpath)
+	for _, p := range l {
+		path := relpath
+		if p.base != "" {
+			path = filepath.ToSlash(filepath.Join(p.base, relpath))
+		}
+		rel, ok := relativeTo(p.dir, path)
+		if !ok {
+			continue
+		}
+		if matchAny([]string{p.pattern}, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// relativeTo returns the path relative to dir. It reports false if path is
+// not under dir.
+func relativeTo(dir, path string) (string, bool) {
+	dir = strings.TrimSuffix(dir, "/")
+	if dir == "" {
+		return strings.TrimPrefix(path, "/"), true
+	}
+	if !strings.HasPrefix(path, dir+"/") {
+		return "", false
+	}
+	return path[len(dir)+1:], true
+}
+
+// parseIgnore reads the patterns of an ignore file located at dir. Empty lines
+// and lines starting with '#' are skipped.
+func parseIgnore(r io.Reader, dir string) (ignoreList, error) {
+	var l ignoreList
+	scanner := bufio.NewScanner(io.LimitReader(r, maxIgnoreFileSize))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		l = append(l, ignorePattern{
+			dir:     filepath.ToSlash(dir),
+			pattern: strings.TrimSuffix(line, "/"),
+		})
+	}
+	return l, scanner.Err()
+}
+
+// readIgnoreFiles collects the patterns of the remote ignore file in files, if
+// any, and the local ignore file of the corresponding local directory.
+func (c *Client) readIgnoreFiles(ctx context.Context, files []putio.File, localdir, cwd string) ignoreList {
+	var l ignoreList
+
+	for _, file := range files {
+		if file.IsDir() || file.Name != ignoreFileName {
+			continue
+		}
+
+		body, err := c.C.Files.Download(ctx, file.ID, false, nil)
+		if err != nil {
+			c.Errorf("Error downloading ignore file %v: %v\n", file.ID, err)
+			break
+		}
+		patterns, err := parseIgnore(body, cwd)
+		body.Close()
+		if err != nil {
+			c.Errorf("Error reading ignore file %v: %v\n", file.ID, err)
+			break
+		}
+		l = append(l, patterns...)
+		break
+	}
+
+	f, err := os.Open(filepath.Join(localdir, ignoreFileName))
+	if err != nil {
+		return l
+	}
+	defer f.Close()
+
+	patterns, err := parseIgnore(f, cwd)
+	if err != nil {
+		c.Errorf("Error reading local ignore file in %v: %v\n", localdir, err)
+		return l
+	}
+	return append(l, patterns...)
+}
+
+// ancestorIgnores collects the patterns of the ignore files in the given
+// folders of the download root, which walk collects on its way down to the
+// folder below them.
+func (c *Client) ancestorIgnores(ctx context.Context, m FolderMapping, folders []ancestor) (ignoreList, error) {
+	var l ignoreList
+	for _, a := range folders {
+		files, _, err := c.C.Files.List(ctx, a.id)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, c.readIgnoreFiles(ctx, files, filepath.Join(m.DownloadTo, a.cwd), a.cwd)...)
+	}
+	return l, nil
+}
+
+// fetchIgnores returns the patterns of the download root of the folder f
+// which apply to it, so that they hold when the folder is fetched as /<name>.
+// There are none if the folder is outside of the download roots.
+func (c *Client) fetchIgnores(ctx context.Context, f putio.File) (ignoreList, error) {
+	m, folders, err := c.ancestors(ctx, f.ParentID)
+	if err != nil {
+		return nil, nil
+	}
+
+	l, err := c.ancestorIgnores(ctx, m, folders)
+	if err != nil {
+		return nil, err
+	}
+	base := folders[len(folders)-1].cwd
+	for i := range l {
+		l[i].base = base
+	}
+	return l, nil
+}
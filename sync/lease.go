@@ -0,0 +1,375 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igungor/go-putio/putio"
+)
+
+// leasesFolderName is the folder in the application folder which holds the
+// download leases.
+const leasesFolderName = "leases"
+
+// A lease expires unless renewed within leaseTTL, so that a crashed device
+// doesn't block a file forever.
+const leaseTTL = time.Hour
+
+// errLeased is returned when another device holds the lease of a file.
+const errLeased = Error("file is downloaded by another device")
+
+// lease is a marker in the leases folder, claiming a file for a device.
+type lease struct {
+	FileID    int64     `json:"file_id"`
+	Device    Device    `json:"device"`
+	Expires   time.Time `json:"expires"`
+	Completed bool      `json:"completed"`
+}
+
+// valid reports whether the lease still holds.
+func (l *lease) valid() bool {
+	return l.Completed || time.Now().Before(l.Expires)
+}
+
+// leaseFileName returns the name of the lease file of the file. Completed
+// leases, which never expire, are told apart by their names, so that pruning
+// doesn't read them.
+func leaseFileName(fileID int64, completed bool) string {
+	name := strconv.FormatInt(fileID, 10)
+	if completed {
+		name += completedLeaseSuffix
+	}
+	return name + leaseSuffix
+}
+
+const (
+	leaseSuffix          = ".lease"
+	completedLeaseSuffix = ".completed"
+)
+
+// leasesFolder returns the ID of the leases folder, creating it if it doesn't
+// exist.
+func (c *Client) leasesFolder(ctx context.Context) (int64, error) {
+	appFolderID, err := c.appFolder(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	c.appFolderMu.Lock()
+	defer c.appFolderMu.Unlock()
+
+	if c.leasesFolderID != 0 {
+		return c.leasesFolderID, nil
+	}
+
+	files, _, err := c.C.Files.List(ctx, appFolderID)
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range files {
+		if f.Name == leasesFolderName && f.IsDir() {
+			c.leasesFolderID = f.ID
+			return f.ID, nil
+		}
+	}
+
+	f, err := c.C.Files.CreateFolder(ctx, leasesFolderName, appFolderID)
+	if err != nil {
+		return 0, err
+	}
+	c.leasesFolderID = f.ID
+	return f.ID, nil
+}
+
+// leaseFile is a lease along with the Put.io file it is stored in.
+type leaseFile struct {
+	*lease
+	file putio.File
+}
+
+// leases returns the leases of the file, oldest first.
+func (c *Client) leases(ctx context.Context, folderID, fileID int64) ([]leaseFile, error) {
+	files, _, err := c.C.Files.List(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+	return c.fileLeases(ctx, files, fileID)
+}
+
+// fileLeases returns the leases of the file among the files of the leases
+// folder, oldest first.
+func (c *Client) fileLeases(ctx context.Context, files []putio.File, fileID int64) ([]leaseFile, error) {
+	var leases []leaseFile
+	for _, f := range files {
+		if f.Name != leaseFileName(fileID, false) && f.Name != leaseFileName(fileID, true) {
+			continue
+		}
+
+		l, err := c.readLease(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		leases = append(leases, leaseFile{lease: l, file: f})
+	}
+
+	// Put.io file IDs are increasing, so the first upload wins a race
+	sort.Slice(leases, func(i, j int) bool { return leases[i].file.ID < leases[j].file.ID })
+	return leases, nil
+}
+
+// readLease downloads the lease in the file. Unreadable leases are returned
+// as expired, so that they are cleaned up.
+func (c *Client) readLease(ctx context.Context, f putio.File) (*lease, error) {
+	body, err := c.C.Files.Download(ctx, f.ID, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var l lease
+	err = json.NewDecoder(io.LimitReader(body, 64*1024)).Decode(&l)
+	if err != nil {
+		return &lease{}, nil
+	}
+	return &l, nil
+}
+
+// pruneLeases deletes the expired leases of the other files in the leases
+// folder, at most once per leaseTTL, as the leases of crashed devices and of
+// cancelled downloads are never replaced. A lease can expire only once its
+// file is older than leaseTTL, and completed leases never expire, so only the
+// other leases are read. files is the listing of the leases folder.
+func (c *Client) pruneLeases(ctx context.Context, files []putio.File, fileID int64) {
+	c.appFolderMu.Lock()
+	due := time.Since(c.leasesPrunedAt) >= leaseTTL
+	if due {
+		c.leasesPrunedAt = time.Now()
+	}
+	if c.validLeases == nil {
+		c.validLeases = make(map[int64]bool)
+	}
+	c.appFolderMu.Unlock()
+	if !due {
+		return
+	}
+
+	var ids []int64
+	for _, f := range files {
+		switch {
+		case f.IsDir() || !strings.HasSuffix(f.Name, leaseSuffix):
+			continue
+		case strings.HasSuffix(f.Name, completedLeaseSuffix+leaseSuffix):
+			continue
+		case f.Name == leaseFileName(fileID, false):
+			continue
+		case f.CreatedAt == nil || time.Since(f.CreatedAt.Time) < leaseTTL:
+			continue
+		}
+
+		// completed leases uploaded before they got their own names
+		c.appFolderMu.Lock()
+		valid := c.validLeases[f.ID]
+		c.appFolderMu.Unlock()
+		if valid {
+			continue
+		}
+
+		l, err := c.readLease(ctx, f)
+		if err != nil {
+			c.Errorf("Error reading lease %v: %v\n", f.Name, err)
+			continue
+		}
+		if l.valid() {
+			c.appFolderMu.Lock()
+			c.validLeases[f.ID] = true
+			c.appFolderMu.Unlock()
+			continue
+		}
+		ids = append(ids, f.ID)
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	err := c.C.Files.Delete(ctx, ids...)
+	if err != nil {
+		c.Errorf("Error pruning expired leases: %v\n", err)
+		return
+	}
+	c.Debugf("Pruned %v expired leases\n", len(ids))
+}
+
+// putLease uploads a lease of this device for the file and removes the
+// leases it replaces. It returns the ID of the uploaded lease file.
+func (c *Client) putLease(ctx context.Context, folderID, fileID int64, completed bool) (int64, error) {
+	l := lease{
+		FileID:    fileID,
+		Device:    c.DeviceInfo(),
+		Expires:   time.Now().Add(leaseTTL),
+		Completed: completed,
+	}
+	b, err := json.Marshal(&l)
+	if err != nil {
+		return 0, err
+	}
+
+	upload, err := c.C.Files.Upload(ctx, bytes.NewReader(b), leaseFileName(fileID, completed), folderID)
+	if err != nil {
+		return 0, err
+	}
+	if upload.File == nil {
+		return 0, Error("lease could not be uploaded")
+	}
+	return upload.File.ID, nil
+}
+
+// deleteLeases removes the leases of this device, except keep, and the
+// expired leases of the other devices.
+func (c *Client) deleteLeases(ctx context.Context, leases []leaseFile, keep int64) error {
+	var ids []int64
+	for _, l := range leases {
+		if l.file.ID == keep {
+			continue
+		}
+		if l.Device.ID == c.Device.ID || !l.valid() {
+			ids = append(ids, l.file.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return c.C.Files.Delete(ctx, ids...)
+}
+
+// acquireLease claims the file of the task for this device, if download
+// leases are enabled. It returns errLeased if another device holds the file;
+// files completed by the other device are marked as completed. The returned
+// function must be called with the result of the download to renew the
+// lease into a permanent one or to release it.
+func (c *Client) acquireLease(ctx context.Context, t *Task) (func(completed bool), error) {
+	noop := func(bool) {}
+	if !c.Config.DownloadLeases {
+		return noop, nil
+	}
+
+	folderID, err := c.leasesFolder(ctx)
+	if err != nil {
+		return noop, err
+	}
+
+	fileID := t.state.FileID
+	holder := func(leases []leaseFile, own int64) *lease {
+		for _, l := range leases {
+			if l.valid() && l.Device.ID != c.Device.ID {
+				return l.lease
+			}
+			if l.file.ID == own {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	files, _, err := c.C.Files.List(ctx, folderID)
+	if err != nil {
+		return noop, err
+	}
+	go c.pruneLeases(ctx, files, fileID)
+
+	leases, err := c.fileLeases(ctx, files, fileID)
+	if err != nil {
+		return noop, err
+	}
+	if l := holder(leases, -1); l != nil {
+		return noop, c.leased(t, l)
+	}
+
+	own, err := c.putLease(ctx, folderID, fileID, false)
+	if err != nil {
+		return noop, err
+	}
+
+	// another device might have uploaded its lease at the same time
+	leases, err = c.leases(ctx, folderID, fileID)
+	if err != nil {
+		return noop, err
+	}
+	if l := holder(leases, own); l != nil {
+		_ = c.C.Files.Delete(ctx, own)
+		return noop, c.leased(t, l)
+	}
+	_ = c.deleteLeases(ctx, leases, own)
+
+	// renew the lease until the download finishes
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(leaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.renewLease(ctx, folderID, fileID, false)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func(completed bool) {
+		close(done)
+
+		// the download context might be cancelled already
+		ctx := context.Background()
+		if completed {
+			c.renewLease(ctx, folderID, fileID, true)
+			return
+		}
+
+		leases, err := c.leases(ctx, folderID, fileID)
+		if err == nil {
+			err = c.deleteLeases(ctx, leases, -1)
+		}
+		if err != nil {
+			c.Errorf("Error releasing lease of %v: %v\n", t, err)
+		}
+	}, nil
+}
+
+// renewLease replaces the lease of this device with a new one.
+func (c *Client) renewLease(ctx context.Context, folderID, fileID int64, completed bool) {
+	own, err := c.putLease(ctx, folderID, fileID, completed)
+	if err != nil {
+		c.Errorf("Error renewing lease of file %v: %v\n", fileID, err)
+		return
+	}
+
+	leases, err := c.leases(ctx, folderID, fileID)
+	if err == nil {
+		err = c.deleteLeases(ctx, leases, own)
+	}
+	if err != nil {
+		c.Errorf("Error cleaning up leases of file %v: %v\n", fileID, err)
+	}
+}
+
+// leased records that the file of the task is held by another device.
+func (c *Client) leased(t *Task, l *lease) error {
+	if l.Completed {
+		t.state.DownloadStatus = DownloadCompleted
+		t.state.DownloadFinishedAt = time.Now().UTC()
+		t.state.DownloadedBy = l.Device
+		t.state.trigger = TriggerLease
+		err := c.Store.SaveState(t.state, c.User.Username)
+		if err != nil {
+			return err
+		}
+	}
+	c.Printf("Skipping %v, %v is downloading it\n", t, l.Device.Name)
+	return errLeased
+}
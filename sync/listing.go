@@ -0,0 +1,194 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/igungor/go-putio/putio"
+)
+
+// defaultListingCacheTTL is the age of a cached folder listing after which
+// the folder is listed again, unless the configuration has another one.
+const defaultListingCacheTTL = 24 * time.Hour
+
+// listing is a cached remote folder listing. It is encoded as Gob and stored
+// to a persistent storage.
+type listing struct {
+	// Size of the folder when it is listed. The size of a folder covers its
+	// whole subtree, so a changed size means changed children.
+	Size int64
+
+	// ETag of the listing response. The changes which keep the size, e.g.
+	// renames, are found by revalidating the listing with it. Without an
+	// ETag, they are found once the listing expires.
+	ETag string
+
+	Parent   putio.File
+	Files    []putio.File
+	ListedAt time.Time
+}
+
+// errNotModified is returned by listFolderIfChanged if the listing is
+// unchanged.
+const errNotModified = Error("listing is not modified")
+
+// listing returns the cached listing of the Put.io folder. It is nil if the
+// folder isn't cached.
+func (s *Store) listing(id int64, forUser string) (*listing, error) {
+	var l *listing
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		if userBkt == nil {
+			return ErrUserNotFound
+		}
+		value := userBkt.Bucket(listingsBucket).Get(itob(id))
+		if value == nil {
+			return nil
+		}
+		l = new(listing)
+		return gob.NewDecoder(bytes.NewReader(value)).Decode(l)
+	})
+	return l, err
+}
+
+// saveListing caches the listing of the Put.io folder.
+func (s *Store) saveListing(id int64, l *listing, forUser string) error {
+	var value bytes.Buffer
+	err := gob.NewEncoder(&value).Encode(l)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		if userBkt == nil {
+			return ErrUserNotFound
+		}
+		return userBkt.Bucket(listingsBucket).Put(itob(id), value.Bytes())
+	})
+}
+
+// ClearListings removes the cached folder listings.
+func (s *Store) ClearListings(forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		err := userBkt.DeleteBucket(listingsBucket)
+		if err != nil {
+			return err
+		}
+		_, err = userBkt.CreateBucket(listingsBucket)
+		return err
+	})
+}
+
+// sawFolder records the size of a remote folder in the listing of its parent.
+func (r *Report) sawFolder(f putio.File) {
+	if r.folderSizes == nil {
+		r.folderSizes = make(map[int64]int64)
+	}
+	r.folderSizes[f.ID] = f.Size
+}
+
+// folderSize returns the size of a remote folder in the listing of its
+// parent, -1 if the parent isn't listed by the walk.
+func (r *Report) folderSize(id int64) int64 {
+	size, ok := r.folderSizes[id]
+	if !ok {
+		return -1
+	}
+	return size
+}
+
+// listingCacheTTL returns the age after which a cached listing is stale.
+func (c *Config) listingCacheTTL() time.Duration {
+	if c.ListingCacheTTL > 0 {
+		return time.Duration(c.ListingCacheTTL)
+	}
+	return defaultListingCacheTTL
+}
+
+// listFolder lists the Put.io folder, from the listing cache if it is enabled
+// and the folder has the same size as when it is cached. The cached listing
+// is revalidated with its ETag if the API has sent one. size is the size of
+// the folder in the listing of its parent, negative if it is unknown, e.g.
+// for the download roots.
+func (c *Client) listFolder(ctx context.Context, id, size int64) ([]putio.File, putio.File, error) {
+	if !c.Config.CacheListings {
+		return c.C.Files.List(ctx, id)
+	}
+
+	var cached *listing
+	if size >= 0 {
+		l, err := c.Store.listing(id, c.User.Username)
+		if err != nil {
+			c.Errorf("Error reading cached listing of folder %v: %v\n", id, err)
+		} else if l != nil && l.Size == size && time.Since(l.ListedAt) < c.Config.listingCacheTTL() {
+			cached = l
+		}
+	}
+	if cached != nil && cached.ETag == "" {
+		c.Debugf("Using cached listing of folder %v\n", id)
+		return cached.Files, cached.Parent, nil
+	}
+
+	var etag string
+	if cached != nil {
+		etag = cached.ETag
+	}
+	files, parent, etag, err := c.listFolderIfChanged(ctx, id, etag)
+	if err == errNotModified {
+		c.Debugf("Using revalidated listing of folder %v\n", id)
+		return cached.Files, cached.Parent, nil
+	}
+	if err != nil {
+		return files, parent, err
+	}
+
+	err = c.Store.saveListing(id, &listing{
+		Size:     parent.Size,
+		ETag:     etag,
+		Parent:   parent,
+		Files:    files,
+		ListedAt: time.Now().UTC(),
+	}, c.User.Username)
+	if err != nil {
+		c.Errorf("Error caching listing of folder %v: %v\n", id, err)
+	}
+	return files, parent, nil
+}
+
+// listFolderIfChanged lists the Put.io folder like FilesService.List, unless
+// the listing still has the given ETag. It returns errNotModified then.
+func (c *Client) listFolderIfChanged(ctx context.Context, id int64, etag string) ([]putio.File, putio.File, string, error) {
+	req, err := c.C.NewRequest(ctx, "GET", fmt.Sprintf("/v2/files/list?parent_id=%v", id), nil)
+	if err != nil {
+		return nil, putio.File{}, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := c.C.Do(req, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotModified {
+		return nil, putio.File{}, etag, errNotModified
+	}
+	if err != nil {
+		return nil, putio.File{}, "", err
+	}
+	defer resp.Body.Close()
+
+	var r struct {
+		Files  []putio.File `json:"files"`
+		Parent putio.File   `json:"parent"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return nil, putio.File{}, "", err
+	}
+	return r.Files, r.Parent, resp.Header.Get("ETag"), nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!windows
+
+package sync
+
+import (
+	"fmt"
+	"os"
+)
+
+func lockFile(f *os.File) error {
+	return fmt.Errorf("Operation not supported on this platform")
+}
@@ -0,0 +1,33 @@
+package sync
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+)
+
+// lockFile locks the whole file exclusively, so that other processes can't
+// read it while it is being written. The lock is released when the file is
+// closed.
+func lockFile(f *os.File) error {
+	var o syscall.Overlapped
+	r, _, err := procLockFileEx.Call(
+		f.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately,
+		0,
+		0xffffffff,
+		0xffffffff,
+		uintptr(unsafe.Pointer(&o)),
+	)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -12,6 +12,12 @@ type Logger struct {
 	debug bool
 	*log.Logger
 	w io.WriteCloser
+
+	// Recent entries for crash reports
+	recent *logRing
+
+	// Errors logged with Errorf, for the error log. Nil if not recorded.
+	errors chan *ErrorEntry
 }
 
 // NewLogger creates a new Logger. If path is not empty, it creates a log file.
@@ -30,10 +36,12 @@ func NewLogger(prefix string, debug bool, path string) *Logger {
 		}
 	}
 
+	recent := newLogRing(crashLogLines)
 	return &Logger{
 		debug:  debug,
-		Logger: log.New(w, prefix, log.Lshortfile|log.LstdFlags),
+		Logger: log.New(io.MultiWriter(w, recent), prefix, log.Lshortfile|log.LstdFlags),
 		w:      w,
+		recent: recent,
 	}
 }
 
@@ -0,0 +1,67 @@
+package sync
+
+import (
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// PartialFile is a file being downloaded, as listed in the in-progress
+// manifest.
+type PartialFile struct {
+	DownloadID string `json:"download_id"`
+	FileID     int64  `json:"file_id"`
+	FileLength int64  `json:"file_length"`
+
+	// Path of the file while it is being downloaded, and once it is
+	// completed
+	Path      string `json:"path"`
+	FinalPath string `json:"final_path"`
+}
+
+// Manifest is the content of the in-progress manifest, for the automation
+// watching the download folders, so that it can skip the files which are not
+// completed yet.
+type Manifest struct {
+	Files     []PartialFile `json:"files"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// Manifest returns the files being downloaded.
+func (c *Client) Manifest() Manifest {
+	m := Manifest{
+		Files:     make([]PartialFile, 0),
+		UpdatedAt: time.Now().UTC(),
+	}
+	for _, t := range c.Tasks.List() {
+		final := filepath.Join(filepath.Clean(t.root), t.cwd, t.state.FileName)
+		m.Files = append(m.Files, PartialFile{
+			DownloadID: t.state.DownloadID,
+			FileID:     t.state.FileID,
+			FileLength: t.state.FileLength,
+			Path:       c.Config.partialPath(final),
+			FinalPath:  final,
+		})
+	}
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].Path < m.Files[j].Path
+	})
+	return m
+}
+
+// writeManifest replaces ManifestFile with the files being downloaded, if it
+// is set.
+func (c *Client) writeManifest() {
+	path := c.Config.ManifestFile
+	if path == "" {
+		return
+	}
+
+	c.manifestMu.Lock()
+	defer c.manifestMu.Unlock()
+
+	err := writeJSONFile(path, c.Manifest())
+	if err != nil {
+		c.Errorf("Error writing in-progress manifest: %v\n", err)
+	}
+}
@@ -0,0 +1,125 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrRemotePathNotFound is returned when a remote path doesn't exist.
+const ErrRemotePathNotFound = Error("remote path not found")
+
+// ResolvePath returns the ID of the Put.io file at the given slash separated
+// path, e.g. "/Movies/New". The root folder is "/".
+func (c *Client) ResolvePath(ctx context.Context, p string) (int64, error) {
+	p = path.Clean("/" + p)
+
+	var id int64
+	if p == "/" {
+		return id, nil
+	}
+
+	for _, name := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		files, _, err := c.C.Files.List(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+
+		found := false
+		for _, f := range files {
+			if f.Name == name {
+				id, found = f.ID, true
+				break
+			}
+		}
+		if !found {
+			return 0, ErrRemotePathNotFound
+		}
+	}
+	return id, nil
+}
+
+// RemotePath returns the slash separated path of the Put.io file with the
+// given ID.
+func (c *Client) RemotePath(ctx context.Context, id int64) (string, error) {
+	var parts []string
+	for id != 0 {
+		f, err := c.C.Files.Get(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		parts = append([]string{f.Name}, parts...)
+		id = f.ParentID
+	}
+	return "/" + strings.Join(parts, "/"), nil
+}
+
+// ValidateMappings checks the folder mappings against the remote file tree
+// and the local file system before they are saved. The remote folders given
+// by DownloadFromPath are resolved into DownloadFrom. The remote folders must
+// exist and the local directories must be writable. The remote folders are
+// not checked if there is no token yet.
+func (c *Client) ValidateMappings(ctx context.Context, mappings []FolderMapping) error {
+	for i := range mappings {
+		m := &mappings[i]
+
+		if m.DownloadTo != "" {
+			err := checkWritable(m.DownloadTo)
+			if err != nil {
+				return err
+			}
+		}
+
+		if c.C == nil {
+			if m.DownloadFromPath != "" {
+				return Error("no OAuth2 token, please log in")
+			}
+			continue
+		}
+
+		if m.DownloadFromPath != "" {
+			id, err := c.ResolvePath(ctx, m.DownloadFromPath)
+			if err != nil {
+				return fmt.Errorf("%v: %v", m.DownloadFromPath, err)
+			}
+			m.DownloadFrom = id
+		}
+
+		// the root folder always exists
+		if m.DownloadFrom == 0 {
+			continue
+		}
+		f, err := c.C.Files.Get(ctx, m.DownloadFrom)
+		if err != nil {
+			return fmt.Errorf("remote folder %v is not found: %v", m.DownloadFrom, err)
+		}
+		if !f.IsDir() {
+			return fmt.Errorf("remote file %v is not a folder", m.DownloadFrom)
+		}
+	}
+	return nil
+}
+
+// checkWritable checks that dir is valid, and creates it if needed to check
+// that it is writable.
+func checkWritable(dir string) error {
+	err := ValidateLocalDir(dir)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, ".putio-sync-check")
+	if err != nil {
+		return fmt.Errorf("%v is not writable: %v", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
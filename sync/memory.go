@@ -0,0 +1,129 @@
+package sync
+
+import (
+	"context"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// memoryCheckInterval is the interval between two memory checks.
+const memoryCheckInterval = 10 * time.Second
+
+// memoryRecovery is the margin of the thresholds which must be cleared
+// before the degraded mode ends, so that the sync doesn't flap around them.
+const memoryRecovery = 0.2
+
+// runtimeMemory returns the memory the Go runtime holds from the operating
+// system. It is close to the resident size of the process where the platform
+// doesn't report it.
+func runtimeMemory() int64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.Sys - m.HeapReleased)
+}
+
+// lowMemory reports whether the memory of the process is above
+// Config.MaxMemory or the free memory of the system is below
+// Config.MinFreeMemory. If recovering is true, the thresholds are moved by
+// memoryRecovery.
+func (c *Client) lowMemory(recovering bool) (bool, string) {
+	margin := 1.0
+	if recovering {
+		margin = 1 + memoryRecovery
+	}
+
+	if max := c.Config.MaxMemory; max > 0 {
+		rss, err := processMemory()
+		if err != nil {
+			rss = runtimeMemory()
+		}
+		if float64(rss)*margin > float64(max) {
+			return true, "process uses " + FormatBytes(rss)
+		}
+	}
+
+	if min := c.Config.MinFreeMemory; min > 0 {
+		free, err := freeMemory()
+		if err != nil {
+			c.Debugf("Error reading free memory: %v\n", err)
+		} else if float64(free) < float64(min)*margin {
+			return true, FormatBytes(free) + " memory is free"
+		}
+	}
+	return false, ""
+}
+
+// watchMemory runs the sync degraded while the memory is low, until ctx is
+// cancelled. A degraded sync downloads a single file with a single segment,
+// which keeps the buffers of the other segments off the memory, and it
+// returns the freed memory to the system.
+func (c *Client) watchMemory(ctx context.Context) {
+	defer c.RecoverCrash()
+
+	// semaphore slots taken while degraded
+	var held int
+	defer func() {
+		for ; held > 0; held-- {
+			<-c.sem
+		}
+		c.setDegraded(false)
+	}()
+
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		degraded := c.isDegraded()
+		low, reason := c.lowMemory(degraded)
+		switch {
+		case low && !degraded:
+			c.Printf("Memory is low, %v, degrading the sync\n", reason)
+			c.setDegraded(true)
+			debug.FreeOSMemory()
+
+			// wait for the running files beyond the first one
+			for n := int(c.parallelFiles()) - 1; held < n; held++ {
+				select {
+				case c.sem <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		case !low && degraded:
+			c.Printf("Memory is back, restoring the sync\n")
+			for ; held > 0; held-- {
+				<-c.sem
+			}
+			c.setDegraded(false)
+		}
+	}
+}
+
+// isDegraded reports whether the sync runs degraded for low memory.
+func (c *Client) isDegraded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.degraded
+}
+
+func (c *Client) setDegraded(degraded bool) {
+	c.mu.Lock()
+	c.degraded = degraded
+	c.mu.Unlock()
+}
+
+// segmentsFor returns the number of connections to download a file of the
+// given size. It is a single one while the sync is degraded.
+func (c *Client) segmentsFor(size int64) uint {
+	if c.isDegraded() {
+		return 1
+	}
+	return c.Config.segmentsFor(size, c.segmentsPerFile())
+}
@@ -0,0 +1,20 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// freeMemory returns the free pages of the system in bytes.
+func freeMemory() (int64, error) {
+	pages, err := syscall.SysctlUint32("vm.page_free_count")
+	if err != nil {
+		return 0, err
+	}
+	return int64(pages) * int64(os.Getpagesize()), nil
+}
+
+func processMemory() (int64, error) {
+	return 0, fmt.Errorf("Operation not supported on this platform")
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package sync
+
+import "fmt"
+
+func freeMemory() (int64, error) {
+	return 0, fmt.Errorf("Operation not supported on this platform")
+}
+
+func processMemory() (int64, error) {
+	return 0, fmt.Errorf("Operation not supported on this platform")
+}
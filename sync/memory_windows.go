@@ -0,0 +1,62 @@
+package sync
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procGlobalMemoryStatusEx    = syscall.NewLazyDLL("kernel32.dll").NewProc("GlobalMemoryStatusEx")
+	procK32GetProcessMemoryInfo = syscall.NewLazyDLL("kernel32.dll").NewProc("K32GetProcessMemoryInfo")
+)
+
+// memoryStatusEx is MEMORYSTATUSEX of Win32.
+type memoryStatusEx struct {
+	Length               uint32
+	MemoryLoad           uint32
+	TotalPhys            uint64
+	AvailPhys            uint64
+	TotalPageFile        uint64
+	AvailPageFile        uint64
+	TotalVirtual         uint64
+	AvailVirtual         uint64
+	AvailExtendedVirtual uint64
+}
+
+// processMemoryCounters is PROCESS_MEMORY_COUNTERS of Win32.
+type processMemoryCounters struct {
+	CB                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+// freeMemory returns the available physical memory.
+func freeMemory() (int64, error) {
+	s := memoryStatusEx{Length: uint32(unsafe.Sizeof(memoryStatusEx{}))}
+	r, _, err := procGlobalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&s)))
+	if r == 0 {
+		return 0, err
+	}
+	return int64(s.AvailPhys), nil
+}
+
+// processMemory returns the working set of the process.
+func processMemory() (int64, error) {
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0, err
+	}
+	m := processMemoryCounters{CB: uint32(unsafe.Sizeof(processMemoryCounters{}))}
+	r, _, err := procK32GetProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&m)), uintptr(m.CB))
+	if r == 0 {
+		return 0, err
+	}
+	return int64(m.WorkingSetSize), nil
+}
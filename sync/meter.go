@@ -0,0 +1,155 @@
+package sync
+
+import (
+	"sync"
+	"time"
+)
+
+// meterWindow is the duration of the rolling window that throughput is
+// computed over.
+const meterWindow = 60 * time.Second
+
+// meter measures the download throughput over a rolling window. Transferred
+// bytes are accounted in one second buckets. The buckets are numbered by the
+// monotonic clock, so that the changes of the wall clock don't skew the rate.
+type meter struct {
+	mu      sync.Mutex
+	buckets [meterWindow / time.Second]meterBucket
+
+	// Start of the measurement, the buckets are numbered from it
+	epoch time.Time
+
+	// Time of the last transfer
+	last time.Time
+}
+
+type meterBucket struct {
+	sec   int64
+	bytes int64
+}
+
+// sec returns the number of the current bucket. m.mu must be held.
+func (m *meter) sec(now time.Time) int64 {
+	if m.epoch.IsZero() {
+		m.epoch = now
+	}
+	return int64(now.Sub(m.epoch) / time.Second)
+}
+
+// Add accounts n transferred bytes.
+func (m *meter) Add(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	sec := m.sec(now)
+	b := &m.buckets[sec%int64(len(m.buckets))]
+	if b.sec != sec {
+		b.sec = sec
+		b.bytes = 0
+	}
+	b.bytes += n
+	m.last = now
+}
+
+// Last returns the time of the last transfer.
+func (m *meter) Last() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last
+}
+
+// Reset drops the measurements, e.g. after the system is suspended, so that
+// the throughput before it doesn't count.
+func (m *meter) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.buckets = [meterWindow / time.Second]meterBucket{}
+	m.epoch = time.Time{}
+}
+
+// Rate returns the average throughput of the window in bytes per second. The
+// window is shorter if the measurement is started recently.
+func (m *meter) Rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.epoch.IsZero() {
+		return 0
+	}
+
+	now := time.Now()
+	sec := m.sec(now)
+	oldest := sec - int64(len(m.buckets)) + 1
+
+	var total int64
+	for _, b := range m.buckets {
+		if b.sec >= oldest && b.sec <= sec {
+			total += b.bytes
+		}
+	}
+
+	window := now.Sub(m.epoch)
+	if window > meterWindow {
+		window = meterWindow
+	}
+	if window < time.Second {
+		window = time.Second
+	}
+	return float64(total) / window.Seconds()
+}
+
+// QueueEstimate is the estimated completion of the download queue.
+type QueueEstimate struct {
+	// Bytes left to download for all the non-completed files
+	RemainingBytes int64 `json:"remaining_bytes"`
+
+	// Throughput of the last minute in bytes per second
+	Speed float64 `json:"speed"`
+
+	// Estimated seconds until the queue is completed. It is -1 if there is no
+	// throughput to estimate from.
+	ETA int64 `json:"eta"`
+
+	// Queue policy and the files waiting for a download slot in their
+	// order
+	Policy  string       `json:"policy"`
+	Pending []QueuedFile `json:"pending"`
+}
+
+// QueueEstimate computes the estimated completion of the download queue from
+// the recent throughput and the remaining bytes.
+func (c *Client) QueueEstimate() (QueueEstimate, error) {
+	var e QueueEstimate
+
+	if c.User == nil {
+		return e, nil
+	}
+
+	states, err := c.Store.States(c.User.Username)
+	if err != nil {
+		return e, err
+	}
+
+	for _, state := range states {
+		if state.DownloadStatus == DownloadCompleted {
+			continue
+		}
+		e.RemainingBytes += state.remaining()
+	}
+
+	e.Policy = c.Config.queuePolicy()
+	e.Pending = c.Queue()
+
+	e.Speed = c.meter.Rate()
+	switch {
+	case e.RemainingBytes == 0:
+		e.ETA = 0
+	case e.Speed == 0:
+		e.ETA = -1
+	default:
+		e.ETA = int64(float64(e.RemainingBytes) / e.Speed)
+	}
+	return e, nil
+}
@@ -0,0 +1,128 @@
+package sync
+
+import (
+	"github.com/boltdb/bolt"
+)
+
+// RenameUser keeps the data of a Put.io account under its current username.
+// Usernames can be changed on Put.io, the user ID can't, so the last known
+// username of every user ID is recorded. If the account is known by another
+// name, its bucket is renamed and the current user is re-keyed. It returns the
+// old username, or an empty string if nothing is migrated.
+func (s *Store) RenameUser(userID int64, username string) (string, error) {
+	if userID == 0 || username == "" {
+		return "", nil
+	}
+
+	var old string
+	var migrate bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		old = string(tx.Bucket(defaultsBucket).Bucket(userIDsBucket).Get(itob(userID)))
+		if old == "" || old == username || tx.Bucket([]byte(old)) == nil {
+			return nil
+		}
+		if tx.Bucket([]byte(username)) != nil {
+			return ErrUserExists
+		}
+		migrate = true
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if old == username {
+		return "", nil
+	}
+
+	if migrate {
+		_, err = s.Backup("rename-user")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = s.update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(defaultsBucket)
+		if migrate {
+			src := tx.Bucket([]byte(old))
+			dst, err := tx.CreateBucket([]byte(username))
+			if err != nil {
+				return err
+			}
+			err = copyBucket(dst, src)
+			if err != nil {
+				return err
+			}
+			err = tx.DeleteBucket([]byte(old))
+			if err != nil {
+				return err
+			}
+			tx.OnCommit(func() {
+				s.seen.forget(old)
+				s.seen.forget(username)
+			})
+			if string(bkt.Get([]byte("current-user"))) == old {
+				err = bkt.Put([]byte("current-user"), []byte(username))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return bkt.Bucket(userIDsBucket).Put(itob(userID), []byte(username))
+	})
+	if err != nil || !migrate {
+		return "", err
+	}
+	return old, nil
+}
+
+// forgetUserID removes the user IDs recorded for the username.
+func forgetUserID(bkt *bolt.Bucket, username string) error {
+	var ids [][]byte
+	err := bkt.ForEach(func(k, v []byte) error {
+		if string(v) == username {
+			ids = append(ids, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err = bkt.Delete(id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyBucket copies all the keys and the nested buckets of src to dst.
+func copyBucket(dst, src *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		child, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(child, src.Bucket(k))
+	})
+}
+
+// migrateUser moves the data stored under an old username of the account to
+// its current username.
+func (c *Client) migrateUser() {
+	if c.User == nil {
+		return
+	}
+	old, err := c.Store.RenameUser(c.User.UserID, c.User.Username)
+	if err != nil {
+		c.Errorf("Error migrating the data of user %v: %v\n", c.User.Username, err)
+		return
+	}
+	if old != "" {
+		c.Printf("User %v is renamed to %v, its data is migrated\n", old, c.User.Username)
+	}
+}
@@ -0,0 +1,115 @@
+package sync
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// moveCompleted moves a completed download from its download directory to
+// MoveCompletedTo, keeping its path relative to the download root.
+func (c *Client) moveCompleted(t *Task) error {
+	dst := filepath.Join(c.Config.MoveCompletedTo, t.cwd, filepath.Base(t.state.LocalPath))
+	if dst == t.state.LocalPath {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = moveFile(t.state.LocalPath, dst)
+	if err != nil {
+		return err
+	}
+
+	c.Debugf("Moved %v to %v\n", t.state.LocalPath, dst)
+	t.state.LocalPath = dst
+	return c.Store.SaveState(t.state, c.User.Username)
+}
+
+// moveFile moves the file or directory at src to dst. Renaming is not possible
+// across filesystems, so the content is copied and then removed in that case.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+
+	if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != syscall.EXDEV {
+		return err
+	}
+
+	err = copyPath(src, dst)
+	if err != nil {
+		// don't leave a partial copy behind
+		_ = os.RemoveAll(dst)
+		return err
+	}
+
+	return os.RemoveAll(src)
+}
+
+// copyPath recursively copies src to dst, preserving file modes.
+func copyPath(src, dst string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return copyFile(src, dst, fi.Mode())
+	}
+
+	err = os.MkdirAll(dst, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err = copyPath(filepath.Join(src, name), filepath.Join(dst, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	// make sure the data is on the disk before the source is removed
+	err = out.Sync()
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,262 @@
+package sync
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttSubscribe  = 8
+	mqttSuback     = 9
+	mqttPingreq    = 12
+	mqttPingresp   = 13
+	mqttDisconnect = 14
+)
+
+// mqttConn is a minimal MQTT 3.1.1 client. It publishes and subscribes with
+// QoS 0 only, which is enough for state updates and commands.
+type mqttConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	// mu serializes the writes
+	mu       sync.Mutex
+	packetID uint16
+}
+
+// mqttWill is the message the broker publishes when the connection is lost.
+type mqttWill struct {
+	topic   string
+	payload string
+	retain  bool
+}
+
+// dialMQTT connects to the broker, e.g. "tcp://host:1883" or
+// "ssl://host:8883", and sends the CONNECT packet.
+func dialMQTT(broker, clientID, username, password string, keepAlive time.Duration, will *mqttWill) (*mqttConn, error) {
+	u, err := url.Parse(broker)
+	if err != nil || u.Host == "" {
+		// host:port without a scheme
+		u = &url.URL{Scheme: "tcp", Host: broker}
+	}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		conn, err = dialer.Dial("tcp", hostPort(u.Host, "1883"))
+	case "ssl", "tls", "mqtts":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(u.Host, "8883"), nil)
+	default:
+		return nil, fmt.Errorf("unsupported MQTT scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &mqttConn{conn: conn, r: bufio.NewReader(conn)}
+	err = c.connect(clientID, username, password, keepAlive, will)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func hostPort(host, defaultPort string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, defaultPort)
+}
+
+func (c *mqttConn) connect(clientID, username, password string, keepAlive time.Duration, will *mqttWill) error {
+	var body []byte
+	body = appendMQTTString(body, "MQTT")
+	body = append(body, 4) // protocol level 3.1.1
+
+	flags := byte(0x02) // clean session
+	if will != nil {
+		flags |= 0x04
+		if will.retain {
+			flags |= 0x20
+		}
+	}
+	if username != "" {
+		flags |= 0x80
+		if password != "" {
+			flags |= 0x40
+		}
+	}
+	body = append(body, flags)
+	body = append(body, byte(keepAlive/time.Second>>8), byte(keepAlive/time.Second))
+
+	body = appendMQTTString(body, clientID)
+	if will != nil {
+		body = appendMQTTString(body, will.topic)
+		body = appendMQTTString(body, will.payload)
+	}
+	if username != "" {
+		body = appendMQTTString(body, username)
+		if password != "" {
+			body = appendMQTTString(body, password)
+		}
+	}
+
+	err := c.write(mqttConnect<<4, body)
+	if err != nil {
+		return err
+	}
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	typ, resp, err := c.read()
+	_ = c.conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+	if typ>>4 != mqttConnack || len(resp) != 2 {
+		return fmt.Errorf("unexpected MQTT packet %v", typ>>4)
+	}
+	if resp[1] != 0 {
+		return fmt.Errorf("MQTT connection refused with code %v", resp[1])
+	}
+	return nil
+}
+
+// Publish sends a QoS 0 message.
+func (c *mqttConn) Publish(topic, payload string, retain bool) error {
+	header := byte(mqttPublish << 4)
+	if retain {
+		header |= 1
+	}
+	body := appendMQTTString(nil, topic)
+	body = append(body, payload...)
+	return c.write(header, body)
+}
+
+// Subscribe subscribes to the topic with QoS 0. The SUBACK is read by the
+// reader of the connection.
+func (c *mqttConn) Subscribe(topic string) error {
+	c.mu.Lock()
+	c.packetID++
+	id := c.packetID
+	c.mu.Unlock()
+
+	body := []byte{byte(id >> 8), byte(id)}
+	body = appendMQTTString(body, topic)
+	body = append(body, 0) // QoS 0
+	return c.write(mqttSubscribe<<4|0x02, body)
+}
+
+// Ping sends a keep alive packet.
+func (c *mqttConn) Ping() error {
+	return c.write(mqttPingreq<<4, nil)
+}
+
+// Close disconnects gracefully, so that the broker doesn't publish the will.
+func (c *mqttConn) Close() error {
+	_ = c.write(mqttDisconnect<<4, nil)
+	return c.conn.Close()
+}
+
+// ReadMessage returns the next message published to a subscribed topic.
+// Other packets are skipped.
+func (c *mqttConn) ReadMessage() (topic, payload string, err error) {
+	for {
+		typ, body, err := c.read()
+		if err != nil {
+			return "", "", err
+		}
+
+		switch typ >> 4 {
+		case mqttPublish:
+			if len(body) < 2 {
+				return "", "", Error("malformed MQTT publish packet")
+			}
+			n := int(binary.BigEndian.Uint16(body))
+			if len(body) < 2+n {
+				return "", "", Error("malformed MQTT publish packet")
+			}
+			topic = string(body[2 : 2+n])
+			rest := body[2+n:]
+			// skip the packet id of QoS 1 and 2 messages
+			if qos := (typ >> 1) & 3; qos > 0 && len(rest) >= 2 {
+				rest = rest[2:]
+			}
+			return topic, string(rest), nil
+		case mqttSuback, mqttPingresp:
+		default:
+			return "", "", fmt.Errorf("unexpected MQTT packet %v", typ>>4)
+		}
+	}
+}
+
+func (c *mqttConn) write(header byte, body []byte) error {
+	packet := []byte{header}
+	packet = appendMQTTLength(packet, len(body))
+	packet = append(packet, body...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+func (c *mqttConn) read() (byte, []byte, error) {
+	typ, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// remaining length is a variable length integer of up to 4 bytes
+	var length, shift uint
+	for i := 0; ; i++ {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= uint(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, Error("malformed MQTT packet length")
+		}
+		shift += 7
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(c.r, body)
+	return typ, body, err
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+func appendMQTTLength(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
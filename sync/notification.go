@@ -0,0 +1,208 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Notification events
+const (
+	NotifyCompleted = "completed"
+	NotifyFailed    = "failed"
+
+	// The polls found no new files for Config.SilenceAlarm
+	NotifySilent = "silent"
+)
+
+// Notification is sent to the notification URL when a download completes or
+// fails, or when nothing new is found for a while. It is the data of the
+// notification template.
+type Notification struct {
+	Event string `json:"event"`
+
+	// Download ID of the file, as in the logs and the API
+	DownloadID string `json:"download_id"`
+
+	// File name and absolute local path
+	Name string `json:"name"`
+	Path string `json:"path"`
+
+	// File size in bytes
+	Size int64 `json:"size"`
+
+	// Average download speed in bytes per second
+	Speed int64 `json:"speed"`
+
+	// Time spent downloading, or the time without new files for silent
+	// events
+	Duration time.Duration `json:"duration"`
+
+	// Time of the last new file, for silent events
+	Since *time.Time `json:"since,omitempty"`
+
+	// Name of the top level file or folder in the download root, which is
+	// the name of the transfer that added the file
+	Transfer string `json:"transfer"`
+
+	// TMDb entry, if the file is matched to a movie
+	Movie *MovieInfo `json:"movie,omitempty"`
+
+	// Failure reason, if the download failed
+	Error string `json:"error,omitempty"`
+}
+
+var notifyFuncs = template.FuncMap{
+	"bytes": FormatBytes,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// ParseNotifyTemplate parses a notification template, e.g.
+//
+//	{{.Transfer}}: {{.Name}} ({{bytes .Size}}) {{.Event}} in {{.Duration}} at {{bytes .Speed}}/s
+//
+// or, for matched movies,
+//
+//	{{with .Movie}}{{.Title}} ({{.Year}}){{else}}{{.Name}}{{end}} is ready
+//
+// Besides the fields of Notification, templates can use the bytes, upper,
+// lower and json functions.
+func ParseNotifyTemplate(text string) (*template.Template, error) {
+	return template.New("notification").Funcs(notifyFuncs).Parse(text)
+}
+
+// FormatBytes formats n as a human readable size, e.g. 1.5 GB.
+func FormatBytes(n interface{}) string {
+	var f float64
+	switch n := n.(type) {
+	case int64:
+		f = float64(n)
+	case float64:
+		f = n
+	}
+
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %v", f, units[i])
+	}
+	return fmt.Sprintf("%.1f %v", f, units[i])
+}
+
+// newNotification describes the outcome of the task.
+func newNotification(t *Task, event string, err error) Notification {
+	transfer := t.state.FileName
+	if cwd := strings.Trim(filepath.ToSlash(t.cwd), "/"); cwd != "" {
+		transfer = strings.SplitN(cwd, "/", 2)[0]
+	}
+
+	n := Notification{
+		Event:      event,
+		DownloadID: t.state.DownloadID,
+		Name:       t.state.FileName,
+		Path:       t.state.LocalPath,
+		Size:       t.state.FileLength,
+		Speed:      int64(t.state.DownloadSpeed),
+		Transfer:   transfer,
+		Movie:      t.state.Movie,
+	}
+	if !t.state.DownloadStartedAt.IsZero() && t.state.DownloadFinishedAt.After(t.state.DownloadStartedAt) {
+		n.Duration = t.state.DownloadFinishedAt.Sub(t.state.DownloadStartedAt).Round(time.Second)
+		if n.Speed == 0 && n.Duration > 0 {
+			n.Speed = int64(float64(n.Size) / n.Duration.Seconds())
+		}
+	}
+	if err != nil {
+		n.Error = err.Error()
+	}
+	return n
+}
+
+// notify posts the outcome of the task to the notification URL, rendered
+// with the notification template or as JSON if there is no template. It
+// doesn't block the download queue.
+func (c *Client) notify(t *Task, event string, err error) {
+	if c.Config.NotifyURL == "" {
+		return
+	}
+
+	n := newNotification(t, event, err)
+	body, contentType, err := c.renderNotification(n)
+	if err != nil {
+		c.Errorf("Error rendering notification for %v: %v\n", t, err)
+		return
+	}
+	c.sendNotification(body, contentType)
+}
+
+// sendNotification posts the rendered notification to the notification URL
+// in the background.
+func (c *Client) sendNotification(body []byte, contentType string) {
+	url := c.Config.NotifyURL
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			c.Errorf("Error sending notification: %v\n", err)
+			return
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("User-Agent", defaultUserAgent)
+
+		client := &http.Client{Transport: c.transport}
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			c.Errorf("Error sending notification: %v\n", err)
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			c.Errorf("Error sending notification: %v\n", resp.Status)
+		}
+	}()
+}
+
+// renderNotification returns the body of the notification and its content
+// type.
+func (c *Client) renderNotification(n Notification) ([]byte, string, error) {
+	if c.Config.NotifyTemplate == "" {
+		b, err := json.Marshal(n)
+		return b, "application/json", err
+	}
+
+	tmpl, err := ParseNotifyTemplate(c.Config.NotifyTemplate)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, n)
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType := "text/plain; charset=utf-8"
+	if json.Valid(buf.Bytes()) {
+		contentType = "application/json"
+	}
+	return buf.Bytes(), contentType, nil
+}
@@ -0,0 +1,107 @@
+package sync
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Partial namings decide how a file is named while it is being downloaded, so
+// that media scanners and other sync tools can be told to ignore it.
+const (
+	// foo.mkv is downloaded as foo.mkv.putdl
+	PartialPutdl = "putdl"
+
+	// foo.mkv is downloaded as foo.mkv.part
+	PartialPart = "part"
+
+	// foo.mkv is downloaded as foo.mkv.!sync
+	PartialSync = "sync"
+
+	// foo.mkv is downloaded as the hidden file .foo.mkv.putdl
+	PartialHidden = "hidden"
+)
+
+// partialNamings are all the partial namings, the default one first.
+var partialNamings = []string{PartialPutdl, PartialPart, PartialSync, PartialHidden}
+
+// ValidPartialNaming reports whether n is a known partial naming.
+func ValidPartialNaming(n string) bool {
+	for _, naming := range partialNamings {
+		if n == naming {
+			return true
+		}
+	}
+	return false
+}
+
+// partialPath returns the path of the file at path while it is being
+// downloaded with the given naming. An unknown naming is the default one.
+func partialPath(naming, path string) string {
+	switch naming {
+	case PartialPart:
+		return path + ".part"
+	case PartialSync:
+		return path + ".!sync"
+	case PartialHidden:
+		dir, name := filepath.Split(path)
+		return filepath.Join(dir, "."+name+inProgressExtension)
+	default:
+		return path + inProgressExtension
+	}
+}
+
+// partialPath returns the path of the file at path while it is being
+// downloaded.
+func (c *Config) partialPath(path string) string {
+	return partialPath(c.PartialNaming, path)
+}
+
+// isPartial reports whether the file name is a partial download of any
+// naming.
+func isPartial(name string) bool {
+	return strings.HasSuffix(name, inProgressExtension) ||
+		strings.HasSuffix(name, ".part") ||
+		strings.HasSuffix(name, ".!sync")
+}
+
+// findPartial returns the existing partial download of the file at path with
+// another naming than the configured one, so that a download started before
+// the naming is changed can be resumed. It returns an empty string if there
+// is none.
+func (c *Config) findPartial(path string) string {
+	current := c.partialPath(path)
+	for _, naming := range partialNamings {
+		p := partialPath(naming, path)
+		if p != current && exists(p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// partialExists reports whether the file at path is being downloaded with any
+// naming.
+func partialExists(path string) bool {
+	for _, naming := range partialNamings {
+		if exists(partialPath(naming, path)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHidden reports whether the file name is of a hidden file on Unix.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// isHiddenPath reports whether the file or one of its parent folders is
+// hidden.
+func isHiddenPath(path string) bool {
+	for _, name := range strings.Split(filepath.ToSlash(path), "/") {
+		if isHidden(name) {
+			return true
+		}
+	}
+	return false
+}
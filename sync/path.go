@@ -0,0 +1,54 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ValidateLocalDir checks that dir is an absolute path on an available
+// volume. Besides regular paths, drive letter roots (C:\Downloads) and UNC
+// paths (\\nas\media) are supported on Windows. Network shares are checked
+// with the credentials of the current user only.
+func ValidateLocalDir(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("empty directory")
+	}
+
+	vol := filepath.VolumeName(dir)
+
+	if strings.HasPrefix(dir, `\\`) && vol == "" {
+		return fmt.Errorf("%q must include both server and share names, e.g. \\\\nas\\media", dir)
+	}
+
+	if !filepath.IsAbs(dir) {
+		// "C:foo" is relative to the current directory of drive C
+		if len(vol) == 2 && vol[1] == ':' {
+			return fmt.Errorf("%q is relative to the current directory of drive %v, use %v\\ instead", dir, vol, vol)
+		}
+		return fmt.Errorf("%q is not an absolute path", dir)
+	}
+
+	root := vol + string(filepath.Separator)
+	fi, err := os.Stat(root)
+	if err != nil {
+		if strings.HasPrefix(vol, `\\`) {
+			return fmt.Errorf("network share %v is unreachable: %v", vol, err)
+		}
+		if vol != "" {
+			return fmt.Errorf("drive %v is not available: %v", vol, err)
+		}
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%v is not a directory", root)
+	}
+
+	// the directory itself is created on demand, but it shouldn't be a file
+	fi, err = os.Stat(dir)
+	if err == nil && !fi.IsDir() {
+		return fmt.Errorf("%v is not a directory", dir)
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Post-processing steps, run in order after a file is downloaded
+const (
+	// Check that the downloaded file is complete
+	StepVerify = "verify"
+
+	// Extract zip archives, if ExtractArchives is set
+	StepExtract = "extract"
+
+	// Transcode or remux videos, if TranscodeProfile is set
+	StepTranscode = "transcode"
+
+	// Read the metadata of videos and audio files, if ProbeMedia is set
+	StepProbe = "probe"
+
+	// Match movies to TMDb entries, if TMDbAPIKey is set
+	StepMatch = "match"
+
+	// Compress the file, if it has one of the CompressExtensions
+	StepCompress = "compress"
+
+	// Encrypt the file, if its folder mapping is encrypted
+	StepEncrypt = "encrypt"
+
+	// Move the file to MoveCompletedTo, if set
+	StepMove = "move"
+
+	// Store the Put.io screenshot next to videos, if SaveThumbnails is set
+	StepThumbnail = "thumbnail"
+
+	// Send the notification, if NotifyURL is set
+	StepNotify = "notify"
+
+	// Queue the remote file for deletion, if DeleteRemoteFile is set
+	StepDeleteRemote = "delete-remote"
+)
+
+// DefaultPipeline is the order of the post-processing steps unless the
+// configuration has another one.
+var DefaultPipeline = []string{
+	StepVerify,
+	StepExtract,
+	StepTranscode,
+	StepProbe,
+	StepMatch,
+	StepCompress,
+	StepEncrypt,
+	StepMove,
+	StepThumbnail,
+	StepNotify,
+	StepDeleteRemote,
+}
+
+// Step statuses
+const (
+	StepRunning = "running"
+	StepDone    = "done"
+	StepSkipped = "skipped"
+	StepFailed  = "failed"
+)
+
+// defaultStepRetries is the number of retries of a failed step unless the
+// configuration has another one.
+const defaultStepRetries = 2
+
+// stepRetryDelay is multiplied by the attempt number to wait between retries.
+const stepRetryDelay = 5 * time.Second
+
+// StepStatus is the outcome of a post-processing step of a file.
+type StepStatus struct {
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	Attempts   int       `json:"attempts"`
+	Progress   float64   `json:"progress"`
+	Error      string    `json:"error,omitempty"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// step is a post-processing step. It is skipped if enabled returns false.
+type step struct {
+	enabled func(c *Client, t *Task) bool
+	run     func(c *Client, ctx context.Context, t *Task) error
+
+	// The download fails with the step, as the file is not usable
+	fatal bool
+}
+
+var steps = map[string]step{
+	StepVerify: {
+		enabled: func(c *Client, t *Task) bool { return true },
+		run:     (*Client).verifyCompleted,
+	},
+	StepExtract: {
+		enabled: func(c *Client, t *Task) bool {
+			_, ok := archiveExt(t.state.LocalPath, zipExtensions)
+			return ok && c.Config.ExtractArchives
+		},
+		run: func(c *Client, ctx context.Context, t *Task) error {
+			dest, err := extractZip(t.state.LocalPath)
+			if err != nil {
+				return err
+			}
+			t.state.LocalPath = dest
+			return nil
+		},
+		fatal: true,
+	},
+	StepTranscode: {
+		enabled: func(c *Client, t *Task) bool {
+			p, ok := c.Config.transcodeProfile()
+			return ok && p.matches(t.state.LocalPath)
+		},
+		run: (*Client).transcodeCompleted,
+	},
+	StepProbe: {
+		enabled: func(c *Client, t *Task) bool { return c.Config.ProbeMedia && isMedia(t.state) },
+		run:     (*Client).probeCompleted,
+	},
+	StepMatch: {
+		enabled: func(c *Client, t *Task) bool {
+			return c.Config.TMDbAPIKey != "" && strings.HasPrefix(t.state.FileType, "video/")
+		},
+		run: (*Client).matchCompleted,
+	},
+	StepCompress: {
+		enabled: func(c *Client, t *Task) bool { return len(c.Config.CompressExtensions) > 0 },
+		run:     func(c *Client, ctx context.Context, t *Task) error { return c.compressCompleted(t) },
+	},
+	StepEncrypt: {
+		enabled: func(c *Client, t *Task) bool {
+			m, ok := c.Config.mapping(t.root)
+			return ok && m.Encrypt
+		},
+		run: func(c *Client, ctx context.Context, t *Task) error { return c.encryptCompleted(t) },
+	},
+	StepMove: {
+		enabled: func(c *Client, t *Task) bool { return c.Config.MoveCompletedTo != "" },
+		run:     func(c *Client, ctx context.Context, t *Task) error { return c.moveCompleted(t) },
+	},
+	StepThumbnail: {
+		enabled: func(c *Client, t *Task) bool {
+			return c.Config.SaveThumbnails && t.state.FileIcon != "" && strings.HasPrefix(t.state.FileType, "video/")
+		},
+		run: (*Client).thumbnailCompleted,
+	},
+	StepNotify: {
+		enabled: func(c *Client, t *Task) bool { return c.Config.NotifyURL != "" },
+		run: func(c *Client, ctx context.Context, t *Task) error {
+			c.notify(t, NotifyCompleted, nil)
+			return nil
+		},
+	},
+	StepDeleteRemote: {
+		enabled: func(c *Client, t *Task) bool { return c.Config.DeleteRemoteFile },
+		run: func(c *Client, ctx context.Context, t *Task) error {
+			return c.queueRemoteDeletion(t.state)
+		},
+	},
+}
+
+// ValidatePipeline checks that the pipeline consists of known steps, each
+// used once.
+func ValidatePipeline(pipeline []string) error {
+	seen := make(map[string]bool)
+	for _, name := range pipeline {
+		if _, ok := steps[name]; !ok {
+			return fmt.Errorf("unknown pipeline step %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("pipeline step %q is used more than once", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// ValidatePipelines checks the named pipelines.
+func ValidatePipelines(pipelines map[string][]string) error {
+	for name, pipeline := range pipelines {
+		if name == "" {
+			return Error("empty pipeline name")
+		}
+		err := ValidatePipeline(pipeline)
+		if err != nil {
+			return fmt.Errorf("pipeline %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// pipeline returns the order of the post-processing steps of the named
+// pipeline, or the configured order if the name is empty or unknown.
+func (c *Config) pipeline(name string) []string {
+	if p, ok := c.Pipelines[name]; ok && name != "" {
+		return p
+	}
+	if len(c.Pipeline) == 0 {
+		return DefaultPipeline
+	}
+	return c.Pipeline
+}
+
+// stepRetries returns the configured number of retries of a failed step.
+func (c *Config) stepRetries() int {
+	if c.StepRetries == 0 {
+		return defaultStepRetries
+	}
+	if c.StepRetries < 0 {
+		return 0
+	}
+	return c.StepRetries
+}
+
+// postProcess runs the post-processing steps of a downloaded file in order
+// and records their statuses in its state. Failed steps are retried and the
+// pipeline stops at a step which keeps failing, so that e.g. the remote file
+// is not deleted if the local file could not be moved. The download is marked
+// as failed if the step is fatal.
+func (c *Client) postProcess(ctx context.Context, t *Task) error {
+	t.state.Steps = nil
+
+	if _, ok := c.Config.Pipelines[t.state.Pipeline]; t.state.Pipeline != "" && !ok {
+		c.Printf("Pipeline %v of %v is not found, using the default one\n", t.state.Pipeline, t)
+	}
+
+	for _, name := range c.Config.pipeline(t.state.Pipeline) {
+		st := steps[name]
+		t.state.Steps = append(t.state.Steps, StepStatus{Name: name, Status: StepSkipped})
+		status := &t.state.Steps[len(t.state.Steps)-1]
+
+		if st.enabled(c, t) {
+			status.Status = StepRunning
+			c.saveSteps(t)
+
+			err := c.runStep(ctx, t, st, status)
+			if err != nil {
+				status.Status = StepFailed
+				status.Error = err.Error()
+			} else {
+				status.Status = StepDone
+				status.Progress = 1
+			}
+		}
+		status.FinishedAt = time.Now().UTC()
+
+		if status.Status == StepFailed {
+			err := fmt.Errorf("%v step failed after %v attempts: %v", name, status.Attempts, status.Error)
+			if st.fatal {
+				t.state.DownloadStatus = DownloadFailed
+				t.state.Error = err.Error()
+			}
+			c.saveSteps(t)
+			return err
+		}
+		c.saveSteps(t)
+	}
+	return nil
+}
+
+// saveSteps saves the step statuses of the task.
+func (c *Client) saveSteps(t *Task) {
+	err := c.Store.SaveState(t.state, c.User.Username)
+	if err != nil {
+		c.Errorf("Error saving state of %v: %v\n", t, err)
+	}
+}
+
+// stepProgress records the progress of the running step, between 0 and 1.
+func (c *Client) stepProgress(t *Task, progress float64) {
+	if len(t.state.Steps) == 0 {
+		return
+	}
+	t.state.Steps[len(t.state.Steps)-1].Progress = progress
+	c.saveSteps(t)
+}
+
+func (c *Client) runStep(ctx context.Context, t *Task, st step, status *StepStatus) error {
+	retries := c.Config.stepRetries()
+	for {
+		status.Attempts++
+		err := st.run(c, ctx, t)
+		if err == nil || status.Attempts > retries {
+			return err
+		}
+
+		c.Printf("Step %v of %v failed, retrying: %v\n", status.Name, t, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(status.Attempts) * stepRetryDelay):
+		}
+	}
+}
+
+// verifyCompleted checks that the downloaded file has the size of the remote
+// file. Extracted archives are checked while extracting.
+func (c *Client) verifyCompleted(ctx context.Context, t *Task) error {
+	info, err := os.Stat(t.state.LocalPath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	if info.Size() != t.state.FileLength {
+		return fmt.Errorf("local file has %v bytes, expected %v", info.Size(), t.state.FileLength)
+	}
+	return nil
+}
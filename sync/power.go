@@ -0,0 +1,161 @@
+package sync
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// powerCheckInterval is the interval of checking the power source and
+	// the network connection.
+	powerCheckInterval = 30 * time.Second
+
+	// defaultThrottleSpeedLimit is the download speed limit in bytes per
+	// second while throttled, unless the configuration has another one.
+	defaultThrottleSpeedLimit = 512 * 1024
+)
+
+// Power policies decide what to do on battery power or on a metered
+// connection.
+const (
+	// Keep syncing
+	PowerIgnore = ""
+
+	// Stop the sync, and run it again once the condition is over
+	PowerPause = "pause"
+
+	// Limit the download speed to Config.ThrottleSpeedLimit
+	PowerThrottle = "throttle"
+)
+
+// ValidPowerPolicy reports whether p is a known power policy.
+func ValidPowerPolicy(p string) bool {
+	switch p {
+	case PowerIgnore, PowerPause, PowerThrottle:
+		return true
+	}
+	return false
+}
+
+// powerState is the condition of the machine which the power policies apply
+// to.
+type powerState struct {
+	battery bool
+	metered bool
+}
+
+// policy returns the strictest policy which applies to the state, and the
+// reason of it.
+func (s powerState) policy(cfg *Config) (string, string) {
+	policy, reason := PowerIgnore, ""
+	for _, p := range []struct {
+		on     bool
+		policy string
+		reason string
+	}{
+		{s.battery, cfg.OnBattery, "on battery power"},
+		{s.metered, cfg.OnMetered, "on a metered connection"},
+	} {
+		if !p.on || p.policy == PowerIgnore || policy == PowerPause {
+			continue
+		}
+		policy, reason = p.policy, p.reason
+	}
+	return policy, reason
+}
+
+// WatchPower applies Config.OnBattery and Config.OnMetered until ctx is done.
+// The sync is paused or throttled when the machine switches to battery power
+// or to a metered connection, and it is resumed when it switches back. A sync
+// run by the user in between is not paused again until the next switch.
+func (c *Client) WatchPower(ctx context.Context) {
+	defer c.RecoverCrash()
+
+	ticker := time.NewTicker(powerCheckInterval)
+	defer ticker.Stop()
+
+	var last, lastReason string
+	for {
+		if c.Config.OnBattery != PowerIgnore || c.Config.OnMetered != PowerIgnore {
+			var s powerState
+			var err error
+			if c.Config.OnBattery != PowerIgnore {
+				s.battery, err = onBattery()
+				if err != nil {
+					c.Debugf("Error checking the power source: %v\n", err)
+				}
+			}
+			if c.Config.OnMetered != PowerIgnore {
+				s.metered, err = onMetered(ctx)
+				if err != nil {
+					c.Debugf("Error checking the network connection: %v\n", err)
+				}
+			}
+
+			policy, reason := s.policy(c.Config)
+			if policy != last {
+				c.applyPower(last, lastReason, policy, reason)
+				last, lastReason = policy, reason
+			}
+		} else if last != PowerIgnore {
+			c.applyPower(last, lastReason, PowerIgnore, "")
+			last, lastReason = PowerIgnore, ""
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// applyPower switches from the old power policy to the new one.
+func (c *Client) applyPower(old, oldReason, policy, reason string) {
+	switch old {
+	case PowerPause:
+		c.mu.Lock()
+		paused := c.powerPaused
+		c.powerPaused = false
+		c.mu.Unlock()
+		if paused {
+			c.Printf("Resuming the sync, the machine is not %v anymore\n", oldReason)
+			err := c.Run()
+			if err != nil {
+				c.Errorf("Error resuming the sync: %v\n", err)
+			}
+		}
+	case PowerThrottle:
+		c.Printf("Restoring the download speed limit\n")
+		c.limiter.SetRate(c.Config.speedLimit())
+	}
+
+	switch policy {
+	case PowerPause:
+		c.mu.Lock()
+		running := c.CancelFunc != nil
+		c.mu.Unlock()
+		if !running {
+			return
+		}
+		c.Printf("Pausing the sync, the machine is %v\n", reason)
+		err := c.pause()
+		if err != nil {
+			c.Errorf("Error pausing the sync: %v\n", err)
+			return
+		}
+		c.mu.Lock()
+		c.powerPaused = true
+		c.mu.Unlock()
+	case PowerThrottle:
+		rate := c.Config.ThrottleSpeedLimit
+		if rate <= 0 {
+			rate = defaultThrottleSpeedLimit
+		}
+		if limit := c.Config.speedLimit(); limit > 0 && limit < rate {
+			rate = limit
+		}
+		c.Printf("Throttling the downloads to %v bytes/s, the machine is %v\n", rate, reason)
+		c.limiter.SetRate(rate)
+	}
+}
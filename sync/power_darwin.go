@@ -0,0 +1,22 @@
+package sync
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+)
+
+// onBattery reports whether the machine runs on battery power.
+func onBattery() (bool, error) {
+	out, err := exec.Command("pmset", "-g", "batt").Output()
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(out), "'Battery Power'"), nil
+}
+
+// onMetered reports whether the connection is metered. macOS doesn't expose
+// it outside of the Network framework, so it is never.
+func onMetered(ctx context.Context) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,57 @@
+package sync
+
+import (
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// onBattery reports whether the machine runs on battery power. A machine
+// without a battery, or with any mains supply online, is not.
+func onBattery() (bool, error) {
+	dirs, err := filepath.Glob("/sys/class/power_supply/*")
+	if err != nil {
+		return false, err
+	}
+
+	var battery bool
+	for _, dir := range dirs {
+		typ, err := ioutil.ReadFile(filepath.Join(dir, "type"))
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(string(typ)) {
+		case "Mains", "USB":
+			online, err := ioutil.ReadFile(filepath.Join(dir, "online"))
+			if err == nil && strings.TrimSpace(string(online)) == "1" {
+				return false, nil
+			}
+		case "Battery":
+			battery = true
+		}
+	}
+	return battery, nil
+}
+
+// onMetered reports whether NetworkManager considers the primary connection
+// metered, by itself or by a guess.
+func onMetered(ctx context.Context) (bool, error) {
+	out, err := exec.CommandContext(ctx, "busctl", "get-property",
+		"org.freedesktop.NetworkManager",
+		"/org/freedesktop/NetworkManager",
+		"org.freedesktop.NetworkManager",
+		"Metered",
+	).Output()
+	if err != nil {
+		return false, err
+	}
+
+	// NMMetered: 1 is yes, 3 is guessed yes
+	switch strings.TrimSpace(string(out)) {
+	case "u 1", "u 3":
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package sync
+
+import (
+	"context"
+	"fmt"
+)
+
+func onBattery() (bool, error) {
+	return false, fmt.Errorf("Operation not supported on this platform")
+}
+
+func onMetered(ctx context.Context) (bool, error) {
+	return false, fmt.Errorf("Operation not supported on this platform")
+}
@@ -0,0 +1,54 @@
+package sync
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var procGetSystemPowerStatus = syscall.NewLazyDLL("kernel32.dll").NewProc("GetSystemPowerStatus")
+
+// systemPowerStatus is SYSTEM_POWER_STATUS of Win32.
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+// onBattery reports whether the machine runs on battery power.
+func onBattery() (bool, error) {
+	var s systemPowerStatus
+	r, _, err := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&s)))
+	if r == 0 {
+		return false, err
+	}
+	// 0 is offline, 1 is online and 255 is unknown
+	return s.ACLineStatus == 0, nil
+}
+
+// meteredScript prints the cost type of the internet connection profile.
+const meteredScript = `[void][Windows.Networking.Connectivity.NetworkInformation,Windows,ContentType=WindowsRuntime]
+$p = [Windows.Networking.Connectivity.NetworkInformation]::GetInternetConnectionProfile()
+if ($p) { $p.GetConnectionCost().NetworkCostType }`
+
+// onMetered reports whether Windows charges the internet connection by the
+// data, as set in the settings of the connection.
+func onMetered(ctx context.Context) (bool, error) {
+	cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", meteredScript)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	out, err := cmd.Output()
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.TrimSpace(string(out)) {
+	case "Fixed", "Variable":
+		return true, nil
+	}
+	return false, nil
+}
@@ -10,6 +10,11 @@ func Preallocate(f *os.File, size int64) error {
 		return nil
 	}
 
+	// no point in falling back if there is no space left
+	if isDiskFull(err) {
+		return err
+	}
+
 	// use default truncation if platform specific allocation fails
 	return f.Truncate(size)
 }
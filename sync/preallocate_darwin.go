@@ -36,6 +36,10 @@ func preallocate(f *os.File, size int64) error {
 		return fmt.Errorf("Operation not supported")
 	}
 
+	if errno == syscall.ENOSPC {
+		return errno
+	}
+
 	if errno != 0 {
 		return fmt.Errorf("error: %v", errno)
 	}
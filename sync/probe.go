@@ -0,0 +1,135 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// MediaInfo is the metadata of a downloaded video or audio file.
+type MediaInfo struct {
+	// Container format, e.g. "matroska,webm"
+	Format string `json:"format"`
+
+	// Duration in seconds
+	Duration float64 `json:"duration"`
+
+	// Overall bitrate in bits per second
+	Bitrate int64 `json:"bitrate"`
+
+	// Resolution of the first video stream
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	VideoCodec  string   `json:"video_codec"`
+	AudioCodecs []string `json:"audio_codecs"`
+
+	// Languages of the audio and subtitle streams, if tagged
+	AudioLanguages    []string `json:"audio_languages"`
+	SubtitleLanguages []string `json:"subtitle_languages"`
+}
+
+// ffprobeOutput is the subset of the JSON output of ffprobe we use.
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType string            `json:"codec_type"`
+		CodecName string            `json:"codec_name"`
+		Width     int               `json:"width"`
+		Height    int               `json:"height"`
+		Tags      map[string]string `json:"tags"`
+	} `json:"streams"`
+}
+
+// isMedia reports whether the state is a video or an audio file.
+func isMedia(state *State) bool {
+	return strings.HasPrefix(state.FileType, "video/") || strings.HasPrefix(state.FileType, "audio/")
+}
+
+// probeCompleted reads the media metadata of the downloaded file with
+// ffprobe and stores it in the state. It is copied to the history index when
+// the download is recorded.
+func (c *Client) probeCompleted(ctx context.Context, t *Task) error {
+	info, err := os.Stat(t.state.LocalPath)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	ffprobe := c.Config.FFprobePath
+	if ffprobe == "" {
+		ffprobe = "ffprobe"
+	}
+	ffprobe, err = exec.LookPath(ffprobe)
+	if err != nil {
+		return fmt.Errorf("ffprobe not found: %v", err)
+	}
+
+	media, err := probeMedia(ctx, ffprobe, t.state.LocalPath)
+	if err != nil {
+		return err
+	}
+	t.state.Media = media
+	return nil
+}
+
+func probeMedia(ctx context.Context, ffprobe, path string) (*MediaInfo, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffprobe,
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format", "-show_streams",
+		path,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %v: %v", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var out ffprobeOutput
+	err = json.Unmarshal(stdout.Bytes(), &out)
+	if err != nil {
+		return nil, err
+	}
+
+	media := &MediaInfo{Format: out.Format.FormatName}
+	media.Duration, _ = strconv.ParseFloat(out.Format.Duration, 64)
+	media.Bitrate, _ = strconv.ParseInt(out.Format.BitRate, 10, 64)
+
+	for _, s := range out.Streams {
+		lang := s.Tags["language"]
+		switch s.CodecType {
+		case "video":
+			// skip the cover images of the audio files
+			if media.VideoCodec == "" && s.CodecName != "mjpeg" && s.CodecName != "png" {
+				media.VideoCodec = s.CodecName
+				media.Width = s.Width
+				media.Height = s.Height
+			}
+		case "audio":
+			media.AudioCodecs = append(media.AudioCodecs, s.CodecName)
+			if lang != "" {
+				media.AudioLanguages = append(media.AudioLanguages, lang)
+			}
+		case "subtitle":
+			if lang != "" {
+				media.SubtitleLanguages = append(media.SubtitleLanguages, lang)
+			}
+		}
+	}
+	return media, nil
+}
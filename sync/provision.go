@@ -0,0 +1,217 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Provision describes the users, configurations and API keys to seed the
+// store with, for automated deployments.
+type Provision struct {
+	Users   []ProvisionUser   `json:"users"`
+	APIKeys []ProvisionAPIKey `json:"api-keys"`
+}
+
+// ProvisionUser is a Put.io account to set up.
+type ProvisionUser struct {
+	// Put.io username. It is looked up with the token if empty.
+	Username string `json:"username"`
+
+	// OAuth2 token of the account
+	Token string `json:"token"`
+
+	// Make this the active account. The first user is active if none is.
+	Current bool `json:"current"`
+
+	// Configuration keys to set, as in the configuration API. Missing keys
+	// keep their current or default values.
+	Config json.RawMessage `json:"config"`
+
+	// Ordered filter rules, replacing the existing ones if given
+	Rules []Rule `json:"rules"`
+}
+
+// ProvisionAPIKey is an API key of the HTTP API.
+type ProvisionAPIKey struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+
+	// The key itself. A random key is generated if empty and the key
+	// doesn't exist yet.
+	Key string `json:"key"`
+}
+
+// ParseProvision parses a provisioning file in JSON or YAML.
+func ParseProvision(data []byte) (*Provision, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		v, err := parseYAML(string(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var p Provision
+	err := json.Unmarshal(data, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Apply seeds the store. It can be run again with the same file, existing
+// users and keys are updated. It returns the generated API keys by name.
+func (p *Provision) Apply(ctx context.Context, store *Store) (map[string]string, error) {
+	_, err := store.Backup("provision")
+	if err != nil {
+		return nil, fmt.Errorf("backing up the database: %v", err)
+	}
+
+	current := ""
+	for i, u := range p.Users {
+		username, err := u.apply(ctx, store)
+		if err != nil {
+			return nil, fmt.Errorf("user %v: %v", i+1, err)
+		}
+		if u.Current || (i == 0 && current == "") {
+			current = username
+		}
+	}
+	if current != "" {
+		err := store.SaveCurrentUser(current)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	existing, err := store.APIKeys()
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool)
+	for _, k := range existing {
+		exists[k.Name] = true
+	}
+
+	generated := make(map[string]string)
+	for _, k := range p.APIKeys {
+		if !ValidRole(k.Role) {
+			return nil, fmt.Errorf("api key %q: invalid role %q", k.Name, k.Role)
+		}
+		if k.Key == "" && exists[k.Name] {
+			continue
+		}
+
+		secret, key, err := NewAPIKey(k.Name, k.Role)
+		if err != nil {
+			return nil, err
+		}
+		if k.Key != "" {
+			key.Hash = hashAPIKey(k.Key)
+		} else {
+			generated[k.Name] = secret
+		}
+
+		if exists[k.Name] {
+			err = store.DeleteAPIKey(k.Name)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = store.SaveAPIKey(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return generated, nil
+}
+
+// apply stores the user and returns its username.
+func (u *ProvisionUser) apply(ctx context.Context, store *Store) (string, error) {
+	username := u.Username
+	if username == "" {
+		if u.Token == "" {
+			return "", Error("either username or token is required")
+		}
+		info, err := newPutioClient(u.Token, nil, nil).Account.Info(ctx)
+		if err != nil {
+			return "", fmt.Errorf("looking up the account: %v", err)
+		}
+		username = info.Username
+	}
+
+	err := store.CreateBuckets(username)
+	if err != nil {
+		return "", err
+	}
+
+	cfg, err := store.Config(username)
+	if err != nil {
+		return "", err
+	}
+	if len(u.Config) > 0 {
+		err = json.Unmarshal(u.Config, cfg)
+		if err != nil {
+			return "", fmt.Errorf("config: %v", err)
+		}
+	}
+	if u.Token != "" {
+		cfg.OAuth2Token = u.Token
+	}
+
+	err = cfg.validate()
+	if err != nil {
+		return "", err
+	}
+	err = store.SaveConfig(cfg, username)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Rules != nil {
+		for i := range u.Rules {
+			err = u.Rules[i].Validate()
+			if err != nil {
+				return "", err
+			}
+		}
+		err = store.SaveRules(u.Rules, username)
+		if err != nil {
+			return "", err
+		}
+	}
+	return username, nil
+}
+
+// validate checks the settings which the configuration API validates.
+func (c *Config) validate() error {
+	for _, m := range c.Mappings() {
+		if m.DownloadTo == "" {
+			continue
+		}
+		if err := ValidateLocalDir(m.DownloadTo); err != nil {
+			return err
+		}
+	}
+	if c.ConflictPolicy != "" && !ValidConflictPolicy(c.ConflictPolicy) {
+		return Error("invalid conflict policy")
+	}
+	if c.DuplicatePolicy != "" && !ValidDuplicatePolicy(c.DuplicatePolicy) {
+		return Error("invalid duplicate policy")
+	}
+	if c.Locale != "" && !ValidLocale(c.Locale) {
+		return Error("invalid locale")
+	}
+	if _, err := CompileScript(c.Script); err != nil {
+		return err
+	}
+	if err := ValidatePipeline(c.Pipeline); err != nil {
+		return err
+	}
+	return ValidateTranscodeProfile(c.TranscodeProfile, c.CustomTranscodeProfiles)
+}
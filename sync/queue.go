@@ -0,0 +1,392 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// drainPollDelay is the wait before the poll after the download queue drains.
+const drainPollDelay = 5 * time.Second
+
+// Queue policies decide which of the found files is downloaded next. Files
+// with higher priority come first with every policy.
+const (
+	// The file found first
+	QueueFIFO = "fifo"
+
+	// The smallest file
+	QueueSmallestFirst = "smallest-first"
+
+	// The largest file
+	QueueLargestFirst = "largest-first"
+
+	// The first found file of the next top level folder, so that a large
+	// transfer doesn't hold the others back
+	QueueRoundRobin = "round-robin"
+)
+
+// ValidQueuePolicy reports whether p is a known queue policy.
+func ValidQueuePolicy(p string) bool {
+	switch p {
+	case QueueFIFO, QueueSmallestFirst, QueueLargestFirst, QueueRoundRobin:
+		return true
+	}
+	return false
+}
+
+// QueuedFile is a found file waiting for a free download slot.
+type QueuedFile struct {
+	DownloadID   string    `json:"download_id"`
+	FileID       int64     `json:"file_id"`
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	Folder       string    `json:"folder"`
+	Priority     int       `json:"priority"`
+	DiscoveredAt time.Time `json:"discovered_at"`
+}
+
+// taskQueue holds the found tasks until a consumer is free, and hands them
+// out in the order of the queue policy.
+type taskQueue struct {
+	mu    sync.Mutex
+	tasks []*Task
+
+	// folder of the last task handed out, for round-robin
+	last string
+
+	// tasks handed out and not done yet, for the folder caps
+	active map[*Task]bool
+
+	// signalled when an active task is done
+	wake chan struct{}
+}
+
+// push adds the task, unless the file is in the queue already.
+func (q *taskQueue) push(t *Task) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, queued := range q.tasks {
+		if queued.state.FileID == t.state.FileID {
+			return
+		}
+	}
+	q.tasks = append(q.tasks, t)
+}
+
+// peek returns the next task by the policy, or nil if the queue is empty.
+// The tasks for which capped returns true are skipped.
+func (q *taskQueue) peek(policy string, capped func(t *Task, active []*Task) bool) *Task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	active := make([]*Task, 0, len(q.active))
+	for t := range q.active {
+		active = append(active, t)
+	}
+
+	var tasks []*Task
+	for _, t := range q.tasks {
+		if !capped(t, active) {
+			tasks = append(tasks, t)
+		}
+	}
+
+	i := nextTask(tasks, policy, q.last)
+	if i < 0 {
+		return nil
+	}
+	return tasks[i]
+}
+
+// remove takes the task handed out off the queue. It is active until done.
+func (q *taskQueue) remove(t *Task) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, queued := range q.tasks {
+		if queued == t {
+			q.tasks = append(q.tasks[:i], q.tasks[i+1:]...)
+			q.last = t.folder()
+			if q.active == nil {
+				q.active = make(map[*Task]bool)
+			}
+			q.active[t] = true
+			return
+		}
+	}
+}
+
+// done marks the task handed out as finished.
+func (q *taskQueue) done(t *Task) {
+	q.mu.Lock()
+	delete(q.active, t)
+	q.mu.Unlock()
+	q.notify()
+}
+
+// notify wakes the queue runner up to hand out the tasks again, e.g. after
+// the caps are changed.
+func (q *taskQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// len returns the number of the queued tasks.
+func (q *taskQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.tasks)
+}
+
+// clear empties the queue.
+func (q *taskQueue) clear() {
+	q.mu.Lock()
+	q.tasks = nil
+	q.last = ""
+	q.active = nil
+	q.mu.Unlock()
+}
+
+// list returns the queued files in the order they are handed out.
+func (q *taskQueue) list(policy string) []QueuedFile {
+	q.mu.Lock()
+	tasks := append([]*Task{}, q.tasks...)
+	last := q.last
+	q.mu.Unlock()
+
+	files := make([]QueuedFile, 0, len(tasks))
+	for len(tasks) > 0 {
+		i := nextTask(tasks, policy, last)
+		t := tasks[i]
+		tasks = append(tasks[:i], tasks[i+1:]...)
+		last = t.folder()
+
+		files = append(files, QueuedFile{
+			DownloadID:   t.state.DownloadID,
+			FileID:       t.state.FileID,
+			Name:         t.state.FileName,
+			Size:         t.state.FileLength,
+			Folder:       last,
+			Priority:     t.state.Priority,
+			DiscoveredAt: t.state.DiscoveredAt,
+		})
+	}
+	return files
+}
+
+// nextTask returns the index of the next task by the policy, or -1 if there
+// are no tasks. last is the folder of the previous task.
+func nextTask(tasks []*Task, policy, last string) int {
+	if len(tasks) == 0 {
+		return -1
+	}
+
+	// only the files with the highest priority are considered
+	top := tasks[0].state.Priority
+	for _, t := range tasks {
+		if t.state.Priority > top {
+			top = t.state.Priority
+		}
+	}
+
+	if policy == QueueRoundRobin {
+		var folders []string
+		seen := make(map[string]bool)
+		for _, t := range tasks {
+			if f := t.folder(); t.state.Priority == top && !seen[f] {
+				seen[f] = true
+				folders = append(folders, f)
+			}
+		}
+		sort.Strings(folders)
+
+		// the folder after the last one, wrapping around
+		folder := folders[0]
+		for _, f := range folders {
+			if f > last {
+				folder = f
+				break
+			}
+		}
+
+		next := -1
+		for i, t := range tasks {
+			if t.state.Priority == top && t.folder() == folder && (next < 0 || t.state.DiscoveredAt.Before(tasks[next].state.DiscoveredAt)) {
+				next = i
+			}
+		}
+		return next
+	}
+
+	next := -1
+	for i, t := range tasks {
+		if t.state.Priority != top {
+			continue
+		}
+		if next < 0 {
+			next = i
+			continue
+		}
+		n := tasks[next].state
+		switch policy {
+		case QueueSmallestFirst:
+			if t.state.FileLength < n.FileLength {
+				next = i
+			}
+		case QueueLargestFirst:
+			if t.state.FileLength > n.FileLength {
+				next = i
+			}
+		default:
+			if t.state.DiscoveredAt.Before(n.DiscoveredAt) {
+				next = i
+			}
+		}
+	}
+	return next
+}
+
+// folder returns the top level folder of the task in its download root, or
+// the file itself if it is in the root. It usually is the transfer which
+// added the file.
+func (t *Task) folder() string {
+	cwd := strings.Trim(filepath.ToSlash(t.cwd), "/")
+	name := strings.SplitN(cwd, "/", 2)[0]
+	if name == "" {
+		name = t.state.FileName
+	}
+	return filepath.Join(t.root, name)
+}
+
+// queuePolicy returns the configured queue policy.
+func (c *Config) queuePolicy() string {
+	if c.QueuePolicy == "" {
+		return QueueFIFO
+	}
+	return c.QueuePolicy
+}
+
+// runQueue collects the found tasks and hands them out to the consumers in
+// the order of the queue policy until ctx is cancelled.
+func (c *Client) runQueue(ctx context.Context) {
+	defer c.RecoverCrash()
+	defer c.queue.clear()
+
+	for {
+		var out chan *Task
+		next := c.queue.peek(c.Config.queuePolicy(), c.Config.capped)
+		if next != nil {
+			out = c.readyCh
+		}
+
+		select {
+		case t := <-c.taskCh:
+			c.queue.push(t)
+		case out <- next:
+			c.queue.remove(next)
+		case <-c.queue.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drained polls shortly after the last download finishes, if
+// Config.PollOnDrain is set. The delay lets the downloads queued meanwhile
+// cancel the poll, so that the poller isn't woken for every file.
+func (c *Client) drained() {
+	if !c.Config.PollOnDrain || !c.Tasks.Empty() || c.queue.len() > 0 {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&c.drainPending, 0, 1) {
+		return
+	}
+
+	time.AfterFunc(drainPollDelay, func() {
+		atomic.StoreInt32(&c.drainPending, 0)
+
+		c.mu.Lock()
+		running := c.CancelFunc != nil
+		c.mu.Unlock()
+
+		if running && c.Tasks.Empty() && c.queue.len() == 0 {
+			c.Debugf("Download queue is drained, polling\n")
+			c.pollSoon()
+		}
+	})
+}
+
+// remotePath returns the folder of the task in the remote folder mapping, or
+// its local folder if the remote one isn't known.
+func (t *Task) remotePath() string {
+	if t.remoteDir != "" {
+		return filepath.ToSlash(t.remoteDir)
+	}
+	return filepath.ToSlash(t.cwd)
+}
+
+// folderLimit returns the folder of Config.FolderLimits containing the task
+// and its limit. The innermost folder applies if they are nested.
+func (c *Config) folderLimit(t *Task) (string, uint) {
+	dir := t.remotePath()
+
+	var folder string
+	var limit uint
+	for f, n := range c.FolderLimits {
+		f = filepath.ToSlash(filepath.Clean("/" + f))
+		if dir != f && !strings.HasPrefix(dir, strings.TrimSuffix(f, "/")+"/") {
+			continue
+		}
+		if folder == "" || len(f) > len(folder) {
+			folder, limit = f, n
+		}
+	}
+	return folder, limit
+}
+
+// capped reports whether the folder or the transfer of the task has as many
+// active downloads as its cap, or the backfill has as many as its own.
+func (c *Config) capped(t *Task, active []*Task) bool {
+	if c.Backfill && uint(len(active)) >= c.backfillFiles() {
+		return true
+	}
+
+	if max := c.MaxFilesPerTransfer; max > 0 {
+		var n uint
+		for _, a := range active {
+			if a.folder() == t.folder() {
+				n++
+			}
+		}
+		if n >= max {
+			return true
+		}
+	}
+
+	if folder, max := c.folderLimit(t); folder != "" && max > 0 {
+		var n uint
+		for _, a := range active {
+			if f, _ := c.folderLimit(a); f == folder {
+				n++
+			}
+		}
+		if n >= max {
+			return true
+		}
+	}
+	return false
+}
+
+// Queue returns the files waiting for a download slot, in the order they are
+// downloaded.
+func (c *Client) Queue() []QueuedFile {
+	return c.queue.list(c.Config.queuePolicy())
+}
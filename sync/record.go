@@ -0,0 +1,259 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Stored records start with recordMagic and the schema version as a uvarint,
+// followed by a JSON document. Records without the magic are the gob records
+// of earlier versions; they are converted when read and rewritten in the
+// versioned encoding when saved.
+//
+// The magic is not a valid first byte of a gob stream.
+const recordMagic = 0xa7
+
+// Schema versions of the records written by this version. A version is only
+// bumped by a change that older versions can't read, e.g. a renamed field;
+// added fields don't need one. Records of a newer schema are refused instead
+// of misread.
+const (
+	stateSchema  = 1
+	configSchema = 1
+)
+
+// ErrRecordTooNew is returned for records written by a newer version with an
+// incompatible schema.
+const ErrRecordTooNew = Error("record is written by a newer version of putio-sync")
+
+// encodeRecord encodes v with the schema version. The fields of extra which v
+// doesn't have are kept, so that the fields added by a newer version survive
+// a downgrade.
+func encodeRecord(schema uint64, v interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(extra) > 0 {
+		fields := make(map[string]json.RawMessage)
+		err = json.Unmarshal(b, &fields)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range extra {
+			if _, ok := fields[k]; !ok {
+				fields[k] = v
+			}
+		}
+		b, err = json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	header := make([]byte, 1+binary.MaxVarintLen64)
+	header[0] = recordMagic
+	n := binary.PutUvarint(header[1:], schema)
+	return append(header[:1+n], b...), nil
+}
+
+// decodeRecord decodes a versioned record into v. It returns the fields of
+// the record which v doesn't have. ok is false for legacy gob records, which
+// are left to the caller.
+func decodeRecord(data []byte, maxSchema uint64, v interface{}) (extra map[string]json.RawMessage, ok bool, err error) {
+	if len(data) == 0 || data[0] != recordMagic {
+		return nil, false, nil
+	}
+
+	schema, n := binary.Uvarint(data[1:])
+	if n <= 0 {
+		return nil, true, fmt.Errorf("malformed record header")
+	}
+	if schema > maxSchema {
+		return nil, true, ErrRecordTooNew
+	}
+	data = data[1+n:]
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return nil, true, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, true, err
+	}
+	known, err := json.Marshal(v)
+	if err != nil {
+		return nil, true, err
+	}
+	var knownFields map[string]json.RawMessage
+	err = json.Unmarshal(known, &knownFields)
+	if err != nil {
+		return nil, true, err
+	}
+	for k := range knownFields {
+		delete(fields, k)
+	}
+	if len(fields) == 0 {
+		fields = nil
+	}
+	return fields, true, nil
+}
+
+// stateRecord is the stored schema of State. The JSON names are the schema:
+// rename a field only with a schema version bump.
+type stateRecord struct {
+	Version    uint   `json:"version"`
+	DownloadID string `json:"download_id"`
+
+	FileID              int64  `json:"file_id"`
+	FileName            string `json:"file_name"`
+	FileLength          int64  `json:"file_length"`
+	FileIcon            string `json:"file_icon"`
+	FileType            string `json:"file_type"`
+	CRC32               string `json:"crc32"`
+	BitfieldPieceLength int    `json:"bitfield_piece_length"`
+
+	LocalPath string `json:"local_path"`
+	LocalRoot string `json:"local_root"`
+	Priority  int    `json:"priority"`
+	Pipeline  string `json:"pipeline"`
+	RemoteDir string `json:"remote_dir"`
+
+	DiscoveredAt       time.Time `json:"discovered_at"`
+	DownloadStatus     int       `json:"download_status"`
+	DownloadStartedAt  time.Time `json:"download_started_at"`
+	DownloadFinishedAt time.Time `json:"download_finished_at"`
+	DownloadSpeed      float64   `json:"download_speed"`
+
+	Compression  string       `json:"compression"`
+	DuplicateOf  int64        `json:"duplicate_of"`
+	DownloadedBy Device       `json:"downloaded_by"`
+	Media        *MediaInfo   `json:"media"`
+	Movie        *MovieInfo   `json:"movie"`
+	Steps        []StepStatus `json:"steps"`
+
+	IsHidden bool   `json:"is_hidden"`
+	Error    string `json:"error"`
+
+	BytesTransferredSinceLastUpdate int64  `json:"bytes_transferred"`
+	Bitfield                        []byte `json:"bitfield"`
+}
+
+// encodeState encodes the state for the store.
+func encodeState(s *State) ([]byte, error) {
+	r := stateRecord{
+		Version:                         s.Version,
+		DownloadID:                      s.DownloadID,
+		FileID:                          s.FileID,
+		FileName:                        s.FileName,
+		FileLength:                      s.FileLength,
+		FileIcon:                        s.FileIcon,
+		FileType:                        s.FileType,
+		CRC32:                           s.CRC32,
+		BitfieldPieceLength:             s.BitfieldPieceLength,
+		LocalPath:                       s.LocalPath,
+		LocalRoot:                       s.LocalRoot,
+		Priority:                        s.Priority,
+		Pipeline:                        s.Pipeline,
+		RemoteDir:                       s.RemoteDir,
+		DownloadStatus:                  int(s.DownloadStatus),
+		DiscoveredAt:                    s.DiscoveredAt,
+		DownloadStartedAt:               s.DownloadStartedAt,
+		DownloadFinishedAt:              s.DownloadFinishedAt,
+		DownloadSpeed:                   s.DownloadSpeed,
+		Compression:                     s.Compression,
+		DuplicateOf:                     s.DuplicateOf,
+		DownloadedBy:                    s.DownloadedBy,
+		Media:                           s.Media,
+		Movie:                           s.Movie,
+		Steps:                           s.Steps,
+		IsHidden:                        s.IsHidden,
+		Error:                           s.Error,
+		BytesTransferredSinceLastUpdate: s.BytesTransferredSinceLastUpdate,
+	}
+	if s.Bitfield != nil && s.Bitfield.Bitfield != nil {
+		b, err := s.Bitfield.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		r.Bitfield = b
+	}
+	return encodeRecord(stateSchema, &r, s.extra)
+}
+
+// decodeState decodes a stored state, either versioned or gob.
+func decodeState(data []byte, s *State) error {
+	var r stateRecord
+	extra, ok, err := decodeRecord(data, stateSchema, &r)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(s)
+	}
+
+	s.Version = r.Version
+	s.DownloadID = r.DownloadID
+	s.FileID = r.FileID
+	s.FileName = r.FileName
+	s.FileLength = r.FileLength
+	s.FileIcon = r.FileIcon
+	s.FileType = r.FileType
+	s.CRC32 = r.CRC32
+	s.BitfieldPieceLength = r.BitfieldPieceLength
+	s.LocalPath = r.LocalPath
+	s.LocalRoot = r.LocalRoot
+	s.Priority = r.Priority
+	s.Pipeline = r.Pipeline
+	s.RemoteDir = r.RemoteDir
+	s.DownloadStatus = DownloadStatus(r.DownloadStatus)
+	s.DiscoveredAt = r.DiscoveredAt
+	s.DownloadStartedAt = r.DownloadStartedAt
+	s.DownloadFinishedAt = r.DownloadFinishedAt
+	s.DownloadSpeed = r.DownloadSpeed
+	s.Compression = r.Compression
+	s.DuplicateOf = r.DuplicateOf
+	s.DownloadedBy = r.DownloadedBy
+	s.Media = r.Media
+	s.Movie = r.Movie
+	s.Steps = r.Steps
+	s.IsHidden = r.IsHidden
+	s.Error = r.Error
+	s.BytesTransferredSinceLastUpdate = r.BytesTransferredSinceLastUpdate
+	s.extra = extra
+	if r.Bitfield != nil {
+		s.Bitfield = &Bitfield{}
+		err = s.Bitfield.UnmarshalBinary(r.Bitfield)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeConfig encodes the configuration for the store. The JSON names of
+// Config are its schema, as in the API.
+func encodeConfig(c *Config) ([]byte, error) {
+	return encodeRecord(configSchema, c, c.extra)
+}
+
+// decodeConfig decodes a stored configuration, either versioned or gob.
+func decodeConfig(data []byte, c *Config) error {
+	extra, ok, err := decodeRecord(data, configSchema, c)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(c)
+	}
+	c.extra = extra
+	return nil
+}
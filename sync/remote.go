@@ -0,0 +1,134 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+)
+
+// RemoteFolder represents a Put.io folder and its subfolders. It is used by
+// clients to present a folder picker instead of raw folder IDs.
+type RemoteFolder struct {
+	ID       int64           `json:"id"`
+	Name     string          `json:"name"`
+	ParentID int64           `json:"parent_id"`
+	Children []*RemoteFolder `json:"children"`
+}
+
+// MaxRemoteTreeDepth is the deepest level of subfolders listed by RemoteTree,
+// as each level costs an API call per folder.
+const MaxRemoteTreeDepth = 5
+
+// RemoteTree lists the folders under the given Put.io folder ID. Subfolders are
+// fetched recursively up to the given depth, at most MaxRemoteTreeDepth. A
+// depth of 1 only returns the immediate children of the folder.
+func (c *Client) RemoteTree(ctx context.Context, id int64, depth int) (*RemoteFolder, error) {
+	if depth > MaxRemoteTreeDepth {
+		depth = MaxRemoteTreeDepth
+	}
+
+	files, parent, err := c.C.Files.List(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	folder := &RemoteFolder{
+		ID:       parent.ID,
+		Name:     parent.Name,
+		ParentID: parent.ParentID,
+		Children: make([]*RemoteFolder, 0),
+	}
+
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+
+		child := &RemoteFolder{
+			ID:       f.ID,
+			Name:     f.Name,
+			ParentID: f.ParentID,
+			Children: make([]*RemoteFolder, 0),
+		}
+
+		if depth > 1 {
+			child, err = c.RemoteTree(ctx, f.ID, depth-1)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		folder.Children = append(folder.Children, child)
+	}
+
+	return folder, nil
+}
+
+// SyncNow walks the given Put.io folder immediately instead of waiting for the
+// next poll. The folder must be a download root or reside in one. Subfolders are
+// walked only if recursive is true. Found files are queued in the background.
+func (c *Client) SyncNow(id int64, recursive bool) error {
+	c.mu.Lock()
+	ctx := c.Ctx
+	running := c.CancelFunc != nil
+	c.mu.Unlock()
+
+	if !running {
+		return Error("sync is not running")
+	}
+
+	m, folders, err := c.ancestors(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// the ignore files of the folder itself are read by walk
+	cwd := folders[len(folders)-1].cwd
+	ignores, err := c.ancestorIgnores(ctx, m, folders[:len(folders)-1])
+	if err != nil {
+		return err
+	}
+	if cwd != "/" && ignores.Match(cwd) {
+		return Error("folder is ignored by a .putioignore file")
+	}
+
+	go func() {
+		defer c.RecoverCrash()
+		c.walk(withTrigger(ctx, TriggerAPI), m, id, cwd, cwd, ignores, recursive, newReport())
+	}()
+	return nil
+}
+
+// locate finds the folder mapping of the given Put.io folder and the path of
+// the folder relative to the download root.
+func (c *Client) locate(ctx context.Context, id int64) (FolderMapping, string, error) {
+	mappings := c.Config.Mappings()
+
+	var parts []string
+	for {
+		for _, m := range mappings {
+			if m.DownloadFrom == id {
+				// parts are collected from the leaf to the root
+				for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+					parts[i], parts[j] = parts[j], parts[i]
+				}
+				return m, filepath.Join(append([]string{"/"}, parts...)...), nil
+			}
+		}
+
+		// reached the root folder of the user
+		if id == 0 {
+			return FolderMapping{}, "", Error("folder is outside of download roots")
+		}
+
+		f, err := c.C.Files.Get(ctx, id)
+		if err != nil {
+			return FolderMapping{}, "", err
+		}
+		if !f.IsDir() {
+			return FolderMapping{}, "", Error("not a folder")
+		}
+
+		parts = append(parts, f.Name)
+		id = f.ParentID
+	}
+}
@@ -0,0 +1,287 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/igungor/go-putio/putio"
+)
+
+// remoteConfigFile is the name of the shared configuration file in the
+// application folder.
+const remoteConfigFile = "config.json"
+
+// maxRemoteConfigSize limits the size of the shared configuration file read.
+const maxRemoteConfigSize = 1024 * 1024
+
+// sharedConfig is the part of the configuration which is synced between the
+// devices of an account: the filters, the rules and the polling schedule.
+// Secrets and the settings of the local machine, e.g. paths, binaries and
+// endpoints, are never shared. The route rules and a script with route
+// statements name local directories, each device keeps its own.
+type sharedConfig struct {
+	PollInterval    Duration `json:"poll-interval"`
+	AdaptivePolling bool     `json:"adaptive-polling"`
+	MaxPollInterval Duration `json:"max-poll-interval"`
+	PollJitter      Duration `json:"poll-jitter"`
+
+	// Filters of the folder mappings by their remote folder ID
+	Filters map[int64]Filter `json:"filters"`
+
+	Rules                []Rule       `json:"rules"`
+	RenameRules          []RenameRule `json:"rename-rules"`
+	Script               string       `json:"script,omitempty"`
+	IgnoreFilesOlderThan time.Time    `json:"ignore-files-older-than"`
+	SkipHiddenFiles      bool         `json:"skip-hidden-files"`
+	MaxDepth             uint         `json:"max-depth"`
+	ConflictPolicy       string       `json:"conflict-policy"`
+	DuplicatePolicy      string       `json:"duplicate-policy"`
+}
+
+// sharedConfig returns a copy of the shared part of the configuration.
+func (c *Client) sharedConfig() sharedConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	filters := make(map[int64]Filter)
+	for _, m := range c.Config.FolderMappings {
+		filters[m.DownloadFrom] = m.Filter
+	}
+
+	var script string
+	if !c.routingScript() {
+		script = c.Config.Script
+	}
+
+	return sharedConfig{
+		PollInterval:         c.Config.PollInterval,
+		AdaptivePolling:      c.Config.AdaptivePolling,
+		MaxPollInterval:      c.Config.MaxPollInterval,
+		PollJitter:           c.Config.PollJitter,
+		Filters:              filters,
+		Rules:                filterRules(c.Rules(), false),
+		RenameRules:          append([]RenameRule(nil), c.Config.RenameRules...),
+		Script:               script,
+		IgnoreFilesOlderThan: c.Config.IgnoreFilesOlderThan,
+		SkipHiddenFiles:      c.Config.SkipHiddenFiles,
+		MaxDepth:             c.Config.MaxDepth,
+		ConflictPolicy:       c.Config.ConflictPolicy,
+		DuplicatePolicy:      c.Config.DuplicatePolicy,
+	}
+}
+
+// validate checks the shared configuration as handleConfig checks the
+// configuration posted to the API.
+func (s *sharedConfig) validate() error {
+	if s.PollInterval < Duration(time.Minute) || s.MaxPollInterval < Duration(time.Minute) {
+		return Error("poll interval must be at least a minute")
+	}
+	if s.PollJitter < 0 {
+		return Error("invalid poll jitter")
+	}
+	for i := range s.Rules {
+		err := s.Rules[i].Validate()
+		if err != nil {
+			return err
+		}
+	}
+	err := ValidateRenameRules(s.RenameRules)
+	if err != nil {
+		return err
+	}
+	script, err := CompileScript(s.Script)
+	if err != nil {
+		return err
+	}
+	if script.routes() {
+		return Error("scripts with route statements are not shared")
+	}
+	if s.ConflictPolicy != "" && !ValidConflictPolicy(s.ConflictPolicy) {
+		return Error("invalid conflict policy")
+	}
+	if s.DuplicatePolicy != "" && !ValidDuplicatePolicy(s.DuplicatePolicy) {
+		return Error("invalid duplicate policy")
+	}
+	return nil
+}
+
+// applySharedConfig replaces the shared part of the configuration. The
+// filters apply to the folder mappings of the same remote folders. The route
+// rules of the device are kept in front of the shared rules and a script with
+// route statements is kept instead of the shared script.
+func (c *Client) applySharedConfig(s sharedConfig) error {
+	rules := append(filterRules(c.Rules(), true), s.Rules...)
+	err := c.SetRules(rules)
+	if err != nil {
+		return err
+	}
+	if !c.routingScript() {
+		err = c.SetScript(s.Script)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Config.PollInterval = s.PollInterval
+	c.Config.AdaptivePolling = s.AdaptivePolling
+	c.Config.MaxPollInterval = s.MaxPollInterval
+	c.Config.PollJitter = s.PollJitter
+	for i, m := range c.Config.FolderMappings {
+		if f, ok := s.Filters[m.DownloadFrom]; ok {
+			c.Config.FolderMappings[i].Filter = f
+		}
+	}
+	c.Config.RenameRules = s.RenameRules
+	c.Config.IgnoreFilesOlderThan = s.IgnoreFilesOlderThan
+	c.Config.SkipHiddenFiles = s.SkipHiddenFiles
+	c.Config.MaxDepth = s.MaxDepth
+	if s.ConflictPolicy != "" {
+		c.Config.ConflictPolicy = s.ConflictPolicy
+	}
+	if s.DuplicatePolicy != "" {
+		c.Config.DuplicatePolicy = s.DuplicatePolicy
+	}
+	return nil
+}
+
+// filterRules returns a copy of the route rules, or of the other rules.
+func filterRules(rules []Rule, route bool) []Rule {
+	var filtered []Rule
+	for _, r := range rules {
+		if (r.Action == RuleRoute) == route {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+// routingScript reports whether the script of the device has route
+// statements.
+func (c *Client) routingScript() bool {
+	c.rulesMu.RLock()
+	defer c.rulesMu.RUnlock()
+	return c.script.routes()
+}
+
+// PushConfig uploads the shared part of the configuration to the application
+// folder so that the other devices syncing the same account pick it up.
+func (c *Client) PushConfig(ctx context.Context) error {
+	if !c.Config.SyncConfigRemotely {
+		return nil
+	}
+
+	folderID, err := c.appFolder(ctx)
+	if err != nil {
+		return err
+	}
+
+	old, err := c.remoteConfigFiles(ctx, folderID)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(c.sharedConfig(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	upload, err := c.C.Files.Upload(ctx, bytes.NewReader(b), remoteConfigFile, folderID)
+	if err != nil {
+		return err
+	}
+	if upload.File != nil {
+		c.remoteConfigID = upload.File.ID
+	}
+
+	// Put.io doesn't overwrite files with the same name
+	var ids []int64
+	for _, f := range old {
+		ids = append(ids, f.ID)
+	}
+	if len(ids) > 0 {
+		return c.C.Files.Delete(ctx, ids...)
+	}
+	return nil
+}
+
+// PullConfig applies the shared configuration uploaded by another device, if
+// it has changed since the last pull. It reports whether the configuration is
+// changed. An invalid configuration is rejected as a whole.
+func (c *Client) PullConfig(ctx context.Context) (bool, error) {
+	if !c.Config.SyncConfigRemotely {
+		return false, nil
+	}
+
+	folderID, err := c.appFolder(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	files, err := c.remoteConfigFiles(ctx, folderID)
+	if err != nil {
+		return false, err
+	}
+
+	// the newest file wins
+	var latest int64
+	for _, f := range files {
+		if f.ID > latest {
+			latest = f.ID
+		}
+	}
+	if latest == 0 || latest == c.remoteConfigID {
+		return false, nil
+	}
+
+	body, err := c.C.Files.Download(ctx, latest, false, nil)
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+
+	// settings missing from the file are kept
+	shared := c.sharedConfig()
+	err = json.NewDecoder(io.LimitReader(body, maxRemoteConfigSize)).Decode(&shared)
+	if err != nil {
+		return false, err
+	}
+	// the route rules of other devices are dropped
+	shared.Rules = filterRules(shared.Rules, false)
+
+	// an invalid file is reported once, not on every poll
+	c.remoteConfigID = latest
+
+	err = shared.validate()
+	if err != nil {
+		return false, fmt.Errorf("invalid shared configuration: %v", err)
+	}
+	err = c.applySharedConfig(shared)
+	if err != nil {
+		return false, err
+	}
+
+	return true, c.Store.SaveConfig(c.Config, c.User.Username)
+}
+
+// remoteConfigFiles returns the shared configuration files in the folder.
+func (c *Client) remoteConfigFiles(ctx context.Context, folderID int64) ([]putio.File, error) {
+	files, _, err := c.C.Files.List(ctx, folderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []putio.File
+	for _, f := range files {
+		if f.Name == remoteConfigFile && !f.IsDir() {
+			configs = append(configs, f)
+		}
+	}
+	return configs, nil
+}
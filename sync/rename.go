@@ -0,0 +1,122 @@
+package sync
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/igungor/go-putio/putio"
+)
+
+// RenameRule rewrites the local names of the downloaded files, e.g. the
+// pattern `[._]` with the replacement " " normalizes the separators, and
+// `-[A-Za-z0-9]+(\.\w+)$` with "$1" strips the release group.
+type RenameRule struct {
+	// Regular expression matched against the file name
+	Pattern string `json:"pattern"`
+
+	// Replacement of the matches, which can refer to the submatches as $1
+	// or ${name}
+	Replacement string `json:"replacement"`
+}
+
+// renameRegexps caches the compiled patterns of the rename rules, which are
+// applied to the name of every new file.
+var renameRegexps = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+// renameRegexp returns the compiled pattern of a rename rule.
+func renameRegexp(pattern string) (*regexp.Regexp, error) {
+	renameRegexps.Lock()
+	defer renameRegexps.Unlock()
+
+	if re, ok := renameRegexps.m[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	renameRegexps.m[pattern] = re
+	return re, nil
+}
+
+// ValidateRenameRules checks that the patterns of the rename rules compile.
+func ValidateRenameRules(rules []RenameRule) error {
+	for _, r := range rules {
+		if r.Pattern == "" {
+			return Error("invalid rename pattern")
+		}
+		if _, err := renameRegexp(r.Pattern); err != nil {
+			return Error("invalid rename pattern")
+		}
+	}
+	return nil
+}
+
+// localName returns the local name of the remote file name with the rename
+// rules applied in order. The remote name is kept if the rules leave no
+// valid file name.
+func (c *Config) localName(name string) string {
+	local := name
+	for _, r := range c.RenameRules {
+		re, err := renameRegexp(r.Pattern)
+		if err != nil {
+			continue
+		}
+		local = re.ReplaceAllString(local, r.Replacement)
+	}
+
+	local = strings.TrimSpace(local)
+	if local == "" || local == "." || local == ".." || strings.ContainsAny(local, `/\`) || filepath.Base(local) != local {
+		return name
+	}
+	return local
+}
+
+// newState creates the state of a new remote file saved into the local
+// folder savedTo, under its local name.
+func (c *Config) newState(f putio.File, savedTo string) *State {
+	state := NewState(f, savedTo)
+	if name := c.localName(f.Name); name != f.Name {
+		state.FileName = name
+		state.LocalPath = filepath.Join(savedTo, name)
+	}
+	return state
+}
+
+// newState creates the state of a new remote file like Config.newState. A
+// renamed file keeps its remote name if its local name is taken by a local
+// file, by another one of the remote files in siblings, or by another file
+// renamed before.
+func (c *Client) newState(f putio.File, savedTo string, siblings []putio.File) *State {
+	state := c.Config.newState(f, savedTo)
+	if state.FileName == f.Name {
+		return state
+	}
+
+	taken := exists(state.LocalPath)
+	for _, s := range siblings {
+		if s.ID != f.ID && s.Name == state.FileName {
+			taken = true
+		}
+	}
+	c.mu.Lock()
+	if id, ok := c.renamed[state.LocalPath]; ok && id != f.ID {
+		taken = true
+	}
+	if !taken {
+		c.renamed[state.LocalPath] = f.ID
+	}
+	c.mu.Unlock()
+
+	if taken {
+		c.Printf("Keeping the name of %v, %v is taken\n", f.Name, state.FileName)
+		state.FileName = f.Name
+		state.LocalPath = filepath.Join(savedTo, f.Name)
+	}
+	return state
+}
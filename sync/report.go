@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"time"
+)
+
+// maxReports is the number of poll reports kept in the store.
+const maxReports = 100
+
+// maxReportSkipped limits the number of skipped files listed in a report.
+const maxReportSkipped = 500
+
+// Skip reasons of the files in a report
+const (
+	SkipIgnored    = "ignored"
+	SkipFiltered   = "filtered"
+	SkipRule       = "excluded-by-rule"
+	SkipDownloaded = "already-downloaded"
+	SkipDuplicate  = "duplicate"
+	SkipTooOld     = "too-old"
+	SkipHidden     = "hidden"
+)
+
+// Report is the summary of a poll cycle. It answers why a file is or isn't
+// downloaded.
+type Report struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+
+	// Files seen for the first time
+	NewFiles int `json:"new_files"`
+
+	// Files pushed to the download queue and their remaining bytes
+	Queued       int   `json:"queued"`
+	BytesPending int64 `json:"bytes_pending"`
+
+	// Number of files skipped for each reason
+	SkippedCounts map[string]int `json:"skipped_counts"`
+
+	// Skipped files, up to maxReportSkipped
+	Skipped []SkippedFile `json:"skipped"`
+
+	Errors []string `json:"errors"`
+
+	// Walk cursor of a poll, nil for the other walks
+	cursor *walkCursor
+
+	// Sizes of the remote folders seen by the walk, for the listing cache
+	folderSizes map[int64]int64
+}
+
+// SkippedFile is a remote file which is not downloaded in a poll.
+type SkippedFile struct {
+	FileID int64  `json:"file_id"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+func newReport() *Report {
+	return &Report{
+		StartedAt:     time.Now().UTC(),
+		SkippedCounts: make(map[string]int),
+	}
+}
+
+func (r *Report) skip(fileID int64, path, reason string) {
+	r.SkippedCounts[reason]++
+	if len(r.Skipped) < maxReportSkipped {
+		r.Skipped = append(r.Skipped, SkippedFile{FileID: fileID, Path: path, Reason: reason})
+	}
+}
+
+func (r *Report) queue(state *State) {
+	r.Queued++
+	r.BytesPending += state.remaining()
+}
+
+func (r *Report) addError(err error) {
+	r.Errors = append(r.Errors, err.Error())
+}
@@ -0,0 +1,146 @@
+package sync
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/igungor/go-putio/putio"
+)
+
+// Rule actions
+const (
+	// Download the file, bypassing the filter of the folder mapping
+	RuleInclude = "include"
+
+	// Don't download the file
+	RuleExclude = "exclude"
+
+	// Download the file into another local directory
+	RuleRoute = "route"
+
+	// Download the file with the given priority
+	RulePriority = "priority"
+)
+
+// Rule is an ordered filter rule. Rules are tried in order and the first
+// rule whose conditions all match decides what happens to the file, like
+// e-mail filters. The filter of the folder mapping applies if no rule
+// matches.
+type Rule struct {
+	Name string `json:"name"`
+
+	// Conditions. Empty or zero conditions are not checked.
+
+	// Shell file name pattern, matched against both the file name and the
+	// path relative to the download root
+	Pattern string `json:"pattern"`
+
+	// Size range in bytes
+	MinSize int64 `json:"min-size"`
+	MaxSize int64 `json:"max-size"`
+
+	// Prefix of the content type, e.g. "video/"
+	ContentType string `json:"content-type"`
+
+	// One of "include", "exclude", "route" or "priority"
+	Action string `json:"action"`
+
+	// Local directory for the route action
+	RouteTo string `json:"route-to"`
+
+	// Priority for the priority action. Higher is downloaded first.
+	Priority int `json:"priority"`
+
+	// Named pipeline of Config.Pipelines post-processing the files, unless
+	// the action is exclude
+	Pipeline string `json:"pipeline"`
+}
+
+// Validate checks the rule for malformed patterns and missing action
+// arguments.
+func (r *Rule) Validate() error {
+	if r.Pattern != "" {
+		if _, err := filepath.Match(r.Pattern, ""); err != nil {
+			return fmt.Errorf("rule %q: invalid pattern: %v", r.Name, err)
+		}
+	}
+
+	switch r.Action {
+	case RuleInclude, RuleExclude, RulePriority:
+	case RuleRoute:
+		if err := ValidateLocalDir(r.RouteTo); err != nil {
+			return fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+	default:
+		return fmt.Errorf("rule %q: invalid action %q", r.Name, r.Action)
+	}
+	return nil
+}
+
+// Match reports whether the file matches all the conditions of the rule.
+func (r *Rule) Match(relpath string, size int64, contentType string) bool {
+	if r.Pattern != "" && !matchAny([]string{r.Pattern}, relpath) {
+		return false
+	}
+	if size < r.MinSize {
+		return false
+	}
+	if r.MaxSize > 0 && size > r.MaxSize {
+		return false
+	}
+	if r.ContentType != "" && !strings.HasPrefix(contentType, r.ContentType) {
+		return false
+	}
+	return true
+}
+
+// matchRule returns the first rule matching the file, or nil.
+func matchRule(rules []Rule, relpath string, size int64, contentType string) *Rule {
+	for i := range rules {
+		if rules[i].Match(relpath, size, contentType) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// decide returns the decision of the script or the first rule matching the
+// file, or nil.
+func (c *Client) decide(relpath string, file putio.File) *Rule {
+	if rule := c.scriptDecide(relpath, file.Size, file.ContentType, file.CRC32); rule != nil {
+		return rule
+	}
+	return matchRule(c.Rules(), relpath, file.Size, file.ContentType)
+}
+
+// Rules returns the ordered filter rules.
+func (c *Client) Rules() []Rule {
+	c.rulesMu.RLock()
+	defer c.rulesMu.RUnlock()
+	return c.rules
+}
+
+// SetRules validates and stores the ordered filter rules. They take effect
+// on the next poll.
+func (c *Client) SetRules(rules []Rule) error {
+	if rules == nil {
+		rules = make([]Rule, 0)
+	}
+	for i := range rules {
+		err := rules[i].Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := c.Store.SaveRules(rules, c.User.Username)
+	if err != nil {
+		return err
+	}
+
+	c.rulesMu.Lock()
+	c.rules = rules
+	c.rulesMu.Unlock()
+	return nil
+}
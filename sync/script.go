@@ -0,0 +1,643 @@
+package sync
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A script is a list of statements, one per line, which decide what happens
+// to a remote file when the ordered rules can't express it:
+//
+//	# skip the samples of the videos
+//	exclude if content_type ~ "video/*" && contains(lower(name), "sample")
+//	route "/media/series/" + folder if name ~ "*S[0-9][0-9]E[0-9][0-9]*"
+//	priority 10 if size < 100MB
+//	pipeline "videos" if content_type ~ "video/*"
+//	include
+//
+// Statements are tried in order and the first one whose condition holds
+// decides, like the rules. A statement without a condition always holds. The
+// actions are the rule actions: include, exclude, route <directory> and
+// priority <number>, and pipeline <name>, which includes the file and
+// post-processes it with the named pipeline of Config.Pipelines.
+//
+// Expressions have access to the metadata of the file: name, path, folder,
+// ext, size, content_type and crc32. They support string, integer and boolean
+// values, the operators || && ! == != < <= > >= + - and ~ (shell pattern
+// match), size suffixes (KB, MB, GB, TB) and the functions lower, upper,
+// contains, hasPrefix, hasSuffix, replace, match and regexp. Scripts are
+// sandboxed: they have no loops and no access to the filesystem, the network
+// or the rest of the program.
+
+// scriptPipeline is the script action which chooses the pipeline of a file.
+const scriptPipeline = "pipeline"
+
+// maxScriptSize limits the length of a script.
+const maxScriptSize = 64 * 1024
+
+type scriptValue interface{}
+
+type scriptEnv map[string]scriptValue
+
+type scriptExpr func(env scriptEnv) (scriptValue, error)
+
+type scriptStatement struct {
+	line   int
+	action string
+	arg    scriptExpr
+	cond   scriptExpr
+}
+
+// Script is a compiled script.
+type Script struct {
+	statements []scriptStatement
+}
+
+// CompileScript parses the script source. Empty sources compile to a nil
+// script, which decides nothing.
+func CompileScript(src string) (*Script, error) {
+	if len(src) > maxScriptSize {
+		return nil, fmt.Errorf("script is larger than %v bytes", maxScriptSize)
+	}
+
+	var s Script
+	for i, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		st, err := parseStatement(line)
+		if err != nil {
+			return nil, fmt.Errorf("script line %v: %v", i+1, err)
+		}
+		st.line = i + 1
+		s.statements = append(s.statements, st)
+	}
+	if len(s.statements) == 0 {
+		return nil, nil
+	}
+
+	// catch type errors by running every statement with a sample file
+	env := scriptFileEnv("/sample/file.mkv", 1024, "video/x-matroska", "00000000")
+	for _, st := range s.statements {
+		single := Script{statements: []scriptStatement{st}}
+		if st.cond != nil {
+			cond := st.cond
+			single.statements[0].cond = func(env scriptEnv) (scriptValue, error) {
+				v, err := cond(env)
+				if _, ok := v.(bool); err == nil && ok {
+					return true, nil
+				}
+				return v, err
+			}
+		}
+		_, err := single.Decide(env)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+// scriptFileEnv returns the variables of a file.
+func scriptFileEnv(relpath string, size int64, contentType, crc32 string) scriptEnv {
+	relpath = filepath.ToSlash(relpath)
+	return scriptEnv{
+		"name":         path.Base(relpath),
+		"path":         relpath,
+		"folder":       strings.TrimPrefix(path.Dir(relpath), "/"),
+		"ext":          strings.ToLower(path.Ext(relpath)),
+		"size":         size,
+		"content_type": contentType,
+		"crc32":        crc32,
+	}
+}
+
+// Decide runs the script for a file and returns the decision as a rule. It
+// returns nil if no statement holds.
+func (s *Script) Decide(env scriptEnv) (*Rule, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	for _, st := range s.statements {
+		if st.cond != nil {
+			v, err := st.cond(env)
+			if err != nil {
+				return nil, fmt.Errorf("script line %v: %v", st.line, err)
+			}
+			ok, isBool := v.(bool)
+			if !isBool {
+				return nil, fmt.Errorf("script line %v: condition is not a boolean", st.line)
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		rule := &Rule{Name: fmt.Sprintf("script line %v", st.line), Action: st.action}
+		if st.arg != nil {
+			v, err := st.arg(env)
+			if err != nil {
+				return nil, fmt.Errorf("script line %v: %v", st.line, err)
+			}
+			switch st.action {
+			case RuleRoute:
+				dir, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("script line %v: route directory is not a string", st.line)
+				}
+				rule.RouteTo = dir
+			case RulePriority:
+				n, ok := v.(int64)
+				if !ok {
+					return nil, fmt.Errorf("script line %v: priority is not an integer", st.line)
+				}
+				rule.Priority = int(n)
+			case scriptPipeline:
+				name, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("script line %v: pipeline name is not a string", st.line)
+				}
+				rule.Action = RuleInclude
+				rule.Pipeline = name
+			}
+		}
+		return rule, nil
+	}
+	return nil, nil
+}
+
+// routes reports whether the script has a route statement. Routes name local
+// directories, such scripts are not shared between the devices.
+func (s *Script) routes() bool {
+	if s == nil {
+		return false
+	}
+	for _, st := range s.statements {
+		if st.action == RuleRoute {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptDecide runs the script of the configuration for a remote file. Errors
+// are logged and leave the decision to the rules.
+func (c *Client) scriptDecide(relpath string, size int64, contentType, crc32 string) *Rule {
+	c.rulesMu.RLock()
+	s := c.script
+	c.rulesMu.RUnlock()
+
+	rule, err := s.Decide(scriptFileEnv(relpath, size, contentType, crc32))
+	if err == nil && rule != nil {
+		err = rule.Validate()
+	}
+	if err != nil {
+		c.Errorf("Error running script for %v: %v\n", relpath, err)
+		return nil
+	}
+	return rule
+}
+
+// SetScript compiles the script and activates it. It takes effect on the next
+// poll.
+func (c *Client) SetScript(src string) error {
+	s, err := CompileScript(src)
+	if err != nil {
+		return err
+	}
+
+	c.rulesMu.Lock()
+	c.script = s
+	c.rulesMu.Unlock()
+
+	c.Config.Script = src
+	return nil
+}
+
+// lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+var sizeSuffixes = map[string]int64{
+	"KB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+	"TB": 1 << 40,
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		ch := rune(src[i])
+		switch {
+		case unicode.IsSpace(ch):
+			i++
+		case ch == '"':
+			j := i + 1
+			for ; j < len(src) && src[j] != '"'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(src) {
+				return nil, Error("unterminated string")
+			}
+			s, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %v", src[i:j+1])
+			}
+			tokens = append(tokens, token{tokString, s})
+			i = j + 1
+		case unicode.IsDigit(ch):
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || unicode.IsLetter(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, src[i:j]})
+			i = j
+		case unicode.IsLetter(ch) || ch == '_':
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, src[i:j]})
+			i = j
+		default:
+			op := src[i : i+1]
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "&&", "||", "==", "!=", "<=", ">=":
+					op = two
+				}
+			}
+			if !strings.Contains("&& || == != <= >= < > ! + - ~ ( ) ,", op) || op == "&" || op == "|" || op == "=" {
+				return nil, fmt.Errorf("unexpected character %q", op)
+			}
+			tokens = append(tokens, token{tokOp, op})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+// parser
+
+type scriptParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *scriptParser) peek() token { return p.tokens[p.pos] }
+
+func (p *scriptParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *scriptParser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == op
+}
+
+func (p *scriptParser) expectOp(op string) error {
+	if !p.isOp(op) {
+		return fmt.Errorf("expected %q", op)
+	}
+	p.next()
+	return nil
+}
+
+func parseStatement(line string) (scriptStatement, error) {
+	var st scriptStatement
+
+	tokens, err := tokenize(line)
+	if err != nil {
+		return st, err
+	}
+	p := &scriptParser{tokens: tokens}
+
+	action := p.next()
+	if action.kind != tokIdent {
+		return st, Error("expected an action")
+	}
+	st.action = action.text
+
+	switch st.action {
+	case RuleInclude, RuleExclude:
+	case RuleRoute, RulePriority, scriptPipeline:
+		st.arg, err = p.parseExpr(0)
+		if err != nil {
+			return st, err
+		}
+	default:
+		return st, fmt.Errorf("unknown action %q", st.action)
+	}
+
+	if t := p.peek(); t.kind == tokIdent && t.text == "if" {
+		p.next()
+		st.cond, err = p.parseExpr(0)
+		if err != nil {
+			return st, err
+		}
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return st, fmt.Errorf("unexpected %q", t.text)
+	}
+	return st, nil
+}
+
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "~": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4,
+}
+
+func (p *scriptParser) parseExpr(minPrec int) (scriptExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		prec, ok := binaryPrecedence[t.text]
+		if t.kind != tokOp || !ok || prec <= minPrec {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseExpr(prec)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr(t.text, left, right)
+	}
+}
+
+func (p *scriptParser) parseUnary() (scriptExpr, error) {
+	if p.isOp("!") || p.isOp("-") {
+		op := p.next().text
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(env scriptEnv) (scriptValue, error) {
+			v, err := x(env)
+			if err != nil {
+				return nil, err
+			}
+			switch v := v.(type) {
+			case bool:
+				if op == "!" {
+					return !v, nil
+				}
+			case int64:
+				if op == "-" {
+					return -v, nil
+				}
+			}
+			return nil, fmt.Errorf("invalid operand for %v", op)
+		}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *scriptParser) parsePrimary() (scriptExpr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		s := t.text
+		return func(scriptEnv) (scriptValue, error) { return s, nil }, nil
+	case tokNumber:
+		n, err := parseScriptNumber(t.text)
+		if err != nil {
+			return nil, err
+		}
+		return func(scriptEnv) (scriptValue, error) { return n, nil }, nil
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			b := t.text == "true"
+			return func(scriptEnv) (scriptValue, error) { return b, nil }, nil
+		}
+		if p.isOp("(") {
+			return p.parseCall(t.text)
+		}
+		name := t.text
+		return func(env scriptEnv) (scriptValue, error) {
+			v, ok := env[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown variable %q", name)
+			}
+			return v, nil
+		}, nil
+	case tokOp:
+		if t.text == "(" {
+			x, err := p.parseExpr(0)
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expectOp(")")
+		}
+	case tokEOF:
+		return nil, Error("unexpected end of line")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+func parseScriptNumber(s string) (int64, error) {
+	mult := int64(1)
+	for suffix, m := range sizeSuffixes {
+		if strings.HasSuffix(strings.ToUpper(s), suffix) {
+			s, mult = s[:len(s)-len(suffix)], m
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n * mult, nil
+}
+
+func (p *scriptParser) parseCall(name string) (scriptExpr, error) {
+	fn, ok := scriptFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	p.next() // (
+
+	var args []scriptExpr
+	for !p.isOp(")") {
+		if len(args) > 0 {
+			if err := p.expectOp(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next() // )
+
+	if len(args) != fn.arity {
+		return nil, fmt.Errorf("%v takes %v arguments", name, fn.arity)
+	}
+
+	return func(env scriptEnv) (scriptValue, error) {
+		var strs []string
+		for _, arg := range args {
+			v, err := arg(env)
+			if err != nil {
+				return nil, err
+			}
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("arguments of %v must be strings", name)
+			}
+			strs = append(strs, s)
+		}
+		return fn.call(strs)
+	}, nil
+}
+
+type scriptFunc struct {
+	arity int
+	call  func(args []string) (scriptValue, error)
+}
+
+var scriptFuncs = map[string]scriptFunc{
+	"lower": {1, func(a []string) (scriptValue, error) { return strings.ToLower(a[0]), nil }},
+	"upper": {1, func(a []string) (scriptValue, error) { return strings.ToUpper(a[0]), nil }},
+	"contains": {2, func(a []string) (scriptValue, error) {
+		return strings.Contains(a[0], a[1]), nil
+	}},
+	"hasPrefix": {2, func(a []string) (scriptValue, error) {
+		return strings.HasPrefix(a[0], a[1]), nil
+	}},
+	"hasSuffix": {2, func(a []string) (scriptValue, error) {
+		return strings.HasSuffix(a[0], a[1]), nil
+	}},
+	"replace": {3, func(a []string) (scriptValue, error) {
+		return strings.Replace(a[0], a[1], a[2], -1), nil
+	}},
+	"match": {2, func(a []string) (scriptValue, error) {
+		return path.Match(a[1], a[0])
+	}},
+	// Go regular expressions run in linear time, so they are safe in the
+	// sandbox
+	"regexp": {2, func(a []string) (scriptValue, error) {
+		return regexp.MatchString(a[1], a[0])
+	}},
+}
+
+func binaryExpr(op string, left, right scriptExpr) scriptExpr {
+	return func(env scriptEnv) (scriptValue, error) {
+		l, err := left(env)
+		if err != nil {
+			return nil, err
+		}
+
+		// short circuit
+		if op == "&&" || op == "||" {
+			lb, ok := l.(bool)
+			if !ok {
+				return nil, fmt.Errorf("operands of %v must be booleans", op)
+			}
+			if (op == "&&" && !lb) || (op == "||" && lb) {
+				return lb, nil
+			}
+			r, err := right(env)
+			if err != nil {
+				return nil, err
+			}
+			rb, ok := r.(bool)
+			if !ok {
+				return nil, fmt.Errorf("operands of %v must be booleans", op)
+			}
+			return rb, nil
+		}
+
+		r, err := right(env)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case "==":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		}
+
+		switch l := l.(type) {
+		case int64:
+			r, ok := r.(int64)
+			if !ok {
+				break
+			}
+			switch op {
+			case "+":
+				return l + r, nil
+			case "-":
+				return l - r, nil
+			case "<":
+				return l < r, nil
+			case "<=":
+				return l <= r, nil
+			case ">":
+				return l > r, nil
+			case ">=":
+				return l >= r, nil
+			}
+		case string:
+			r, ok := r.(string)
+			if !ok {
+				break
+			}
+			switch op {
+			case "+":
+				return l + r, nil
+			case "~":
+				return path.Match(r, l)
+			case "<":
+				return l < r, nil
+			case "<=":
+				return l <= r, nil
+			case ">":
+				return l > r, nil
+			case ">=":
+				return l >= r, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid operands for %v", op)
+	}
+}
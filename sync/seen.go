@@ -0,0 +1,84 @@
+package sync
+
+import (
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// seenIndex is an in-memory index of the completed downloads of the users, so
+// that the poller doesn't read the store for every downloaded file. The index
+// of a user is built from the store on its first use and is kept up to date
+// by the committed states.
+type seenIndex struct {
+	mu    sync.Mutex
+	users map[string]map[int64]struct{}
+}
+
+// set records whether the download of the file is completed. It is ignored
+// until the index of the user is built.
+func (x *seenIndex) set(forUser string, id int64, completed bool) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	ids, ok := x.users[forUser]
+	if !ok {
+		return
+	}
+	if completed {
+		ids[id] = struct{}{}
+	} else {
+		delete(ids, id)
+	}
+}
+
+// forget drops the index of the user, e.g. after its states are deleted.
+func (x *seenIndex) forget(forUser string) {
+	x.mu.Lock()
+	delete(x.users, forUser)
+	x.mu.Unlock()
+}
+
+// Completed reports whether the download of the file is completed, without
+// reading the store once the index of the user is built.
+func (s *Store) Completed(id int64, forUser string) (bool, error) {
+	s.seen.mu.Lock()
+	defer s.seen.mu.Unlock()
+
+	ids, ok := s.seen.users[forUser]
+	if !ok {
+		// the states committed meanwhile wait for the lock, so they are
+		// applied after the index is built
+		ids = make(map[int64]struct{})
+		err := s.db.View(func(tx *bolt.Tx) error {
+			userBkt := tx.Bucket([]byte(forUser))
+			if userBkt == nil {
+				return ErrUserNotFound
+			}
+			return userBkt.Bucket(downloadItemsBucket).ForEach(func(k, v []byte) error {
+				var state State
+				err := decodeState(v, &state)
+				if err != nil {
+					return err
+				}
+				if state.DownloadStatus == DownloadCompleted {
+					ids[btoi(k)] = struct{}{}
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return false, err
+		}
+		if s.seen.users == nil {
+			s.seen.users = make(map[string]map[int64]struct{})
+		}
+		s.seen.users[forUser] = ids
+	}
+
+	_, completed := ids[id]
+	return completed, nil
+}
@@ -0,0 +1,356 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxResolves is the number of times the download URL is resolved again when
+// the CDN serves a stale or truncated object.
+const maxResolves = 2
+
+// errStaleResponse is returned if a segment response doesn't match the file.
+const errStaleResponse = Error("stale or truncated response from the CDN")
+
+// errURLExpired is returned if the CDN refuses the download URL.
+const errURLExpired = Error("download URL is expired")
+
+// errObjectChanged is returned if the download URL is resolved again to an
+// object with another ETag. The written segments are of the old object.
+const errObjectChanged = Error("file has changed on the CDN")
+
+// doRequest requests the chunk of the file. The download URL is resolved by
+// the API once and reused for the other segments, until the CDN refuses it or
+// serves a stale object.
+func (c *Client) doRequest(ctx context.Context, t *Task, ch *chunk) (io.ReadCloser, error) {
+	header := c.Config.downloadHeaders(t.root)
+	// 0 byte files cannot be retrieved with a range request. Servers will
+	// return "416 - Requested Range Not Satisfiable".
+	// Set the boundry only if the file has content.
+	if t.state.FileLength != 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%v-%v", ch.offset, ch.offset+ch.length-1))
+	}
+
+	for resolves := 0; ; {
+		t.mu.Lock()
+		u := t.url
+		t.mu.Unlock()
+
+		var resp *http.Response
+		var err error
+		// ETag of the object before the URL is resolved again
+		var oldETag string
+		if u != "" {
+			resp, err = c.requestURL(ctx, u, header)
+		} else {
+			// the other segments wait for the URL resolved by the first
+			t.resolveMu.Lock()
+			t.mu.Lock()
+			u = t.url
+			t.mu.Unlock()
+			switch {
+			case u != "":
+				resp, err = c.requestURL(ctx, u, header)
+			default:
+				// the ETag is taken from the response of the new URL
+				resolves++
+				t.mu.Lock()
+				oldETag, t.etag = t.etag, ""
+				t.mu.Unlock()
+				if c.Config.RaceEndpoints {
+					resp, err = c.raceEndpoints(ctx, t, ch, header)
+				} else {
+					resp, err = c.resolveURL(ctx, t, header, false)
+				}
+			}
+			// the URL which served the response
+			t.mu.Lock()
+			u = t.url
+			t.mu.Unlock()
+			t.resolveMu.Unlock()
+		}
+		if err == errURLExpired {
+			c.Printf("Download URL of %v is expired, resolving it again\n", t)
+			t.forgetURL(u)
+			continue
+		}
+		if err != nil {
+			if strings.Contains(err.Error(), "request canceled") {
+				err = context.Canceled
+			}
+			return nil, err
+		}
+
+		err = t.validateResponse(resp, ch)
+		if err == nil && oldETag != "" {
+			t.mu.Lock()
+			changed := t.etag != "" && t.etag != oldETag
+			t.mu.Unlock()
+			if changed {
+				resp.Body.Close()
+				return nil, errObjectChanged
+			}
+		}
+		if err == nil {
+			return resp.Body, nil
+		}
+		resp.Body.Close()
+		t.forgetURL(u)
+		if resolves > maxResolves {
+			return nil, err
+		}
+		c.Debugf("Resolving the download URL of %v again: %v\n", t, err)
+	}
+}
+
+// resolveURL requests the file through the API, which redirects to the file
+// on the CDN, or on the tunnel of Put.io if tunnel is true. The URL is kept for
+// the next requests.
+func (c *Client) resolveURL(ctx context.Context, t *Task, header http.Header, tunnel bool) (*http.Response, error) {
+	notunnel := 1
+	if tunnel {
+		notunnel = 0
+	}
+	req, err := c.C.NewRequest(ctx, "GET", fmt.Sprintf("/v2/files/%v/download?notunnel=%v", t.state.FileID, notunnel), nil)
+	if err != nil {
+		return nil, err
+	}
+	// the custom headers and cookies are for the CDN, not for the API
+	if r := header.Get("Range"); r != "" {
+		req.Header.Set("Range", r)
+	}
+
+	resp, err := c.C.Do(req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	u := resp.Request.URL
+	if u.Host == c.C.BaseURL.Host {
+		return resp, nil
+	}
+	t.mu.Lock()
+	t.url = u.String()
+	t.mu.Unlock()
+
+	// the redirect is followed without the custom headers, the CDN is
+	// requested again with them
+	for k := range header {
+		if k != "Range" {
+			resp.Body.Close()
+			return c.requestURL(ctx, u.String(), header)
+		}
+	}
+	return resp, nil
+}
+
+// endpoints are the download endpoints of Put.io which are raced if
+// Config.RaceEndpoints is set.
+var endpoints = []struct {
+	name   string
+	tunnel bool
+}{
+	{"direct", false},
+	{"tunnel", true},
+}
+
+// raceEndpoints requests the chunk from all the endpoints at once. The first
+// valid response wins, and its URL is kept for the rest of the file.
+func (c *Client) raceEndpoints(ctx context.Context, t *Task, ch *chunk, header http.Header) (*http.Response, error) {
+	type result struct {
+		resp     *http.Response
+		race     *Task
+		endpoint string
+		err      error
+	}
+
+	cancels := make([]context.CancelFunc, len(endpoints))
+	results := make(chan result, len(endpoints))
+	for i, e := range endpoints {
+		var rctx context.Context
+		rctx, cancels[i] = context.WithCancel(ctx)
+
+		// every request has its own copy of the task, so that only the
+		// winner keeps its URL
+		race := &Task{state: t.state, root: t.root, cwd: t.cwd}
+		t.mu.Lock()
+		race.etag = t.etag
+		t.mu.Unlock()
+
+		go func(i int, tunnel bool, name string) {
+			defer c.RecoverCrash()
+			resp, err := c.resolveURL(rctx, race, header, tunnel)
+			if err == nil {
+				err = race.validateResponse(resp, ch)
+				if err != nil {
+					resp.Body.Close()
+				}
+			}
+			results <- result{resp: resp, race: race, endpoint: name, err: err}
+		}(i, e.tunnel, e.name)
+	}
+
+	var winner *result
+	var err error
+	for range endpoints {
+		r := <-results
+		switch {
+		case r.err != nil:
+			err = r.err
+		case winner == nil:
+			winner = &r
+			c.Debugf("Endpoint %v won the race for %v\n", r.endpoint, t)
+			r.race.mu.Lock()
+			t.mu.Lock()
+			t.url, t.etag = r.race.url, r.race.etag
+			t.mu.Unlock()
+			r.race.mu.Unlock()
+			// the losers are cancelled, their responses are closed below
+			for i, e := range endpoints {
+				if e.name != r.endpoint {
+					cancels[i]()
+				}
+			}
+		default:
+			r.resp.Body.Close()
+		}
+	}
+	if winner == nil {
+		for _, cancel := range cancels {
+			cancel()
+		}
+		return nil, err
+	}
+	return winner.resp, nil
+}
+
+// requestURL requests the download URL on the CDN. It returns errURLExpired
+// if the CDN refuses the URL.
+func (c *Client) requestURL(ctx context.Context, u string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header.Clone()
+	req.Header.Set("User-Agent", defaultUserAgent)
+
+	client := &http.Client{Transport: c.transport}
+	if c.tracer != nil {
+		client.Transport = &traceTransport{transport: c.transport, tracer: c.tracer}
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusForbidden, resp.StatusCode == http.StatusGone:
+		resp.Body.Close()
+		return nil, errURLExpired
+	case resp.StatusCode >= 300:
+		resp.Body.Close()
+		return nil, fmt.Errorf("Unexpected HTTP Status: %v", resp.Status)
+	}
+	return resp, nil
+}
+
+// forgetURL drops the download URL if it is still u, so that the next request
+// resolves it again.
+func (t *Task) forgetURL(u string) {
+	t.mu.Lock()
+	if t.url == u {
+		t.url = ""
+	}
+	t.mu.Unlock()
+}
+
+// validateResponse checks that the response to the request of the chunk is
+// for the expected range of the file, and that it is the same object as the
+// other segments by its ETag.
+func (t *Task) validateResponse(resp *http.Response, ch *chunk) error {
+	length := t.state.FileLength
+
+	switch {
+	case length == 0:
+		// 0 byte files are requested without a range
+		if resp.ContentLength > 0 {
+			return fmt.Errorf("%v: %v bytes for an empty file", errStaleResponse, resp.ContentLength)
+		}
+	case resp.StatusCode == http.StatusPartialContent:
+		start, end, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok {
+			return fmt.Errorf("%v: invalid Content-Range %q", errStaleResponse, resp.Header.Get("Content-Range"))
+		}
+		if start != ch.offset || end != ch.offset+ch.length-1 || (total >= 0 && total != length) {
+			return fmt.Errorf("%v: got range %v-%v/%v, want %v-%v/%v", errStaleResponse, start, end, total, ch.offset, ch.offset+ch.length-1, length)
+		}
+		if resp.ContentLength >= 0 && resp.ContentLength != ch.length {
+			return fmt.Errorf("%v: got %v bytes, want %v", errStaleResponse, resp.ContentLength, ch.length)
+		}
+	default:
+		// the whole file is acceptable only if it is the range
+		if ch.offset != 0 || ch.length != length {
+			return fmt.Errorf("%v: range is ignored with status %v", errStaleResponse, resp.StatusCode)
+		}
+		if resp.ContentLength >= 0 && resp.ContentLength != length {
+			return fmt.Errorf("%v: got %v bytes, want %v", errStaleResponse, resp.ContentLength, length)
+		}
+	}
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.etag == "" {
+		t.etag = etag
+		return nil
+	}
+	if t.etag != etag {
+		return fmt.Errorf("%v: ETag %v differs from %v of the other segments", errStaleResponse, etag, t.etag)
+	}
+	return nil
+}
+
+// parseContentRange parses a Content-Range header of the form
+// "bytes start-end/total". total is -1 if it is unknown.
+func parseContentRange(s string) (start, end, total int64, ok bool) {
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, 0, false
+	}
+	s = strings.TrimPrefix(s, "bytes ")
+
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return 0, 0, 0, false
+	}
+	r, size := s[:i], s[i+1:]
+
+	total = -1
+	if size != "*" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		total = n
+	}
+
+	j := strings.IndexByte(r, '-')
+	if j < 0 {
+		return 0, 0, 0, false
+	}
+	start, err := strconv.ParseInt(r[:j], 10, 64)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	end, err = strconv.ParseInt(r[j+1:], 10, 64)
+	if err != nil || end < start {
+		return 0, 0, 0, false
+	}
+	return start, end, total, true
+}
@@ -0,0 +1,99 @@
+package sync
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// key of the last time new files are found in the user bucket
+var lastActivityKey = []byte("last-activity")
+
+// LastActivity returns the last time a poll found new files. It is zero if
+// it is never saved.
+func (s *Store) LastActivity(forUser string) (time.Time, error) {
+	var t time.Time
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		if userBkt == nil {
+			return ErrUserNotFound
+		}
+		value := userBkt.Get(lastActivityKey)
+		if value == nil {
+			return nil
+		}
+		return t.UnmarshalBinary(value)
+	})
+	return t, err
+}
+
+// SaveLastActivity stores the last time a poll found new files.
+func (s *Store) SaveLastActivity(t time.Time, forUser string) error {
+	value, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		if userBkt == nil {
+			return ErrUserNotFound
+		}
+		return userBkt.Put(lastActivityKey, value)
+	})
+}
+
+// checkSilence records the activity of the poll and sends a notification if
+// the polls succeed but find no new files for Config.SilenceAlarm. The alarm
+// is repeated every Config.SilenceAlarm while the silence lasts.
+func (c *Client) checkSilence(report *Report) {
+	now := time.Now().UTC()
+	if report.NewFiles > 0 {
+		err := c.Store.SaveLastActivity(now, c.User.Username)
+		if err != nil {
+			c.Errorf("Error saving last activity: %v\n", err)
+		}
+		return
+	}
+
+	alarm := time.Duration(c.Config.SilenceAlarm)
+	if alarm <= 0 || len(report.Errors) > 0 {
+		return
+	}
+
+	last, err := c.Store.LastActivity(c.User.Username)
+	if err != nil {
+		c.Errorf("Error retrieving last activity: %v\n", err)
+		return
+	}
+	if last.IsZero() {
+		// the silence is counted from the first poll
+		err = c.Store.SaveLastActivity(now, c.User.Username)
+		if err != nil {
+			c.Errorf("Error saving last activity: %v\n", err)
+		}
+		return
+	}
+
+	silence := now.Sub(last)
+	if silence < alarm || now.Sub(c.silenceNotified) < alarm {
+		return
+	}
+	c.silenceNotified = now
+
+	silence = silence.Round(time.Minute)
+	c.Printf("No new files are found for %v, check the filters and the download folders\n", silence)
+
+	if c.Config.NotifyURL == "" {
+		return
+	}
+	body, contentType, err := c.renderNotification(Notification{
+		Event:    NotifySilent,
+		Duration: silence,
+		Since:    &last,
+	})
+	if err != nil {
+		c.Errorf("Error rendering silence notification: %v\n", err)
+		return
+	}
+	c.sendNotification(body, contentType)
+}
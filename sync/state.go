@@ -2,8 +2,13 @@ package sync
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
@@ -53,17 +58,40 @@ func (ds DownloadStatus) String() string {
 	return s
 }
 
+// Label returns the human readable label of the download status.
+func (ds DownloadStatus) Label() string {
+	var s string
+	switch ds {
+	case DownloadIdle:
+		s = "Idle"
+	case DownloadFailed:
+		s = "Failed"
+	case DownloadInQueue:
+		s = "In queue"
+	case DownloadPaused:
+		s = "Paused"
+	case DownloadInProgress:
+		s = "In progress"
+	case DownloadCompleted:
+		s = "Completed"
+	}
+	return s
+}
+
 // MarshalJSON implements json.Marshaler interface for DownloadStatus.
 func (ds DownloadStatus) MarshalJSON() ([]byte, error) {
 	return []byte(fmt.Sprintf("\"%v\"", ds)), nil
 }
 
-// State stores all the metadata and state of a download. It is encoded as Gob
-// and stored to a persistent storage.
+// State stores all the metadata and state of a download. It is stored as a
+// versioned stateRecord.
 type State struct {
 	// State version number
 	Version uint `json:"version"`
 
+	// Stable ID of the download, in the logs, the events and the API
+	DownloadID string `json:"download_id"`
+
 	// File metadata
 	FileID              int64  `json:"file_id"`
 	FileName            string `json:"file_name"`
@@ -76,19 +104,62 @@ type State struct {
 	// Absolute path of the stored file
 	LocalPath string `json:"local_path"`
 
+	// Download root of the file if it is routed outside of its folder
+	// mapping by a rule
+	LocalRoot string `json:"-"`
+
+	// Files with higher priority are downloaded first
+	Priority int `json:"priority"`
+
+	// Named post-processing pipeline chosen by a rule, the configured
+	// pipeline if empty
+	Pipeline string `json:"pipeline,omitempty"`
+
 	// Directory of the file relative to the Put.io root folder
 	RemoteDir string `json:"-"`
 
+	// Time the file is found by a poll, zero for the files found before it
+	// is recorded
+	DiscoveredAt time.Time `json:"discovered_at"`
+
 	// Download states
 	DownloadStatus     DownloadStatus `json:"download_status"`
 	DownloadStartedAt  time.Time      `json:"download_started_at"`
 	DownloadFinishedAt time.Time      `json:"download_finished_at"`
 	DownloadSpeed      float64        `json:"download_speed"`
 
+	// Compression of the local file, e.g. "gzip". Empty if the file is
+	// stored as is.
+	Compression string `json:"compression"`
+
+	// ID of the previously downloaded file with the same content, if the
+	// file is handled by the duplicate policy instead of being downloaded
+	DuplicateOf int64 `json:"duplicate_of"`
+
+	// Machine which completed the download
+	DownloadedBy Device `json:"downloaded_by"`
+
+	// Media metadata, if the file is probed
+	Media *MediaInfo `json:"media"`
+
+	// TMDb entry, if the file is matched to a movie
+	Movie *MovieInfo `json:"movie"`
+
+	// Statuses of the post-processing steps of the last download
+	Steps []StepStatus `json:"steps"`
+
 	IsHidden bool `json:"-"`
 
 	Error string `json:"fail-reason"`
 
+	// Stored fields unknown to this version, kept when the state is saved
+	extra map[string]json.RawMessage
+
+	// Download status in the store, and what changes it, for the audit log
+	stored       bool
+	storedStatus DownloadStatus
+	trigger      string
+
 	// mu guards below
 	mu                              sync.Mutex
 	BytesTransferredSinceLastUpdate int64     `json:"-"`
@@ -104,6 +175,7 @@ func NewState(f putio.File, savedTo string) *State {
 
 	return &State{
 		Version:             version,
+		DownloadID:          newDownloadID(),
 		FileID:              int64(f.ID),
 		FileLength:          f.Size,
 		FileName:            f.Name,
@@ -112,6 +184,7 @@ func NewState(f putio.File, savedTo string) *State {
 		CRC32:               f.CRC32,
 		BitfieldPieceLength: bitfieldPieceLength,
 		LocalPath:           filepath.Join(savedTo, f.Name),
+		DiscoveredAt:        time.Now().UTC(),
 		DownloadStatus:      DownloadIdle,
 		Bitfield: &Bitfield{
 			length:   bflength,
@@ -120,10 +193,31 @@ func NewState(f putio.File, savedTo string) *State {
 	}
 }
 
+// newDownloadID returns a random download ID.
+func newDownloadID() string {
+	b := make([]byte, 6)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ensureDownloadID gives the states stored before download IDs an ID derived
+// from the file ID, so that it stays the same until the state is saved.
+func (s *State) ensureDownloadID() {
+	if s.DownloadID != "" {
+		return
+	}
+	sum := sha1.Sum([]byte(strconv.FormatInt(s.FileID, 10)))
+	s.DownloadID = hex.EncodeToString(sum[:6])
+}
+
 // String implements fmt.Stringer interface for State.
 func (s *State) String() string {
 	var buf bytes.Buffer
 	buf.WriteString(fmt.Sprintf("ID: %v\n", s.FileID))
+	buf.WriteString(fmt.Sprintf("Download ID: %v\n", s.DownloadID))
 	buf.WriteString(fmt.Sprintf("Name: %v\n", s.FileName))
 	buf.WriteString(fmt.Sprintf("Length: %v\n", s.FileLength))
 	buf.WriteString(fmt.Sprintf("CRC32: %v\n", s.CRC32))
@@ -139,3 +233,25 @@ func (s *State) String() string {
 
 	return buf.String()
 }
+
+// remaining returns the number of bytes left to download.
+func (s *State) remaining() int64 {
+	if s.Bitfield == nil {
+		return s.FileLength
+	}
+
+	n := s.FileLength - int64(s.Bitfield.Count())*int64(s.BitfieldPieceLength)
+	if n < 0 {
+		// last piece is usually shorter than the piece length
+		return 0
+	}
+	return n
+}
+
+// Progress returns the downloaded percentage of the file.
+func (s *State) Progress() float64 {
+	if s.DownloadStatus == DownloadCompleted || s.FileLength == 0 {
+		return 100
+	}
+	return 100 * float64(s.FileLength-s.remaining()) / float64(s.FileLength)
+}
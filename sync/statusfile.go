@@ -0,0 +1,132 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// statusFileInterval is the interval of writing the status file.
+const statusFileInterval = 10 * time.Second
+
+// StatusSnapshot is the content of the status file, for monitors which can
+// read a file but can't talk to the API, e.g. the file sensor of Home
+// Assistant.
+type StatusSnapshot struct {
+	Status string `json:"status"`
+	Label  string `json:"label"`
+
+	// Files being downloaded
+	ActiveDownloads int `json:"active_downloads"`
+
+	// Queue estimate
+	RemainingBytes int64   `json:"remaining_bytes"`
+	Speed          float64 `json:"speed"`
+	ETA            int64   `json:"eta"`
+
+	// Summary of the last poll
+	LastPoll     time.Time `json:"last_poll"`
+	LastNewFiles int       `json:"last_new_files"`
+	Errors       []string  `json:"errors"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// setLastReport keeps the report of the last poll for the status file.
+func (c *Client) setLastReport(r *Report) {
+	c.reportMu.Lock()
+	c.lastReport = r
+	c.reportMu.Unlock()
+}
+
+// Snapshot returns the current status.
+func (c *Client) Snapshot() StatusSnapshot {
+	s := StatusSnapshot{
+		Status:          c.Status(),
+		Label:           c.StatusLabel(),
+		ActiveDownloads: c.Tasks.Len(),
+		Errors:          make([]string, 0),
+		UpdatedAt:       time.Now().UTC(),
+	}
+
+	if e, err := c.QueueEstimate(); err == nil {
+		s.RemainingBytes = e.RemainingBytes
+		s.Speed = e.Speed
+		s.ETA = e.ETA
+	}
+
+	c.reportMu.Lock()
+	if r := c.lastReport; r != nil {
+		s.LastPoll = r.FinishedAt
+		s.LastNewFiles = r.NewFiles
+		s.Errors = append(s.Errors, r.Errors...)
+	}
+	c.reportMu.Unlock()
+	return s
+}
+
+// writeStatusFile writes the status snapshot to StatusFile periodically while
+// syncing, and once more with the stopped status when the sync stops.
+func (c *Client) writeStatusFile(ctx context.Context) {
+	ticker := time.NewTicker(statusFileInterval)
+	defer ticker.Stop()
+
+	for {
+		path := c.Config.StatusFile
+		if path == "" {
+			return
+		}
+
+		snapshot := c.Snapshot()
+		if ctx.Err() != nil {
+			snapshot.Status = "stopped"
+			snapshot.Label = c.T(statusLabels[snapshot.Status])
+			snapshot.ActiveDownloads = 0
+		}
+
+		err := writeJSONFile(path, snapshot)
+		if err != nil {
+			c.Errorf("Error writing status file: %v\n", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if snapshot.Status == "stopped" {
+				return
+			}
+		}
+	}
+}
+
+// writeJSONFile replaces the file at path atomically, so that readers never
+// see a partial file.
+func writeJSONFile(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".status")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(f.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
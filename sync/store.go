@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/gob"
+	"fmt"
 	"os/user"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -15,7 +18,21 @@ import (
 var (
 	downloadItemsBucket   = []byte("download-items")
 	watchedTorrentsBucket = []byte("watched-torrents")
+	conflictsBucket       = []byte("conflicts")
+	uploadsBucket         = []byte("uploads")
+	historyBucket         = []byte("history")
+	reportsBucket         = []byte("reports")
+	rulesBucket           = []byte("rules")
+	urlTransfersBucket    = []byte("url-transfers")
+	errorsBucket          = []byte("errors")
+	deletionsBucket       = []byte("remote-deletions")
+	auditBucket           = []byte("audit")
+	backfillBucket        = []byte("backfill")
+	walkCursorBucket      = []byte("walk-cursor")
+	listingsBucket        = []byte("listings")
 	defaultsBucket        = []byte("defaults")
+	apiKeysBucket         = []byte("api-keys")
+	userIDsBucket         = []byte("user-ids")
 )
 
 // Error represents a custom error.
@@ -28,12 +45,29 @@ const (
 	ErrStateNotFound   = Error("state not found")
 	ErrConfigNotFound  = Error("configuration not found")
 	ErrSaveStateFailed = Error("state could not be saved")
+	ErrUploadNotFound  = Error("upload not found")
+	ErrTorrentNotFound = Error("watched torrent not found")
+	ErrHistoryNotFound = Error("history entry not found")
+	ErrUserNotFound    = Error("user not found")
+	ErrUserExists      = Error("user already exists")
 )
 
 // Store represents persistent storage for user configuration, states etc.
 type Store struct {
 	path string
 	db   *bolt.DB
+
+	// Tuning of the database
+	options DatabaseOptions
+
+	// Metrics of write transactions. It is nil if telemetry is disabled.
+	telemetry *telemetry
+
+	// Completed downloads of the users
+	seen seenIndex
+
+	// Concurrent state writes committed together
+	group writeGroup
 }
 
 // NewStore creates a new Store.
@@ -43,14 +77,23 @@ func NewStore(path string) *Store {
 
 // Open acquires database handle and creates default buckets.
 func (s *Store) Open() error {
-	db, err := bolt.Open(s.path, 0666, &bolt.Options{Timeout: 10 * time.Second})
+	db, err := bolt.Open(s.path, 0666, s.options.boltOptions())
 	if err != nil {
 		return err
 	}
+	s.options.apply(db)
 	s.db = db
 
 	err = s.db.Update(func(tx *bolt.Tx) error {
-		_, err = tx.CreateBucketIfNotExists(defaultsBucket)
+		bkt, err := tx.CreateBucketIfNotExists(defaultsBucket)
+		if err != nil {
+			return err
+		}
+		_, err = bkt.CreateBucketIfNotExists(apiKeysBucket)
+		if err != nil {
+			return err
+		}
+		_, err = bkt.CreateBucketIfNotExists(userIDsBucket)
 		return err
 	})
 	if err != nil {
@@ -59,15 +102,55 @@ func (s *Store) Open() error {
 	return nil
 }
 
+// update executes fn in a write transaction and records its duration.
+func (s *Store) update(fn func(tx *bolt.Tx) error) error {
+	start := time.Now()
+	err := s.db.Update(fn)
+	s.telemetry.Add("putio_sync.store.writes", 1)
+	s.telemetry.Add("putio_sync.store.write_time_us", int64(time.Since(start)/time.Microsecond))
+	return err
+}
+
 // Close releases database handle.
 func (s *Store) Close() error { return s.db.Close() }
 
 // Path returns the full path of the database file.
 func (s *Store) Path() string { return s.path }
 
+// maxCheckErrors is the number of inconsistencies described by Check.
+const maxCheckErrors = 10
+
+// Check verifies the integrity of the database and returns the
+// inconsistencies found.
+func (s *Store) Check() error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		// the checker blocks until all of its errors are received
+		var first error
+		var errs []string
+		n := 0
+		for err := range tx.Check() {
+			if first == nil {
+				first = err
+			}
+			if n < maxCheckErrors {
+				errs = append(errs, err.Error())
+			}
+			n++
+		}
+
+		switch {
+		case n <= 1:
+			return first
+		case n > maxCheckErrors:
+			errs = append(errs, fmt.Sprintf("and %v more", n-maxCheckErrors))
+		}
+		return fmt.Errorf("%v inconsistencies: %v", n, strings.Join(errs, "; "))
+	})
+}
+
 // CreateBuckets creates default buckets for the given user.
 func (s *Store) CreateBuckets(forUser string) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		userBkt, err := tx.CreateBucketIfNotExists([]byte(forUser))
 		if err != nil {
 			return err
@@ -76,6 +159,18 @@ func (s *Store) CreateBuckets(forUser string) error {
 		buckets := [][]byte{
 			downloadItemsBucket,
 			watchedTorrentsBucket,
+			conflictsBucket,
+			uploadsBucket,
+			historyBucket,
+			reportsBucket,
+			rulesBucket,
+			urlTransfersBucket,
+			errorsBucket,
+			deletionsBucket,
+			auditBucket,
+			backfillBucket,
+			walkCursorBucket,
+			listingsBucket,
 		}
 
 		for _, bucket := range buckets {
@@ -88,40 +183,26 @@ func (s *Store) CreateBuckets(forUser string) error {
 	})
 }
 
-// SaveState inserts or updates the given state.
+// SaveState inserts or updates the given state. The states saved at the
+// same time, e.g. the progress of the segments, share a transaction.
 func (s *Store) SaveState(state *State, forUser string) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
-		userBkt := tx.Bucket([]byte(forUser))
-		downloadsBkt := userBkt.Bucket(downloadItemsBucket)
-
-		key := itob(state.FileID)
-		var value bytes.Buffer
-
-		err := gob.NewEncoder(&value).Encode(state)
-		if err != nil {
-			return err
-		}
-
-		return downloadsBkt.Put(key, value.Bytes())
+	return s.Batch(forUser, func(tx StoreTx) error {
+		return tx.SaveState(state)
 	})
 }
 
 // State returns a state by the given file ID.
 func (s *Store) State(id int64, forUser string) (*State, error) {
-	var state State
+	var state *State
 	err := s.db.View(func(tx *bolt.Tx) error {
-		userBkt := tx.Bucket([]byte(forUser))
-		downloadsBkt := userBkt.Bucket(downloadItemsBucket)
-		fileID := itob(id)
-
-		value := downloadsBkt.Get(fileID)
-		if value == nil {
-			return ErrStateNotFound
-		}
-
-		return gob.NewDecoder(bytes.NewReader(value)).Decode(&state)
+		var err error
+		state, err = StoreTx{tx: tx, user: forUser}.State(id)
+		return err
 	})
-	return &state, err
+	if err != nil {
+		return &State{}, err
+	}
+	return state, nil
 }
 
 // States returns all the states in the store.
@@ -139,7 +220,7 @@ func (s *Store) States(forUser string) ([]*State, error) {
 		cursor := downloadsBkt.Cursor()
 		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
 			var state State
-			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&state)
+			err := decodeState(v, &state)
 			if err != nil {
 				return err
 			}
@@ -147,6 +228,8 @@ func (s *Store) States(forUser string) ([]*State, error) {
 			if state.IsHidden {
 				continue
 			}
+			state.ensureDownloadID()
+			state.markStored()
 			states = append(states, &state)
 		}
 		return nil
@@ -155,6 +238,562 @@ func (s *Store) States(forUser string) ([]*State, error) {
 	return states, err
 }
 
+// SaveConflict appends the given conflict to the conflict journal.
+func (s *Store) SaveConflict(conflict *Conflict, forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		conflictsBkt := userBkt.Bucket(conflictsBucket)
+
+		seq, err := conflictsBkt.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		var value bytes.Buffer
+		err = gob.NewEncoder(&value).Encode(conflict)
+		if err != nil {
+			return err
+		}
+
+		return conflictsBkt.Put(itob(int64(seq)), value.Bytes())
+	})
+}
+
+// Conflicts returns all the entries in the conflict journal, oldest first.
+func (s *Store) Conflicts(forUser string) ([]*Conflict, error) {
+	conflicts := make([]*Conflict, 0)
+
+	if forUser == "" {
+		return conflicts, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		conflictsBkt := userBkt.Bucket(conflictsBucket)
+
+		cursor := conflictsBkt.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var conflict Conflict
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&conflict)
+			if err != nil {
+				return err
+			}
+			conflicts = append(conflicts, &conflict)
+		}
+		return nil
+	})
+
+	return conflicts, err
+}
+
+// SaveUpload inserts or updates the given upload.
+func (s *Store) SaveUpload(upload *Upload, forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		uploadsBkt := userBkt.Bucket(uploadsBucket)
+
+		var value bytes.Buffer
+		err := gob.NewEncoder(&value).Encode(upload)
+		if err != nil {
+			return err
+		}
+
+		return uploadsBkt.Put([]byte(upload.Path), value.Bytes())
+	})
+}
+
+// Upload returns the upload of the local file at path.
+func (s *Store) Upload(path string, forUser string) (*Upload, error) {
+	var upload Upload
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		uploadsBkt := userBkt.Bucket(uploadsBucket)
+
+		value := uploadsBkt.Get([]byte(path))
+		if value == nil {
+			return ErrUploadNotFound
+		}
+		return gob.NewDecoder(bytes.NewReader(value)).Decode(&upload)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// Uploads returns all the uploads.
+func (s *Store) Uploads(forUser string) ([]*Upload, error) {
+	var uploads []*Upload
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		uploadsBkt := userBkt.Bucket(uploadsBucket)
+
+		return uploadsBkt.ForEach(func(k, v []byte) error {
+			var upload Upload
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&upload)
+			if err != nil {
+				return err
+			}
+			uploads = append(uploads, &upload)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+// SaveWatchedTorrent inserts or updates the queued torrent.
+func (s *Store) SaveWatchedTorrent(t *WatchedTorrent, forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		torrentsBkt := userBkt.Bucket(watchedTorrentsBucket)
+
+		var value bytes.Buffer
+		err := gob.NewEncoder(&value).Encode(t)
+		if err != nil {
+			return err
+		}
+
+		return torrentsBkt.Put([]byte(t.Path), value.Bytes())
+	})
+}
+
+// WatchedTorrent returns the queued torrent of the local file at path.
+func (s *Store) WatchedTorrent(path string, forUser string) (*WatchedTorrent, error) {
+	var t WatchedTorrent
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		torrentsBkt := userBkt.Bucket(watchedTorrentsBucket)
+
+		value := torrentsBkt.Get([]byte(path))
+		if value == nil {
+			return ErrTorrentNotFound
+		}
+		return gob.NewDecoder(bytes.NewReader(value)).Decode(&t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// WatchedTorrents returns the queued torrents.
+func (s *Store) WatchedTorrents(forUser string) ([]*WatchedTorrent, error) {
+	torrents := make([]*WatchedTorrent, 0)
+
+	if forUser == "" {
+		return torrents, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		torrentsBkt := userBkt.Bucket(watchedTorrentsBucket)
+
+		return torrentsBkt.ForEach(func(k, v []byte) error {
+			var t WatchedTorrent
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&t)
+			if err != nil {
+				return err
+			}
+			torrents = append(torrents, &t)
+			return nil
+		})
+	})
+	return torrents, err
+}
+
+// DeleteWatchedTorrent removes the torrent of the local file at path from the
+// queue.
+func (s *Store) DeleteWatchedTorrent(path string, forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		torrentsBkt := userBkt.Bucket(watchedTorrentsBucket)
+		return torrentsBkt.Delete([]byte(path))
+	})
+}
+
+// SaveURLTransfer inserts or updates the tracked URL transfer.
+func (s *Store) SaveURLTransfer(u *URLTransfer, forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		transfersBkt := userBkt.Bucket(urlTransfersBucket)
+
+		var value bytes.Buffer
+		err := gob.NewEncoder(&value).Encode(u)
+		if err != nil {
+			return err
+		}
+
+		return transfersBkt.Put(itob(u.TransferID), value.Bytes())
+	})
+}
+
+// URLTransfers returns the tracked URL transfers, oldest first.
+func (s *Store) URLTransfers(forUser string) ([]*URLTransfer, error) {
+	transfers := make([]*URLTransfer, 0)
+
+	if forUser == "" {
+		return transfers, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		transfersBkt := userBkt.Bucket(urlTransfersBucket)
+
+		return transfersBkt.ForEach(func(k, v []byte) error {
+			var u URLTransfer
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&u)
+			if err != nil {
+				return err
+			}
+			transfers = append(transfers, &u)
+			return nil
+		})
+	})
+	return transfers, err
+}
+
+// SaveHistory records the downloaded file with the given checksum and size
+// in the history index.
+func (s *Store) SaveHistory(crc32 string, size int64, entry *HistoryEntry, forUser string) error {
+	return s.Batch(forUser, func(tx StoreTx) error {
+		return tx.SaveHistory(crc32, size, entry)
+	})
+}
+
+// History looks up a downloaded file by its checksum and size.
+func (s *Store) History(crc32 string, size int64, forUser string) (*HistoryEntry, error) {
+	var entry *HistoryEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		entry, err = StoreTx{tx: tx, user: forUser}.History(crc32, size)
+		return err
+	})
+	return entry, err
+}
+
+// HistoryEntries returns the history index, most recently downloaded first.
+func (s *Store) HistoryEntries(forUser string) ([]*HistoryEntry, error) {
+	entries := make([]*HistoryEntry, 0)
+
+	if forUser == "" {
+		return entries, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		historyBkt := userBkt.Bucket(historyBucket)
+
+		return historyBkt.ForEach(func(k, v []byte) error {
+			var entry HistoryEntry
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entry)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, &entry)
+			return nil
+		})
+	})
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DownloadedAt.After(entries[j].DownloadedAt)
+	})
+	return entries, err
+}
+
+// SaveReport stores the poll report, dropping the oldest reports beyond
+// maxReports.
+func (s *Store) SaveReport(report *Report, forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		reportsBkt := userBkt.Bucket(reportsBucket)
+
+		seq, err := reportsBkt.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		var value bytes.Buffer
+		err = gob.NewEncoder(&value).Encode(report)
+		if err != nil {
+			return err
+		}
+
+		err = reportsBkt.Put(itob(int64(seq)), value.Bytes())
+		if err != nil {
+			return err
+		}
+
+		var n int
+		cursor := reportsBkt.Cursor()
+		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+			n++
+		}
+
+		// keys are increasing, so the oldest reports come first
+		var old [][]byte
+		for k, _ := cursor.First(); k != nil && n-len(old) > maxReports; k, _ = cursor.Next() {
+			old = append(old, k)
+		}
+		for _, k := range old {
+			err = reportsBkt.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Reports returns the stored poll reports, newest first.
+func (s *Store) Reports(forUser string) ([]*Report, error) {
+	reports := make([]*Report, 0)
+
+	if forUser == "" {
+		return reports, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		reportsBkt := userBkt.Bucket(reportsBucket)
+
+		cursor := reportsBkt.Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			var report Report
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&report)
+			if err != nil {
+				return err
+			}
+			reports = append(reports, &report)
+		}
+		return nil
+	})
+
+	return reports, err
+}
+
+// SaveRemoteDeletion inserts or updates the remote deletion. A new deletion is
+// given the next ID, which keeps the queue in order. The oldest finished
+// deletions beyond maxRemoteDeletions are dropped.
+func (s *Store) SaveRemoteDeletion(d *RemoteDeletion, forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		deletionsBkt := userBkt.Bucket(deletionsBucket)
+
+		if d.ID == 0 {
+			seq, err := deletionsBkt.NextSequence()
+			if err != nil {
+				return err
+			}
+			d.ID = int64(seq)
+		}
+
+		var value bytes.Buffer
+		err := gob.NewEncoder(&value).Encode(d)
+		if err != nil {
+			return err
+		}
+
+		err = deletionsBkt.Put(itob(d.ID), value.Bytes())
+		if err != nil || !d.finished() {
+			return err
+		}
+
+		// keys are increasing, so the oldest deletions come first
+		var finished [][]byte
+		cursor := deletionsBkt.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var old RemoteDeletion
+			err = gob.NewDecoder(bytes.NewReader(v)).Decode(&old)
+			if err != nil {
+				return err
+			}
+			if old.finished() {
+				finished = append(finished, k)
+			}
+		}
+		for i := 0; i < len(finished)-maxRemoteDeletions; i++ {
+			err = deletionsBkt.Delete(finished[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RemoteDeletions returns the queued and the finished remote deletions,
+// oldest first.
+func (s *Store) RemoteDeletions(forUser string) ([]*RemoteDeletion, error) {
+	deletions := make([]*RemoteDeletion, 0)
+
+	if forUser == "" {
+		return deletions, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		deletionsBkt := userBkt.Bucket(deletionsBucket)
+
+		return deletionsBkt.ForEach(func(k, v []byte) error {
+			var d RemoteDeletion
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&d)
+			if err != nil {
+				return err
+			}
+			deletions = append(deletions, &d)
+			return nil
+		})
+	})
+	return deletions, err
+}
+
+// RecordError adds the error to the error log. An error seen before is
+// counted, and the least recently seen errors beyond maxErrors are dropped.
+func (s *Store) RecordError(entry *ErrorEntry, forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		if userBkt == nil {
+			// the user is purged
+			return ErrUserNotFound
+		}
+		errorsBkt := userBkt.Bucket(errorsBucket)
+
+		key := []byte(entry.key())
+		v := errorsBkt.Get(key)
+		if v != nil {
+			var old ErrorEntry
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&old)
+			if err != nil {
+				return err
+			}
+			entry.Count += old.Count
+			entry.FirstSeen = old.FirstSeen
+		}
+
+		var value bytes.Buffer
+		err := gob.NewEncoder(&value).Encode(entry)
+		if err != nil {
+			return err
+		}
+		err = errorsBkt.Put(key, value.Bytes())
+		if err != nil {
+			return err
+		}
+		if v != nil {
+			// a repeated error doesn't grow the log
+			return nil
+		}
+
+		// the keys are counted without decoding the entries, which are
+		// decoded only when the log is full
+		var n int
+		cursor := errorsBkt.Cursor()
+		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+			n++
+		}
+		if n <= maxErrors {
+			return nil
+		}
+
+		var oldestKey []byte
+		var oldest time.Time
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var e ErrorEntry
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&e)
+			if err != nil {
+				return err
+			}
+			if oldestKey == nil || e.LastSeen.Before(oldest) {
+				oldestKey, oldest = append([]byte{}, k...), e.LastSeen
+			}
+		}
+		return errorsBkt.Delete(oldestKey)
+	})
+}
+
+// Errors returns the error log, most recently seen first.
+func (s *Store) Errors(forUser string) ([]*ErrorEntry, error) {
+	entries := make([]*ErrorEntry, 0)
+
+	if forUser == "" {
+		return entries, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		errorsBkt := userBkt.Bucket(errorsBucket)
+
+		return errorsBkt.ForEach(func(k, v []byte) error {
+			var entry ErrorEntry
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entry)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, &entry)
+			return nil
+		})
+	})
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastSeen.After(entries[j].LastSeen)
+	})
+	return entries, err
+}
+
+// ClearErrors empties the error log.
+func (s *Store) ClearErrors(forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		err := userBkt.DeleteBucket(errorsBucket)
+		if err != nil {
+			return err
+		}
+		_, err = userBkt.CreateBucket(errorsBucket)
+		return err
+	})
+}
+
+// SaveRules replaces the ordered filter rules.
+func (s *Store) SaveRules(rules []Rule, forUser string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		rulesBkt := userBkt.Bucket(rulesBucket)
+
+		var value bytes.Buffer
+		err := gob.NewEncoder(&value).Encode(rules)
+		if err != nil {
+			return err
+		}
+
+		return rulesBkt.Put([]byte("rules"), value.Bytes())
+	})
+}
+
+// Rules returns the ordered filter rules.
+func (s *Store) Rules(forUser string) ([]Rule, error) {
+	rules := make([]Rule, 0)
+
+	if forUser == "" {
+		return rules, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBkt := tx.Bucket([]byte(forUser))
+		rulesBkt := userBkt.Bucket(rulesBucket)
+
+		value := rulesBkt.Get([]byte("rules"))
+		if value == nil {
+			return nil
+		}
+		return gob.NewDecoder(bytes.NewReader(value)).Decode(&rules)
+	})
+
+	return rules, err
+}
+
 // Config returns configuration of the associated user.
 func (s *Store) Config(forUser string) (*Config, error) {
 	if forUser == "" {
@@ -172,7 +811,7 @@ func (s *Store) Config(forUser string) (*Config, error) {
 			return ErrConfigNotFound
 		}
 
-		return gob.NewDecoder(bytes.NewReader(value)).Decode(&cfg)
+		return decodeConfig(value, &cfg)
 	})
 
 	if err == ErrConfigNotFound {
@@ -184,18 +823,16 @@ func (s *Store) Config(forUser string) (*Config, error) {
 
 // SaveConfig stores given configuration associated with given user.
 func (s *Store) SaveConfig(cfg *Config, forUser string) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		userBkt := tx.Bucket([]byte(forUser))
 
 		key := []byte("config")
-		var value bytes.Buffer
-
-		err := gob.NewEncoder(&value).Encode(cfg)
+		value, err := encodeConfig(cfg)
 		if err != nil {
 			return err
 		}
 
-		return userBkt.Put(key, value.Bytes())
+		return userBkt.Put(key, value)
 	})
 }
 
@@ -207,13 +844,20 @@ func (s *Store) DefaultConfig() (*Config, error) {
 	}
 	return &Config{
 		PollInterval:        Duration(defaultPollInterval),
+		MaxPollInterval:     Duration(defaultMaxPollInterval),
 		DownloadTo:          filepath.Join(u.HomeDir, "putio-sync"),
 		DownloadFrom:        defaultDownloadFrom,
 		SegmentsPerFile:     defaultSegmentsPerFile,
+		SingleSegmentBelow:  defaultSingleSegmentBelow,
+		MaxSegmentsAbove:    defaultMaxSegmentsAbove,
 		MaxParallelFiles:    defaultMaxParallelFiles,
 		IsPaused:            true,
 		WatchTorrentsFolder: false,
 		TorrentsFolder:      "",
+		ConflictPolicy:      ConflictOverwrite,
+		DuplicatePolicy:     DuplicateDownload,
+		WakeCatchUp:         true,
+		PollOnDrain:         true,
 	}, nil
 }
 
@@ -235,15 +879,116 @@ func (s *Store) CurrentUser() (string, error) {
 // SaveCurrentUser stores the last login user. It is used to know which user is
 // active, and whose bucket should we get.
 func (s *Store) SaveCurrentUser(username string) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+	return s.update(func(tx *bolt.Tx) error {
 		bkt := tx.Bucket(defaultsBucket)
 		key := []byte("current-user")
 		return bkt.Put(key, []byte(username))
 	})
 }
 
+// Users returns the names of the users that have data in the store.
+func (s *Store) Users() ([]string, error) {
+	users := make([]string, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if !bytes.Equal(name, defaultsBucket) {
+				users = append(users, string(name))
+			}
+			return nil
+		})
+	})
+	return users, err
+}
+
+// DeleteUser removes everything stored for the user: the configuration with
+// the token, the download states, the history, the reports and the error
+// log. If the user is the current user, there is no current user afterwards.
+func (s *Store) DeleteUser(username string) error {
+	if username == "" || username == string(defaultsBucket) {
+		return ErrUserNotFound
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(username))
+		if err == bolt.ErrBucketNotFound {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		tx.OnCommit(func() { s.seen.forget(username) })
+		return forgetUser(tx, username)
+	})
+}
+
+// forgetUser removes the references to the user from the defaults bucket.
+func forgetUser(tx *bolt.Tx, username string) error {
+	bkt := tx.Bucket(defaultsBucket)
+	err := forgetUserID(bkt.Bucket(userIDsBucket), username)
+	if err != nil {
+		return err
+	}
+	if string(bkt.Get([]byte("current-user"))) == username {
+		return bkt.Put([]byte("current-user"), nil)
+	}
+	return nil
+}
+
+// SaveAPIKey stores a new API key. API keys are shared by all users.
+func (s *Store) SaveAPIKey(key *APIKey) error {
+	return s.update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(defaultsBucket).Bucket(apiKeysBucket)
+		if bkt.Get([]byte(key.Name)) != nil {
+			return ErrAPIKeyExists
+		}
+
+		var buf bytes.Buffer
+		err := gob.NewEncoder(&buf).Encode(key)
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte(key.Name), buf.Bytes())
+	})
+}
+
+// APIKeys returns all stored API keys.
+func (s *Store) APIKeys() ([]*APIKey, error) {
+	var keys []*APIKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(defaultsBucket).Bucket(apiKeysBucket)
+		return bkt.ForEach(func(k, v []byte) error {
+			var key APIKey
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&key)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, &key)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey removes the API key with the given name.
+func (s *Store) DeleteAPIKey(name string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(defaultsBucket).Bucket(apiKeysBucket)
+		if bkt.Get([]byte(name)) == nil {
+			return ErrAPIKeyNotFound
+		}
+		return bkt.Delete([]byte(name))
+	})
+}
+
 func itob(v int64) []byte {
 	b := make([]byte, 8)
 	binary.BigEndian.PutUint64(b, uint64(v))
 	return b
 }
+
+// btoi returns the int64 of the 8-byte big endian representation.
+func btoi(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b))
+}
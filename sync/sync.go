@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/user"
@@ -12,17 +13,21 @@ import (
 	"sync"
 	"time"
 
-	"golang.org/x/oauth2"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/igungor/go-putio/putio"
 	"github.com/rjeczalik/notify"
 )
 
-// temporary extension to indicate that the file is still not downloaded.
+// temporary extension to indicate that the file is still not downloaded, by
+// default. See Config.PartialNaming.
 const inProgressExtension = ".putdl"
 const defaultUserAgent = "putio-sync"
 
+// errKeptLocal is returned when a download is skipped in favor of an existing
+// local file.
+const errKeptLocal = Error("local file is kept")
+
 type Client struct {
 	// Logging facility
 	*Logger
@@ -42,6 +47,9 @@ type Client struct {
 	// Database handle
 	Store *Store
 
+	// Identity of this machine
+	Device *Device
+
 	// Currently running tasks
 	Tasks *Tasks
 
@@ -64,6 +72,15 @@ type Client struct {
 	// Serves as a job queue
 	taskCh chan *Task
 
+	// Set while a poll is scheduled after the download queue drains, see
+	// drained
+	drainPending int32
+
+	// Found tasks waiting for a consumer, handed out to readyCh in the
+	// order of the queue policy
+	queue   *taskQueue
+	readyCh chan *Task
+
 	// semaphore channel to limit max outstanding running tasks
 	sem chan struct{}
 
@@ -75,6 +92,109 @@ type Client struct {
 
 	// Channel to listen to filesystem events for torrents folder
 	torrentsCh chan notify.EventInfo
+
+	// Reports whether the client is stopped since the download disk is full
+	diskFull bool
+
+	// Reports whether the client is stopped on battery power or on a metered
+	// connection, see WatchPower
+	powerPaused bool
+
+	// Reports whether the sync waits for the VPN, see WatchVPN
+	vpnDown bool
+
+	// Reports whether the sync runs degraded for low memory, see
+	// watchMemory
+	degraded bool
+
+	// Concurrency found by the auto-tuner, which overrides the config
+	// without being saved. They are zero until it makes an adjustment.
+	tunedFiles    uint
+	tunedSegments uint
+
+	// Last time the silence alarm is sent. It is only used by the
+	// queueNewTasks goroutine.
+	silenceNotified time.Time
+
+	// Download throughput of all tasks
+	meter *meter
+
+	// Download speed limit of all tasks
+	limiter *rateLimiter
+
+	// Transport of the API and the download requests, see dialer
+	transport http.RoundTripper
+
+	// Gate of the transport aborting the requests on Halt
+	halt *haltTransport
+
+	// Trace log of API requests and segment timings. It is nil unless tracing
+	// is enabled.
+	tracer *tracer
+
+	// OpenTelemetry spans and metrics. It is nil unless an OTLP endpoint is
+	// configured.
+	telemetry *telemetry
+
+	// appFolderMu guards appFolderID
+	appFolderMu sync.Mutex
+
+	// ID of the hidden Put.io folder shared by the devices. It is zero until
+	// looked up.
+	appFolderID int64
+
+	// ID of the leases folder in the application folder. It is zero until
+	// looked up.
+	leasesFolderID int64
+
+	// Last time the expired leases are pruned, and the old lease files
+	// found to be still valid then, see pruneLeases
+	leasesPrunedAt time.Time
+	validLeases    map[int64]bool
+
+	// rulesMu guards rules
+	rulesMu sync.RWMutex
+
+	// Ordered filter rules of the user
+	rules []Rule
+
+	// Compiled script of the configuration, decides before the rules
+	script *Script
+
+	// Local paths of the renamed new files by their file IDs, so that two
+	// files aren't renamed to the same path. Guarded by mu.
+	renamed map[string]int64
+
+	// ID of the last shared configuration file pushed or pulled
+	remoteConfigID int64
+
+	// reportMu guards lastReport
+	reportMu sync.Mutex
+
+	// Report of the last completed poll
+	lastReport *Report
+
+	// Signalled when an update is installed
+	restartCh chan struct{}
+
+	// Signals the deletion queue that a remote file is queued
+	deleteCh chan struct{}
+
+	// Signals the poller to poll immediately
+	pollCh chan struct{}
+
+	// Serializes the writes of the in-progress manifest
+	manifestMu sync.Mutex
+
+	// watchdogMu guards loopBeat, loopExpect and stalled
+	watchdogMu sync.Mutex
+
+	// Last sign of life of the sync loop, and when the next one is expected
+	loopBeat   time.Time
+	loopExpect time.Duration
+
+	// Subsystem found stalled by the watchdog
+	stalled string
 }
 
 func NewClient(debug bool) (*Client, error) {
@@ -103,14 +223,44 @@ func NewClient(debug bool) (*Client, error) {
 		return nil, err
 	}
 
-	oauthClient := oauth2.NewClient(
-		oauth2.NoContext,
-		oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: cfg.OAuth2Token},
-		),
-	)
-	client := putio.NewClient(oauthClient)
-	client.UserAgent = defaultUserAgent
+	err = store.SetOptions(cfg.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.backupOnUpgrade()
+	if err != nil {
+		return nil, err
+	}
+
+	// buckets might be missing if the database is created by an older
+	// version.
+	if usr != "" {
+		err = store.CreateBuckets(usr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rules, err := store.Rules(usr)
+	if err != nil {
+		return nil, err
+	}
+
+	// a script which doesn't compile anymore, e.g. saved by an older
+	// version, must not keep the daemon from starting
+	script, scriptErr := CompileScript(cfg.Script)
+
+	device, err := store.Device()
+	if err != nil {
+		return nil, err
+	}
+
+	// the dialer follows the configuration of the client once it is created
+	dialer := newDialer(func() *Config { return cfg })
+	halt := &haltTransport{transport: dialer.transport()}
+	transport := http.RoundTripper(halt)
+	client := newPutioClient(cfg.OAuth2Token, nil, transport)
 
 	var account putio.AccountInfo
 	if cfg.OAuth2Token != "" {
@@ -125,21 +275,61 @@ func NewClient(debug bool) (*Client, error) {
 		sem <- struct{}{}
 	}
 
-	return &Client{
-		Logger: NewLogger("sync: ", debug, appPath),
-		Debug:  debug,
-		Config: cfg,
-		C:      client,
-		User:   &account,
-		Store:  store,
-		Tasks:  NewTasks(),
-		taskCh: make(chan *Task),
-		sem:    sem,
+	limiter := &rateLimiter{}
+	limiter.SetRate(cfg.speedLimit())
+
+	var t *telemetry
+	if cfg.OTLPEndpoint != "" {
+		t = newTelemetry(cfg.OTLPEndpoint, device.ID, transport)
+		store.telemetry = t
+	}
+
+	tasks := NewTasks()
+	t.Gauge("putio_sync.active_downloads", func() int64 { return int64(tasks.Len()) })
+
+	logger := NewLogger("sync: ", debug, appPath)
+	logger.errors = make(chan *ErrorEntry, 100)
+
+	c := &Client{
+		Logger:  logger,
+		Debug:   debug,
+		Config:  cfg,
+		C:       client,
+		User:    &account,
+		Store:   store,
+		Device:  device,
+		Tasks:   tasks,
+		rules:   rules,
+		script:  script,
+		renamed: make(map[string]int64),
+		taskCh:  make(chan *Task),
+		queue:   &taskQueue{wake: make(chan struct{}, 1)},
+		readyCh: make(chan *Task),
+		sem:     sem,
 		// Make the channel buffered to ensure no event is dropped.
 		// Notify will drop an event if the receiver is not able to
 		// keep up the sending pace.
 		torrentsCh: make(chan notify.EventInfo, 1),
-	}, nil
+		meter:      &meter{},
+		limiter:    limiter,
+		transport:  transport,
+		halt:       halt,
+		telemetry:  t,
+		restartCh:  make(chan struct{}, 1),
+		deleteCh:   make(chan struct{}, 1),
+		pollCh:     make(chan struct{}, 1),
+	}
+	dialer.config = func() *Config { return c.Config }
+
+	go c.recordErrors(logger.errors)
+
+	if scriptErr != nil {
+		c.Errorf("Error compiling the script, it is disabled until it is fixed: %v\n", scriptErr)
+	}
+
+	// the username of the account might be changed since the last run
+	c.migrateUser()
+	return c, nil
 }
 
 // Run starts watching the remote directory and spawns workers to consume
@@ -152,8 +342,17 @@ func (c *Client) Run() error {
 		return Error("already running")
 	}
 
-	if c.Config.DownloadFrom < 0 {
-		return Error("Invalid Put.io folder ID")
+	if c.halt.isHalted() {
+		return errHalted
+	}
+
+	for _, m := range c.Config.Mappings() {
+		if m.DownloadFrom < 0 {
+			return Error("Invalid Put.io folder ID")
+		}
+		if err := ValidateLocalDir(m.DownloadTo); err != nil {
+			return fmt.Errorf("Invalid download directory: %v", err)
+		}
 	}
 
 	if c.Config.OAuth2Token == "" {
@@ -169,44 +368,97 @@ func (c *Client) Run() error {
 		_ = c.Store.SaveConfig(c.Config, c.User.Username)
 	}
 
+	// the kill-switch holds the sync until the VPN is up
+	if err := c.checkVPN(); err != nil {
+		c.vpnDown = true
+		c.Printf("Waiting for the VPN to sync: %v\n", err)
+		return nil
+	}
+	c.vpnDown = false
+
 	// assign the cancellation function to indicate that the client is already
 	// runnign and is cancellable.
 	c.Ctx, c.CancelFunc = context.WithCancel(context.Background())
 	c.doneCh = make(chan struct{})
+	c.diskFull = false
+	c.powerPaused = false
 
 	go c.queueFailedTasks(c.Ctx)
 	go c.queueNewTasks(c.Ctx)
 
+	go c.runQueue(c.Ctx)
 	go c.runConsumers(c.Ctx)
 
+	if c.Config.AutoTuneConcurrency {
+		go c.autoTune(c.Ctx)
+	}
+
+	go c.WatchUploadFolder(c.Ctx)
+	go c.WatchTorrentFolder(c.Ctx)
+	go c.runDeletions(c.Ctx)
+
+	if c.Config.StatusFile != "" {
+		go c.writeStatusFile(c.Ctx)
+	}
+
+	go c.watchdog(c.Ctx)
+	go c.watchClock(c.Ctx)
+	go c.watchMemory(c.Ctx)
+
 	return nil
 }
 
-// Stop halts all running tasks in a graceful way.
+// Stop halts all running tasks in a graceful way. The sync stays stopped
+// after a restart.
 func (c *Client) Stop() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.CancelFunc == nil {
+		if !c.vpnDown && !c.powerPaused {
+			return Error("already stopped")
+		}
+		// the sync isn't resumed when the VPN or the power is back
+		c.vpnDown = false
+		c.powerPaused = false
+		c.Config.IsPaused = true
+		_ = c.Store.SaveConfig(c.Config, c.User.Username)
+		return nil
+	}
+
+	c.stop()
+
+	if !c.Config.IsPaused {
+		c.Config.IsPaused = true
+		_ = c.Store.SaveConfig(c.Config, c.User.Username)
+	}
+	return nil
+}
+
+// pause halts all running tasks like Stop, for the watchers which resume the
+// sync themselves. The sync is started again after a restart.
+func (c *Client) pause() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.CancelFunc == nil {
 		return Error("already stopped")
 	}
+	c.stop()
+	return nil
+}
 
+// stop cancels the sync and waits for the running tasks. c.mu must be held.
+func (c *Client) stop() {
 	// cancel the poll/download cycle.
 	c.CancelFunc()
 
 	// wait for all the active running tasks
 	<-c.doneCh
 
-	if !c.Config.IsPaused {
-		c.Config.IsPaused = true
-		_ = c.Store.SaveConfig(c.Config, c.User.Username)
-	}
-
 	// reset cancellation states for fresh start.
 	c.CancelFunc = nil
 	c.doneCh = nil
-
-	return nil
 }
 
 // Close releases all the resources, closes database connections and file
@@ -227,6 +479,16 @@ func (c *Client) Close() error {
 		return err
 	}
 
+	err = c.tracer.Close()
+	if err != nil {
+		return err
+	}
+
+	err = c.telemetry.Close()
+	if err != nil {
+		return err
+	}
+
 	return c.Logger.Close()
 }
 
@@ -235,10 +497,30 @@ func (c *Client) Status() string {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.halt.isHalted() {
+		return "halted"
+	}
+
+	if c.diskFull {
+		return "disk-full"
+	}
+
+	if c.powerPaused {
+		return "power-paused"
+	}
+
+	if c.vpnDown {
+		return "vpn-down"
+	}
+
 	if c.CancelFunc == nil {
 		return "stopped"
 	}
 
+	if c.degraded {
+		return "degraded"
+	}
+
 	if c.Tasks.Empty() {
 		return "up-to-date"
 	}
@@ -246,6 +528,13 @@ func (c *Client) Status() string {
 	return "syncing"
 }
 
+// SetSpeedLimit changes the total download speed limit in bytes per second.
+// Zero means unlimited.
+func (c *Client) SetSpeedLimit(limit int64) {
+	c.Config.DownloadSpeedLimit = limit
+	c.limiter.SetRate(c.Config.speedLimit())
+}
+
 // RenewToken creates a new OAuth2 enabled HTTP client for the stored token.
 // This method is used for changing the OAuth2 token of the Client without
 // restarting the application.
@@ -258,29 +547,42 @@ func (c *Client) RenewToken() error {
 		return fmt.Errorf("OAuth2 token is empty")
 	}
 
-	oauthClient := oauth2.NewClient(
-		oauth2.NoContext,
-		oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: c.Config.OAuth2Token},
-		),
-	)
+	c.C = newPutioClient(c.Config.OAuth2Token, c.tracer, c.transport)
 
-	client := putio.NewClient(oauthClient)
-	client.UserAgent = defaultUserAgent
-	c.C = client
+	// the account might be changed
+	c.appFolderMu.Lock()
+	c.appFolderID = 0
+	c.leasesFolderID = 0
+	c.leasesPrunedAt = time.Time{}
+	c.validLeases = nil
+	c.appFolderMu.Unlock()
+	c.remoteConfigID = 0
 
 	user, err := c.C.Account.Info(nil)
 	if err != nil {
 		return err
 	}
 	c.User = &user
+	c.migrateUser()
 
 	err = c.Store.SaveCurrentUser(user.Username)
 	if err != nil {
 		return err
 	}
 
-	return c.Store.CreateBuckets(c.User.Username)
+	err = c.Store.CreateBuckets(c.User.Username)
+	if err != nil {
+		return err
+	}
+
+	rules, err := c.Store.Rules(c.User.Username)
+	if err != nil {
+		return err
+	}
+	c.rulesMu.Lock()
+	c.rules = rules
+	c.rulesMu.Unlock()
+	return nil
 }
 
 // DeleteToken deletes the token associated with the Client.
@@ -299,12 +601,71 @@ func (c *Client) DeleteToken() error {
 	return c.Store.SaveCurrentUser("")
 }
 
+// PurgeUser removes all the data of the user from the store and its backups,
+// including the token, and the cached thumbnails of the user. The crash
+// reports and the goroutine dumps of the watchdog are removed as well, they
+// aren't separated by user. If the user is the current user, sync is stopped
+// and the client is logged out. Downloaded files are kept.
+func (c *Client) PurgeUser(username string) error {
+	c.mu.Lock()
+	current := c.User != nil && c.User.Username == username
+	c.mu.Unlock()
+
+	if current {
+		_ = c.Stop()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.Store.DeleteUser(username)
+	if err != nil {
+		return err
+	}
+	err = c.Store.scrubBackups(username)
+	if err != nil {
+		return err
+	}
+	err = c.removeThumbnails(username)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(c.Store.Path())
+	err = removeCrashReports(dir)
+	if err != nil {
+		return err
+	}
+	err = removeGoroutineDumps(dir)
+	if err != nil {
+		return err
+	}
+	c.Printf("Purged the data of user %v\n", username)
+
+	if !current {
+		return nil
+	}
+
+	cfg, err := c.Store.DefaultConfig()
+	if err != nil {
+		return err
+	}
+	c.Config = cfg
+	c.User = nil
+
+	c.rulesMu.Lock()
+	c.rules = nil
+	c.rulesMu.Unlock()
+	return nil
+}
+
 // queueFailedTasks retrieves paused and failed tasks from the store and pushes
 // them to the task channel.
 func (c *Client) queueFailedTasks(ctx context.Context) {
+	defer c.RecoverCrash()
+
 	states, err := c.Store.States(c.User.Username)
 	if err != nil {
-		c.Printf("Error fetching states: %v\n", err)
+		c.Errorf("Error fetching states: %v\n", err)
 		return
 	}
 
@@ -312,8 +673,13 @@ func (c *Client) queueFailedTasks(ctx context.Context) {
 		switch state.DownloadStatus {
 		case DownloadFailed, DownloadPaused:
 			dir, _ := filepath.Split(state.LocalPath)
-			cwd := strings.TrimPrefix(dir, c.Config.DownloadTo)
-			t := NewTask(state, cwd, c.Config.SegmentsPerFile)
+			root := state.LocalRoot
+			if root == "" {
+				root = c.Config.localRoot(state.LocalPath)
+			}
+			cwd := strings.TrimPrefix(dir, root)
+			state.trigger = TriggerRetry
+			t := NewTask(state, root, cwd, c.segmentsFor(state.FileLength))
 			select {
 			case c.taskCh <- t:
 				c.Debugf("Adding failed task %v to queue\n", t)
@@ -325,16 +691,39 @@ func (c *Client) queueFailedTasks(ctx context.Context) {
 	}
 }
 
-// queueNewTasks repeatedly calls walk function at predefined intervals to find
+// queueNewTasks repeatedly calls poll function at predefined intervals to find
 // new files.
 func (c *Client) queueNewTasks(ctx context.Context) {
-	const rootFolder = "/"
-	c.walk(ctx, c.Config.DownloadFrom, rootFolder)
+	defer c.RecoverCrash()
+
+	// number of consecutive polls which found no new files
+	var idle uint
+
+	c.beat(time.Duration(c.Config.PollInterval))
+	if c.poll(ctx) == 0 {
+		idle++
+	}
 
 	for {
+		delay := c.pollDelay(idle)
+		c.beat(delay)
+
 		select {
-		case <-time.After(time.Duration(c.Config.PollInterval)):
-			c.walk(ctx, c.Config.DownloadFrom, rootFolder)
+		case <-time.After(delay):
+			c.beat(time.Duration(c.Config.PollInterval))
+			if n := c.Config.SkipPollActiveDownloads; n > 0 && uint(c.Tasks.Len()) >= n {
+				c.Debugf("Skipping poll, %v downloads are active\n", c.Tasks.Len())
+				continue
+			}
+			if c.poll(ctx) == 0 {
+				idle++
+			} else {
+				idle = 0
+			}
+		case <-c.pollCh:
+			c.beat(time.Duration(c.Config.PollInterval))
+			idle = 0
+			c.poll(ctx)
 		case <-ctx.Done():
 			c.Debugf("Queueing new tasks got cancelled\n")
 			return
@@ -342,51 +731,264 @@ func (c *Client) queueNewTasks(ctx context.Context) {
 	}
 }
 
+// pollSoon makes the poller poll immediately instead of waiting for the next
+// poll, if the sync is running.
+func (c *Client) pollSoon() {
+	select {
+	case c.pollCh <- struct{}{}:
+	default:
+	}
+}
+
+// pollRand is the random source for poll jitter. It is only used by the
+// queueNewTasks goroutine.
+var pollRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// pollDelay returns the duration to wait before the next poll. idle is the
+// number of consecutive polls which found no new files.
+func (c *Client) pollDelay(idle uint) time.Duration {
+	delay := time.Duration(c.Config.PollInterval)
+
+	if c.Config.AdaptivePolling {
+		max := time.Duration(c.Config.MaxPollInterval)
+		if max <= 0 {
+			max = defaultMaxPollInterval
+		}
+		for i := uint(0); i < idle && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			delay = max
+		}
+	}
+
+	if jitter := int64(c.Config.PollJitter); jitter > 0 {
+		delay += time.Duration(pollRand.Int63n(jitter))
+	}
+	return delay
+}
+
+// poll walks every folder mapping once. It returns the number of newly found
+// files.
+func (c *Client) poll(ctx context.Context) int {
+	ctx, span := c.telemetry.StartSpan(ctx, "poll")
+	c.telemetry.Add("putio_sync.polls", 1)
+
+	changed, err := c.PullConfig(ctx)
+	if err != nil {
+		c.Errorf("Error pulling shared configuration: %v\n", err)
+	} else if changed {
+		c.Printf("Shared configuration is updated by another device\n")
+	}
+
+	report := newReport()
+	if err != nil {
+		report.addError(err)
+	}
+
+	c.startFromNow()
+	c.trackURLTransfers(ctx)
+
+	report.cursor = c.loadWalkCursor()
+
+	const rootFolder = "/"
+	for _, m := range c.Config.Mappings() {
+		c.walk(ctx, m, m.DownloadFrom, rootFolder, rootFolder, nil, true, report)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	c.finishWalkCursor(ctx, report.cursor)
+	if c.Config.Backfill && ctx.Err() == nil {
+		c.finishBackfill()
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	if ctx.Err() == nil {
+		err = c.Store.SaveReport(report, c.User.Username)
+		if err != nil {
+			c.Errorf("Error saving poll report: %v\n", err)
+		}
+		c.setLastReport(report)
+		c.checkSilence(report)
+	}
+
+	span.SetAttr("files.found", report.NewFiles)
+	span.End(ctx.Err())
+	return report.NewFiles
+}
+
 // walk recursively walks the Put.io filetree, starting from the given
-// putioFolderID. Only non-completed files are pushed to the task channel.
-func (c *Client) walk(ctx context.Context, putioFolderID int64, cwd string) {
-	files, _, err := c.C.Files.List(ctx, putioFolderID)
+// putioFolderID. Only non-completed files which pass the filter of the folder
+// mapping and are not ignored by an ignore file are pushed to the task channel.
+// Subfolders are skipped unless recursive is true. The outcome for every file
+// is recorded in the report. Files are downloaded into localcwd, which is cwd
+// unless the folders are flattened.
+func (c *Client) walk(ctx context.Context, m FolderMapping, putioFolderID int64, cwd, localcwd string, ignores ignoreList, recursive bool, report *Report) {
+	if report.cursor.skip(putioFolderID) {
+		c.Debugf("Skipping folder %v walked before the restart\n", putioFolderID)
+		return
+	}
+
+	if c.Config.Backfill {
+		if c.backfilled(putioFolderID) {
+			c.Debugf("Skipping folder %v downloaded by the backfill\n", putioFolderID)
+			return
+		}
+		defer c.checkpoint(ctx, putioFolderID, recursive, report, report.Queued, len(report.Errors))
+	}
+	errors := len(report.Errors)
+
+	files, _, err := c.listFolder(ctx, putioFolderID, report.folderSize(putioFolderID))
 	if err != nil {
-		c.Printf("Error listing directory %v: %v\n", putioFolderID, err)
+		c.Errorf("Error listing directory %v: %v\n", putioFolderID, err)
+		report.addError(fmt.Errorf("listing directory %v: %v", putioFolderID, err))
 		return
 	}
 
+	localdir := filepath.Join(m.DownloadTo, cwd)
+
+	if len(files) == 1 && cwd != "/" && c.Config.CollapseSingleFolders {
+		localcwd = filepath.Dir(localcwd)
+	}
+
+	// folders are created for the downloaded files otherwise, so a folder
+	// with only filtered files has no local folder
+	if len(files) == 0 && cwd != "/" && c.Config.MirrorEmptyFolders {
+		dir := filepath.Join(m.DownloadTo, c.Config.flattenDir(localcwd))
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			c.Errorf("Error creating empty folder %v: %v\n", dir, err)
+			report.addError(fmt.Errorf("creating empty folder %v: %v", dir, err))
+		}
+	}
+
+	// copy the parent patterns so that sibling folders don't share them
+	ignores = append(ignores[:len(ignores):len(ignores)], c.readIgnoreFiles(ctx, files, localdir, cwd)...)
+
 	for _, file := range files {
+		if file.Name == ignoreFileName {
+			continue
+		}
+
+		if putioFolderID == rootFolderID && file.Name == appFolderName {
+			continue
+		}
+
+		relpath := filepath.Join(cwd, file.Name)
+
+		if c.Config.SkipHiddenFiles && isHidden(file.Name) {
+			c.Debugf("Skipping hidden file %v\n", file)
+			report.skip(file.ID, relpath, SkipHidden)
+			continue
+		}
+
+		if ignores.Match(relpath) {
+			c.Debugf("Skipping ignored file %v\n", file)
+			report.skip(file.ID, relpath, SkipIgnored)
+			continue
+		}
+
 		if file.IsDir() {
-			newcwd := filepath.Join(cwd, file.Name)
-			c.walk(ctx, file.ID, newcwd)
+			if recursive {
+				report.sawFolder(file)
+				c.walk(ctx, m, file.ID, relpath, filepath.Join(localcwd, file.Name), ignores, true, report)
+			}
+			continue
+		}
+
+		rule := c.decide(relpath, file)
+		if rule != nil && rule.Action == RuleExclude {
+			c.Debugf("Skipping file %v excluded by rule %q\n", file, rule.Name)
+			report.skip(file.ID, relpath, SkipRule)
+			continue
+		}
+
+		if rule == nil && !m.Filter.Match(relpath, file.Size) {
+			c.Debugf("Skipping filtered file %v\n", file)
+			report.skip(file.ID, relpath, SkipFiltered)
+			continue
+		}
+
+		// the completed downloads are known without reading the store
+		completed, err := c.Store.Completed(file.ID, c.User.Username)
+		if err != nil {
+			c.Debugf("Error looking up file %v in the completed downloads: %v\n", file.ID, err)
+		}
+		if completed {
+			c.Debugf("Skipping already downloaded file %v\n", file)
+			report.skip(file.ID, relpath, SkipDownloaded)
 			continue
 		}
 
 		// look for an existing state, so that we can resume
 		state, err := c.Store.State(file.ID, c.User.Username)
 		if err != nil && err != ErrStateNotFound {
-			c.Printf("Error retrieving state for file %v: %v\n", file.ID, err)
+			c.Errorf("Error retrieving state for file %v: %v\n", file.ID, err)
+			report.addError(fmt.Errorf("retrieving state for file %v: %v", file.ID, err))
+			continue
+		}
+
+		if err == ErrStateNotFound && c.Config.tooOld(file) {
+			c.Debugf("Skipping file %v added before the cutoff\n", file)
+			report.skip(file.ID, relpath, SkipTooOld)
 			continue
 		}
 
 		if err == ErrStateNotFound {
 			c.Debugf("State not found for %v, creating a new one\n", file)
-			savedTo := filepath.Join(c.Config.DownloadTo, cwd)
-			state = NewState(file, savedTo)
+			root := m.DownloadTo
+			if rule != nil && rule.Action == RuleRoute {
+				root = rule.RouteTo
+			}
+			savedTo := filepath.Join(root, c.Config.flattenDir(localcwd))
+			state = c.newState(file, savedTo, files)
+			if root != m.DownloadTo {
+				state.LocalRoot = root
+			}
+			if rule != nil && rule.Action == RulePriority {
+				state.Priority = rule.Priority
+			}
+			if rule != nil {
+				state.Pipeline = rule.Pipeline
+			}
+			report.NewFiles++
+
+			if c.handleDuplicate(state) {
+				report.skip(file.ID, relpath, SkipDuplicate)
+				continue
+			}
 		}
 
 		// skip already synced task
 		if state.DownloadStatus == DownloadCompleted {
 			c.Debugf("Skipping already downloaded file %v\n", file)
+			report.skip(file.ID, relpath, SkipDownloaded)
 			continue
 		}
+		state.trigger = triggerOf(ctx)
 
-		t := NewTask(state, cwd, c.Config.SegmentsPerFile)
+		root := m.DownloadTo
+		if state.LocalRoot != "" {
+			root = state.LocalRoot
+		}
+		taskcwd := taskDir(state, root, c.Config.flattenDir(localcwd))
+		t := NewTask(state, root, taskcwd, c.segmentsFor(state.FileLength))
+		t.remoteDir = cwd
 
 		select {
 		case c.taskCh <- t:
 			c.Debugf("Adding %v to queue\n", t)
+			report.queue(state)
 		case <-ctx.Done():
 			c.Debugf("Directory walking got cancelled\n")
 			return
 		}
 	}
+
+	if recursive {
+		c.walkedFolder(ctx, report, putioFolderID, errors)
+	}
 }
 
 // SetConcurrency dynamically resizes the number of active consumers.
@@ -420,6 +1022,8 @@ func (c *Client) AdjustConcurreny(n int) error {
 }
 
 func (c *Client) runConsumers(ctx context.Context) {
+	defer c.RecoverCrash()
+
 	var wg sync.WaitGroup
 
 LOOP:
@@ -441,9 +1045,12 @@ LOOP:
 
 func (c *Client) consumeTask(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer c.RecoverCrash()
 
 	select {
-	case t := <-c.taskCh:
+	case t := <-c.readyCh:
+		defer c.queue.done(t)
+
 		// skip running tasks
 		if c.Tasks.Exists(t) {
 			c.Debugf("%v is already in active tasks\n", t)
@@ -451,8 +1058,11 @@ func (c *Client) consumeTask(ctx context.Context, wg *sync.WaitGroup) {
 		}
 
 		c.Tasks.Add(t)
+		c.writeManifest()
 		c.processTask(ctx, t)
 		c.Tasks.Remove(t)
+		c.writeManifest()
+		c.drained()
 
 		<-c.sem
 		return
@@ -463,23 +1073,62 @@ func (c *Client) consumeTask(ctx context.Context, wg *sync.WaitGroup) {
 }
 
 func (c *Client) processTask(ctx context.Context, t *Task) {
-	err := c.download(ctx, t)
+	ctx, span := c.telemetry.StartSpan(ctx, "download")
+	span.SetAttr("download.id", t.state.DownloadID)
+	span.SetAttr("file.id", t.state.FileID)
+	span.SetAttr("file.name", t.state.FileName)
+	span.SetAttr("file.size", t.state.FileLength)
+
+	release, err := c.acquireLease(ctx, t)
+	if err == nil {
+		err = c.download(ctx, t)
+		release(err == nil)
+	}
+	span.End(err)
+	switch err {
+	case nil:
+		c.telemetry.Add("putio_sync.files.completed", 1)
+	case context.Canceled, errKeptLocal, errLeased:
+	default:
+		c.telemetry.Add("putio_sync.files.failed", 1)
+	}
+
 	if err == context.Canceled {
 		c.Debugf("Task %v cancelled by request\n", t)
 		return
 	}
 
+	if err == errKeptLocal {
+		c.Printf("Skipping %v, %v\n", t, err)
+		return
+	}
+
+	if err == errLeased {
+		return
+	}
+
+	if err == errDiskFull {
+		c.pauseDiskFull()
+		return
+	}
+
 	if err != nil {
-		c.Printf("Error downloading %v. err: %v\n", t, err)
+		c.Errorf("Error downloading %v. err: %v\n", t, err)
+		c.notify(t, NotifyFailed, err)
 		return
 	}
 
-	if c.Config.DeleteRemoteFile {
-		err = c.C.Files.Delete(ctx, t.state.FileID)
-		if err != nil {
-			c.Printf("File %v successfully downloaded but the remote file could not be deleted: %v\n", t, err)
-		}
+	downloadPath := t.state.LocalPath
+	err = c.postProcess(ctx, t)
+	if err != nil && t.state.DownloadStatus == DownloadFailed {
+		c.Errorf("Error post-processing %v. err: %v\n", t, err)
+		c.notify(t, NotifyFailed, err)
+		return
 	}
+	if err != nil {
+		c.Printf("File %v successfully downloaded but post-processing stopped: %v\n", t, err)
+	}
+	c.recordHistory(t.state, downloadPath)
 	c.Printf("File %v successfully downloaded\n", t)
 }
 
@@ -489,11 +1138,19 @@ func (c *Client) download(ctx context.Context, t *Task) error {
 	c.Debugf("Starting to download: %v\n", t)
 
 	// parent directory of the file
-	taskdir := filepath.Join(filepath.Clean(c.Config.DownloadTo), t.cwd)
-	// absolute path of the file, with an extension added, indicating that the
-	// file is not completed yet.
-	taskpath := filepath.Join(taskdir, t.state.FileName)
-	taskpath += inProgressExtension
+	taskdir := filepath.Join(filepath.Clean(t.root), t.cwd)
+
+	if !c.resolveConflict(t, filepath.Join(taskdir, t.state.FileName)) {
+		t.state.DownloadStatus = DownloadCompleted
+		t.state.DownloadFinishedAt = time.Now().UTC()
+		t.state.DownloadedBy = c.DeviceInfo()
+		_ = c.Store.SaveState(t.state, c.User.Username)
+		return errKeptLocal
+	}
+
+	// absolute path of the file, and its name while it is not completed yet
+	finalpath := filepath.Join(taskdir, t.state.FileName)
+	taskpath := c.Config.partialPath(finalpath)
 
 	_, err := os.Stat(taskdir)
 	if os.IsNotExist(err) {
@@ -503,14 +1160,50 @@ func (c *Client) download(ctx context.Context, t *Task) error {
 		}
 	}
 
+	if c.Config.ExtractArchives && t.state.Bitfield.Count() == 0 {
+		if _, ok := archiveExt(t.state.FileName, tarExtensions); ok {
+			return c.streamExtract(ctx, t, taskdir)
+		}
+	}
+
+	// resume the download started with another partial naming
+	if p := c.Config.findPartial(finalpath); p != "" && !exists(taskpath) {
+		err = os.Rename(p, taskpath)
+		if err != nil {
+			return err
+		}
+	}
+
 	f, err := os.OpenFile(taskpath, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	// pre-allocate file space. It's ok if it fails.
+	// keep the other watchers of the folder off the partial file
+	if err := lockFile(f); err != nil {
+		c.Debugf("Error locking %v: %v\n", taskpath, err)
+	}
+	if c.Config.HidePartialFiles {
+		if err := setHidden(taskpath, true); err != nil {
+			c.Debugf("Error hiding %v: %v\n", taskpath, err)
+		}
+	}
+
+	// fail early if a new file doesn't fit on the disk. Space of existing
+	// files are already allocated.
+	if fi, err := f.Stat(); err == nil && fi.Size() == 0 {
+		free, err := freeSpace(taskdir)
+		if err == nil && free < t.state.FileLength {
+			return c.failDiskFull(t)
+		}
+	}
+
+	// pre-allocate file space. It's ok if it fails, unless the disk is full.
 	err = Preallocate(f, t.state.FileLength)
+	if isDiskFull(err) {
+		return c.failDiskFull(t)
+	}
 	if err != nil {
 		c.Printf("Preallocation for %v failed: %v\n", t, err)
 	}
@@ -524,15 +1217,31 @@ func (c *Client) download(ctx context.Context, t *Task) error {
 		return err
 	}
 
-	g, ctx := errgroup.WithContext(ctx)
-	for _, ch := range t.chunks {
-		ch := ch // https://golang.org/doc/faq#closures_and_goroutines
-		g.Go(func() error {
-			return c.downloadRange(ctx, f, t, ch)
-		})
-	}
+	// the file is restarted once if it changes on the CDN meanwhile
+	for restarts := 0; ; restarts++ {
+		g, gctx := errgroup.WithContext(ctx)
+		for _, ch := range t.chunks {
+			ch := ch // https://golang.org/doc/faq#closures_and_goroutines
+			g.Go(func() error {
+				defer c.RecoverCrash()
+				return c.downloadRange(gctx, f, t, ch)
+			})
+		}
 
-	err = g.Wait()
+		err = g.Wait()
+		if err != errObjectChanged || restarts > 0 {
+			break
+		}
+		c.Printf("%v has changed on the CDN, restarting it\n", t)
+		t.restart(c.segmentsFor(t.state.FileLength))
+		err = c.Store.SaveState(t.state, c.User.Username)
+		if err != nil {
+			return err
+		}
+	}
+	if isDiskFull(err) {
+		return c.failDiskFull(t)
+	}
 	if err != nil {
 		switch err {
 		case context.Canceled:
@@ -554,8 +1263,14 @@ func (c *Client) download(ctx context.Context, t *Task) error {
 		return err
 	}
 
+	// the attribute is kept by the rename
+	err = setHidden(taskpath, false)
+	if err != nil {
+		c.Debugf("Error unhiding %v: %v\n", taskpath, err)
+	}
+
 	// Rename the file to its original name after a successful download operation
-	err = os.Rename(taskpath, strings.TrimSuffix(taskpath, inProgressExtension))
+	err = os.Rename(taskpath, finalpath)
 	if err != nil {
 		return err
 	}
@@ -563,52 +1278,121 @@ func (c *Client) download(ctx context.Context, t *Task) error {
 	// all chunks are downloaded and verified
 	t.state.DownloadStatus = DownloadCompleted
 	t.state.DownloadFinishedAt = time.Now().UTC()
+	t.state.DownloadedBy = c.DeviceInfo()
 	t.state.Error = ""
 	return c.Store.SaveState(t.state, c.User.Username)
 }
 
-func (c *Client) downloadRange(ctx context.Context, w io.WriterAt, t *Task, ch *chunk) error {
-	body, err := c.doRequest(ctx, t, ch)
-	if err != nil {
-		c.Debugf("Error retrieving body for %v/%v: %v\n", ch, t, err)
-		return err
+// failDiskFull pauses the task since there is no space left for it.
+func (c *Client) failDiskFull(t *Task) error {
+	c.Printf("No space left on disk for %v\n", t)
+	t.state.DownloadStatus = DownloadPaused
+	t.state.Error = errDiskFull.Error()
+	_ = c.Store.SaveState(t.state, c.User.Username)
+	return errDiskFull
+}
+
+// pauseDiskFull stops the whole queue since the download disk is full. Sync
+// can be resumed with Run once some space is freed.
+func (c *Client) pauseDiskFull() {
+	c.mu.Lock()
+	if c.diskFull || c.CancelFunc == nil {
+		c.mu.Unlock()
+		return
 	}
+	c.diskFull = true
+	c.mu.Unlock()
+
+	c.Printf("Download disk is full, pausing the queue\n")
 
-	return c.copyChunk(w, body, ch, t.state)
+	// Stop waits for all the tasks, including the caller.
+	go func() { _ = c.Stop() }()
 }
 
-func (c *Client) doRequest(ctx context.Context, t *Task, ch *chunk) (io.ReadCloser, error) {
-	rangeHeader := http.Header{}
-	// 0 byte files cannot be retrieved with a range request. Servers will
-	// return "416 - Requested Range Not Satisfiable".
-	// Set the boundry only if the file has content.
-	if t.state.FileLength != 0 {
-		rangeHeader.Set("Range", fmt.Sprintf("bytes=%v-%v", ch.offset, ch.offset+ch.length-1))
-	}
+func (c *Client) downloadRange(ctx context.Context, w io.WriterAt, t *Task, ch *chunk) (err error) {
+	ctx, span := c.telemetry.StartSpan(ctx, "segment")
+	span.SetAttr("segment.offset", ch.offset)
+	span.SetAttr("segment.length", ch.length)
+	defer func() { span.End(err) }()
 
-	body, err := c.C.Files.Download(ctx, t.state.FileID, false, rangeHeader)
-	if err != nil {
-		if strings.Contains(err.Error(), "request canceled") {
-			err = context.Canceled
+	start := time.Now()
+
+	// the rest of the segment is re-fetched if the body ends short
+	rest := &chunk{offset: ch.offset, length: ch.length}
+	var refetches int
+	for {
+		body, err := c.doRequest(ctx, t, rest)
+		if err != nil {
+			c.Debugf("Error retrieving body for %v/%v: %v\n", rest, t, err)
+			c.tracer.Printf("segment %v of %q failed after %v: %v", ch, t.state.FileName, time.Since(start), err)
+			return err
+		}
+		if rest.offset == ch.offset {
+			c.tracer.Printf("segment %v of %q first byte in %v", ch, t.state.FileName, time.Since(start))
+		}
+
+		next, err := c.copyChunk(ctx, w, body, rest, t.state)
+		short, ok := err.(*shortReadError)
+		if !ok {
+			c.tracer.Printf("segment %v of %q finished in %v: %v", ch, t.state.FileName, time.Since(start), err)
+			return err
+		}
+
+		// the attempts are counted only while no bytes arrive
+		if next > rest.offset {
+			refetches = 0
+		}
+		refetches++
+		if refetches > maxRefetches {
+			c.tracer.Printf("segment %v of %q failed after %v: %v", ch, t.state.FileName, time.Since(start), short.err)
+			return short.err
+		}
+
+		c.Debugf("Body of %v/%v ended short at %v, re-fetching the rest: %v\n", rest, t, next, short.err)
+		c.tracer.Printf("segment %v of %q ended short at %v, re-fetching the rest: %v", ch, t.state.FileName, next, short.err)
+		end := rest.offset + rest.length
+		rest = &chunk{offset: next, length: end - next}
+
+		select {
+		case <-time.After(time.Duration(refetches-1) * time.Second):
+		case <-ctx.Done():
+			return context.Canceled
 		}
 	}
-	return body, err
 }
 
-func (c *Client) copyChunk(w io.WriterAt, body io.ReadCloser, ch *chunk, state *State) error {
+// maxRefetches is the number of times the rest of a segment is re-fetched
+// without receiving any bytes before the segment fails.
+const maxRefetches = 3
+
+// shortReadError is returned by copyChunk if the body ends before the chunk,
+// e.g. the connection is reset. The bytes before it are written, so only the
+// rest of the chunk needs to be fetched again.
+type shortReadError struct {
+	err error
+}
+
+func (e *shortReadError) Error() string { return e.err.Error() }
+
+// copyChunk writes the body to the chunk of the file. The chunk may start in
+// the middle of a piece if the beginning of the piece is written before. It
+// returns the offset of the first byte which isn't written.
+func (c *Client) copyChunk(ctx context.Context, w io.WriterAt, body io.ReadCloser, ch *chunk, state *State) (int64, error) {
 	c.Debugf("Copying %v of %v\n", ch, state.FileName)
 
 	defer body.Close()
 
-	var n int64
 	bfPieceLength := int64(state.BitfieldPieceLength)
 	buf := make([]byte, bfPieceLength)
-
-	for curoffset := ch.offset; curoffset < ch.offset+ch.length; curoffset += n {
-		idx := curoffset / bfPieceLength                    // bitfield index
-		n = (ch.offset + ch.length) - (idx * bfPieceLength) // read this amount of bytes
-		if n > bfPieceLength {
-			n = bfPieceLength
+	end := ch.offset + ch.length
+
+	curoffset := ch.offset
+	for curoffset < end {
+		idx := curoffset / bfPieceLength // bitfield index
+		// read up to the end of the piece
+		n := (idx+1)*bfPieceLength - curoffset
+		if n > end-curoffset {
+			n = end - curoffset
 		}
 
 		written, err := io.ReadFull(body, buf[:n])
@@ -617,17 +1401,43 @@ func (c *Client) copyChunk(w io.WriterAt, body io.ReadCloser, ch *chunk, state *
 			// XXX: ugly workaround to detect cancellation error. Concrete
 			// error is not context.Canceled even though the cancel function is
 			// called.
-			if strings.Contains(err.Error(), "request canceled") {
-				return context.Canceled
+			if strings.Contains(err.Error(), "request canceled") || ctx.Err() != nil {
+				return curoffset, context.Canceled
 			}
-			return err
+			// keep the bytes read, the piece is completed by the re-fetch
+			if written > 0 {
+				_, werr := w.WriteAt(buf[:written], curoffset)
+				if werr != nil {
+					return curoffset, werr
+				}
+				c.meter.Add(int64(written))
+				c.telemetry.Add("putio_sync.bytes.downloaded", int64(written))
+				curoffset += int64(written)
+
+				state.mu.Lock()
+				state.BytesTransferredSinceLastUpdate += int64(written)
+				state.mu.Unlock()
+			}
+			return curoffset, &shortReadError{err: err}
 		}
 
 		_, err = w.WriteAt(buf[:n], curoffset)
 		if err != nil {
 			c.Debugf("Error writing body at offset %v: %v\n", curoffset, err)
-			return err
+			if isDiskFull(err) {
+				return curoffset, errDiskFull
+			}
+			return curoffset, err
+		}
+
+		c.meter.Add(int64(written))
+		c.telemetry.Add("putio_sync.bytes.downloaded", int64(written))
+
+		err = c.limiter.Wait(ctx, int64(written))
+		if err != nil {
+			return curoffset, err
 		}
+		curoffset += n
 
 		state.mu.Lock()
 		state.BytesTransferredSinceLastUpdate += int64(written)
@@ -636,106 +1446,12 @@ func (c *Client) copyChunk(w io.WriterAt, body io.ReadCloser, ch *chunk, state *
 
 		err = c.Store.SaveState(state, c.User.Username)
 		if err != nil {
-			return err
+			return curoffset, err
 		}
 	}
 
 	c.Debugf("Copying %v of %q success\n", ch, state.FileName)
-	return nil
-}
-
-// WatchTorrentFolder registers a watcher for the user's preferred
-// TorrentsFolder.
-func (c *Client) WatchTorrentFolder() {
-	if !c.Config.WatchTorrentsFolder {
-		return
-	}
-
-	if c.Config.TorrentsFolder == "" {
-		c.Println("No torrent folder is given")
-		return
-	}
-
-	// watch for create and rename events, since moving from one folder to
-	// another is simpy a 'rename' event.
-	err := notify.Watch(c.Config.TorrentsFolder, c.torrentsCh, notify.Create, notify.Rename)
-	if err != nil {
-		c.Printf("error watching torrent fodler: %v\n", err)
-		return
-	}
-
-	uploadTorrentFunc := func(path string) error {
-		path = filepath.Clean(path)
-
-		f, err := os.Open(path)
-		if err != nil {
-			return fmt.Errorf("Error opening file: %v", err)
-		}
-		defer f.Close()
-
-		_, filename := filepath.Split(path)
-		u, err := c.C.Files.Upload(nil, f, filename, -1)
-		if err != nil {
-			return fmt.Errorf("Error uploading file: %v", err)
-		}
-
-		if u.Transfer == nil {
-			return fmt.Errorf("API hasn't started the transfer for some reason")
-		}
-
-		err = os.RemoveAll(path)
-		if err != nil {
-			return fmt.Errorf("Error removing file: %v", err)
-		}
-
-		return nil
-
-	}
-
-	// Perform an initial scan on the directory
-	err = filepath.Walk(c.Config.TorrentsFolder, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !strings.HasSuffix(info.Name(), ".torrent") {
-			return nil
-		}
-
-		c.Debugf("found '%v' on initial scan\n", info.Name())
-
-		return uploadTorrentFunc(path)
-	})
-
-	if err != nil {
-		c.Printf("Error walking the torrent folder: %v\n", err)
-	}
-
-	for {
-		select {
-		case event := <-c.torrentsCh:
-			path := event.Path()
-
-			if !strings.HasSuffix(path, ".torrent") {
-				continue
-			}
-
-			// if a file is renamed, it might have been moved from someplace
-			// else. so check if the file exists, and skip the simple
-			// 'renaming' events.
-			if event.Event() == notify.Rename && !exists(path) {
-				continue
-			}
-
-			c.Debugf("New event: %v, %v\n", event.Event(), path)
-
-			err = uploadTorrentFunc(path)
-			if err != nil {
-				c.Printf("Error uploading torrent file: %v\n", err)
-				return
-			}
-		}
-	}
+	return curoffset, nil
 }
 
 func exists(filename string) bool {
@@ -15,23 +15,57 @@ import (
 // file.
 type Task struct {
 	state  *State
+	root   string
 	cwd    string
 	chunks []*chunk
+
+	// Folder of the file in the remote folder mapping, see remotePath
+	remoteDir string
+
+	// mu guards etag and url
+	mu sync.Mutex
+
+	// ETag of the object served for the segments
+	etag string
+
+	// Download URL on the CDN, resolved by the API. It is resolved again
+	// when it expires.
+	url string
+
+	// Serializes the resolutions of the download URL
+	resolveMu sync.Mutex
 }
 
-// NewTask creates a new Task, with a fresh internal state.
-func NewTask(state *State, cwd string, segmentnum uint) *Task {
+// NewTask creates a new Task, with a fresh internal state. The file is
+// downloaded into cwd, which is relative to the root directory.
+func NewTask(state *State, root, cwd string, segmentnum uint) *Task {
 	chunks := calculateChunks(state, segmentnum)
 	return &Task{
 		state:  state,
+		root:   root,
 		cwd:    cwd,
 		chunks: chunks,
 	}
 }
 
+// restart discards the downloaded pieces and the resolved URL, so that the
+// file is downloaded from the beginning in the given number of segments.
+func (t *Task) restart(segmentnum uint) {
+	t.state.mu.Lock()
+	t.state.Bitfield.ClearAll()
+	t.state.mu.Unlock()
+
+	t.mu.Lock()
+	t.etag, t.url = "", ""
+	t.mu.Unlock()
+
+	t.chunks = calculateChunks(t.state, segmentnum)
+}
+
 // String implements fmt.Stringer interface for the Task.
-func (t Task) String() string {
-	return fmt.Sprintf("task<name: %q, size: %v, chunks: %v, bitfield: %v>",
+func (t *Task) String() string {
+	return fmt.Sprintf("task<id: %v, name: %q, size: %v, chunks: %v, bitfield: %v>",
+		t.state.DownloadID,
 		trimPath(path.Join(t.cwd, t.state.FileName)),
 		t.state.FileLength,
 		t.chunks,
@@ -96,6 +130,26 @@ func (m *Tasks) Exists(t *Task) bool {
 	return ok
 }
 
+// List returns the active tasks.
+func (m *Tasks) List() []*Task {
+	m.Lock()
+	defer m.Unlock()
+
+	tasks := make([]*Task, 0, len(m.s))
+	for _, t := range m.s {
+		tasks = append(tasks, t)
+	}
+	return tasks
+}
+
+// Len returns the number of active tasks.
+func (m *Tasks) Len() int {
+	m.Lock()
+	defer m.Unlock()
+
+	return len(m.s)
+}
+
 // Empty reports whether there are active tasks.
 func (m *Tasks) Empty() bool {
 	m.Lock()
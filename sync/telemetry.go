@@ -0,0 +1,324 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// telemetry records OpenTelemetry compatible spans and metrics and exports
+// them to an OTLP/HTTP collector with the JSON encoding. A nil *telemetry is
+// valid and records nothing.
+type telemetry struct {
+	endpoint string
+	instance string
+	client   *http.Client
+	start    time.Time
+
+	mu       sync.Mutex
+	spans    []*span
+	counters map[string]int64
+	gauges   map[string]func() int64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+const (
+	telemetryExportInterval = 10 * time.Second
+	telemetryMaxSpans       = 2048
+)
+
+func newTelemetry(endpoint, instance string, transport http.RoundTripper) *telemetry {
+	t := &telemetry{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		instance: instance,
+		client:   &http.Client{Transport: transport, Timeout: 10 * time.Second},
+		start:    time.Now(),
+		counters: make(map[string]int64),
+		gauges:   make(map[string]func() int64),
+		done:     make(chan struct{}),
+	}
+
+	t.wg.Add(1)
+	go t.run()
+	return t
+}
+
+// span is a timed operation, e.g. a poll or a segment download.
+type span struct {
+	t        *telemetry
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	err      string
+}
+
+type spanKey struct{}
+
+// StartSpan starts a new span. If ctx carries a span, the new span becomes
+// its child.
+func (t *telemetry) StartSpan(ctx context.Context, name string) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &span{
+		t:     t,
+		name:  name,
+		start: time.Now(),
+		attrs: make(map[string]interface{}),
+	}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok && parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		_, _ = rand.Read(s.traceID[:])
+	}
+	_, _ = rand.Read(s.spanID[:])
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SetAttr sets an attribute of the span.
+func (s *span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.attrs[key] = value
+}
+
+// End finishes the span and records err, if any.
+func (s *span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	// drop spans if the collector can't keep up
+	if len(s.t.spans) < telemetryMaxSpans {
+		s.t.spans = append(s.t.spans, s)
+	}
+}
+
+// Add increments the counter with the given name.
+func (t *telemetry) Add(name string, n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.counters[name] += n
+	t.mu.Unlock()
+}
+
+// Gauge registers a function which reports the current value of a gauge.
+func (t *telemetry) Gauge(name string, fn func() int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.gauges[name] = fn
+	t.mu.Unlock()
+}
+
+func (t *telemetry) run() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(telemetryExportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.export()
+		case <-t.done:
+			t.export()
+			return
+		}
+	}
+}
+
+// Close exports the remaining spans and metrics and stops the exporter.
+func (t *telemetry) Close() error {
+	if t == nil {
+		return nil
+	}
+	close(t.done)
+	t.wg.Wait()
+	return nil
+}
+
+func (t *telemetry) export() {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	counters := make(map[string]int64, len(t.counters))
+	for k, v := range t.counters {
+		counters[k] = v
+	}
+	gauges := make(map[string]func() int64, len(t.gauges))
+	for k, v := range t.gauges {
+		gauges[k] = v
+	}
+	t.mu.Unlock()
+
+	if len(spans) > 0 {
+		_ = t.post("/v1/traces", t.tracesPayload(spans))
+	}
+	_ = t.post("/v1/metrics", t.metricsPayload(counters, gauges))
+}
+
+func (t *telemetry) post(path string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.client.Post(t.endpoint+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OTLP export failed: %v", resp.Status)
+	}
+	return nil
+}
+
+// The following types mirror the OTLP JSON encoding.
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func otlpAttrs(attrs map[string]interface{}) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]interface{}
+		switch v := v.(type) {
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: value})
+	}
+	return kvs
+}
+
+func (t *telemetry) resource() map[string]interface{} {
+	host, _ := os.Hostname()
+	return map[string]interface{}{
+		"attributes": otlpAttrs(map[string]interface{}{
+			"service.name":        "putio-sync",
+			"service.instance.id": t.instance,
+			"host.name":           host,
+		}),
+	}
+}
+
+func nanos(tm time.Time) string {
+	return strconv.FormatInt(tm.UnixNano(), 10)
+}
+
+func (t *telemetry) tracesPayload(spans []*span) interface{} {
+	var otlpSpans []map[string]interface{}
+	for _, s := range spans {
+		status := map[string]interface{}{"code": 1}
+		if s.err != "" {
+			status = map[string]interface{}{"code": 2, "message": s.err}
+		}
+
+		otlpSpan := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              1,
+			"startTimeUnixNano": nanos(s.start),
+			"endTimeUnixNano":   nanos(s.end),
+			"attributes":        otlpAttrs(s.attrs),
+			"status":            status,
+		}
+		if s.parentID != [8]byte{} {
+			otlpSpan["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": t.resource(),
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "putio-sync"},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+}
+
+func (t *telemetry) metricsPayload(counters map[string]int64, gauges map[string]func() int64) interface{} {
+	now := nanos(time.Now())
+
+	var metrics []map[string]interface{}
+	for name, value := range counters {
+		metrics = append(metrics, map[string]interface{}{
+			"name": name,
+			"sum": map[string]interface{}{
+				"aggregationTemporality": 2, // cumulative
+				"isMonotonic":            true,
+				"dataPoints": []interface{}{map[string]interface{}{
+					"asInt":             strconv.FormatInt(value, 10),
+					"startTimeUnixNano": nanos(t.start),
+					"timeUnixNano":      now,
+				}},
+			},
+		})
+	}
+	for name, fn := range gauges {
+		metrics = append(metrics, map[string]interface{}{
+			"name": name,
+			"gauge": map[string]interface{}{
+				"dataPoints": []interface{}{map[string]interface{}{
+					"asInt":        strconv.FormatInt(fn(), 10),
+					"timeUnixNano": now,
+				}},
+			},
+		})
+	}
+
+	return map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource": t.resource(),
+			"scopeMetrics": []interface{}{map[string]interface{}{
+				"scope":   map[string]interface{}{"name": "putio-sync"},
+				"metrics": metrics,
+			}},
+		}},
+	}
+}
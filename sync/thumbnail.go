@@ -0,0 +1,171 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// thumbnailsDir is the directory of the thumbnail cache, next to the
+// database. Each user has a directory of their own.
+const thumbnailsDir = "thumbnails"
+
+// maxThumbnailSize limits the size of a downloaded thumbnail.
+const maxThumbnailSize = 5 * 1024 * 1024
+
+// maxCachedThumbnails limits the number of cached thumbnails of a user. The
+// oldest ones are removed first.
+const maxCachedThumbnails = 1000
+
+// ErrNoThumbnail is returned for files without a Put.io screenshot.
+const ErrNoThumbnail = Error("file has no thumbnail")
+
+// thumbnailDir returns the directory of the cached thumbnails of the user.
+func (c *Client) thumbnailDir(username string) string {
+	return filepath.Join(filepath.Dir(c.Store.Path()), thumbnailsDir, username)
+}
+
+// removeThumbnails removes the cached thumbnails of the user.
+func (c *Client) removeThumbnails(username string) error {
+	// the directory is never outside of the cache
+	if username == "" || username == "." || username == ".." || filepath.Base(username) != username {
+		return nil
+	}
+	return os.RemoveAll(c.thumbnailDir(username))
+}
+
+// Thumbnail writes the Put.io screenshot of the file to w, fetching it into
+// the cache on first use. The screenshots of the files in the encrypted folder
+// mappings are not cached, a plain thumbnail would reveal their content.
+func (c *Client) Thumbnail(ctx context.Context, fileID int64, w io.Writer) error {
+	state, err := c.Store.State(fileID, c.User.Username)
+	if err != nil {
+		return err
+	}
+	if state.FileIcon == "" {
+		return ErrNoThumbnail
+	}
+
+	if c.encryptedState(state) {
+		return c.fetchThumbnail(ctx, state.FileIcon, w)
+	}
+
+	dir := c.thumbnailDir(c.User.Username)
+	path := filepath.Join(dir, strconv.FormatInt(fileID, 10)+".jpg")
+	if !exists(path) {
+		err = os.MkdirAll(dir, 0700)
+		if err != nil {
+			return err
+		}
+		// the cache never has a broken thumbnail of a failed request
+		err = writeFile(path, func(w io.Writer) error {
+			return c.fetchThumbnail(ctx, state.FileIcon, w)
+		})
+		if err != nil {
+			return err
+		}
+		pruneThumbnails(dir)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// encryptedState reports whether the file is in an encrypted folder mapping.
+func (c *Client) encryptedState(state *State) bool {
+	if strings.HasSuffix(state.LocalPath, EncryptedExtension) {
+		return true
+	}
+	m, ok := c.Config.mapping(c.Config.localRoot(state.LocalPath))
+	return ok && m.Encrypt
+}
+
+// pruneThumbnails removes the oldest thumbnails in the directory above
+// maxCachedThumbnails.
+func pruneThumbnails(dir string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) <= maxCachedThumbnails {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, fi := range files[:len(files)-maxCachedThumbnails] {
+		os.Remove(filepath.Join(dir, fi.Name()))
+	}
+}
+
+// fetchThumbnail downloads the image at url to w.
+func (c *Client) fetchThumbnail(ctx context.Context, url string, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+
+	client := &http.Client{Transport: c.transport}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("thumbnail request failed: %v", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "image/") {
+		return fmt.Errorf("thumbnail is not an image: %v", ct)
+	}
+
+	_, err = io.Copy(w, io.LimitReader(resp.Body, maxThumbnailSize))
+	return err
+}
+
+// thumbnailCompleted stores the thumbnail next to the downloaded video as
+// "<name>-thumb.jpg", which media centers pick up. The thumbnails of the
+// encrypted folder mappings are encrypted too.
+func (c *Client) thumbnailCompleted(ctx context.Context, t *Task) error {
+	var thumbnail bytes.Buffer
+	err := c.Thumbnail(ctx, t.state.FileID, &thumbnail)
+	if err != nil {
+		return err
+	}
+
+	path := t.state.LocalPath
+	m, ok := c.Config.mapping(t.root)
+	encrypted := ok && m.Encrypt
+	if encrypted {
+		path = strings.TrimSuffix(path, EncryptedExtension)
+	}
+	dst := strings.TrimSuffix(path, filepath.Ext(path)) + "-thumb.jpg"
+	if !encrypted {
+		return writeFile(dst, func(w io.Writer) error {
+			_, err := thumbnail.WriteTo(w)
+			return err
+		})
+	}
+
+	// a plain thumbnail would reveal the content of the encrypted video
+	key, err := c.encryptionKey()
+	if err != nil {
+		return err
+	}
+	return writeFile(dst+EncryptedExtension, func(w io.Writer) error {
+		return encrypt(key, w, &thumbnail)
+	})
+}
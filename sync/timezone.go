@@ -0,0 +1,29 @@
+package sync
+
+import (
+	"fmt"
+	"time"
+)
+
+// ValidateTimeZone checks that name is an IANA time zone, e.g.
+// "Europe/Istanbul". An empty name is the local time zone of the server.
+func ValidateTimeZone(name string) error {
+	_, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("invalid time zone %q", name)
+	}
+	return nil
+}
+
+// Location returns the time zone of the account. The local time zone of the
+// server is returned if TimeZone is empty or is not known on this machine.
+func (c *Config) Location() *time.Location {
+	if c.TimeZone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
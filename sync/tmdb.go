@@ -0,0 +1,152 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TMDb API endpoints
+const (
+	tmdbSearchURL = "https://api.themoviedb.org/3/search/movie"
+	tmdbPosterURL = "https://image.tmdb.org/t/p/w342"
+)
+
+// MovieInfo is the TMDb entry matched to a downloaded movie.
+type MovieInfo struct {
+	TMDbID    int64  `json:"tmdb_id"`
+	Title     string `json:"title"`
+	Year      int    `json:"year"`
+	Overview  string `json:"overview"`
+	PosterURL string `json:"poster_url"`
+}
+
+var (
+	// movieYear matches the release year in a file name
+	movieYear = regexp.MustCompile(`\b(19|20)\d\d\b`)
+
+	// episodeTag matches the season and episode tags of series, which are
+	// not movies
+	episodeTag = regexp.MustCompile(`(?i)\bS\d{1,2}E\d{1,3}\b|\b\d{1,2}x\d{2}\b`)
+)
+
+// parseMovieName guesses the title and the year of a movie from its file
+// name, e.g. "The.Matrix.1999.1080p.BluRay.mkv". ok is false if the name
+// doesn't look like a movie.
+func parseMovieName(name string) (title string, year int, ok bool) {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = strings.NewReplacer(".", " ", "_", " ").Replace(name)
+	if episodeTag.MatchString(name) {
+		return "", 0, false
+	}
+
+	// the last year-like number is the release year, titles may have one,
+	// e.g. "Blade Runner 2049 2017"
+	locs := movieYear.FindAllStringIndex(name, -1)
+	if len(locs) == 0 {
+		return "", 0, false
+	}
+	loc := locs[len(locs)-1]
+	if loc[0] == 0 {
+		return "", 0, false
+	}
+
+	year, _ = strconv.Atoi(name[loc[0]:loc[1]])
+	title = strings.Trim(name[:loc[0]], " -([")
+	return title, year, title != ""
+}
+
+// matchCompleted looks up the downloaded movie on TMDb and stores the match
+// in the state. Files which don't look like movies or don't match are left
+// as they are.
+func (c *Client) matchCompleted(ctx context.Context, t *Task) error {
+	title, year, ok := parseMovieName(t.state.FileName)
+	if !ok {
+		return nil
+	}
+
+	movie, err := c.searchMovie(ctx, c.Config.TMDbAPIKey, title, year)
+	if err != nil {
+		return err
+	}
+	if movie == nil {
+		c.Debugf("No TMDb match for %v (%v)\n", title, year)
+		return nil
+	}
+
+	c.Debugf("Matched %v to %v (%v)\n", t.state.FileName, movie.Title, movie.Year)
+	t.state.Movie = movie
+	return nil
+}
+
+// searchMovie returns the best TMDb match for the title and year, or nil.
+func (c *Client) searchMovie(ctx context.Context, apiKey, title string, year int) (*MovieInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	q := url.Values{}
+	q.Set("api_key", apiKey)
+	q.Set("query", title)
+	q.Set("year", strconv.Itoa(year))
+
+	req, err := http.NewRequest("GET", tmdbSearchURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+
+	client := &http.Client{Transport: c.transport}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		// the URL carries the API key, which must not reach the logs and
+		// the step statuses
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("TMDb search failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("TMDb search failed: %v", resp.Status)
+	}
+
+	var result struct {
+		Results []struct {
+			ID          int64  `json:"id"`
+			Title       string `json:"title"`
+			ReleaseDate string `json:"release_date"`
+			Overview    string `json:"overview"`
+			PosterPath  string `json:"poster_path"`
+		} `json:"results"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Results) == 0 {
+		return nil, nil
+	}
+
+	// results are ordered by relevance
+	r := result.Results[0]
+	movie := &MovieInfo{
+		TMDbID:   r.ID,
+		Title:    r.Title,
+		Overview: r.Overview,
+	}
+	if len(r.ReleaseDate) >= 4 {
+		movie.Year, _ = strconv.Atoi(r.ReleaseDate[:4])
+	}
+	if r.PosterPath != "" {
+		movie.PosterURL = tmdbPosterURL + r.PosterPath
+	}
+	return movie, nil
+}
@@ -0,0 +1,321 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igungor/go-putio/putio"
+	"github.com/rjeczalik/notify"
+)
+
+// Bounds of the backoff between the submissions of a watched torrent while
+// Put.io is unreachable.
+const (
+	torrentRetryMin = 30 * time.Second
+	torrentRetryMax = time.Hour
+)
+
+// WatchedTorrent is a torrent file found in the watched torrents folder. It is
+// queued persistently until Put.io accepts it, so that the torrents added
+// while Put.io is unreachable are not lost. It is encoded as Gob and stored to
+// a persistent storage.
+type WatchedTorrent struct {
+	// Absolute path of the torrent file
+	Path string `json:"path"`
+
+	// Size and modification time of the file when it is queued
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+
+	AddedAt     time.Time `json:"added_at"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	Error       string    `json:"error"`
+
+	// Put.io refused the torrent, it isn't retried until the file changes
+	Rejected bool `json:"rejected"`
+}
+
+// backoff returns the delay before the next attempt.
+func (t *WatchedTorrent) backoff() time.Duration {
+	d := torrentRetryMin
+	for i := 1; i < t.Attempts && d < torrentRetryMax; i++ {
+		d *= 2
+	}
+	if d > torrentRetryMax {
+		d = torrentRetryMax
+	}
+	return d
+}
+
+// WatchTorrentFolder submits the torrent files dropped into the user's
+// preferred TorrentsFolder to Put.io until ctx is cancelled. The torrents are
+// queued first and retried with backoff if the submission fails.
+func (c *Client) WatchTorrentFolder(ctx context.Context) {
+	defer c.RecoverCrash()
+
+	if !c.Config.WatchTorrentsFolder {
+		return
+	}
+
+	dir := c.Config.TorrentsFolder
+	if dir == "" {
+		c.Println("No torrent folder is given")
+		return
+	}
+
+	// watch for create and rename events, since moving from one folder to
+	// another is simpy a 'rename' event.
+	err := notify.Watch(dir, c.torrentsCh, notify.Create, notify.Rename)
+	if err != nil {
+		c.Errorf("Error watching torrent folder: %v\n", err)
+		return
+	}
+	defer notify.Stop(c.torrentsCh)
+
+	// Perform an initial scan on the directory
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(info.Name(), ".torrent") {
+			return nil
+		}
+
+		c.Debugf("found '%v' on initial scan\n", info.Name())
+		c.queueTorrent(path, info)
+		return nil
+	})
+	if err != nil {
+		c.Errorf("Error walking the torrent folder: %v\n", err)
+	}
+
+	timer := time.NewTimer(c.submitTorrents(ctx))
+	defer timer.Stop()
+
+	for {
+		select {
+		case event := <-c.torrentsCh:
+			path := event.Path()
+
+			if !strings.HasSuffix(path, ".torrent") {
+				continue
+			}
+
+			// if a file is renamed, it might have been moved from someplace
+			// else. so check if the file exists, and skip the simple
+			// 'renaming' events.
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+
+			c.Debugf("New event: %v, %v\n", event.Event(), path)
+			c.queueTorrent(path, info)
+
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(c.submitTorrents(ctx))
+		case <-timer.C:
+			timer.Reset(c.submitTorrents(ctx))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// queueTorrent adds the torrent file to the queue, unless it is queued
+// already.
+func (c *Client) queueTorrent(path string, info os.FileInfo) {
+	path = filepath.Clean(path)
+
+	t, err := c.Store.WatchedTorrent(path, c.User.Username)
+	if err == nil && t.Size == info.Size() && t.ModTime.Equal(info.ModTime()) {
+		return
+	}
+
+	now := time.Now().UTC()
+	t = &WatchedTorrent{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		AddedAt: now,
+		// the file might be still being written
+		NextAttempt: now.Add(uploadSettleTime),
+	}
+	err = c.Store.SaveWatchedTorrent(t, c.User.Username)
+	if err != nil {
+		c.Errorf("Error queueing torrent %v: %v\n", path, err)
+	}
+}
+
+// submitTorrents submits the queued torrents that are due. It returns the
+// delay until the next one is due.
+func (c *Client) submitTorrents(ctx context.Context) time.Duration {
+	torrents, err := c.Store.WatchedTorrents(c.User.Username)
+	if err != nil {
+		c.Errorf("Error retrieving watched torrents: %v\n", err)
+		return torrentRetryMin
+	}
+
+	next := torrentRetryMax
+	due := func(d time.Duration) {
+		if d < next {
+			next = d
+		}
+	}
+
+	for _, t := range torrents {
+		if ctx.Err() != nil {
+			break
+		}
+		if t.Rejected {
+			continue
+		}
+
+		now := time.Now().UTC()
+		if wait := t.NextAttempt.Sub(now); wait > 0 {
+			due(wait)
+			continue
+		}
+
+		info, err := os.Stat(t.Path)
+		if err != nil {
+			c.Debugf("Torrent %v is removed, dropping it from the queue\n", t.Path)
+			err = c.Store.DeleteWatchedTorrent(t.Path, c.User.Username)
+			if err != nil {
+				c.Errorf("Error removing watched torrent %v: %v\n", t.Path, err)
+			}
+			continue
+		}
+
+		if info.Size() != t.Size || !info.ModTime().Equal(t.ModTime) {
+			t.Size = info.Size()
+			t.ModTime = info.ModTime()
+			t.NextAttempt = now.Add(uploadSettleTime)
+			due(uploadSettleTime)
+		} else if err = c.submitTorrent(ctx, t.Path); err == nil {
+			err = c.Store.DeleteWatchedTorrent(t.Path, c.User.Username)
+			if err != nil {
+				c.Errorf("Error removing watched torrent %v: %v\n", t.Path, err)
+			}
+			err = os.Remove(t.Path)
+			if err != nil {
+				c.Errorf("Error removing torrent file %v: %v\n", t.Path, err)
+			}
+			c.Printf("Torrent %v is submitted\n", t.Path)
+			continue
+		} else if ctx.Err() != nil {
+			break
+		} else {
+			t.Attempts++
+			t.Error = err.Error()
+			if torrentRejected(err) {
+				t.Rejected = true
+				c.Errorf("Put.io rejected torrent %v: %v\n", t.Path, err)
+			} else {
+				t.NextAttempt = now.Add(t.backoff())
+				due(t.backoff())
+				c.Errorf("Error submitting torrent %v, retrying in %v: %v\n", t.Path, t.backoff(), err)
+			}
+		}
+
+		err = c.Store.SaveWatchedTorrent(t, c.User.Username)
+		if err != nil {
+			c.Errorf("Error saving watched torrent %v: %v\n", t.Path, err)
+		}
+	}
+	return next
+}
+
+// submitTorrent uploads the torrent file to start a transfer.
+func (c *Client) submitTorrent(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Error opening file: %v", err)
+	}
+	defer f.Close()
+
+	u, err := c.UploadTorrent(ctx, f, filepath.Base(path), -1)
+	if err != nil {
+		return err
+	}
+	if u.Transfer == nil {
+		return fmt.Errorf("API hasn't started the transfer for some reason")
+	}
+	return nil
+}
+
+// UploadTorrent uploads a torrent file to start a transfer in the parent
+// folder, or in the preferred download folder of the user if parent is
+// negative. Put.io calls back when the transfer completes if a callback URL
+// is configured, which FilesService.Upload doesn't support.
+func (c *Client) UploadTorrent(ctx context.Context, r io.Reader, filename string, parent int64) (putio.Upload, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	if parent >= 0 {
+		err := mw.WriteField("parent_id", strconv.FormatInt(parent, 10))
+		if err != nil {
+			return putio.Upload{}, err
+		}
+	}
+	if callback := c.callbackURL(); callback != "" {
+		err := mw.WriteField("callback_url", callback)
+		if err != nil {
+			return putio.Upload{}, err
+		}
+	}
+
+	formfile, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		return putio.Upload{}, err
+	}
+	_, err = io.Copy(formfile, r)
+	if err != nil {
+		return putio.Upload{}, err
+	}
+	err = mw.Close()
+	if err != nil {
+		return putio.Upload{}, err
+	}
+
+	req, err := c.C.NewRequest(ctx, "POST", "/v2/files/upload", &buf)
+	if err != nil {
+		return putio.Upload{}, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var response struct {
+		putio.Upload
+	}
+	_, err = c.C.Do(req, &response)
+	if err != nil {
+		return putio.Upload{}, err
+	}
+	return response.Upload, nil
+}
+
+// torrentRejected reports whether Put.io refused the torrent itself, so that
+// submitting it again is pointless.
+func torrentRejected(err error) bool {
+	switch code := apiStatusCode(err); code {
+	case http.StatusUnauthorized, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	default:
+		return code >= 400 && code < 500
+	}
+}
@@ -0,0 +1,233 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igungor/go-putio/putio"
+	"golang.org/x/oauth2"
+)
+
+// trace file is rotated when it exceeds traceMaxSize. traceBackups rotated
+// files are kept.
+const (
+	traceFileName = "putio-sync.trace"
+	traceMaxSize  = 10 * 1024 * 1024
+	traceBackups  = 3
+)
+
+// redacted replaces secrets in trace logs.
+const redacted = "REDACTED"
+
+// tracer writes API requests and segment timings to a rotating trace file.
+type tracer struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	size int64
+
+	// Reports whether the header carries a secret, besides the
+	// credentials of the API
+	secretHeader func(name string) bool
+}
+
+func newTracer(dir string) (*tracer, error) {
+	t := &tracer{path: filepath.Join(dir, traceFileName)}
+	return t, t.open()
+}
+
+func (t *tracer) open() error {
+	f, err := os.OpenFile(t.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	t.f = f
+	t.size = fi.Size()
+	return nil
+}
+
+// rotate shifts the trace files by one, dropping the oldest one.
+func (t *tracer) rotate() error {
+	err := t.f.Close()
+	if err != nil {
+		return err
+	}
+
+	for i := traceBackups - 1; i > 0; i-- {
+		_ = os.Rename(fmt.Sprintf("%v.%v", t.path, i), fmt.Sprintf("%v.%v", t.path, i+1))
+	}
+	err = os.Rename(t.path, t.path+".1")
+	if err != nil {
+		return err
+	}
+	return t.open()
+}
+
+// Printf writes a timestamped line to the trace file.
+func (t *tracer) Printf(format string, v ...interface{}) {
+	if t == nil {
+		return
+	}
+
+	line := time.Now().Format("2006/01/02 15:04:05.000000 ") + fmt.Sprintf(format, v...)
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.f == nil {
+		return
+	}
+
+	if t.size+int64(len(line)) > traceMaxSize {
+		if err := t.rotate(); err != nil {
+			t.f = nil
+			return
+		}
+	}
+
+	n, _ := t.f.WriteString(line)
+	t.size += int64(n)
+}
+
+// Close closes the trace file.
+func (t *tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.f == nil {
+		return nil
+	}
+	return t.f.Close()
+}
+
+// traceTransport logs every request passing through it, with credentials
+// redacted.
+type traceTransport struct {
+	transport http.RoundTripper
+	tracer    *tracer
+}
+
+var _ http.RoundTripper = &traceTransport{}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.tracer.Printf("--> %v %v %v", req.Method, redactURL(req.URL), redactHeader(req.Header, t.tracer.secretHeader))
+
+	start := time.Now()
+	resp, err := t.transport.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		t.tracer.Printf("<-- %v %v error: %v (%v)", req.Method, redactURL(req.URL), err, elapsed)
+		return resp, err
+	}
+
+	t.tracer.Printf("<-- %v %v %v %v (%v)", req.Method, redactURL(req.URL), resp.Status, redactHeader(resp.Header, t.tracer.secretHeader), elapsed)
+	return resp, err
+}
+
+// redactURL hides the credentials in the URL.
+func redactURL(u *url.URL) string {
+	ru := *u
+	if ru.User != nil {
+		ru.User = url.User(redacted)
+	}
+
+	q := ru.Query()
+	for key := range q {
+		k := strings.ToLower(key)
+		if strings.Contains(k, "token") || strings.Contains(k, "key") || strings.Contains(k, "secret") {
+			q.Set(key, redacted)
+		}
+	}
+	ru.RawQuery = q.Encode()
+	return ru.String()
+}
+
+// redactHeader formats the header with the credentials and the headers
+// reported by secret hidden. secret may be nil.
+func redactHeader(h http.Header, secret func(name string) bool) string {
+	var keys []string
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		value := strings.Join(h[key], ",")
+		switch http.CanonicalHeaderKey(key) {
+		case "Authorization", "Cookie", "Set-Cookie":
+			value = redacted
+		default:
+			if secret != nil && secret(key) {
+				value = redacted
+			}
+		}
+		parts = append(parts, key+"="+value)
+	}
+	return "{" + strings.Join(parts, " ") + "}"
+}
+
+// newPutioClient creates a Put.io API client for the given token. If the
+// tracer is not nil, all API requests are traced. The requests are made with
+// transport, or the default one if it is nil.
+func newPutioClient(token string, tr *tracer, transport http.RoundTripper) *putio.Client {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if tr != nil {
+		transport = &traceTransport{transport: transport, tracer: tr}
+	}
+	ctx := context.WithValue(oauth2.NoContext, oauth2.HTTPClient, &http.Client{
+		Transport: transport,
+	})
+
+	oauthClient := oauth2.NewClient(
+		ctx,
+		oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: token},
+		),
+	)
+	client := putio.NewClient(oauthClient)
+	client.UserAgent = defaultUserAgent
+	return client
+}
+
+// EnableTrace starts logging every API request and per-segment timings to a
+// rotating trace file next to the database.
+func (c *Client) EnableTrace() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tr, err := newTracer(filepath.Dir(c.Store.Path()))
+	if err != nil {
+		return err
+	}
+
+	// the custom headers of the downloads often carry a session
+	tr.secretHeader = func(name string) bool { return c.Config.customHeader(name) }
+	c.tracer = tr
+	c.C = newPutioClient(c.Config.OAuth2Token, tr, c.transport)
+	c.Printf("Tracing to %v\n", tr.path)
+	return nil
+}
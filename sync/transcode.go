@@ -0,0 +1,222 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TranscodeProfile describes how ffmpeg converts the completed videos.
+type TranscodeProfile struct {
+	// Extensions of the files to convert, e.g. ".mkv"
+	Extensions []string `json:"extensions"`
+
+	// Extension of the converted file, e.g. ".mp4"
+	Output string `json:"output"`
+
+	// ffmpeg arguments between the input and the output file
+	Args []string `json:"args"`
+
+	// Keep the original file next to the converted one. The converted file
+	// is named name.transcoded.ext if it has the extension of the original.
+	KeepOriginal bool `json:"keep-original"`
+}
+
+// TranscodeProfiles are the built-in transcode profiles.
+var TranscodeProfiles = map[string]TranscodeProfile{
+	// change the container only, which is fast and lossless
+	"remux-mp4": {
+		Extensions: []string{".mkv", ".mov", ".ts"},
+		Output:     ".mp4",
+		Args:       []string{"-map", "0:v", "-map", "0:a?", "-c", "copy", "-movflags", "+faststart"},
+	},
+	// re-encode to the most compatible codecs
+	"h264-mp4": {
+		Extensions: []string{".mkv", ".avi", ".wmv", ".mov", ".flv", ".webm"},
+		Output:     ".mp4",
+		Args: []string{
+			"-map", "0:v", "-map", "0:a?",
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "22",
+			"-c:a", "aac", "-b:a", "160k",
+			"-movflags", "+faststart",
+		},
+	},
+}
+
+// transcodeProgressInterval is the interval of saving the transcode progress.
+const transcodeProgressInterval = 5 * time.Second
+
+func (p TranscodeProfile) matches(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range p.Extensions {
+		if strings.ToLower(e) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTranscodeProfile checks that the profile is either a built-in or a
+// custom profile of the configuration.
+func ValidateTranscodeProfile(name string, custom map[string]TranscodeProfile) error {
+	if name == "" {
+		return nil
+	}
+
+	p, ok := custom[name]
+	if !ok {
+		p, ok = TranscodeProfiles[name]
+	}
+	if !ok {
+		return fmt.Errorf("unknown transcode profile %q", name)
+	}
+	if !strings.HasPrefix(p.Output, ".") {
+		return fmt.Errorf("transcode profile %q: output must be an extension", name)
+	}
+	return nil
+}
+
+// transcodeProfile returns the configured transcode profile. Custom profiles
+// override the built-in ones.
+func (c *Config) transcodeProfile() (TranscodeProfile, bool) {
+	if c.TranscodeProfile == "" {
+		return TranscodeProfile{}, false
+	}
+	if p, ok := c.CustomTranscodeProfiles[c.TranscodeProfile]; ok {
+		return p, true
+	}
+	p, ok := TranscodeProfiles[c.TranscodeProfile]
+	return p, ok
+}
+
+const errTranscodedExists = Error("transcoded file already exists")
+
+// ffmpegDuration matches the input duration in the output of ffmpeg.
+var ffmpegDuration = regexp.MustCompile(`Duration: (\d+):(\d+):(\d+(?:\.\d+)?)`)
+
+// transcodeCompleted converts the downloaded video with ffmpeg and replaces
+// it with the converted file. The progress is recorded in the step status.
+func (c *Client) transcodeCompleted(ctx context.Context, t *Task) error {
+	p, _ := c.Config.transcodeProfile()
+
+	ffmpeg := c.Config.FFmpegPath
+	if ffmpeg == "" {
+		ffmpeg = "ffmpeg"
+	}
+	ffmpeg, err := exec.LookPath(ffmpeg)
+	if err != nil {
+		return fmt.Errorf("ffmpeg not found: %v", err)
+	}
+
+	src := t.state.LocalPath
+	dst := strings.TrimSuffix(src, filepath.Ext(src)) + p.Output
+	if dst == src && p.KeepOriginal {
+		dst = strings.TrimSuffix(src, filepath.Ext(src)) + ".transcoded" + p.Output
+	}
+	if dst != src && exists(dst) {
+		return errTranscodedExists
+	}
+	// ffmpeg guesses the format from the extension, keep it
+	tmp := filepath.Join(filepath.Dir(dst), ".transcoding-"+filepath.Base(dst))
+	defer os.Remove(tmp)
+
+	args := []string{"-nostdin", "-y", "-hide_banner", "-i", src}
+	args = append(args, p.Args...)
+	args = append(args, "-progress", "pipe:1", "-nostats", tmp)
+
+	cmd := exec.CommandContext(ctx, ffmpeg, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	c.Debugf("Transcoding %v to %v\n", src, dst)
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	// duration in microseconds and the last lines of the log
+	var duration int64
+	lastLines := make(chan string, 1)
+	go func() {
+		var last []string
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if m := ffmpegDuration.FindStringSubmatch(line); m != nil && atomic.LoadInt64(&duration) == 0 {
+				h, _ := strconv.ParseFloat(m[1], 64)
+				min, _ := strconv.ParseFloat(m[2], 64)
+				sec, _ := strconv.ParseFloat(m[3], 64)
+				atomic.StoreInt64(&duration, int64((h*3600+min*60+sec)*1e6))
+			}
+			last = append(last, line)
+			if len(last) > 3 {
+				last = last[1:]
+			}
+		}
+		lastLines <- strings.Join(last, "\n")
+	}()
+
+	c.readTranscodeProgress(t, stdout, &duration)
+
+	err = cmd.Wait()
+	log := <-lastLines
+	if err != nil {
+		return fmt.Errorf("ffmpeg failed: %v: %v", err, log)
+	}
+
+	// the original is removed only once the output is in place, so that
+	// one of them is always kept. Only the original itself is replaced.
+	err = os.Rename(tmp, dst)
+	if err != nil {
+		return err
+	}
+	t.state.LocalPath = dst
+
+	if !p.KeepOriginal && dst != src {
+		err = os.Remove(src)
+		if err != nil {
+			c.Errorf("Error removing the original of %v: %v\n", dst, err)
+		}
+	}
+	return nil
+}
+
+// readTranscodeProgress reads the progress reports of ffmpeg.
+func (c *Client) readTranscodeProgress(t *Task, r io.Reader, duration *int64) {
+	var saved time.Time
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "out_time_us=") {
+			continue
+		}
+
+		us, err := strconv.ParseInt(strings.TrimPrefix(line, "out_time_us="), 10, 64)
+		total := atomic.LoadInt64(duration)
+		if err != nil || total <= 0 || time.Since(saved) < transcodeProgressInterval {
+			continue
+		}
+
+		progress := float64(us) / float64(total)
+		if progress > 1 {
+			progress = 1
+		}
+		c.stepProgress(t, progress)
+		saved = time.Now()
+	}
+}
@@ -0,0 +1,131 @@
+package sync
+
+import (
+	"context"
+	"time"
+)
+
+// tuneInterval is the interval between two concurrency adjustments. It should
+// be long enough for the throughput to settle after an adjustment.
+const tuneInterval = 30 * time.Second
+
+// autoTune periodically adjusts the parallel files and segments per file to find
+// the concurrency which maximizes the throughput. It keeps stepping in the same
+// direction while the throughput improves and reverses the direction once it
+// degrades. No adjustment is made while the bandwidth cap is nearly reached.
+func (c *Client) autoTune(ctx context.Context) {
+	defer c.RecoverCrash()
+
+	var lastRate float64
+	step := 1
+
+	ticker := time.NewTicker(tuneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			c.Debugf("Auto-tuner got cancelled\n")
+			return
+		}
+
+		// nothing to measure, or the memory guard holds the concurrency
+		if c.Tasks.Empty() || c.isDegraded() {
+			lastRate = 0
+			continue
+		}
+
+		rate := c.meter.Rate()
+		if limit := c.limiter.Rate(); limit > 0 && rate >= 0.9*float64(limit) {
+			lastRate = rate
+			continue
+		}
+
+		switch {
+		case lastRate == 0:
+			// first measurement, probe upwards
+		case rate > lastRate*1.05:
+			// keep going
+		case rate < lastRate*0.95:
+			step = -step
+		default:
+			lastRate = rate
+			continue
+		}
+		lastRate = rate
+
+		err := c.tune(step)
+		if err != nil {
+			c.Debugf("Auto-tuner got cancelled: %v\n", err)
+			return
+		}
+	}
+}
+
+// tune adds step to the number of parallel files and segments per file,
+// within their limits. Segment changes take effect on new tasks. The tuned
+// values are kept apart from the config, so they are not saved over the
+// settings of the user.
+func (c *Client) tune(step int) error {
+	files := int(c.parallelFiles()) + step
+	if files >= 1 && files <= limitParallelFiles {
+		c.Debugf("Auto-tuner sets max parallel files to %v\n", files)
+		c.mu.Lock()
+		c.tunedFiles = uint(files)
+		c.mu.Unlock()
+		err := c.AdjustConcurreny(step)
+		if err != nil {
+			return err
+		}
+	}
+
+	segments := int(c.segmentsPerFile()) + step
+	if segments >= 1 && segments <= limitSegmentsPerFile {
+		c.Debugf("Auto-tuner sets segments per file to %v\n", segments)
+		c.mu.Lock()
+		c.tunedSegments = uint(segments)
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// parallelFiles returns the number of files downloaded at the same time.
+func (c *Client) parallelFiles() uint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tunedFiles > 0 {
+		return c.tunedFiles
+	}
+	return c.Config.MaxParallelFiles
+}
+
+// segmentsPerFile returns the number of connections per file.
+func (c *Client) segmentsPerFile() uint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tunedSegments > 0 {
+		return c.tunedSegments
+	}
+	return c.Config.SegmentsPerFile
+}
+
+// SetMaxParallelFiles sets the number of files downloaded at the same time.
+// The auto-tuner continues from it.
+func (c *Client) SetMaxParallelFiles(n uint) error {
+	old := c.parallelFiles()
+	c.mu.Lock()
+	c.Config.MaxParallelFiles = n
+	c.tunedFiles = 0
+	c.mu.Unlock()
+	return c.AdjustConcurreny(int(n) - int(old))
+}
+
+// SetSegmentsPerFile sets the number of connections per file. The auto-tuner
+// continues from it.
+func (c *Client) SetSegmentsPerFile(n uint) {
+	c.mu.Lock()
+	c.Config.SegmentsPerFile = n
+	c.tunedSegments = 0
+	c.mu.Unlock()
+}
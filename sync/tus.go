@@ -0,0 +1,176 @@
+package sync
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tusEndpoint is the resumable upload endpoint of Put.io, speaking the tus
+// protocol.
+const tusEndpoint = "https://upload.put.io/files/"
+
+const tusVersion = "1.0.0"
+
+// tusChunkSize is the size of the chunks sent in a request. The upload offset
+// is checkpointed to the store after each chunk.
+const tusChunkSize = 16 * 1024 * 1024
+
+// tusClient returns the HTTP client for the upload requests.
+func (c *Client) tusClient() *http.Client {
+	client := &http.Client{Transport: c.transport, Timeout: 10 * time.Minute}
+	if c.tracer != nil {
+		client.Transport = &traceTransport{transport: c.transport, tracer: c.tracer}
+	}
+	return client
+}
+
+func (c *Client) tusRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Authorization", "token "+c.Config.OAuth2Token)
+	req.Header.Set("User-Agent", defaultUserAgent)
+	return req, nil
+}
+
+// tusCreate creates a new upload on the server and returns its URL.
+func (c *Client) tusCreate(ctx context.Context, u *Upload) (string, error) {
+	req, err := c.tusRequest(ctx, "POST", tusEndpoint, nil)
+	if err != nil {
+		return "", err
+	}
+
+	meta := []string{
+		"name " + base64.StdEncoding.EncodeToString([]byte(filepath.Base(u.Path))),
+		"parent_id " + base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(c.Config.UploadTo, 10))),
+		"no-torrent " + base64.StdEncoding.EncodeToString([]byte("true")),
+	}
+	req.Header.Set("Upload-Length", strconv.FormatInt(u.Size, 10))
+	req.Header.Set("Upload-Metadata", strings.Join(meta, ","))
+
+	resp, err := c.tusClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("creating upload failed: %v", resp.Status)
+	}
+
+	loc, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return "", err
+	}
+	return loc.String(), nil
+}
+
+// tusOffset asks the server how many bytes of the upload are received.
+func (c *Client) tusOffset(ctx context.Context, url string) (int64, error) {
+	req, err := c.tusRequest(ctx, "HEAD", url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.tusClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+	case http.StatusNotFound, http.StatusGone:
+		return 0, errUploadExpired
+	default:
+		return 0, fmt.Errorf("querying upload offset failed: %v", resp.Status)
+	}
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// errUploadExpired is returned when the server has dropped an unfinished
+// upload.
+const errUploadExpired = Error("upload expired")
+
+// tusUpload uploads the file in chunks, resuming from the checkpointed
+// upload URL if there is one. The offset is saved to the store after every
+// chunk, so that the upload survives restarts.
+func (c *Client) tusUpload(ctx context.Context, u *Upload) error {
+	f, err := os.Open(u.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if u.UploadURL != "" {
+		u.Offset, err = c.tusOffset(ctx, u.UploadURL)
+		if err == errUploadExpired {
+			c.Debugf("Upload of %v expired, starting over\n", u.Path)
+			u.UploadURL = ""
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if u.UploadURL == "" {
+		u.UploadURL, err = c.tusCreate(ctx, u)
+		if err != nil {
+			return err
+		}
+		u.Offset = 0
+		err = c.Store.SaveUpload(u, c.User.Username)
+		if err != nil {
+			return err
+		}
+	}
+
+	for u.Offset < u.Size {
+		n := u.Size - u.Offset
+		if n > tusChunkSize {
+			n = tusChunkSize
+		}
+
+		req, err := c.tusRequest(ctx, "PATCH", u.UploadURL, io.NewSectionReader(f, u.Offset, n))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = n
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+
+		resp, err := c.tusClient().Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("uploading chunk failed: %v", resp.Status)
+		}
+
+		u.Offset, err = strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			return err
+		}
+		if id, err := strconv.ParseInt(resp.Header.Get("Putio-File-Id"), 10, 64); err == nil {
+			u.FileID = id
+		}
+
+		err = c.Store.SaveUpload(u, c.User.Username)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
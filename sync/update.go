@@ -0,0 +1,464 @@
+package sync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of putio-sync, set at build time with
+// -ldflags "-X github.com/putdotio/putio-sync/sync.Version=v1.2.3".
+var Version = "dev"
+
+// UpdatePublicKey is the base64 encoded Ed25519 public key which signs the
+// release manifests, set at build time like Version. Builds without it
+// refuse to update themselves.
+var UpdatePublicKey = ""
+
+// Update channels
+const (
+	// Stable releases only
+	UpdateStable = "stable"
+
+	// Pre-releases too
+	UpdateBeta = "beta"
+)
+
+const (
+	releasesURL = "https://api.github.com/repos/putdotio/putio-sync/releases"
+
+	// updateCheckInterval is the interval of checking for updates with
+	// AutoUpdate.
+	updateCheckInterval = 6 * time.Hour
+
+	// oldBinarySuffix is appended to the replaced binary, which can't be
+	// removed while it is running on Windows.
+	oldBinarySuffix = ".old"
+)
+
+// Release is a GitHub release of putio-sync.
+type Release struct {
+	Version    string    `json:"tag_name"`
+	Prerelease bool      `json:"prerelease"`
+	Draft      bool      `json:"draft"`
+	URL        string    `json:"html_url"`
+	Published  time.Time `json:"published_at"`
+	Assets     []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+		Size int64  `json:"size"`
+	} `json:"assets"`
+
+	// Update channel the release is checked on
+	channel string
+}
+
+// updateManifest describes a release binary. The manifest is signed instead
+// of the binary, so that the signature covers the version, the channel and
+// the platform too, and an older signed binary can't be served as an update.
+type updateManifest struct {
+	Version  string `json:"version"`
+	Channel  string `json:"channel"`
+	Platform string `json:"platform"`
+
+	// Hex encoded SHA-256 of the binary
+	SHA256 string `json:"sha256"`
+}
+
+// verify checks the manifest against the release and the running binary.
+func (m *updateManifest) verify(r *Release) error {
+	if m.Version != r.Version {
+		return fmt.Errorf("manifest is of version %v, not %v", m.Version, r.Version)
+	}
+	if m.Platform != runtime.GOOS+"-"+runtime.GOARCH {
+		return fmt.Errorf("manifest is for %v", m.Platform)
+	}
+	if m.Channel != UpdateStable && (m.Channel != UpdateBeta || r.channel != UpdateBeta) {
+		return fmt.Errorf("release is on the %q channel", m.Channel)
+	}
+
+	current, ok := parseVersion(Version)
+	if !ok {
+		return fmt.Errorf("development build %q can't be updated", Version)
+	}
+	v, ok := parseVersion(m.Version)
+	if !ok || v.compare(current) <= 0 {
+		return fmt.Errorf("version %v is not newer than %v", m.Version, Version)
+	}
+	return nil
+}
+
+// ValidateUpdateChannel checks the update channel.
+func ValidateUpdateChannel(channel string) error {
+	switch channel {
+	case "", UpdateStable, UpdateBeta:
+		return nil
+	}
+	return fmt.Errorf("invalid update channel %q", channel)
+}
+
+// binaryName is the name of the release asset for this platform, as built by
+// the Makefile.
+func binaryName() string {
+	return "putio-sync." + runtime.GOOS + "-" + runtime.GOARCH
+}
+
+// asset returns the download URL and size of the release asset with the
+// given name.
+func (r *Release) asset(name string) (string, int64, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, a.Size, true
+		}
+	}
+	return "", 0, false
+}
+
+// CheckUpdate returns the newest release of the channel which is newer than
+// the running version, or nil if it is up to date.
+func (c *Client) CheckUpdate(ctx context.Context, channel string) (*Release, error) {
+	current, ok := parseVersion(Version)
+	if !ok {
+		return nil, fmt.Errorf("development build %q can't be updated", Version)
+	}
+
+	var releases []Release
+	err := c.getJSON(ctx, releasesURL, &releases)
+	if err != nil {
+		return nil, err
+	}
+
+	var newest *Release
+	newestVersion := current
+	for i := range releases {
+		r := &releases[i]
+		if r.Draft || (r.Prerelease && channel != UpdateBeta) {
+			continue
+		}
+		v, ok := parseVersion(r.Version)
+		if !ok || v.compare(newestVersion) <= 0 {
+			continue
+		}
+		if _, _, ok := r.asset(binaryName()); !ok {
+			continue
+		}
+		newest, newestVersion = r, v
+	}
+	if newest != nil {
+		newest.channel = channel
+	}
+	return newest, nil
+}
+
+// Update replaces the running binary with the one of the release after
+// verifying the signature of its manifest. The new binary runs after a
+// restart.
+func (c *Client) Update(ctx context.Context, r *Release) error {
+	if UpdatePublicKey == "" {
+		return Error("this build has no update signing key")
+	}
+	key, err := base64.StdEncoding.DecodeString(UpdatePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return Error("invalid update signing key")
+	}
+
+	binURL, size, ok := r.asset(binaryName())
+	if !ok {
+		return fmt.Errorf("release %v has no binary for %v", r.Version, binaryName())
+	}
+	manifestURL, _, ok := r.asset(binaryName() + ".manifest")
+	if !ok {
+		return fmt.Errorf("release %v has no manifest", r.Version)
+	}
+	sigURL, _, ok := r.asset(binaryName() + ".manifest.sig")
+	if !ok {
+		return fmt.Errorf("release %v is not signed", r.Version)
+	}
+
+	sig, err := c.httpGet(ctx, sigURL, 1024)
+	if err != nil {
+		return err
+	}
+	// the signature is either raw or base64 encoded
+	if len(sig) != ed25519.SignatureSize {
+		sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil {
+			return fmt.Errorf("malformed signature: %v", err)
+		}
+	}
+
+	b, err := c.httpGet(ctx, manifestURL, 64*1024)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), b, sig) {
+		return Error("signature verification failed")
+	}
+	var manifest updateManifest
+	err = json.Unmarshal(b, &manifest)
+	if err != nil {
+		return fmt.Errorf("malformed manifest: %v", err)
+	}
+	err = manifest.verify(r)
+	if err != nil {
+		return err
+	}
+
+	bin, err := c.httpGet(ctx, binURL, size+1)
+	if err != nil {
+		return err
+	}
+	if int64(len(bin)) != size {
+		return fmt.Errorf("binary size is %v, expected %v", len(bin), size)
+	}
+	sum := sha256.Sum256(bin)
+	if hex.EncodeToString(sum[:]) != strings.ToLower(manifest.SHA256) {
+		return Error("binary doesn't match the manifest")
+	}
+
+	_, err = c.Store.Backup("update-to-" + r.Version)
+	if err != nil {
+		return fmt.Errorf("backing up the database: %v", err)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(exe), ".putio-sync-update")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(bin)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(f.Name(), 0755)
+	if err != nil {
+		return err
+	}
+
+	// a running binary can be renamed but not replaced on Windows
+	old := exe + oldBinarySuffix
+	os.Remove(old)
+	err = os.Rename(exe, old)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.Name(), exe)
+	if err != nil {
+		_ = os.Rename(old, exe)
+		return err
+	}
+
+	c.Printf("Updated from %v to %v, restart to run the new version\n", Version, r.Version)
+	return nil
+}
+
+// RestartRequested receives when the binary is updated and the daemon should
+// restart gracefully into the new version.
+func (c *Client) RestartRequested() <-chan struct{} {
+	return c.restartCh
+}
+
+// requestRestart asks the daemon to restart.
+func (c *Client) requestRestart() {
+	select {
+	case c.restartCh <- struct{}{}:
+	default:
+	}
+}
+
+// UpdateAndRestart updates to the release and asks the daemon to restart.
+func (c *Client) UpdateAndRestart(ctx context.Context, r *Release) error {
+	err := c.Update(ctx, r)
+	if err != nil {
+		return err
+	}
+	c.requestRestart()
+	return nil
+}
+
+// ServeUpdates checks for updates periodically and installs them if
+// AutoUpdate is enabled, until ctx is done.
+func (c *Client) ServeUpdates(ctx context.Context) {
+	removeOldBinary()
+
+	ticker := time.NewTicker(updateCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		// no binary is replaced while the network activity is halted
+		if c.Config.AutoUpdate && !c.Halted() {
+			r, err := c.CheckUpdate(ctx, c.Config.UpdateChannel)
+			switch {
+			case err != nil:
+				c.Debugf("Error checking for updates: %v\n", err)
+			case r != nil:
+				c.Printf("Updating to %v\n", r.Version)
+				err = c.UpdateAndRestart(ctx, r)
+				if err != nil {
+					c.Errorf("Error updating to %v: %v\n", r.Version, err)
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// removeOldBinary removes the binary replaced by the last update.
+func removeOldBinary() {
+	exe, err := os.Executable()
+	if err != nil {
+		return
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return
+	}
+	os.Remove(exe + oldBinarySuffix)
+}
+
+// getJSON decodes the JSON response of a GET request.
+func (c *Client) getJSON(ctx context.Context, url string, v interface{}) error {
+	b, err := c.httpGet(ctx, url, 10<<20)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// httpGet reads the response of a GET request, up to limit bytes. The request
+// is refused while the sync is halted.
+func (c *Client) httpGet(ctx context.Context, url string, limit int64) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", defaultUserAgent)
+
+	client := &http.Client{Transport: c.transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v responded with %v", url, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+// semver is a semantic version, e.g. v1.2.3 or v1.3.0-beta.1.
+type semver struct {
+	nums [3]int
+	pre  []string
+}
+
+func parseVersion(s string) (semver, bool) {
+	var v semver
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		v.pre = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return v, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.nums[i] = n
+	}
+	return v, true
+}
+
+// compare returns -1, 0 or 1 if v is older, the same as or newer than w.
+func (v semver) compare(w semver) int {
+	for i := range v.nums {
+		if v.nums[i] != w.nums[i] {
+			return sign(v.nums[i] - w.nums[i])
+		}
+	}
+
+	// a pre-release is older than the release
+	switch {
+	case len(v.pre) == 0 && len(w.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(w.pre) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.pre) && i < len(w.pre); i++ {
+		a, aerr := strconv.Atoi(v.pre[i])
+		b, berr := strconv.Atoi(w.pre[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if a != b {
+				return sign(a - b)
+			}
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		case v.pre[i] != w.pre[i]:
+			if v.pre[i] < w.pre[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return sign(len(v.pre) - len(w.pre))
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,245 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rjeczalik/notify"
+)
+
+// uploadSettleTime is how long the size of a file must stay the same before
+// it is uploaded, so that files still being copied into the upload folder are
+// not picked up.
+const uploadSettleTime = 2 * time.Second
+
+// uploadWorkers is the number of files uploaded at the same time.
+const uploadWorkers = 4
+
+// uploadEvents is the size of the event buffer of the upload folder. The
+// watcher drops the events which don't fit, so the folder is scanned again
+// when the buffer fills up.
+const uploadEvents = 256
+
+// WatchUploadFolder uploads every file dropped into the upload folder to the
+// configured Put.io folder until ctx is cancelled. Uploaded files are deleted
+// if DeleteUploaded is set.
+func (c *Client) WatchUploadFolder(ctx context.Context) {
+	defer c.RecoverCrash()
+
+	dir := c.Config.UploadFolder
+	if dir == "" {
+		return
+	}
+
+	ch := make(chan notify.EventInfo, uploadEvents)
+	// watch for create and rename events, since moving from one folder to
+	// another is simpy a 'rename' event.
+	err := notify.Watch(dir, ch, notify.Create, notify.Rename)
+	if err != nil {
+		c.Errorf("Error watching upload folder: %v\n", err)
+		return
+	}
+	defer notify.Stop(ch)
+
+	// files are uploaded in the background, so that the events are received
+	// while waiting for the files to settle. A file is handled once at a time.
+	var mu sync.Mutex
+	pending := make(map[string]bool)
+	workers := make(chan struct{}, uploadWorkers)
+	dispatch := func(path string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if pending[path] {
+			return
+		}
+		pending[path] = true
+
+		go func() {
+			defer func() {
+				mu.Lock()
+				delete(pending, path)
+				mu.Unlock()
+			}()
+
+			select {
+			case workers <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-workers }()
+			c.uploadFile(ctx, path)
+		}()
+	}
+
+	scan := func() {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			c.Errorf("Error reading upload folder: %v\n", err)
+		}
+		for _, info := range infos {
+			dispatch(filepath.Join(dir, info.Name()))
+		}
+	}
+
+	// Perform an initial scan on the directory
+	scan()
+
+	overflow := false
+	for {
+		select {
+		case event := <-ch:
+			// the buffer was full, the events which didn't fit are lost
+			if len(ch) >= cap(ch)-1 {
+				overflow = true
+			}
+
+			path := event.Path()
+
+			// if a file is renamed, it might have been moved from someplace
+			// else. so check if the file exists, and skip the simple
+			// 'renaming' events.
+			if event.Event() != notify.Rename || exists(path) {
+				c.Debugf("New upload event: %v, %v\n", event.Event(), path)
+				dispatch(path)
+			}
+
+			if overflow && len(ch) == 0 {
+				overflow = false
+				c.Printf("Too many changes in the upload folder, scanning it again\n")
+				scan()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Upload is the state of a local file uploaded to Put.io. It is encoded as Gob
+// and stored to a persistent storage.
+type Upload struct {
+	// Absolute path of the local file
+	Path string `json:"path"`
+
+	// Size and modification time of the file when it is uploaded
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+
+	// ID of the uploaded Put.io file
+	FileID int64 `json:"file_id"`
+
+	// URL of the resumable upload and the number of bytes the server has
+	// received
+	UploadURL string `json:"-"`
+	Offset    int64  `json:"offset"`
+
+	Completed bool `json:"completed"`
+}
+
+// uploadFile uploads the regular file at path once it is completely written.
+func (c *Client) uploadFile(ctx context.Context, path string) {
+	name := filepath.Base(path)
+	if isHidden(name) || isPartial(name) {
+		return
+	}
+
+	info, err := waitSettled(ctx, path)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+
+	// skip the files uploaded before, unless they are changed
+	u, err := c.Store.Upload(path, c.User.Username)
+	if err == nil && u.Completed && u.Size == info.Size() && u.ModTime.Equal(info.ModTime()) {
+		c.Debugf("Skipping already uploaded file %v\n", path)
+		return
+	}
+
+	// resume the unfinished upload of the same file
+	if err != nil || u.Completed || u.Size != info.Size() || !u.ModTime.Equal(info.ModTime()) {
+		u = &Upload{
+			Path:    path,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+	}
+	err = c.upload(ctx, u)
+	if err != nil {
+		c.Errorf("Error uploading %v: %v\n", path, err)
+		return
+	}
+
+	u.Completed = true
+	err = c.Store.SaveUpload(u, c.User.Username)
+	if err != nil {
+		c.Errorf("Error saving upload state of %v: %v\n", path, err)
+	}
+
+	if c.Config.DeleteUploaded {
+		err = os.Remove(path)
+		if err != nil {
+			c.Errorf("Error removing uploaded file %v: %v\n", path, err)
+		}
+	}
+	c.Printf("File %v successfully uploaded\n", path)
+}
+
+// upload sends the file to the upload destination folder. Torrent files are
+// sent with a single request to start a transfer; the other files are
+// uploaded in resumable chunks.
+func (c *Client) upload(ctx context.Context, u *Upload) error {
+	if !strings.HasSuffix(u.Path, ".torrent") {
+		return c.tusUpload(ctx, u)
+	}
+
+	f, err := os.Open(u.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	resp, err := c.C.Files.Upload(ctx, f, filepath.Base(u.Path), c.Config.UploadTo)
+	if err != nil {
+		return err
+	}
+	if resp.File == nil {
+		// torrent files start a transfer instead
+		if resp.Transfer == nil {
+			return fmt.Errorf("API hasn't created the file for some reason")
+		}
+		return nil
+	}
+	u.FileID = resp.File.ID
+	return nil
+}
+
+// waitSettled waits until the size and the modification time of the file
+// stop changing.
+func waitSettled(ctx context.Context, path string) (os.FileInfo, error) {
+	last, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		select {
+		case <-time.After(uploadSettleTime):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() == last.Size() && info.ModTime().Equal(last.ModTime()) {
+			return info, nil
+		}
+		last = info
+	}
+}
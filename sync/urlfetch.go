@@ -0,0 +1,138 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+)
+
+// Put.io transfer statuses
+const (
+	transferCompleted = "COMPLETED"
+	transferSeeding   = "SEEDING"
+	transferError     = "ERROR"
+)
+
+// URLTransfer is a link submitted to Put.io to be fetched. It is tracked
+// until the transfer completes and its files are queued for download.
+type URLTransfer struct {
+	TransferID int64     `json:"transfer_id"`
+	URL        string    `json:"url"`
+	Name       string    `json:"name"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error"`
+	FileID     int64     `json:"file_id"`
+	AddedAt    time.Time `json:"added_at"`
+
+	// Files of the transfer are queued for download
+	Queued bool `json:"queued"`
+}
+
+// done reports whether the transfer doesn't need tracking anymore.
+func (u *URLTransfer) done() bool {
+	return u.Queued || u.Status == transferError
+}
+
+// AddURL submits an HTTP, FTP or magnet link to Put.io to be fetched into the
+// given folder. The transfer is tracked and its files are downloaded once it
+// completes, even if the folder is not in a download root.
+func (c *Client) AddURL(ctx context.Context, link string, parent int64) (*URLTransfer, error) {
+	transfer, err := c.C.Transfers.Add(ctx, link, parent, c.callbackURL())
+	if err != nil {
+		return nil, err
+	}
+
+	u := &URLTransfer{
+		TransferID: transfer.ID,
+		URL:        link,
+		Name:       transfer.Name,
+		Status:     transfer.Status,
+		AddedAt:    time.Now().UTC(),
+	}
+	err = c.Store.SaveURLTransfer(u, c.User.Username)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// trackURLTransfers checks the tracked transfers and queues the files of the
+// completed ones.
+func (c *Client) trackURLTransfers(ctx context.Context) {
+	transfers, err := c.Store.URLTransfers(c.User.Username)
+	if err != nil {
+		c.Errorf("Error retrieving URL transfers: %v\n", err)
+		return
+	}
+
+	for _, u := range transfers {
+		if u.done() {
+			continue
+		}
+		c.checkURLTransfer(ctx, u)
+	}
+}
+
+// checkURLTransfer updates the tracked transfer and queues its files if it is
+// completed.
+func (c *Client) checkURLTransfer(ctx context.Context, u *URLTransfer) {
+	transfer, err := c.C.Transfers.Get(ctx, u.TransferID)
+	if err != nil {
+		c.Errorf("Error retrieving transfer %v: %v\n", u.TransferID, err)
+		return
+	}
+
+	u.Name = transfer.Name
+	u.Status = transfer.Status
+	u.Error = transfer.ErrorMessage
+	u.FileID = transfer.FileID
+
+	if (u.Status == transferCompleted || u.Status == transferSeeding) && u.FileID != 0 {
+		err = c.queueTransfer(ctx, u.FileID)
+		if err != nil {
+			c.Errorf("Error queueing transfer %v: %v\n", u.Name, err)
+			return
+		}
+		c.Printf("Transfer %v is completed, downloading\n", u.Name)
+		u.Queued = true
+	}
+
+	err = c.Store.SaveURLTransfer(u, c.User.Username)
+	if err != nil {
+		c.Errorf("Error saving URL transfer %v: %v\n", u.TransferID, err)
+	}
+}
+
+// queueTransfer queues the file or folder created by a transfer. Files in a
+// download root are downloaded to their usual place, others into DownloadTo.
+func (c *Client) queueTransfer(ctx context.Context, fileID int64) error {
+	f, err := c.C.Files.Get(ctx, fileID)
+	if err != nil {
+		return err
+	}
+
+	m, folders, err := c.ancestors(ctx, f.ParentID)
+	if err != nil {
+		return c.Fetch([]int64{fileID})
+	}
+
+	ignores, err := c.ancestorIgnores(ctx, m, folders)
+	if err != nil {
+		return err
+	}
+	cwd := folders[len(folders)-1].cwd
+	if ignores.Match(filepath.Join(cwd, f.Name)) {
+		c.Debugf("Skipping ignored transfer file %v\n", f)
+		return nil
+	}
+
+	if f.IsDir() {
+		dir := filepath.Join(cwd, f.Name)
+		go func() {
+			defer c.RecoverCrash()
+			c.walk(ctx, m, f.ID, dir, dir, ignores, true, newReport())
+		}()
+		return nil
+	}
+	return c.fetchFile(ctx, f, m.DownloadTo, cwd)
+}
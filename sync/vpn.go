@@ -0,0 +1,183 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// vpnCheckInterval is the interval of checking the VPN while the
+	// kill-switch is enabled.
+	vpnCheckInterval = 15 * time.Second
+
+	// vpnCheckTimeout is how long the egress IP is waited for.
+	vpnCheckTimeout = 10 * time.Second
+
+	// defaultVPNCheckURL echoes the IP address of the client.
+	defaultVPNCheckURL = "https://api.ipify.org"
+)
+
+// ValidateVPNEgress checks that the egress address is an IP address or a
+// CIDR block.
+func ValidateVPNEgress(s string) error {
+	if net.ParseIP(s) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(s); err == nil {
+		return nil
+	}
+	return Error("VPN egress must be an IP address or a CIDR block")
+}
+
+// ValidateVPNCheckURL checks that the IP echo service is an HTTP URL. An
+// empty URL is the default service.
+func ValidateVPNCheckURL(s string) error {
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Error("invalid VPN check url")
+	}
+	return nil
+}
+
+// vpnEnabled reports whether the kill-switch is enabled.
+func (c *Config) vpnEnabled() bool {
+	return c.VPNInterface != "" || len(c.VPNEgress) > 0
+}
+
+// checkVPN checks that Config.VPNInterface is up and that the egress IP is in
+// Config.VPNEgress. It returns nil if the kill-switch is disabled.
+func (c *Client) checkVPN() error {
+	cfg := c.Config
+	if !cfg.vpnEnabled() {
+		return nil
+	}
+
+	if cfg.VPNInterface != "" {
+		iface, err := net.InterfaceByName(cfg.VPNInterface)
+		if err != nil {
+			return err
+		}
+		if iface.Flags&net.FlagUp == 0 {
+			return fmt.Errorf("interface %v is down", cfg.VPNInterface)
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return err
+		}
+		if len(addrs) == 0 {
+			return fmt.Errorf("interface %v has no addresses", cfg.VPNInterface)
+		}
+	}
+
+	if len(cfg.VPNEgress) == 0 {
+		return nil
+	}
+
+	ip, err := c.egressIP(cfg.VPNCheckURL)
+	if err != nil {
+		return fmt.Errorf("Error checking the egress IP: %v", err)
+	}
+	for _, e := range cfg.VPNEgress {
+		if expected := net.ParseIP(e); expected != nil && expected.Equal(ip) {
+			return nil
+		}
+		if _, block, err := net.ParseCIDR(e); err == nil && block.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("egress IP %v is not the VPN", ip)
+}
+
+// egressIP returns the IP address which the downloads come from, as seen by
+// the given echo service.
+func (c *Client) egressIP(u string) (net.IP, error) {
+	if u == "" {
+		u = defaultVPNCheckURL
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), vpnCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+
+	client := &http.Client{Transport: c.transport}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected HTTP Status: %v", resp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(b)))
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", b)
+	}
+	return ip, nil
+}
+
+// WatchVPN enforces the kill-switch until ctx is done. The sync is paused when
+// the VPN drops, and it is resumed when the VPN is back. Run doesn't start
+// the sync either while the VPN is down.
+func (c *Client) WatchVPN(ctx context.Context) {
+	defer c.RecoverCrash()
+
+	ticker := time.NewTicker(vpnCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		c.mu.Lock()
+		running := c.CancelFunc != nil
+		waiting := c.vpnDown
+		c.mu.Unlock()
+		if !running && !waiting {
+			continue
+		}
+
+		err := c.checkVPN()
+		switch {
+		case err != nil && running:
+			c.Printf("VPN is down, pausing the sync: %v\n", err)
+			if err := c.pause(); err != nil {
+				c.Errorf("Error pausing the sync: %v\n", err)
+				continue
+			}
+			c.mu.Lock()
+			c.vpnDown = true
+			c.mu.Unlock()
+		case err == nil && waiting:
+			c.Printf("VPN is up, resuming the sync\n")
+			c.mu.Lock()
+			c.vpnDown = false
+			c.mu.Unlock()
+			if err := c.Run(); err != nil {
+				c.Errorf("Error resuming the sync: %v\n", err)
+			}
+		}
+	}
+}
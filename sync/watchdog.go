@@ -0,0 +1,170 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+const (
+	// watchdogInterval is the interval of the watchdog checks.
+	watchdogInterval = 30 * time.Second
+
+	// watchdogStopTimeout is how long the watchdog waits for a stalled sync
+	// to stop before giving up on the process.
+	watchdogStopTimeout = time.Minute
+
+	// watchdogExitCode is the exit code when the watchdog can't recover the
+	// sync, for the service manager to restart the daemon.
+	watchdogExitCode = 70
+)
+
+// beat records that the sync loop is alive and expects to beat again within
+// the given duration.
+func (c *Client) beat(expect time.Duration) {
+	c.watchdogMu.Lock()
+	c.loopBeat = time.Now()
+	c.loopExpect = expect
+	c.watchdogMu.Unlock()
+}
+
+// Stalled returns the subsystem which the watchdog found stalled, or an empty
+// string.
+func (c *Client) Stalled() string {
+	c.watchdogMu.Lock()
+	defer c.watchdogMu.Unlock()
+	return c.stalled
+}
+
+func (c *Client) setStalled(s string) {
+	c.watchdogMu.Lock()
+	c.stalled = s
+	c.watchdogMu.Unlock()
+}
+
+// watchdog checks that the sync loop polls and the downloads progress. A
+// subsystem is stalled if it shows no sign of life for Config.Watchdog times
+// the interval it is expected in.
+func (c *Client) watchdog(ctx context.Context) {
+	defer c.RecoverCrash()
+	defer c.setStalled("")
+
+	started := time.Now()
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		n := time.Duration(c.Config.Watchdog)
+		if n == 0 {
+			continue
+		}
+
+		stalled := ""
+		c.watchdogMu.Lock()
+		beat, expect := c.loopBeat, c.loopExpect
+		c.watchdogMu.Unlock()
+		if expect < time.Duration(c.Config.PollInterval) {
+			expect = time.Duration(c.Config.PollInterval)
+		}
+		if !beat.IsZero() && time.Since(beat) > n*expect {
+			stalled = fmt.Sprintf("sync loop, no poll since %v", beat.Format(time.RFC3339))
+		}
+
+		last := c.meter.Last()
+		if last.Before(started) {
+			last = started
+		}
+		if stalled == "" && !c.Tasks.Empty() && c.Status() != "disk-full" && time.Since(last) > n*time.Duration(c.Config.PollInterval) {
+			stalled = fmt.Sprintf("downloader, no progress since %v", last.Format(time.RFC3339))
+		}
+
+		previous := c.Stalled()
+		c.setStalled(stalled)
+		if stalled == "" || previous != "" {
+			continue
+		}
+
+		c.Printf("Watchdog: %v\n", stalled)
+		c.dumpGoroutines()
+
+		if c.Config.WatchdogRestart {
+			go c.restartSync()
+			return
+		}
+	}
+}
+
+// dumpGoroutines logs the active tasks and writes the stacks of all
+// goroutines next to the database.
+func (c *Client) dumpGoroutines() {
+	c.Tasks.Lock()
+	for _, t := range c.Tasks.s {
+		c.Printf("Watchdog: active %v\n", t)
+	}
+	c.Tasks.Unlock()
+
+	path := filepath.Join(filepath.Dir(c.Store.Path()), "watchdog-"+time.Now().Format("20060102-150405")+".txt")
+	f, err := os.Create(path)
+	if err != nil {
+		c.Printf("Watchdog: error writing goroutine dump: %v\n", err)
+		return
+	}
+	defer f.Close()
+
+	err = pprof.Lookup("goroutine").WriteTo(f, 2)
+	if err != nil {
+		c.Printf("Watchdog: error writing goroutine dump: %v\n", err)
+		return
+	}
+	c.Printf("Watchdog: %v goroutines are dumped to %v\n", runtime.NumGoroutine(), path)
+}
+
+// removeGoroutineDumps removes the goroutine dumps in dir.
+func removeGoroutineDumps(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "watchdog-*.txt"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restartSync stops and starts the sync. A deadlocked sync may never stop,
+// the daemon exits then for the service manager to restart it.
+func (c *Client) restartSync() {
+	c.Printf("Watchdog: restarting the sync\n")
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- c.Stop() }()
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			c.Printf("Watchdog: error stopping the sync: %v\n", err)
+			return
+		}
+	case <-time.After(watchdogStopTimeout):
+		c.Printf("Watchdog: the sync didn't stop in %v, exiting\n", watchdogStopTimeout)
+		os.Exit(watchdogExitCode)
+	}
+
+	err := c.Run()
+	if err != nil {
+		c.Printf("Watchdog: error starting the sync: %v\n", err)
+	}
+}
@@ -0,0 +1,304 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML parses the block style subset of YAML used by provisioning
+// files: nested mappings and sequences, scalars, flow sequences of scalars,
+// literal blocks (|) and comments. Anchors, tags and multiple documents are
+// not supported. The result consists of map[string]interface{},
+// []interface{} and scalar values, like the result of encoding/json.
+func parseYAML(data string) (interface{}, error) {
+	var lines []yamlLine
+	for i, text := range strings.Split(strings.Replace(data, "\r\n", "\n", -1), "\n") {
+		trimmed := strings.TrimLeft(text, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %v: tabs are not allowed for indentation", i+1)
+		}
+		lines = append(lines, yamlLine{
+			num:    i + 1,
+			indent: len(text) - len(trimmed),
+			raw:    text,
+			text:   strings.TrimRight(stripYAMLComment(trimmed), " "),
+		})
+	}
+
+	p := &yamlParser{lines: lines}
+	p.skipBlank()
+	if p.i >= len(p.lines) {
+		return nil, nil
+	}
+
+	v, err := p.parseBlock(p.lines[p.i].indent)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if p.i < len(p.lines) {
+		return nil, fmt.Errorf("line %v: unexpected indentation", p.lines[p.i].num)
+	}
+	return v, nil
+}
+
+type yamlLine struct {
+	num    int
+	indent int
+	raw    string
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+// stripYAMLComment removes a comment outside of quotes from the line.
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func (p *yamlParser) skipBlank() {
+	for p.i < len(p.lines) && p.lines[p.i].text == "" {
+		p.i++
+	}
+}
+
+func isListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseBlock parses the mapping or sequence starting at the current line.
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if isListItem(p.lines[p.i].text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	list := make([]interface{}, 0)
+	for {
+		p.skipBlank()
+		if p.i >= len(p.lines) || p.lines[p.i].indent != indent || !isListItem(p.lines[p.i].text) {
+			return list, nil
+		}
+
+		line := p.lines[p.i]
+		content := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+
+		var item interface{}
+		var err error
+		switch {
+		case content == "":
+			p.i++
+			item, err = p.parseNested(indent)
+		case isListItem(content) || yamlKey(content) != "":
+			// the item is a block starting on the same line, e.g. "- key: value"
+			itemIndent := indent + len(line.text) - len(content)
+			p.lines[p.i] = yamlLine{num: line.num, indent: itemIndent, raw: line.raw, text: content}
+			item, err = p.parseBlock(itemIndent)
+		default:
+			p.i++
+			item, err = p.parseScalar(content, line, indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for {
+		p.skipBlank()
+		if p.i >= len(p.lines) || p.lines[p.i].indent != indent || isListItem(p.lines[p.i].text) {
+			return m, nil
+		}
+
+		line := p.lines[p.i]
+		key := yamlKey(line.text)
+		if key == "" {
+			return nil, fmt.Errorf("line %v: expected a key", line.num)
+		}
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("line %v: duplicate key %q", line.num, key)
+		}
+
+		rest := strings.TrimSpace(line.text[strings.Index(line.text, ":")+1:])
+		key, err := unquoteYAMLKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", line.num, err)
+		}
+		p.i++
+
+		var value interface{}
+		if rest == "" {
+			value, err = p.parseNested(indent)
+		} else {
+			value, err = p.parseScalar(rest, line, indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+}
+
+// parseNested parses the block following a key or a dash without a value.
+// Sequences may be at the same indentation as their key.
+func (p *yamlParser) parseNested(indent int) (interface{}, error) {
+	p.skipBlank()
+	if p.i >= len(p.lines) {
+		return nil, nil
+	}
+
+	next := p.lines[p.i]
+	switch {
+	case next.indent > indent:
+		return p.parseBlock(next.indent)
+	case next.indent == indent && isListItem(next.text):
+		return p.parseSequence(indent)
+	}
+	return nil, nil
+}
+
+// yamlKey returns the key of a "key: value" line, or an empty string.
+func yamlKey(text string) string {
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 || !strings.HasPrefix(text[end+2:], ":") {
+			return ""
+		}
+		return text[:end+2]
+	}
+
+	i := strings.Index(text, ":")
+	if i <= 0 || (i+1 < len(text) && text[i+1] != ' ') {
+		return ""
+	}
+	if strings.ContainsAny(text[:i], "[]{},") {
+		return ""
+	}
+	return strings.TrimSpace(text[:i])
+}
+
+func unquoteYAMLKey(key string) (string, error) {
+	v, err := parseYAMLScalar(key)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return key, nil
+}
+
+// parseScalar parses the inline value of a key or a list item. Literal
+// blocks continue on the following lines.
+func (p *yamlParser) parseScalar(value string, line yamlLine, indent int) (interface{}, error) {
+	if value == "|" || value == "|-" {
+		return p.parseLiteral(indent, value == "|-"), nil
+	}
+
+	v, err := parseYAMLScalar(value)
+	if err != nil {
+		return nil, fmt.Errorf("line %v: %v", line.num, err)
+	}
+	return v, nil
+}
+
+// parseLiteral collects the lines of a literal block, keeping the newlines.
+func (p *yamlParser) parseLiteral(indent int, strip bool) string {
+	var lines []string
+	blockIndent := -1
+	for ; p.i < len(p.lines); p.i++ {
+		line := p.lines[p.i]
+		if strings.TrimSpace(line.raw) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if line.indent <= indent {
+			break
+		}
+		if blockIndent < 0 {
+			blockIndent = line.indent
+		}
+		if line.indent < blockIndent {
+			break
+		}
+		// comments are part of the text in literal blocks
+		lines = append(lines, line.raw[blockIndent:])
+	}
+
+	text := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if !strip {
+		text += "\n"
+	}
+	return text
+}
+
+func parseYAMLScalar(s string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(s, "\""):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("unterminated string %v", s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{"):
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err == nil {
+			return v, nil
+		}
+		if s == "[]" || s == "{}" || !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unsupported flow collection %v", s)
+		}
+		list := make([]interface{}, 0)
+		for _, item := range strings.Split(s[1:len(s)-1], ",") {
+			v, err := parseYAMLScalar(strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	}
+
+	switch s {
+	case "null", "~":
+		return nil, nil
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off":
+		return false, nil
+	}
+
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return s, nil
+}
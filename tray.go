@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/putdotio/putio-sync/sync"
+)
+
+// tray drives the tray icon of the desktop. It talks to the running server
+// through the HTTP API like the other commands, so the icon works as well
+// with a server started as a service.
+type tray struct {
+	addr string
+}
+
+// trayState is what the tray icon shows.
+type trayState struct {
+	// The server is reachable
+	connected bool
+
+	// The sync is started
+	syncing bool
+
+	label string
+}
+
+func runTray(args []string) error {
+	fs := flag.NewFlagSet("tray", flag.ExitOnError)
+	addr := fs.String("addr", defaultAPIAddr, "Address of the running server")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: putio-sync tray [flags]\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	t := &tray{addr: *addr}
+	return t.run()
+}
+
+// state fetches the status of the server.
+func (t *tray) state() trayState {
+	body, err := healthRequest(t.addr)
+	if err != nil {
+		return trayState{label: "Not running"}
+	}
+
+	var h sync.Health
+	err = json.Unmarshal(body, &h)
+	if err != nil {
+		return trayState{label: err.Error()}
+	}
+	return trayState{connected: true, syncing: h.Status != "stopped", label: h.Label}
+}
+
+// toggle pauses the sync if it is started, and resumes it otherwise.
+func (t *tray) toggle(s trayState) error {
+	path := "/api/start"
+	if s.syncing {
+		path = "/api/stop"
+	}
+	_, err := apiRequest(t.addr, "POST", path, url.Values{})
+	return err
+}
+
+// openDownloadFolder opens the download folder in the file manager.
+func (t *tray) openDownloadFolder() error {
+	body, err := apiRequest(t.addr, "GET", "/api/config", url.Values{})
+	if err != nil {
+		return err
+	}
+
+	var c sync.Config
+	err = json.Unmarshal(body, &c)
+	if err != nil {
+		return err
+	}
+	if c.DownloadTo == "" {
+		return fmt.Errorf("download folder is not set")
+	}
+	return openPath(c.DownloadTo)
+}
+
+// openWebUI opens the web interface in the browser.
+func (t *tray) openWebUI() error {
+	return openPath(t.addr)
+}
+
+// openPath opens a folder or a URL with the default application.
+func openPath(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", path)
+	case "darwin":
+		cmd = exec.Command("open", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "fmt"
+
+// run shows the tray icon. There is no tray icon on this platform; the web
+// interface offers the same controls.
+func (t *tray) run() error {
+	return fmt.Errorf("tray mode is not supported on this platform, use the web interface at %v", t.addr)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	user32   = syscall.NewLazyDLL("user32.dll")
+	shell32  = syscall.NewLazyDLL("shell32.dll")
+	kernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+	procRegisterClassEx  = user32.NewProc("RegisterClassExW")
+	procCreateWindowEx   = user32.NewProc("CreateWindowExW")
+	procDefWindowProc    = user32.NewProc("DefWindowProcW")
+	procDestroyWindow    = user32.NewProc("DestroyWindow")
+	procGetMessage       = user32.NewProc("GetMessageW")
+	procTranslateMessage = user32.NewProc("TranslateMessage")
+	procDispatchMessage  = user32.NewProc("DispatchMessageW")
+	procPostQuitMessage  = user32.NewProc("PostQuitMessage")
+	procSetTimer         = user32.NewProc("SetTimer")
+	procCreatePopupMenu  = user32.NewProc("CreatePopupMenu")
+	procAppendMenu       = user32.NewProc("AppendMenuW")
+	procTrackPopupMenu   = user32.NewProc("TrackPopupMenu")
+	procDestroyMenu      = user32.NewProc("DestroyMenu")
+	procGetCursorPos     = user32.NewProc("GetCursorPos")
+	procSetForegroundWnd = user32.NewProc("SetForegroundWindow")
+	procLoadIcon         = user32.NewProc("LoadIconW")
+	procMessageBox       = user32.NewProc("MessageBoxW")
+	procShellNotifyIcon  = shell32.NewProc("Shell_NotifyIconW")
+	procGetModuleHandle  = kernel32.NewProc("GetModuleHandleW")
+)
+
+// Win32 constants
+const (
+	wmDestroy     = 0x0002
+	wmTimer       = 0x0113
+	wmLButtonUp   = 0x0202
+	wmRButtonUp   = 0x0205
+	wmUser        = 0x0400
+	wmTrayMessage = wmUser + 1
+
+	nimAdd    = 0
+	nimModify = 1
+	nimDelete = 2
+
+	nifMessage = 0x1
+	nifIcon    = 0x2
+	nifTip     = 0x4
+
+	mfString    = 0x0
+	mfGrayed    = 0x1
+	mfSeparator = 0x800
+
+	tpmRightButton = 0x2
+	tpmReturnCmd   = 0x100
+
+	idiApplication = 32512
+
+	mbIconError = 0x10
+)
+
+// tray menu items
+const (
+	trayMenuStatus = iota + 1
+	trayMenuToggle
+	trayMenuOpenFolder
+	trayMenuOpenWebUI
+	trayMenuQuit
+)
+
+// trayRefreshInterval is the interval of updating the status in milliseconds.
+const trayRefreshInterval = 5000
+
+type wndClassEx struct {
+	Size       uint32
+	Style      uint32
+	WndProc    uintptr
+	ClsExtra   int32
+	WndExtra   int32
+	Instance   uintptr
+	Icon       uintptr
+	Cursor     uintptr
+	Background uintptr
+	MenuName   *uint16
+	ClassName  *uint16
+	IconSm     uintptr
+}
+
+type point struct {
+	X, Y int32
+}
+
+type msg struct {
+	Hwnd    uintptr
+	Message uint32
+	WParam  uintptr
+	LParam  uintptr
+	Time    uint32
+	Pt      point
+}
+
+type notifyIconData struct {
+	Size            uint32
+	Wnd             uintptr
+	ID              uint32
+	Flags           uint32
+	CallbackMessage uint32
+	Icon            uintptr
+	Tip             [128]uint16
+	State           uint32
+	StateMask       uint32
+	Info            [256]uint16
+	Version         uint32
+	InfoTitle       [64]uint16
+	InfoFlags       uint32
+	GUIDItem        [16]byte
+	BalloonIcon     uintptr
+}
+
+// trayWindow is the hidden window receiving the messages of the tray icon.
+// There is a single tray icon per process, the window procedure finds it here.
+var trayWindow struct {
+	t     *tray
+	hwnd  uintptr
+	icon  notifyIconData
+	state trayState
+}
+
+// run shows the tray icon and runs the message loop until Quit is clicked.
+func (t *tray) run() error {
+	// the window belongs to the thread which created it
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	trayWindow.t = t
+
+	instance, _, _ := procGetModuleHandle.Call(0)
+	icon, _, _ := procLoadIcon.Call(0, idiApplication)
+	className := syscall.StringToUTF16Ptr("putio-sync-tray")
+
+	wc := wndClassEx{
+		WndProc:   syscall.NewCallback(trayWndProc),
+		Instance:  instance,
+		Icon:      icon,
+		ClassName: className,
+	}
+	wc.Size = uint32(unsafe.Sizeof(wc))
+	r, _, err := procRegisterClassEx.Call(uintptr(unsafe.Pointer(&wc)))
+	if r == 0 {
+		return err
+	}
+
+	hwnd, _, err := procCreateWindowEx.Call(
+		0,
+		uintptr(unsafe.Pointer(className)),
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr("putio-sync"))),
+		0, 0, 0, 0, 0, 0, 0, instance, 0)
+	if hwnd == 0 {
+		return err
+	}
+	trayWindow.hwnd = hwnd
+
+	nid := &trayWindow.icon
+	nid.Size = uint32(unsafe.Sizeof(*nid))
+	nid.Wnd = hwnd
+	nid.ID = 1
+	nid.Flags = nifMessage | nifIcon | nifTip
+	nid.CallbackMessage = wmTrayMessage
+	nid.Icon = icon
+	trayWindow.state = t.state()
+	setTrayTip(trayWindow.state.label)
+
+	r, _, err = procShellNotifyIcon.Call(nimAdd, uintptr(unsafe.Pointer(nid)))
+	if r == 0 {
+		procDestroyWindow.Call(hwnd)
+		return err
+	}
+
+	procSetTimer.Call(hwnd, 1, trayRefreshInterval, 0)
+
+	var m msg
+	for {
+		r, _, _ := procGetMessage.Call(uintptr(unsafe.Pointer(&m)), 0, 0, 0)
+		if int32(r) <= 0 {
+			return nil
+		}
+		procTranslateMessage.Call(uintptr(unsafe.Pointer(&m)))
+		procDispatchMessage.Call(uintptr(unsafe.Pointer(&m)))
+	}
+}
+
+func trayWndProc(hwnd, message, wParam, lParam uintptr) uintptr {
+	switch message {
+	case wmTrayMessage:
+		switch lParam & 0xffff {
+		case wmLButtonUp, wmRButtonUp:
+			showTrayMenu()
+		}
+		return 0
+	case wmTimer:
+		trayWindow.state = trayWindow.t.state()
+		setTrayTip(trayWindow.state.label)
+		procShellNotifyIcon.Call(nimModify, uintptr(unsafe.Pointer(&trayWindow.icon)))
+		return 0
+	case wmDestroy:
+		procShellNotifyIcon.Call(nimDelete, uintptr(unsafe.Pointer(&trayWindow.icon)))
+		procPostQuitMessage.Call(0)
+		return 0
+	}
+	r, _, _ := procDefWindowProc.Call(hwnd, message, wParam, lParam)
+	return r
+}
+
+// setTrayTip sets the tooltip of the icon, truncated to fit.
+func setTrayTip(label string) {
+	tip := syscall.StringToUTF16("putio-sync: " + label)
+	if len(tip) > len(trayWindow.icon.Tip) {
+		tip = tip[:len(trayWindow.icon.Tip)]
+		tip[len(tip)-1] = 0
+	}
+	copy(trayWindow.icon.Tip[:], tip)
+}
+
+func showTrayMenu() {
+	t, s := trayWindow.t, trayWindow.state
+
+	menu, _, _ := procCreatePopupMenu.Call()
+	if menu == 0 {
+		return
+	}
+	defer procDestroyMenu.Call(menu)
+
+	appendMenu := func(flags uintptr, id int, text string) {
+		procAppendMenu.Call(menu, flags, uintptr(id), uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(text))))
+	}
+
+	appendMenu(mfString|mfGrayed, trayMenuStatus, s.label)
+	procAppendMenu.Call(menu, mfSeparator, 0, 0)
+	disabled := uintptr(0)
+	if !s.connected {
+		disabled = mfGrayed
+	}
+	if s.syncing {
+		appendMenu(mfString|disabled, trayMenuToggle, "Pause sync")
+	} else {
+		appendMenu(mfString|disabled, trayMenuToggle, "Resume sync")
+	}
+	appendMenu(mfString|disabled, trayMenuOpenFolder, "Open download folder")
+	appendMenu(mfString|disabled, trayMenuOpenWebUI, "Open web interface")
+	procAppendMenu.Call(menu, mfSeparator, 0, 0)
+	appendMenu(mfString, trayMenuQuit, "Quit")
+
+	var pt point
+	procGetCursorPos.Call(uintptr(unsafe.Pointer(&pt)))
+
+	// the menu doesn't close when clicking elsewhere unless the window is in
+	// the foreground
+	procSetForegroundWnd.Call(trayWindow.hwnd)
+	cmd, _, _ := procTrackPopupMenu.Call(menu, tpmReturnCmd|tpmRightButton, uintptr(pt.X), uintptr(pt.Y), 0, trayWindow.hwnd, 0)
+
+	var err error
+	switch cmd {
+	case trayMenuToggle:
+		err = t.toggle(s)
+		trayWindow.state = t.state()
+		setTrayTip(trayWindow.state.label)
+		procShellNotifyIcon.Call(nimModify, uintptr(unsafe.Pointer(&trayWindow.icon)))
+	case trayMenuOpenFolder:
+		err = t.openDownloadFolder()
+	case trayMenuOpenWebUI:
+		err = t.openWebUI()
+	case trayMenuQuit:
+		procDestroyWindow.Call(trayWindow.hwnd)
+	}
+	if err != nil {
+		procMessageBox.Call(trayWindow.hwnd,
+			uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(err.Error()))),
+			uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr("putio-sync"))),
+			mbIconError)
+	}
+}